@@ -2,7 +2,6 @@ package main
 
 import (
 	"context"
-	"fmt"
 	"log"
 	"os"
 	"os/signal"
@@ -10,6 +9,8 @@ import (
 
 	"github.com/Arcadian-Sky/musthave-metrics/internal/agent/controller"
 	"github.com/Arcadian-Sky/musthave-metrics/internal/agent/flags"
+	"github.com/Arcadian-Sky/musthave-metrics/internal/logging"
+	"go.uber.org/zap"
 )
 
 var (
@@ -31,20 +32,33 @@ func main() {
 	if err != nil {
 		log.Fatalf("Ошибка загрузки конфигурации: %v", err)
 	}
-	fmt.Printf("Build version: %s\n", buildVersion)
-	fmt.Printf("Build date: %s\n", buildDate)
-	fmt.Printf("Build commit: %s\n", buildCommit)
+	logger, err := logging.New(config.GetLogging())
+	if err != nil {
+		log.Fatalf("Ошибка настройки логгера: %v", err)
+	}
+	defer logger.Sync()
+	zap.ReplaceGlobals(logger)
+
+	logger.Info("Starting agent",
+		zap.String("version", buildVersion),
+		zap.String("date", buildDate),
+		zap.String("commit", buildCommit),
+	)
 	serviceController := controller.NewCollectAndSendMetricsService(&config)
+	serviceController.SetLogger(logger)
 	go serviceController.Run(ctx)
 
 	<-stop
-	fmt.Println("Получен сигнал, останавливаем все горутины...")
+	logger.Info("Получен сигнал, останавливаем все горутины")
 
 	// Отменяем контекст, чтобы уведомить все горутины о завершении
 	cancel()
 
 	// Ожидаем завершения всех горутин
 	serviceController.Wg.Wait()
+	if err := serviceController.Close(); err != nil {
+		logger.Error("Ошибка при закрытии соединения", zap.Error(err))
+	}
 
-	log.Println("Agent stopped gracefully")
+	logger.Info("Agent stopped gracefully")
 }
@@ -2,10 +2,13 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
 	"database/sql"
 	"errors"
 	"fmt"
+	"io"
 	"log"
+	"net"
 	"os"
 	"os/signal"
 	"syscall"
@@ -16,14 +19,27 @@ import (
 	_ "github.com/jackc/pgx/v5/stdlib"
 
 	"github.com/pressly/goose/v3"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 
+	"github.com/Arcadian-Sky/musthave-metrics/internal/keyring"
+	"github.com/Arcadian-Sky/musthave-metrics/internal/logging"
+	"github.com/Arcadian-Sky/musthave-metrics/internal/server/alerting"
 	"github.com/Arcadian-Sky/musthave-metrics/internal/server/flags"
+	"github.com/Arcadian-Sky/musthave-metrics/internal/server/grpcserver"
 	"github.com/Arcadian-Sky/musthave-metrics/internal/server/handler"
+	"github.com/Arcadian-Sky/musthave-metrics/internal/server/health"
+	"github.com/Arcadian-Sky/musthave-metrics/internal/server/ratelimit"
+	"github.com/Arcadian-Sky/musthave-metrics/internal/server/replay"
 	"github.com/Arcadian-Sky/musthave-metrics/internal/server/server"
+	"github.com/Arcadian-Sky/musthave-metrics/internal/server/statsd"
 	"github.com/Arcadian-Sky/musthave-metrics/internal/server/storage"
 	"github.com/Arcadian-Sky/musthave-metrics/internal/server/storage/config"
+	"github.com/Arcadian-Sky/musthave-metrics/internal/server/storage/history"
 	"github.com/Arcadian-Sky/musthave-metrics/internal/server/storage/inmemory"
 	"github.com/Arcadian-Sky/musthave-metrics/internal/server/storage/postgres"
+	"github.com/Arcadian-Sky/musthave-metrics/internal/server/telemetry"
 	"github.com/Arcadian-Sky/musthave-metrics/migrations"
 )
 
@@ -62,6 +78,13 @@ func main() {
 
 	parsed := flags.Parse()
 
+	logger, err := logging.New(parsed.GetLogging())
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+	defer logger.Sync()
+	zap.ReplaceGlobals(logger)
+
 	db, err := OpenDatabase(parsed.DBSettings)
 	if err != nil {
 		panic(err)
@@ -70,29 +93,90 @@ func main() {
 
 	goose.SetBaseFS(migrations.Migrations)
 
-	storeMetrics := CreateMetricsStorage(parsed, db)
+	selfMetrics := telemetry.New()
+	storeMetrics := CreateMetricsStorage(parsed, db, logger, selfMetrics)
+	checker := InitializeHealth(parsed, storeMetrics)
+	config.SetMementoObserver(selfMetrics, checker)
 
 	memStore, memStoreOk, err := InitializeConfig(storeMetrics, parsed)
 	if err != nil {
-		log.Fatal(err.Error())
+		logger.Fatal("Failed to initialize storage", zap.Error(err))
 	}
+	logger.Info("Storage initialized", zap.String("type", parsed.StorageType), zap.Bool("memento", memStoreOk))
 
-	httpserver := InitializeHTTPServer(parsed, storeMetrics)
+	tlsConfig, err := parsed.GetTLSConfig()
+	if err != nil {
+		logger.Fatal("Failed to load TLS config", zap.Error(err))
+	}
+
+	alertCtx, stopAlerts := context.WithCancel(context.Background())
+	defer stopAlerts()
+	alerts, err := InitializeAlerting(alertCtx, parsed, storeMetrics, checker, logger)
+	if err != nil {
+		logger.Fatal("Failed to initialize alerting", zap.Error(err))
+	}
+
+	stopStatsD, err := InitializeStatsD(parsed, storeMetrics, checker, logger)
+	if err != nil {
+		logger.Fatal("Failed to start StatsD", zap.Error(err))
+	}
+
+	limiter := ratelimit.New(parsed.GetRateLimit())
+	limiter.SetRegistry(selfMetrics.Registry())
+
+	keys, err := InitializeKeyring(parsed, logger)
+	if err != nil {
+		logger.Fatal("Failed to load hash keys", zap.Error(err))
+	}
+	defer WatchKeyring(parsed, keys, logger)()
+	// Один кеш nonce на HTTP и gRPC
+	guard := replay.New(parsed.GetReplay())
+
+	httpserver, err := InitializeHTTPServer(parsed, storeMetrics, tlsConfig, alerts, logger, selfMetrics, checker, limiter, keys, guard)
+	if err != nil {
+		logger.Fatal("Failed to initialize HTTP server", zap.Error(err))
+	}
+	grpcServer := InitializeGRPCServer(parsed, storeMetrics, tlsConfig, limiter, keys, guard)
 
 	go func() {
-		log.Println("Starting server...")
-		fmt.Printf("Build version: %s\n", buildVersion)
-		fmt.Printf("Build date: %s\n", buildDate)
-		fmt.Printf("Build commit: %s\n", buildCommit)
-		if err := httpserver.ListenAndServe(); err != http.ErrServerClosed {
-			log.Fatalf("Server failed: %v", err)
+		logger.Info("Starting server...",
+			zap.String("address", parsed.Endpoint),
+			zap.String("version", buildVersion),
+			zap.String("date", buildDate),
+			zap.String("commit", buildCommit),
+		)
+		var err error
+		if httpserver.TLSConfig != nil {
+			err = httpserver.ListenAndServeTLS("", "")
+		} else {
+			err = httpserver.ListenAndServe()
+		}
+		if err != http.ErrServerClosed {
+			logger.Fatal("Server failed", zap.Error(err))
 		}
 	}()
 
+	if parsed.GRPCEndpoint != "" {
+		go func() {
+			listen, err := net.Listen("tcp", parsed.GRPCEndpoint)
+			if err != nil {
+				logger.Fatal("gRPC server failed to listen", zap.Error(err))
+			}
+			logger.Info("Starting gRPC server", zap.String("address", parsed.GRPCEndpoint))
+			if err := grpcServer.Serve(listen); err != nil {
+				logger.Fatal("gRPC server failed", zap.Error(err))
+			}
+		}()
+	}
+
 	<-stop
 
 	// Handle graceful shutdown
-	GracefulShutdown(httpserver, memStore, memStoreOk, parsed)
+	stopBackground := func() {
+		stopAlerts()
+		stopStatsD()
+	}
+	GracefulShutdown(httpserver, grpcServer, checker, stopBackground, memStore, memStoreOk, parsed, logger)
 }
 
 func InitSignalHandler() chan os.Signal {
@@ -101,6 +185,52 @@ func InitSignalHandler() chan os.Signal {
 	return stop
 }
 
+// Загружаем ключи подписи из -k и файла -hash-keys
+func InitializeKeyring(parsed *flags.InitedFlags, logger *zap.Logger) (*keyring.Keyring, error) {
+	keys, err := parsed.GetHashKeys()
+	if err != nil {
+		return nil, err
+	}
+	ring, err := keyring.New(keys...)
+	if err != nil {
+		return nil, err
+	}
+	if ring.Len() > 0 {
+		logger.Info("Hash keys loaded", zap.Strings("ids", ring.IDs()))
+	}
+	return ring, nil
+}
+
+// Перечитываем ключи подписи по SIGHUP. При ошибке остаются прежние ключи.
+// Возвращенная функция прекращает ожидание сигнала.
+func WatchKeyring(parsed *flags.InitedFlags, ring *keyring.Keyring, logger *zap.Logger) func() {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-hup:
+				keys, err := parsed.GetHashKeys()
+				if err == nil {
+					err = ring.Replace(keys...)
+				}
+				if err != nil {
+					logger.Error("Failed to reload hash keys", zap.Error(err))
+					continue
+				}
+				logger.Info("Hash keys reloaded", zap.Strings("ids", ring.IDs()))
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() {
+		signal.Stop(hup)
+		close(done)
+	}
+}
+
 func OpenDatabase(dbSettings string) (*sql.DB, error) {
 	db, err := sql.Open("pgx", dbSettings)
 	if err != nil {
@@ -109,17 +239,48 @@ func OpenDatabase(dbSettings string) (*sql.DB, error) {
 	return db, nil
 }
 
-func CreateMetricsStorage(cnf *flags.InitedFlags, db *sql.DB) storage.MetricsStorage {
+func CreateMetricsStorage(cnf *flags.InitedFlags, db *sql.DB, logger *zap.Logger, selfMetrics *telemetry.Metrics) storage.MetricsStorage {
 	// NewMemStorage создает новый экземпляр хранилищв
 	// Создаем хранилище
+	historyCfg := history.Config{
+		Retention:  cnf.HistoryRetention,
+		Resolution: cnf.HistoryResolution,
+	}
 	if cnf.StorageType == "postgres" {
 		if db == nil {
-			log.Println("CreateMetricsStorage: db is nil")
+			logger.Warn("CreateMetricsStorage: db is nil")
+		}
+		pgStorage := postgres.NewPostgresStorage(db)
+		pgStorage.SetHistoryConfig(historyCfg)
+		pgStorage.SetLogger(logger)
+		pgStorage.SetObserver(selfMetrics)
+		if db != nil {
+			selfMetrics.WatchDB(db.Stats)
 		}
-		return postgres.NewPostgresStorage(db)
+		return pgStorage
 	}
 	// mementoStore = storeMetrics
-	return inmemory.NewMemStorage()
+	memStorage := inmemory.NewMemStorage()
+	memStorage.SetHistoryConfig(historyCfg)
+	memStorage.SetLogger(logger)
+	memStorage.SetObserver(selfMetrics)
+	return memStorage
+}
+
+// Собираем проверки состояния для /healthz и /readyz: хранилище, миграции базы и сохранение снимков.
+// Снимок считается устаревшим, если не сохранялся дольше двух интервалов сохранения.
+func InitializeHealth(parsed *flags.InitedFlags, storeMetrics storage.MetricsStorage) *health.Checker {
+	checker := health.New()
+	if pgStorage, ok := storeMetrics.(*postgres.PostgresStorage); ok {
+		checker.AddCheck("storage", health.StorageCheck(parsed.StorageType, pgStorage.Ping))
+		checker.AddCheck("migrations", health.MigrationCheck(pgStorage.MigrationStatus))
+	} else {
+		checker.AddCheck("storage", health.StorageCheck(parsed.StorageType, nil))
+	}
+	if _, ok := storeMetrics.(config.MementoStorage); ok && parsed.FileStorage != "" && parsed.StoreInterval > 0 {
+		checker.WatchMemento(2 * parsed.StoreInterval)
+	}
+	return checker
 }
 
 func InitializeConfig(storeMetrics storage.MetricsStorage, parsed *flags.InitedFlags) (config.MementoStorage, bool, error) {
@@ -134,35 +295,138 @@ func InitializeConfig(storeMetrics storage.MetricsStorage, parsed *flags.InitedF
 		}
 	}
 
-	log.Println("cnf.StorageType:", parsed.StorageType)
-	log.Println("memStoreOk:", memStoreOk)
-
 	return memStore, memStoreOk, nil
 }
 
-// Инициируем хендлеры
-func InitializeHTTPServer(parsed *flags.InitedFlags, storeMetrics storage.MetricsStorage) *http.Server {
+// Запускаем вычисление правил алертов до отмены ctx. Если правил нет, возвращает nil.
+func InitializeAlerting(ctx context.Context, parsed *flags.InitedFlags, storeMetrics storage.MetricsStorage, checker *health.Checker, logger *zap.Logger) (*alerting.Engine, error) {
+	if len(parsed.Alerts.Rules) == 0 {
+		return nil, nil
+	}
+	engine, err := alerting.NewEngine(parsed.Alerts, storeMetrics, alerting.NewNotifier(parsed.Alerts))
+	if err != nil {
+		return nil, err
+	}
+	done := checker.Track("alerting")
+	go func() {
+		defer done()
+		engine.Run(ctx)
+	}()
+	logger.Info("Alert rules loaded", zap.Int("rules", len(parsed.Alerts.Rules)))
+	return engine, nil
+}
+
+// Запускаем прием метрик StatsD по UDP и TCP, если заданы адреса.
+// Возвращенная функция закрывает слушатели и сбрасывает накопленные метрики в хранилище.
+func InitializeStatsD(parsed *flags.InitedFlags, storeMetrics storage.MetricsStorage, checker *health.Checker, logger *zap.Logger) (func(), error) {
+	if parsed.StatsDUDPAddress == "" && parsed.StatsDTCPAddress == "" {
+		return func() {}, nil
+	}
+	listener := statsd.New(storeMetrics, parsed.StatsDFlushInterval, parsed.StatsDMaxBatch)
+	listener.SetLogger(logger)
+	var closers []io.Closer
+	closeAll := func() {
+		for _, c := range closers {
+			c.Close()
+		}
+	}
+	if parsed.StatsDUDPAddress != "" {
+		conn, err := net.ListenPacket("udp", parsed.StatsDUDPAddress)
+		if err != nil {
+			return nil, fmt.Errorf("StatsD UDP: %w", err)
+		}
+		closers = append(closers, conn)
+		go func() {
+			if err := listener.ServeUDP(conn); err != nil {
+				logger.Error("StatsD UDP server failed", zap.Error(err))
+			}
+		}()
+		logger.Info("Starting StatsD UDP listener", zap.String("address", parsed.StatsDUDPAddress))
+	}
+	if parsed.StatsDTCPAddress != "" {
+		ln, err := net.Listen("tcp", parsed.StatsDTCPAddress)
+		if err != nil {
+			closeAll()
+			return nil, fmt.Errorf("StatsD TCP: %w", err)
+		}
+		closers = append(closers, ln)
+		go func() {
+			if err := listener.ServeTCP(ln); err != nil {
+				logger.Error("StatsD TCP server failed", zap.Error(err))
+			}
+		}()
+		logger.Info("Starting StatsD TCP listener", zap.String("address", parsed.StatsDTCPAddress))
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	tracked := checker.Track("statsd")
+	go func() {
+		defer close(done)
+		defer tracked()
+		listener.Run(ctx)
+	}()
+	return func() {
+		closeAll()
+		cancel()
+		<-done
+	}, nil
+}
+
+// Инициируем хендлеры. Если tlsConfig задан, сервер принимает только TLS соединения.
+func InitializeHTTPServer(parsed *flags.InitedFlags, storeMetrics storage.MetricsStorage, tlsConfig *tls.Config, alerts *alerting.Engine, logger *zap.Logger, selfMetrics *telemetry.Metrics, checker *health.Checker, limiter *ratelimit.Limiter, keys *keyring.Keyring, guard *replay.Guard) (*http.Server, error) {
 	vhandler := handler.NewHandler(storeMetrics, parsed)
+	vhandler.SetAlertEngine(alerts)
+	vhandler.SetLogger(logger)
+	vhandler.SetTelemetry(selfMetrics)
+	vhandler.SetHealth(checker)
+	vhandler.SetRateLimiter(limiter)
+	vhandler.SetKeyring(keys)
+	vhandler.SetReplayGuard(guard)
+	router, err := server.InitRouter(*vhandler, *parsed)
+	if err != nil {
+		return nil, err
+	}
 	httpserver := &http.Server{
-		Addr:    parsed.Endpoint,
-		Handler: server.InitRouter(*vhandler, *parsed),
+		Addr:      parsed.Endpoint,
+		Handler:   router,
+		TLSConfig: tlsConfig,
 	}
-	return httpserver
+	return httpserver, nil
 }
 
-func GracefulShutdown(httpserver *http.Server, memStore config.MementoStorage, memStoreOk bool, parsed *flags.InitedFlags) {
+// Инициируем gRPC сервис поверх того же хранилища
+func InitializeGRPCServer(parsed *flags.InitedFlags, storeMetrics storage.MetricsStorage, tlsConfig *tls.Config, limiter *ratelimit.Limiter, keys *keyring.Keyring, guard *replay.Guard) *grpc.Server {
+	if tlsConfig != nil {
+		return grpcserver.NewServer(parsed, storeMetrics, limiter, keys, guard, grpc.Creds(credentials.NewTLS(tlsConfig)))
+	}
+	return grpcserver.NewServer(parsed, storeMetrics, limiter, keys, guard)
+}
+
+// Останавливаем сервер: сначала /readyz начинает отвечать 503 и в течение ShutdownDelay слушатель еще принимает
+// запросы, пока балансировщик выводит сервер. Затем закрываются HTTP и gRPC серверы, останавливается фоновая
+// работа stopBackground, и последним сохраняется снимок хранилища.
+func GracefulShutdown(httpserver *http.Server, grpcServer *grpc.Server, checker *health.Checker, stopBackground func(), memStore config.MementoStorage, memStoreOk bool, parsed *flags.InitedFlags, logger *zap.Logger) {
+	checker.SetShuttingDown()
+	if parsed.ShutdownDelay > 0 {
+		logger.Info("Readiness disabled, waiting before shutdown", zap.Duration("delay", parsed.ShutdownDelay))
+		time.Sleep(parsed.ShutdownDelay)
+	}
+
 	// Timeout for active connections to close
 	shutdownTimeout := 5 * time.Second
 	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
 	defer cancel()
 
 	if err := httpserver.Shutdown(ctx); err != nil {
-		log.Fatalf("Server shutdown failed: %v", err)
+		logger.Fatal("Server shutdown failed", zap.Error(err))
 	}
+	grpcServer.GracefulStop()
+	stopBackground()
 
 	if memStoreOk {
 		config.SaveMetricsToFile(memStore, parsed.FileStorage)
 	}
 
-	fmt.Println("Server stopped gracefully")
+	logger.Info("Server stopped gracefully")
 }
@@ -4,10 +4,14 @@
 package main
 
 import (
+	"context"
 	"testing"
+	"time"
 
 	"github.com/Arcadian-Sky/musthave-metrics/internal/server/flags"
+	"github.com/Arcadian-Sky/musthave-metrics/internal/server/health"
 	"github.com/Arcadian-Sky/musthave-metrics/internal/server/storage"
+	"github.com/Arcadian-Sky/musthave-metrics/internal/server/storage/inmemory"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -74,3 +78,16 @@ func TestInitializeConfig(t *testing.T) {
 		})
 	}
 }
+
+func TestInitializeHealth(t *testing.T) {
+	parsed := &flags.InitedFlags{StorageType: "inmemory", FileStorage: "/tmp/metrics-db.json", StoreInterval: time.Minute}
+	checker := InitializeHealth(parsed, inmemory.NewMemStorage())
+
+	report := checker.Readiness(context.Background())
+	assert.Equal(t, health.StatusOK, report.Status)
+	assert.Equal(t, "inmemory", report.Components["storage"].Details["type"])
+	assert.Contains(t, report.Components, "memento")
+
+	checker.SetShuttingDown()
+	assert.Equal(t, health.StatusFail, checker.Readiness(context.Background()).Status)
+}
@@ -4,6 +4,7 @@ go 1.22
 
 require (
 	github.com/DATA-DOG/go-sqlmock v1.5.2
+	github.com/andybalholm/brotli v1.0.6
 	github.com/cenkalti/backoff/v4 v4.3.0
 	github.com/fatih/errwrap v1.6.0
 	github.com/go-chi/chi/v5 v5.0.14
@@ -11,6 +12,7 @@ require (
 	github.com/jackc/pgerrcode v0.0.0-20240316143900-6e2875d9b438
 	github.com/jackc/pgx/v5 v5.6.0
 	github.com/joho/godotenv v1.5.1
+	github.com/klauspost/compress v1.17.2
 	github.com/masibw/goone v1.4.1
 	github.com/pressly/goose/v3 v3.21.1
 	github.com/shirou/gopsutil v3.21.11+incompatible
@@ -18,7 +20,10 @@ require (
 	github.com/swaggo/http-swagger v1.3.4
 	github.com/swaggo/swag v1.16.3
 	go.uber.org/zap v1.27.0
+	golang.org/x/time v0.5.0
 	golang.org/x/tools v0.23.0
+	google.golang.org/grpc v1.65.0
+	google.golang.org/protobuf v1.34.2
 	honnef.co/go/tools v0.4.7
 )
 
@@ -53,6 +58,7 @@ require (
 	golang.org/x/sync v0.7.0 // indirect
 	golang.org/x/sys v0.22.0 // indirect
 	golang.org/x/text v0.16.0 // indirect
+	google.golang.org/genproto/googleapis/rpc v0.0.0-20240528184218-531527333157 // indirect
 	gopkg.in/yaml.v2 v2.4.0 // indirect
 	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
@@ -0,0 +1,217 @@
+package collector
+
+import (
+	"context"
+	"math/rand"
+	"os"
+	"runtime"
+	"strconv"
+	"time"
+
+	"github.com/shirou/gopsutil/cpu"
+	"github.com/shirou/gopsutil/disk"
+	"github.com/shirou/gopsutil/load"
+	"github.com/shirou/gopsutil/mem"
+	"github.com/shirou/gopsutil/net"
+	"github.com/shirou/gopsutil/process"
+)
+
+func init() {
+	Register(Runtime, func() Collector { return runtimeCollector{} })
+	Register(Memory, func() Collector { return memoryCollector{} })
+	Register(CPU, func() Collector { return cpuCollector{percent: cpu.PercentWithContext} })
+	Register(Disk, func() Collector { return diskCollector{path: "/"} })
+	Register(Network, func() Collector { return networkCollector{} })
+	Register(Load, func() Collector { return loadCollector{} })
+	Register(Process, func() Collector { return &processCollector{pid: int32(os.Getpid())} })
+}
+
+// runtimeCollector собирает статистику памяти Go из runtime.MemStats и RandomValue
+type runtimeCollector struct{}
+
+func (runtimeCollector) Name() string { return Runtime }
+
+func (runtimeCollector) Collect(context.Context) (map[string]float64, error) {
+	var memStats runtime.MemStats
+	runtime.ReadMemStats(&memStats)
+
+	return map[string]float64{
+		"Alloc":         float64(memStats.Alloc),
+		"BuckHashSys":   float64(memStats.BuckHashSys),
+		"Frees":         float64(memStats.Frees),
+		"GCCPUFraction": memStats.GCCPUFraction,
+		"GCSys":         float64(memStats.GCSys),
+		"HeapAlloc":     float64(memStats.HeapAlloc),
+		"HeapIdle":      float64(memStats.HeapIdle),
+		"HeapInuse":     float64(memStats.HeapInuse),
+		"HeapObjects":   float64(memStats.HeapObjects),
+		"HeapReleased":  float64(memStats.HeapReleased),
+		"HeapSys":       float64(memStats.HeapSys),
+		"LastGC":        float64(memStats.LastGC),
+		"Lookups":       float64(memStats.Lookups),
+		"MCacheInuse":   float64(memStats.MCacheInuse),
+		"MCacheSys":     float64(memStats.MCacheSys),
+		"MSpanInuse":    float64(memStats.MSpanInuse),
+		"MSpanSys":      float64(memStats.MSpanSys),
+		"Mallocs":       float64(memStats.Mallocs),
+		"NextGC":        float64(memStats.NextGC),
+		"NumForcedGC":   float64(memStats.NumForcedGC),
+		"NumGC":         float64(memStats.NumGC),
+		"OtherSys":      float64(memStats.OtherSys),
+		"PauseTotalNs":  float64(memStats.PauseTotalNs),
+		"StackInuse":    float64(memStats.StackInuse),
+		"StackSys":      float64(memStats.StackSys),
+		"Sys":           float64(memStats.Sys),
+		"TotalAlloc":    float64(memStats.TotalAlloc),
+		// Произвольное значение
+		"RandomValue": rand.Float64(),
+	}, nil
+}
+
+// memoryCollector собирает сведения о памяти системы
+type memoryCollector struct{}
+
+func (memoryCollector) Name() string { return Memory }
+
+func (memoryCollector) Collect(ctx context.Context) (map[string]float64, error) {
+	memoryInfo, err := mem.VirtualMemoryWithContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]float64{
+		"TotalMemory": float64(memoryInfo.Total),
+		"FreeMemory":  float64(memoryInfo.Free),
+	}, nil
+}
+
+// percentFunc возвращает загрузку процессоров в процентах, сигнатура cpu.PercentWithContext
+type percentFunc func(ctx context.Context, interval time.Duration, percpu bool) ([]float64, error)
+
+// cpuCollector собирает загрузку каждого логического процессора в CPUutilization1..N
+// и общую загрузку в CPUutilization.
+//
+// cpu.Percent вызывается с нулевым интервалом: загрузка считается по разнице времен процессора
+// с предыдущего вызова, то есть за интервал опроса, и опрос не ждет целый интервал.
+// Первый вызов считает загрузку с момента запуска агента.
+type cpuCollector struct {
+	percent percentFunc
+}
+
+func (cpuCollector) Name() string { return CPU }
+
+func (c cpuCollector) Collect(ctx context.Context) (map[string]float64, error) {
+	perCPU, err := c.percent(ctx, 0, true)
+	if err != nil {
+		return nil, err
+	}
+	total, err := c.percent(ctx, 0, false)
+	if err != nil {
+		return nil, err
+	}
+	metrics := make(map[string]float64, len(perCPU)+1)
+	for i, value := range perCPU {
+		metrics["CPUutilization"+strconv.Itoa(i+1)] = value
+	}
+	if len(total) > 0 {
+		metrics["CPUutilization"] = total[0]
+	}
+	return metrics, nil
+}
+
+// diskCollector собирает заполненность файловой системы, в которой находится path
+type diskCollector struct {
+	path string
+}
+
+func (diskCollector) Name() string { return Disk }
+
+func (c diskCollector) Collect(ctx context.Context) (map[string]float64, error) {
+	usage, err := disk.UsageWithContext(ctx, c.path)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]float64{
+		"DiskTotal":       float64(usage.Total),
+		"DiskFree":        float64(usage.Free),
+		"DiskUsedPercent": usage.UsedPercent,
+	}, nil
+}
+
+// networkCollector собирает счетчики всех сетевых интерфейсов в сумме.
+// Значения накопительные с момента загрузки системы.
+type networkCollector struct{}
+
+func (networkCollector) Name() string { return Network }
+
+func (networkCollector) Collect(ctx context.Context) (map[string]float64, error) {
+	counters, err := net.IOCountersWithContext(ctx, false)
+	if err != nil {
+		return nil, err
+	}
+	metrics := make(map[string]float64, 6)
+	for _, c := range counters {
+		metrics["NetBytesSent"] += float64(c.BytesSent)
+		metrics["NetBytesRecv"] += float64(c.BytesRecv)
+		metrics["NetPacketsSent"] += float64(c.PacketsSent)
+		metrics["NetPacketsRecv"] += float64(c.PacketsRecv)
+		metrics["NetErrIn"] += float64(c.Errin)
+		metrics["NetErrOut"] += float64(c.Errout)
+	}
+	return metrics, nil
+}
+
+// loadCollector собирает среднюю загрузку системы
+type loadCollector struct{}
+
+func (loadCollector) Name() string { return Load }
+
+func (loadCollector) Collect(ctx context.Context) (map[string]float64, error) {
+	avg, err := load.AvgWithContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]float64{
+		"Load1":  avg.Load1,
+		"Load5":  avg.Load5,
+		"Load15": avg.Load15,
+	}, nil
+}
+
+// processCollector собирает статистику процесса агента
+type processCollector struct {
+	pid  int32
+	proc *process.Process
+}
+
+func (*processCollector) Name() string { return Process }
+
+func (c *processCollector) Collect(ctx context.Context) (map[string]float64, error) {
+	if c.proc == nil {
+		proc, err := process.NewProcessWithContext(ctx, c.pid)
+		if err != nil {
+			return nil, err
+		}
+		c.proc = proc
+	}
+	memInfo, err := c.proc.MemoryInfoWithContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	metrics := map[string]float64{
+		"ProcessRSS":        float64(memInfo.RSS),
+		"ProcessVMS":        float64(memInfo.VMS),
+		"ProcessGoroutines": float64(runtime.NumGoroutine()),
+	}
+	// Число потоков и дескрипторов доступно не на всех платформах
+	if threads, err := c.proc.NumThreadsWithContext(ctx); err == nil {
+		metrics["ProcessThreads"] = float64(threads)
+	}
+	if fds, err := c.proc.NumFDsWithContext(ctx); err == nil {
+		metrics["ProcessFDs"] = float64(fds)
+	}
+	// Доля CPU с момента запуска процесса
+	if percent, err := c.proc.CPUPercentWithContext(ctx); err == nil {
+		metrics["ProcessCPUPercent"] = percent
+	}
+	return metrics, nil
+}
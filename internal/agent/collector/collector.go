@@ -0,0 +1,137 @@
+// Пакет collector описывает источники метрик агента и их реестр.
+//
+// Каждый источник реализует Collector и регистрируется под своим именем через Register,
+// обычно в функции init. Набор включенных источников задается в конфигурации агента,
+// поэтому новый источник не требует изменений в контроллере:
+//
+//	func init() {
+//		collector.Register("queue", func() collector.Collector { return &queueCollector{} })
+//	}
+package collector
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Collector источник метрик типа gauge
+type Collector interface {
+	// Name возвращает имя, под которым источник зарегистрирован
+	Name() string
+	// Collect возвращает текущие значения метрик источника
+	Collect(ctx context.Context) (map[string]float64, error)
+}
+
+// Factory создает источник метрик
+type Factory func() Collector
+
+// Имена встроенных источников
+const (
+	Runtime = "runtime"
+	Memory  = "memory"
+	CPU     = "cpu"
+	Disk    = "disk"
+	Network = "network"
+	Load    = "load"
+	Process = "process"
+)
+
+// Default источники, включенные по умолчанию
+var Default = []string{Runtime, Memory, CPU}
+
+var (
+	mu       sync.RWMutex
+	registry = make(map[string]Factory)
+)
+
+// Register добавляет источник в реестр. Повторная регистрация имени приводит к панике,
+// как и регистрация драйвера в database/sql.
+func Register(name string, factory Factory) {
+	mu.Lock()
+	defer mu.Unlock()
+	if factory == nil {
+		panic("collector: Register factory is nil")
+	}
+	if _, dup := registry[name]; dup {
+		panic("collector: Register called twice for " + name)
+	}
+	registry[name] = factory
+}
+
+// Names возвращает отсортированные имена зарегистрированных источников
+func Names() []string {
+	mu.RLock()
+	defer mu.RUnlock()
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Registered сообщает, зарегистрирован ли источник
+func Registered(name string) bool {
+	mu.RLock()
+	defer mu.RUnlock()
+	_, ok := registry[name]
+	return ok
+}
+
+// Select применяет к списку Default включения и отключения из конфигурации
+// и возвращает имена включенных источников в порядке Names
+func Select(overrides map[string]bool) ([]string, error) {
+	enabled := make(map[string]bool, len(Default)+len(overrides))
+	for _, name := range Default {
+		enabled[name] = true
+	}
+	for name, on := range overrides {
+		if !Registered(name) {
+			return nil, fmt.Errorf("неизвестный источник метрик %q, доступны: %s", name, strings.Join(Names(), ", "))
+		}
+		enabled[name] = on
+	}
+	names := make([]string, 0, len(enabled))
+	for _, name := range Names() {
+		if enabled[name] {
+			names = append(names, name)
+		}
+	}
+	return names, nil
+}
+
+// New создает источники по именам
+func New(names []string) ([]Collector, error) {
+	mu.RLock()
+	defer mu.RUnlock()
+	collectors := make([]Collector, 0, len(names))
+	for _, name := range names {
+		factory, ok := registry[name]
+		if !ok {
+			return nil, fmt.Errorf("неизвестный источник метрик %q", name)
+		}
+		collectors = append(collectors, factory())
+	}
+	return collectors, nil
+}
+
+// ParseOverrides разбирает список вида disk,network,-memory:
+// имя включает источник, имя с минусом отключает
+func ParseOverrides(s string) map[string]bool {
+	overrides := make(map[string]bool)
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		if name, off := strings.CutPrefix(item, "-"); off {
+			overrides[name] = false
+			continue
+		}
+		overrides[strings.TrimPrefix(item, "+")] = true
+	}
+	return overrides
+}
@@ -0,0 +1,125 @@
+package collector
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type staticCollector struct{}
+
+func (staticCollector) Name() string { return "static" }
+
+func (staticCollector) Collect(context.Context) (map[string]float64, error) {
+	return map[string]float64{"Static": 1}, nil
+}
+
+func TestRegister(t *testing.T) {
+	Register("static", func() Collector { return staticCollector{} })
+	// Реестр общий для пакета: убираем запись, чтобы тест можно было повторить через -count
+	t.Cleanup(func() {
+		mu.Lock()
+		defer mu.Unlock()
+		delete(registry, "static")
+	})
+	assert.True(t, Registered("static"))
+	assert.Contains(t, Names(), "static")
+	assert.Panics(t, func() {
+		Register("static", func() Collector { return staticCollector{} })
+	})
+
+	collectors, err := New([]string{"static"})
+	assert.NoError(t, err)
+	assert.Len(t, collectors, 1)
+	metrics, err := collectors[0].Collect(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]float64{"Static": 1}, metrics)
+
+	_, err = New([]string{"missing"})
+	assert.Error(t, err)
+}
+
+func TestSelect(t *testing.T) {
+	names, err := Select(nil)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{CPU, Memory, Runtime}, names)
+
+	names, err = Select(map[string]bool{Disk: true, Network: true, Memory: false})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{CPU, Disk, Network, Runtime}, names)
+
+	_, err = Select(map[string]bool{"missing": true})
+	assert.Error(t, err)
+}
+
+func TestParseOverrides(t *testing.T) {
+	assert.Equal(t, map[string]bool{"disk": true, "network": true, "memory": false}, ParseOverrides(" disk, +network,-memory,,"))
+	assert.Empty(t, ParseOverrides(""))
+}
+
+func TestBuiltin(t *testing.T) {
+	tests := []struct {
+		name string
+		want []string
+	}{
+		{name: Runtime, want: []string{"Alloc", "HeapSys", "RandomValue"}},
+		{name: Memory, want: []string{"TotalMemory", "FreeMemory"}},
+		{name: CPU, want: []string{"CPUutilization1", "CPUutilization"}},
+		{name: Disk, want: []string{"DiskTotal", "DiskFree", "DiskUsedPercent"}},
+		{name: Process, want: []string{"ProcessRSS", "ProcessGoroutines"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			collectors, err := New([]string{tt.name})
+			assert.NoError(t, err)
+			assert.Equal(t, tt.name, collectors[0].Name())
+			metrics, err := collectors[0].Collect(context.Background())
+			assert.NoError(t, err)
+			for _, key := range tt.want {
+				assert.Contains(t, metrics, key)
+			}
+		})
+	}
+}
+
+// fakeCPU источник загрузки процессоров для тестов
+type fakeCPU struct {
+	perCPU    []float64
+	total     float64
+	err       error
+	intervals []time.Duration
+}
+
+func (f *fakeCPU) percent(_ context.Context, interval time.Duration, percpu bool) ([]float64, error) {
+	f.intervals = append(f.intervals, interval)
+	if f.err != nil {
+		return nil, f.err
+	}
+	if percpu {
+		return f.perCPU, nil
+	}
+	return []float64{f.total}, nil
+}
+
+func TestCPUCollector(t *testing.T) {
+	source := &fakeCPU{perCPU: []float64{12.5, 80, 0}, total: 30.8}
+	c := cpuCollector{percent: source.percent}
+
+	metrics, err := c.Collect(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]float64{
+		"CPUutilization1": 12.5,
+		"CPUutilization2": 80,
+		"CPUutilization3": 0,
+		"CPUutilization":  30.8,
+	}, metrics)
+	// Загрузка считается с предыдущего вызова, опрос не ждет интервал
+	assert.Equal(t, []time.Duration{0, 0}, source.intervals)
+
+	source.err = errors.New("no /proc/stat")
+	_, err = c.Collect(context.Background())
+	assert.Error(t, err)
+}
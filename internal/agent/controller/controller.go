@@ -6,80 +6,156 @@ import (
 	"sync"
 	"time"
 
+	"go.uber.org/zap"
+
+	"github.com/Arcadian-Sky/musthave-metrics/internal/agent/collector"
 	senderPack "github.com/Arcadian-Sky/musthave-metrics/internal/agent/controller/sender"
 	"github.com/Arcadian-Sky/musthave-metrics/internal/agent/flags"
 	"github.com/Arcadian-Sky/musthave-metrics/internal/agent/models"
 	"github.com/Arcadian-Sky/musthave-metrics/internal/agent/repository"
+	"github.com/Arcadian-Sky/musthave-metrics/internal/agent/spool"
 )
 
 type CollectAndSendMetricsService struct {
-	config flags.Config
-	sender *senderPack.Sender
-	stopCh chan struct{}
-	Wg     sync.WaitGroup
+	config     flags.Config
+	sender     *senderPack.Sender
+	spool      *spool.Spool
+	sendMu     sync.Mutex // при включенной очереди отправка идет по одной пачке, см. sendPack
+	collectors []collector.Collector
+	log        *zap.Logger
+	stopCh     chan struct{}
+	Wg         sync.WaitGroup
 }
 
 func NewCollectAndSendMetricsService(conf *flags.Config) *CollectAndSendMetricsService {
-	return &CollectAndSendMetricsService{
+	c := &CollectAndSendMetricsService{
 		config: *conf,
 		sender: senderPack.NewSender(conf),
+		log:    zap.L(),
 		stopCh: make(chan struct{}),
 	}
+	if dir, maxSize, maxAge := conf.GetSpool(); dir != "" {
+		s, err := spool.New(dir, maxSize, maxAge)
+		if err != nil {
+			c.logger().Warn("Очередь неотправленных пачек отключена", zap.Error(err))
+		} else {
+			c.spool = s
+		}
+	}
+	collectors, err := collector.New(conf.GetCollectors())
+	if err != nil {
+		c.logger().Warn("Используются источники метрик по умолчанию", zap.Error(err))
+	} else {
+		c.collectors = collectors
+	}
+	return c
+}
+
+// SetLogger задает логгер контроллера, его отправителя и очереди
+func (c *CollectAndSendMetricsService) SetLogger(logger *zap.Logger) {
+	if logger == nil {
+		return
+	}
+	c.log = logger
+	c.sender.SetLogger(logger)
+	if c.spool != nil {
+		c.spool.SetLogger(logger)
+	}
 }
 
+// logger возвращает логгер контроллера, без заданного - глобальный логгер zap
+func (c *CollectAndSendMetricsService) logger() *zap.Logger {
+	if c.log == nil {
+		return zap.L()
+	}
+	return c.log
+}
+
+// Run опрашивает метрики раз в PollInterval и отправляет последний снимок раз в ReportInterval.
+// Опрос и отправка работают в отдельных горутинах и не задерживают друг друга.
 func (c *CollectAndSendMetricsService) Run(ctx context.Context) {
-	var pollCount int64
-	metricsRepo := repository.NewInMemoryMetricsRepository()
-	// Отправляем метрики на сервер
-	fmt.Println("send")
+	metricsRepo := repository.NewInMemoryMetricsRepository(c.collectors...)
+	metricsRepo.SetLogger(c.logger())
 	c.Wg.Add(2)
+
+	// Собираем метрики
 	go func() {
 		defer c.Wg.Done()
+		ticker := time.NewTicker(interval(c.config.GetPollInterval()))
+		defer ticker.Stop()
 		for {
+			c.poll(metricsRepo)
 			select {
 			case <-ctx.Done():
-				fmt.Printf("Горутина 1 остановлена.\n")
+				c.logger().Debug("Горутина опроса остановлена")
 				return
-			default:
-				if c.config.GetRateLimit() == 0 {
-					metrics, err := metricsRepo.GetMetrics()
-					if err != nil {
-						fmt.Println("Error collecting metrics:", err)
-						return
-					}
-					c.Push(metrics, &pollCount)
-				} else {
-					c.Init(metricsRepo, &pollCount)
-				}
-
-				time.Sleep(c.config.GetPollInterval())
+			case <-ticker.C:
 			}
-
 		}
 	}()
 
-	// Собираем метрики
+	// Отправляем метрики на сервер
 	go func() {
 		defer c.Wg.Done()
-		for {
+		c.report(ctx, metricsRepo)
+		c.logger().Debug("Горутина отправки остановлена")
+	}()
+
+	<-ctx.Done()
+	c.logger().Debug("Горутина run остановлена")
+}
+
+// report отправляет снимок метрик раз в ReportInterval.
+// При RateLimit больше нуля снимки отправляют воркеры, одновременно не больше RateLimit запросов.
+// С очередью снимки отправляются по одному в порядке снятия, иначе воркеры могли бы переставить пачки.
+// При остановке последний снимок отправляется с отмененным контекстом и попадает в очередь, если она включена.
+func (c *CollectAndSendMetricsService) report(ctx context.Context, metricsRepo *repository.InMemoryMetricsRepository) {
+	push := func(r pollReport) {
+		c.Push(ctx, r.metrics, r.polls)
+	}
+	if c.config.GetRateLimit() > 0 && c.spool == nil {
+		jobs, wait := c.Init(ctx)
+		defer wait()
+		defer close(jobs)
+		push = func(r pollReport) {
 			select {
+			case jobs <- r:
 			case <-ctx.Done():
-				fmt.Printf("Горутина 2 остановлена.\n")
-				return
-			default:
-				// fmt.Println("updateMterics")
-				_, err := metricsRepo.GetMetrics()
-				if err != nil {
-					// fmt.Println("Error collecting metrics:", err)
-					return
-				}
-				time.Sleep(c.config.GetPollInterval())
+				c.Push(ctx, r.metrics, r.polls)
 			}
 		}
-	}()
+	}
 
-	<-ctx.Done()
-	fmt.Printf("Горутина run остановлена.\n")
+	ticker := time.NewTicker(interval(c.config.GetReportInterval()))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			if metrics, polls := metricsRepo.Snapshot(); polls > 0 && c.spool != nil {
+				c.Push(ctx, metrics, polls)
+			}
+			return
+		case <-ticker.C:
+			metrics, polls := metricsRepo.Snapshot()
+			if len(metrics) == 0 {
+				continue
+			}
+			push(pollReport{metrics: metrics, polls: polls})
+		}
+	}
+}
+
+// interval заменяет неположительный интервал секундой, time.NewTicker не принимает ноль
+func interval(d time.Duration) time.Duration {
+	if d <= 0 {
+		return time.Second
+	}
+	return d
+}
+
+// Close освобождает ресурсы отправителя
+func (c *CollectAndSendMetricsService) Close() error {
+	return c.sender.Close()
 }
 
 func (c *CollectAndSendMetricsService) makePack(metrics map[string]interface{}, pollCount int64) []interface{} {
@@ -87,40 +163,72 @@ func (c *CollectAndSendMetricsService) makePack(metrics map[string]interface{},
 	for metricType, value := range metrics {
 		mValue := value.(float64)
 		forSend = append(forSend, models.Metrics{
-			ID:    metricType,
-			MType: "gauge",
-			Value: &mValue,
+			ID:     metricType,
+			MType:  "gauge",
+			Value:  &mValue,
+			Labels: c.config.GetLabels(),
 		})
 	}
 	mValue := int64(pollCount)
 	forSend = append(forSend, models.Metrics{
-		ID:    "PollCount",
-		MType: "counter",
-		Delta: &mValue,
+		ID:     "PollCount",
+		MType:  "counter",
+		Delta:  &mValue,
+		Labels: c.config.GetLabels(),
 	})
 
 	return forSend
 }
 
-// Отправляем метрики
-func (c *CollectAndSendMetricsService) send(metrics map[string]interface{}, pollCount int64) error {
+// sendPack отправляет снимок одной пачкой после пачек из очереди. В очередь пачка попадает, только если
+// сервер ее точно не применил, см. sender.Retryable: иначе при повторе приращения счетчиков учлись бы дважды.
+// Досылка очереди и отправка новой пачки выполняются под одной блокировкой, чтобы новая пачка
+// не дошла до сервера раньше старых и старые значения gauge не перезаписали новые.
+func (c *CollectAndSendMetricsService) sendPack(ctx context.Context, metrics map[string]interface{}, pollCount int64) error {
 	var forSend = c.makePack(metrics, pollCount)
-	for _, metric := range forSend {
-		err := c.sender.SendMetricJSON(metric, senderPack.UpdatePathOne)
-		if err != nil {
-			return err
-		}
+	if c.spool == nil {
+		return c.sender.SendMetricJSON(ctx, forSend, senderPack.UpdatePathPack)
 	}
+	c.sendMu.Lock()
+	defer c.sendMu.Unlock()
 
-	return nil
+	// Сначала по порядку досылаем пачки из очереди, чтобы новая пачка не обогнала старые
+	err := c.spool.Replay(func(batch []models.Metrics) error {
+		return c.replayBatch(ctx, batch)
+	})
+	if err == nil {
+		err = c.sender.SendMetricJSON(ctx, forSend, senderPack.UpdatePathPack)
+	}
+	if err == nil || !senderPack.Retryable(err) {
+		return err
+	}
+	if spoolErr := c.spool.Put(toBatch(forSend)); spoolErr != nil {
+		return fmt.Errorf("%w; пачка потеряна: %v", err, spoolErr)
+	}
+	return fmt.Errorf("пачка сохранена в очередь: %w", err)
 }
 
-func (c *CollectAndSendMetricsService) sendPack(metrics map[string]interface{}, pollCount int64) error {
-	var forSend = c.makePack(metrics, pollCount)
-	err := c.sender.SendMetricJSON(forSend, senderPack.UpdatePathPack)
-	if err != nil {
-		return err
+// replayBatch отправляет пачку из очереди. Пачка, которую сервер отверг как некорректную или мог уже
+// применить, удаляется из очереди: первая блокировала бы все последующие, повтор второй учел бы счетчики дважды.
+func (c *CollectAndSendMetricsService) replayBatch(ctx context.Context, batch []models.Metrics) error {
+	pack := make([]interface{}, 0, len(batch))
+	for _, m := range batch {
+		pack = append(pack, m)
+	}
+	err := c.sender.SendMetricJSON(ctx, pack, senderPack.UpdatePathPack)
+	if err != nil && !senderPack.Retryable(err) {
+		c.logger().Warn("Пачка из очереди удалена: сервер отверг ее или мог уже применить", zap.Error(err))
+		return nil
 	}
+	return err
+}
 
-	return nil
+func toBatch(pack []interface{}) []models.Metrics {
+	batch := make([]models.Metrics, 0, len(pack))
+	for _, item := range pack {
+		if m, ok := item.(models.Metrics); ok {
+			batch = append(batch, m)
+		}
+	}
+	return batch
 }
@@ -1,14 +1,22 @@
 package controller
 
 import (
+	"context"
+	"encoding/json"
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"sync"
 	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
 
 	"github.com/Arcadian-Sky/musthave-metrics/internal/agent/controller/sender"
 	"github.com/Arcadian-Sky/musthave-metrics/internal/agent/flags"
 	"github.com/Arcadian-Sky/musthave-metrics/internal/agent/models"
+	"github.com/Arcadian-Sky/musthave-metrics/internal/agent/spool"
+	"github.com/Arcadian-Sky/musthave-metrics/internal/compression"
 )
 
 func TestNewCollectAndSendMetricsService(t *testing.T) {
@@ -55,15 +63,6 @@ func TestNewCollectAndSendMetricsService(t *testing.T) {
 	}
 }
 
-// func TestCollectAndSendMetricsService_send(t *testing.T) {
-// 	metrics := make(map[string]interface{})
-// 	pollCount := int64(10) // Пример значения pollCount
-
-// 	service := NewCollectAndSendMetricsService(flags.SetDefault())
-// 	err := service.send(metrics, pollCount)
-// 	assert.Error(t, err, "Функция должна вернуть errоr")
-// }
-
 func TestCollectAndSendMetricsService_sendMetricValue(t *testing.T) {
 	type fields struct {
 		// config flags.Config
@@ -186,8 +185,16 @@ func TestSendMetricJSONValues(t *testing.T) {
 		if contentType != "application/json" {
 			t.Errorf("Expected Content-Type 'application/json', got '%s'", contentType)
 		}
+		// Агент по умолчанию сжимает тело в gzip
+		if encoding := r.Header.Get("Content-Encoding"); encoding != compression.Gzip {
+			t.Errorf("Expected Content-Encoding 'gzip', got '%s'", encoding)
+		}
+		zr, err := compression.NewReader(r.Header.Get("Content-Encoding"), r.Body)
+		if err != nil {
+			t.Fatalf("Failed to decompress request body: %v", err)
+		}
 		// Читаем тело запроса
-		body, err := io.ReadAll(r.Body)
+		body, err := io.ReadAll(zr)
 		if err != nil {
 			t.Fatalf("Failed to read request body: %v", err)
 		}
@@ -214,7 +221,7 @@ func TestSendMetricJSONValues(t *testing.T) {
 	var fl200 = float64(200)
 	var de5 = int64(5)
 	// // Тестируемый вызов
-	err := c.sender.SendMetricJSON([]interface{}{
+	err := c.sender.SendMetricJSON(context.Background(), []interface{}{
 		models.Metrics{ID: "metric1", MType: "gauge", Value: &fl100},
 		models.Metrics{ID: "metric2", MType: "gauge", Value: &fl200},
 		models.Metrics{ID: "PollCount", MType: "counter", Delta: &de5},
@@ -223,3 +230,174 @@ func TestSendMetricJSONValues(t *testing.T) {
 		t.Errorf("sendMetricJSONValues failed: %v", err)
 	}
 }
+
+func TestSendPackSpool(t *testing.T) {
+	var (
+		mu        sync.Mutex
+		down      = true
+		pollTotal int64
+		allocs    []float64
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if down {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		var batch []models.Metrics
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&batch))
+		for _, m := range batch {
+			switch m.ID {
+			case "PollCount":
+				pollTotal += *m.Delta
+			case "Alloc":
+				allocs = append(allocs, *m.Value)
+			}
+		}
+	}))
+	defer srv.Close()
+
+	conf := flags.SetDefault()
+	conf.SetConfigServer(srv.URL)
+	s, err := spool.New(t.TempDir(), 0, 0)
+	assert.NoError(t, err)
+	c := &CollectAndSendMetricsService{sender: sender.NewSender(conf), spool: s}
+
+	assert.Error(t, c.sendPack(context.Background(), map[string]interface{}{"Alloc": 1.0}, 1))
+	assert.Error(t, c.sendPack(context.Background(), map[string]interface{}{"Alloc": 2.0}, 2))
+	assert.Equal(t, 2, s.Len())
+
+	mu.Lock()
+	down = false
+	mu.Unlock()
+
+	assert.NoError(t, c.sendPack(context.Background(), map[string]interface{}{"Alloc": 3.0}, 3))
+	assert.Equal(t, 0, s.Len())
+	assert.Equal(t, []float64{1, 2, 3}, allocs)
+	assert.Equal(t, int64(6), pollTotal)
+}
+
+func TestSendPackRejectedNotSpooled(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer srv.Close()
+
+	conf := flags.SetDefault()
+	conf.SetConfigServer(srv.URL)
+	s, err := spool.New(t.TempDir(), 0, 0)
+	assert.NoError(t, err)
+	c := &CollectAndSendMetricsService{sender: sender.NewSender(conf), spool: s}
+
+	assert.Error(t, c.sendPack(context.Background(), map[string]interface{}{"Alloc": 1.0}, 1))
+	assert.Equal(t, 0, s.Len())
+}
+
+// TestSendPackAppliedThenFailed проверяет, что пачка, которую сервер применил, но не смог ответить,
+// не повторяется и не попадает в очередь, и PollCount учитывается на сервере один раз
+func TestSendPackAppliedThenFailed(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		fail func(w http.ResponseWriter)
+	}{
+		{name: "500", fail: func(w http.ResponseWriter) { w.WriteHeader(http.StatusInternalServerError) }},
+		{name: "connection reset", fail: func(w http.ResponseWriter) {
+			if conn, _, err := http.NewResponseController(w).Hijack(); err == nil {
+				conn.Close()
+			}
+		}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			var (
+				mu        sync.Mutex
+				requests  int
+				pollTotal int64
+			)
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				mu.Lock()
+				defer mu.Unlock()
+				var batch []models.Metrics
+				assert.NoError(t, json.NewDecoder(r.Body).Decode(&batch))
+				for _, m := range batch {
+					if m.ID == "PollCount" {
+						pollTotal += *m.Delta
+					}
+				}
+				requests++
+				if requests == 1 {
+					tt.fail(w)
+				}
+			}))
+			defer srv.Close()
+
+			conf := flags.SetDefault()
+			conf.SetConfigServer(srv.URL)
+			s, err := spool.New(t.TempDir(), 0, 0)
+			assert.NoError(t, err)
+			c := &CollectAndSendMetricsService{sender: sender.NewSender(conf), spool: s}
+
+			assert.Error(t, c.sendPack(context.Background(), map[string]interface{}{"Alloc": 1.0}, 2))
+			assert.Equal(t, 0, s.Len())
+			assert.NoError(t, c.sendPack(context.Background(), map[string]interface{}{"Alloc": 2.0}, 3))
+
+			mu.Lock()
+			defer mu.Unlock()
+			assert.Equal(t, 2, requests)
+			assert.Equal(t, int64(5), pollTotal)
+		})
+	}
+}
+
+// TestSendPackSerialized проверяет, что новая пачка не уходит на сервер, пока досылается очередь
+func TestSendPackSerialized(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		inFlight int
+		maxIn    int
+		allocs   []float64
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		inFlight++
+		maxIn = max(maxIn, inFlight)
+		mu.Unlock()
+		time.Sleep(50 * time.Millisecond)
+		var batch []models.Metrics
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&batch))
+		mu.Lock()
+		defer mu.Unlock()
+		inFlight--
+		for _, m := range batch {
+			if m.ID == "Alloc" {
+				allocs = append(allocs, *m.Value)
+			}
+		}
+	}))
+	defer srv.Close()
+
+	conf := flags.SetDefault()
+	conf.SetConfigServer(srv.URL)
+	s, err := spool.New(t.TempDir(), 0, 0)
+	assert.NoError(t, err)
+	one := 1.0
+	assert.NoError(t, s.Put([]models.Metrics{{ID: "Alloc", MType: "gauge", Value: &one}}))
+	c := &CollectAndSendMetricsService{sender: sender.NewSender(conf), spool: s}
+
+	var wg sync.WaitGroup
+	for _, alloc := range []float64{2, 3} {
+		wg.Add(1)
+		go func(alloc float64) {
+			defer wg.Done()
+			assert.NoError(t, c.sendPack(context.Background(), map[string]interface{}{"Alloc": alloc}, 1))
+		}(alloc)
+		time.Sleep(10 * time.Millisecond)
+	}
+	wg.Wait()
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, 1, maxIn)
+	assert.Len(t, allocs, 3)
+	assert.Equal(t, 1.0, allocs[0])
+}
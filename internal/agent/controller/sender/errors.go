@@ -0,0 +1,82 @@
+package sender
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ErrResponseSignature подпись ответа сервера отсутствует или не совпадает.
+// Сервер уже принял пачку, поэтому такая ошибка не повторяется, иначе счетчики учлись бы дважды.
+var ErrResponseSignature = errors.New("подпись ответа сервера не совпадает")
+
+// StatusError ответ сервера с кодом ошибки
+type StatusError struct {
+	Code       int
+	RetryAfter time.Duration // пауза, которую сервер просит выдержать перед повтором
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("сервер ответил статусом %d", e.Code)
+}
+
+// RetryAfterError ответ ResourceExhausted сервера gRPC с паузой из заголовка retry-after,
+// аналог StatusError с кодом 429
+type RetryAfterError struct {
+	Err        error
+	RetryAfter time.Duration // пауза, которую сервер просит выдержать перед повтором
+}
+
+func (e *RetryAfterError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *RetryAfterError) Unwrap() error {
+	return e.Err
+}
+
+// ErrNotSent запрос не дошел до сервера: соединение не установлено или контекст отменен до отправки.
+// Такой запрос можно повторить или сохранить в очередь, не рискуя учесть счетчики дважды.
+var ErrNotSent = errors.New("запрос не отправлен")
+
+// notSent помечает ошибку как ErrNotSent
+func notSent(err error) error {
+	return fmt.Errorf("%w: %w", ErrNotSent, err)
+}
+
+// dialFailed сообщает, что соединение с сервером не установлено и запрос не отправлен
+func dialFailed(err error) bool {
+	var opErr *net.OpError
+	if errors.As(err, &opErr) && opErr.Op == "dial" {
+		return true
+	}
+	var dnsErr *net.DNSError
+	return errors.As(err, &dnsErr)
+}
+
+// Retryable сообщает, имеет ли смысл повторить отправку позже. Повторяются только запросы, которые
+// сервер точно не применил: не отправленные (ErrNotSent) и отклоненные до обработки ответами 429 и 503
+// или ResourceExhausted в gRPC. После таймаута, обрыва соединения, других ответов 5xx и Unavailable
+// сервер мог уже применить пачку, а приращения счетчиков при повторе учлись бы дважды,
+// поэтому такие ошибки, как и остальные ответы 4xx, не повторяются.
+func Retryable(err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, ErrNotSent) {
+		return true
+	}
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.Code == http.StatusTooManyRequests || statusErr.Code == http.StatusServiceUnavailable
+	}
+	if st, ok := status.FromError(err); ok {
+		return st.Code() == codes.ResourceExhausted
+	}
+	return false
+}
@@ -0,0 +1,46 @@
+package sender
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestRetryable(t *testing.T) {
+	assert.False(t, Retryable(nil))
+	assert.True(t, Retryable(notSent(errors.New("connection refused"))))
+	assert.True(t, Retryable(notSent(context.Canceled)))
+	assert.True(t, Retryable(fmt.Errorf("wrapped: %w", &StatusError{Code: http.StatusTooManyRequests})))
+	assert.True(t, Retryable(&StatusError{Code: http.StatusServiceUnavailable}))
+	assert.True(t, Retryable(status.Error(codes.ResourceExhausted, "slow down")))
+	// Сервер мог уже применить пачку
+	assert.False(t, Retryable(errors.New("connection reset by peer")))
+	assert.False(t, Retryable(context.DeadlineExceeded))
+	assert.False(t, Retryable(&StatusError{Code: http.StatusInternalServerError}))
+	assert.False(t, Retryable(&StatusError{Code: http.StatusBadGateway}))
+	assert.False(t, Retryable(status.Error(codes.Unavailable, "down")))
+	// Сервер отверг данные
+	assert.False(t, Retryable(&StatusError{Code: http.StatusBadRequest}))
+	assert.False(t, Retryable(&StatusError{Code: http.StatusForbidden}))
+	assert.False(t, Retryable(status.Error(codes.InvalidArgument, "bad")))
+}
+
+func TestDialFailed(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := listener.Addr().String()
+	require.NoError(t, listener.Close())
+
+	_, err = http.Post("http://"+addr+"/updates", "application/json", nil)
+	require.Error(t, err)
+	assert.True(t, dialFailed(err))
+	assert.False(t, dialFailed(errors.New("connection reset by peer")))
+}
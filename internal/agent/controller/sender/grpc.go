@@ -0,0 +1,194 @@
+package sender
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/cenkalti/backoff/v4"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	protobuf "google.golang.org/protobuf/proto"
+
+	"github.com/Arcadian-Sky/musthave-metrics/internal/agent/models"
+	"github.com/Arcadian-Sky/musthave-metrics/internal/keyring"
+	"github.com/Arcadian-Sky/musthave-metrics/internal/proto"
+)
+
+// newGRPCClient создает клиента gRPC. Соединение устанавливается лениво и переиспользуется
+// для всех последующих отправок. Без tlsConfig соединение не шифруется.
+func newGRPCClient(address string, tlsConfig *tls.Config) (*grpc.ClientConn, proto.MetricsServiceClient, error) {
+	creds := insecure.NewCredentials()
+	if tlsConfig != nil {
+		creds = credentials.NewTLS(tlsConfig)
+	}
+	conn, err := grpc.NewClient(address, grpc.WithTransportCredentials(creds))
+	if err != nil {
+		return nil, nil, err
+	}
+	return conn, proto.NewMetricsServiceClient(conn), nil
+}
+
+// sendGRPC отправляет метрику или пачку метрик через gRPC
+func (s *Sender) sendGRPC(ctx context.Context, m any, method string) error {
+	if s.grpcClient == nil {
+		return backoff.Permanent(fmt.Errorf("gRPC client is not initialized"))
+	}
+	ctx, cancel := context.WithTimeout(ctx, s.retry.RequestTimeout)
+	defer cancel()
+	if err := s.grpcReady(ctx); err != nil {
+		return err
+	}
+	if s.realIP != "" {
+		ctx = metadata.AppendToOutgoingContext(ctx, proto.RealIPMetadataKey, s.realIP)
+	}
+
+	switch method {
+	case UpdatePathOne:
+		metric, ok := m.(models.Metrics)
+		if !ok {
+			return fmt.Errorf("unsupported metric type %T", m)
+		}
+		req := &proto.UpdateRequest{Metric: toProto(metric)}
+		ctx, nonce, err := s.signGRPC(ctx, proto.MetricsService_Update_FullMethodName, req)
+		if err != nil {
+			return err
+		}
+		var header metadata.MD
+		resp, err := s.grpcClient.Update(ctx, req, grpc.Header(&header))
+		if err != nil {
+			return grpcError(err, header)
+		}
+		return s.verifyGRPC(header, nonce, resp)
+	case UpdatePathPack:
+		pack, ok := m.([]interface{})
+		if !ok {
+			return fmt.Errorf("unsupported pack type %T", m)
+		}
+		req := &proto.UpdateBatchRequest{Metrics: make([]*proto.Metric, 0, len(pack))}
+		for _, item := range pack {
+			metric, ok := item.(models.Metrics)
+			if !ok {
+				return fmt.Errorf("unsupported metric type %T", item)
+			}
+			req.Metrics = append(req.Metrics, toProto(metric))
+		}
+		ctx, nonce, err := s.signGRPC(ctx, proto.MetricsService_UpdateBatch_FullMethodName, req)
+		if err != nil {
+			return err
+		}
+		var header metadata.MD
+		resp, err := s.grpcClient.UpdateBatch(ctx, req, grpc.Header(&header))
+		if err != nil {
+			return grpcError(err, header)
+		}
+		return s.verifyGRPC(header, nonce, resp)
+	default:
+		return fmt.Errorf("unsupported method %s", method)
+	}
+}
+
+// grpcError дополняет ответ ResourceExhausted паузой из заголовка retry-after, см. RetryAfterError
+func grpcError(err error, header metadata.MD) error {
+	if status.Code(err) != codes.ResourceExhausted {
+		return err
+	}
+	return &RetryAfterError{Err: err, RetryAfter: parseRetryAfter(firstValue(header, proto.RetryAfterMetadataKey), time.Now())}
+}
+
+// grpcReady дожидается готовности соединения gRPC перед отправкой. Если соединение не установлено,
+// запрос не отправляется и возвращается ErrNotSent: ответ Unavailable на уже отправленный запрос
+// не отличить от неудачного подключения, а повторять можно только неотправленные запросы.
+func (s *Sender) grpcReady(ctx context.Context) error {
+	if s.grpcConn == nil {
+		return nil
+	}
+	s.grpcConn.Connect()
+	for {
+		state := s.grpcConn.GetState()
+		switch state {
+		case connectivity.Ready:
+			return nil
+		case connectivity.TransientFailure, connectivity.Shutdown:
+			return notSent(fmt.Errorf("соединение gRPC в состоянии %s", state))
+		}
+		if !s.grpcConn.WaitForStateChange(ctx, state) {
+			return notSent(ctx.Err())
+		}
+	}
+}
+
+// signGRPC добавляет в метаданные подпись HMAC-SHA256 полного имени метода и сериализованного запроса
+// вместе со временем и nonce, см. sign, и идентификатор ключа. Вызывается при каждой попытке отправки.
+// Возвращает nonce попытки для проверки подписи ответа.
+func (s *Sender) signGRPC(ctx context.Context, fullMethod string, req protobuf.Message) (context.Context, string, error) {
+	if s.keys.Len() == 0 {
+		return ctx, "", nil
+	}
+	body, err := protobuf.MarshalOptions{Deterministic: true}.Marshal(req)
+	if err != nil {
+		return ctx, "", err
+	}
+	timestamp, nonce, signature, err := s.sign(http.MethodPost, fullMethod, body)
+	if err != nil {
+		return ctx, "", err
+	}
+	ctx = metadata.AppendToOutgoingContext(ctx,
+		proto.HashMetadataKey, signature,
+		proto.HashTimestampMetadataKey, timestamp,
+		proto.HashNonceMetadataKey, nonce,
+	)
+	if s.keyID != "" {
+		ctx = metadata.AppendToOutgoingContext(ctx, proto.HashKeyIDMetadataKey, s.keyID)
+	}
+	return ctx, nonce, nil
+}
+
+// verifyGRPC проверяет подпись ответа из заголовков gRPC вместе с nonce запроса, см. verifyResponse
+func (s *Sender) verifyGRPC(header metadata.MD, nonce string, resp protobuf.Message) error {
+	if s.keys.Len() == 0 {
+		return nil
+	}
+	body, err := protobuf.MarshalOptions{Deterministic: true}.Marshal(resp)
+	if err != nil {
+		return err
+	}
+	if id := firstValue(header, proto.HashKeyIDMetadataKey); id != s.keyID {
+		return backoff.Permanent(fmt.Errorf("%w: ключ %q вместо %q", ErrResponseSignature, id, s.keyID))
+	}
+	if err := s.keys.Verify(s.keyID, firstValue(header, proto.HashMetadataKey), keyring.ResponsePayload(nonce, body)); err != nil {
+		return backoff.Permanent(fmt.Errorf("%w: %v", ErrResponseSignature, err))
+	}
+	return nil
+}
+
+func firstValue(md metadata.MD, key string) string {
+	if values := md.Get(key); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+func toProto(metric models.Metrics) *proto.Metric {
+	pm := &proto.Metric{
+		Id:     metric.ID,
+		Labels: metric.Labels,
+	}
+	if metric.MType == "counter" {
+		pm.Type = proto.MType_COUNTER
+	}
+	if metric.Delta != nil {
+		pm.Delta = *metric.Delta
+	}
+	if metric.Value != nil {
+		pm.Value = *metric.Value
+	}
+	return pm
+}
@@ -0,0 +1,44 @@
+package sender
+
+import (
+	"net"
+	"net/url"
+	"strings"
+)
+
+// realIPHeader заголовок, по которому сервер проверяет доверенную подсеть агента
+const realIPHeader = "X-Real-IP"
+
+// outboundIP возвращает адрес интерфейса, через который уходят запросы к серверу.
+// UDP сокет не отправляет пакетов, он нужен только чтобы ядро выбрало маршрут.
+// Если маршрут определить не удалось, возвращается первый адрес не loopback интерфейса.
+func outboundIP(serverAddress string) string {
+	host := serverAddress
+	if strings.Contains(host, "://") {
+		if u, err := url.Parse(host); err == nil {
+			host = u.Host
+		}
+	}
+	if _, _, err := net.SplitHostPort(host); err != nil {
+		host = net.JoinHostPort(host, "80")
+	}
+
+	conn, err := net.Dial("udp", host)
+	if err == nil {
+		defer conn.Close()
+		if addr, ok := conn.LocalAddr().(*net.UDPAddr); ok {
+			return addr.IP.String()
+		}
+	}
+
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return ""
+	}
+	for _, addr := range addrs {
+		if ipNet, ok := addr.(*net.IPNet); ok && !ipNet.IP.IsLoopback() {
+			return ipNet.IP.String()
+		}
+	}
+	return ""
+}
@@ -0,0 +1,13 @@
+package sender
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOutboundIP(t *testing.T) {
+	assert.Equal(t, "127.0.0.1", outboundIP("http://127.0.0.1:8080"))
+	assert.Equal(t, "127.0.0.1", outboundIP("127.0.0.1:3200"))
+	assert.Equal(t, "127.0.0.1", outboundIP("127.0.0.1"))
+}
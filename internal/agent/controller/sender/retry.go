@@ -0,0 +1,108 @@
+package sender
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/cenkalti/backoff/v4"
+	"go.uber.org/zap"
+)
+
+// retryAfterBackOff экспоненциальная пауза со случайным разбросом, которую заменяет
+// пауза из заголовка Retry-After последнего ответа, если сервер ее указал, см. retryAfter
+type retryAfterBackOff struct {
+	*backoff.ExponentialBackOff
+	retryAfter time.Duration
+}
+
+func (b *retryAfterBackOff) NextBackOff() time.Duration {
+	next := b.ExponentialBackOff.NextBackOff()
+	if next == backoff.Stop || b.retryAfter <= 0 {
+		return next
+	}
+	next, b.retryAfter = b.retryAfter, 0
+	if b.MaxElapsedTime != 0 && b.GetElapsedTime()+next > b.MaxElapsedTime {
+		return backoff.Stop
+	}
+	return next
+}
+
+// withRetry выполняет attempt, повторяя его при временных ошибках, см. Retryable.
+// Повторы прекращаются по истечении MaxElapsedTime или при отмене ctx. Если ctx отменен во время
+// паузы, возвращается ошибка последней попытки: она повторяемая, значит, сервер пачку не применил.
+// Без настроенных пауз выполняется одна попытка.
+func (s *Sender) withRetry(ctx context.Context, attempt func(context.Context) error) error {
+	if s.retry.InitialInterval <= 0 || s.retry.MaxElapsedTime <= 0 {
+		err := attempt(ctx)
+		var permanent *backoff.PermanentError
+		if errors.As(err, &permanent) {
+			return permanent.Err
+		}
+		return err
+	}
+	exp := backoff.NewExponentialBackOff()
+	exp.InitialInterval = s.retry.InitialInterval
+	exp.MaxInterval = s.retry.MaxInterval
+	if exp.MaxInterval < exp.InitialInterval {
+		exp.MaxInterval = exp.InitialInterval
+	}
+	exp.MaxElapsedTime = s.retry.MaxElapsedTime
+	b := &retryAfterBackOff{ExponentialBackOff: exp}
+
+	var last error
+	operation := func() error {
+		err := attempt(ctx)
+		if err == nil {
+			return nil
+		}
+		last = err
+		if !Retryable(err) {
+			return backoff.Permanent(err)
+		}
+		b.retryAfter = retryAfter(err)
+		return err
+	}
+	notify := func(err error, next time.Duration) {
+		s.logger().Warn("Ошибка отправки, повтор", zap.Duration("next", next.Round(time.Millisecond)), zap.Error(err))
+	}
+	err := backoff.RetryNotify(operation, backoff.WithContext(b, ctx), notify)
+	if err != nil && last != nil && Retryable(last) && ctx.Err() != nil && errors.Is(err, ctx.Err()) {
+		return fmt.Errorf("%w: %w", last, err)
+	}
+	return err
+}
+
+// retryAfter возвращает паузу, которую сервер попросил выдержать перед повтором:
+// из заголовка Retry-After ответа HTTP или из метаданных retry-after ответа gRPC
+func retryAfter(err error) time.Duration {
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.RetryAfter
+	}
+	var retryErr *RetryAfterError
+	if errors.As(err, &retryErr) {
+		return retryErr.RetryAfter
+	}
+	return 0
+}
+
+// parseRetryAfter разбирает заголовок Retry-After: число секунд или дату HTTP
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0
+		}
+		return time.Duration(seconds) * time.Second
+	}
+	if at, err := http.ParseTime(value); err == nil && at.After(now) {
+		return at.Sub(now)
+	}
+	return 0
+}
@@ -0,0 +1,187 @@
+package sender
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/Arcadian-Sky/musthave-metrics/internal/agent/flags"
+	"github.com/Arcadian-Sky/musthave-metrics/internal/proto"
+)
+
+// newRetrySender создает отправителя на тестовый сервер и считает попытки
+func newRetrySender(t *testing.T, retry flags.RetryConfig, handler func(attempt int32, w http.ResponseWriter)) (*Sender, *int32) {
+	var attempts int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handler(atomic.AddInt32(&attempts, 1), w)
+	}))
+	t.Cleanup(srv.Close)
+	return &Sender{
+		serverAddress: srv.URL,
+		client:        srv.Client(),
+		retry:         retry,
+	}, &attempts
+}
+
+var fastRetry = flags.RetryConfig{
+	RequestTimeout:  time.Second,
+	InitialInterval: 10 * time.Millisecond,
+	MaxInterval:     20 * time.Millisecond,
+	MaxElapsedTime:  time.Second,
+}
+
+func TestSendMetricJSON_RetriesServerErrors(t *testing.T) {
+	s, attempts := newRetrySender(t, fastRetry, func(attempt int32, w http.ResponseWriter) {
+		if attempt < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	})
+
+	assert.NoError(t, s.SendMetricJSON(context.Background(), []interface{}{}, UpdatePathPack))
+	assert.Equal(t, int32(3), atomic.LoadInt32(attempts))
+}
+
+// TestSendMetricJSON_NoRetryAfterApply проверяет, что запрос не повторяется, если сервер мог его уже
+// применить: ответ 500 или обрыв соединения после чтения тела
+func TestSendMetricJSON_NoRetryAfterApply(t *testing.T) {
+	s, attempts := newRetrySender(t, fastRetry, func(_ int32, w http.ResponseWriter) {
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+	assert.Error(t, s.SendMetricJSON(context.Background(), []interface{}{}, UpdatePathPack))
+	assert.Equal(t, int32(1), atomic.LoadInt32(attempts))
+
+	s, attempts = newRetrySender(t, fastRetry, func(_ int32, w http.ResponseWriter) {
+		conn, _, err := http.NewResponseController(w).Hijack()
+		if assert.NoError(t, err) {
+			conn.Close()
+		}
+	})
+	err := s.SendMetricJSON(context.Background(), []interface{}{}, UpdatePathPack)
+	assert.Error(t, err)
+	assert.False(t, Retryable(err))
+	assert.Equal(t, int32(1), atomic.LoadInt32(attempts))
+}
+
+func TestSendMetricJSON_RetriesDialErrors(t *testing.T) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	srv.Close()
+	s := &Sender{serverAddress: srv.URL, client: &http.Client{}, retry: fastRetry}
+	s.retry.MaxElapsedTime = 50 * time.Millisecond
+
+	err := s.SendMetricJSON(context.Background(), []interface{}{}, UpdatePathPack)
+	assert.ErrorIs(t, err, ErrNotSent)
+	assert.True(t, Retryable(err))
+}
+
+func TestSendMetricJSON_NoRetryOnClientError(t *testing.T) {
+	s, attempts := newRetrySender(t, fastRetry, func(_ int32, w http.ResponseWriter) {
+		w.WriteHeader(http.StatusBadRequest)
+	})
+
+	err := s.SendMetricJSON(context.Background(), []interface{}{}, UpdatePathPack)
+	var statusErr *StatusError
+	assert.ErrorAs(t, err, &statusErr)
+	assert.Equal(t, http.StatusBadRequest, statusErr.Code)
+	assert.Equal(t, int32(1), atomic.LoadInt32(attempts))
+}
+
+func TestSendMetricJSON_MaxElapsedTime(t *testing.T) {
+	retry := fastRetry
+	retry.MaxElapsedTime = 100 * time.Millisecond
+	s, attempts := newRetrySender(t, retry, func(_ int32, w http.ResponseWriter) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})
+
+	start := time.Now()
+	err := s.SendMetricJSON(context.Background(), []interface{}{}, UpdatePathPack)
+	assert.Less(t, time.Since(start), time.Second)
+	var statusErr *StatusError
+	assert.ErrorAs(t, err, &statusErr)
+	assert.Greater(t, atomic.LoadInt32(attempts), int32(1))
+}
+
+func TestSendMetricJSON_RetryAfter(t *testing.T) {
+	retry := fastRetry
+	retry.MaxElapsedTime = 3 * time.Second
+	s, attempts := newRetrySender(t, retry, func(attempt int32, w http.ResponseWriter) {
+		if attempt == 1 {
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusTooManyRequests)
+		}
+	})
+
+	start := time.Now()
+	assert.NoError(t, s.SendMetricJSON(context.Background(), []interface{}{}, UpdatePathPack))
+	assert.GreaterOrEqual(t, time.Since(start), time.Second)
+	assert.Equal(t, int32(2), atomic.LoadInt32(attempts))
+}
+
+// Пауза из метаданных retry-after ответа ResourceExhausted gRPC учитывается так же, как Retry-After в HTTP
+func TestWithRetry_GRPCRetryAfter(t *testing.T) {
+	retry := fastRetry
+	retry.MaxElapsedTime = 3 * time.Second
+	s := &Sender{retry: retry}
+	attempts := 0
+	start := time.Now()
+	err := s.withRetry(context.Background(), func(context.Context) error {
+		attempts++
+		if attempts == 1 {
+			return grpcError(status.Error(codes.ResourceExhausted, "slow down"), metadata.Pairs(proto.RetryAfterMetadataKey, "1"))
+		}
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.GreaterOrEqual(t, time.Since(start), time.Second)
+	assert.Equal(t, 2, attempts)
+
+	// Другие коды не меняются
+	unavailable := status.Error(codes.Unavailable, "down")
+	assert.Equal(t, unavailable, grpcError(unavailable, metadata.Pairs(proto.RetryAfterMetadataKey, "1")))
+}
+
+func TestSendMetricJSON_RetryAfterBeyondBudget(t *testing.T) {
+	s, attempts := newRetrySender(t, fastRetry, func(_ int32, w http.ResponseWriter) {
+		w.Header().Set("Retry-After", "60")
+		w.WriteHeader(http.StatusTooManyRequests)
+	})
+
+	start := time.Now()
+	err := s.SendMetricJSON(context.Background(), []interface{}{}, UpdatePathPack)
+	assert.Error(t, err)
+	assert.Less(t, time.Since(start), time.Second)
+	assert.Equal(t, int32(1), atomic.LoadInt32(attempts))
+}
+
+func TestSendMetricJSON_ContextCancel(t *testing.T) {
+	retry := fastRetry
+	retry.InitialInterval = time.Second
+	retry.MaxElapsedTime = 10 * time.Second
+	s, _ := newRetrySender(t, retry, func(_ int32, w http.ResponseWriter) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	err := s.SendMetricJSON(ctx, []interface{}{}, UpdatePathPack)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Less(t, time.Since(start), time.Second)
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	assert.Equal(t, time.Duration(0), parseRetryAfter("", now))
+	assert.Equal(t, 5*time.Second, parseRetryAfter("5", now))
+	assert.Equal(t, time.Duration(0), parseRetryAfter("-5", now))
+	assert.Equal(t, 30*time.Second, parseRetryAfter(now.Add(30*time.Second).Format(http.TimeFormat), now))
+	assert.Equal(t, time.Duration(0), parseRetryAfter(now.Add(-time.Minute).Format(http.TimeFormat), now))
+	assert.Equal(t, time.Duration(0), parseRetryAfter("soon", now))
+}
@@ -2,124 +2,287 @@ package sender
 
 import (
 	"bytes"
-	"crypto/hmac"
-	"crypto/rand"
+	"context"
 	"crypto/rsa"
-	"crypto/sha256"
-	"encoding/hex"
 	"encoding/json"
 	"fmt"
-	"log"
+	"io"
 	"net/http"
+	"strconv"
 	"time"
 
+	"github.com/cenkalti/backoff/v4"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+
 	"github.com/Arcadian-Sky/musthave-metrics/internal/agent/flags"
+	"github.com/Arcadian-Sky/musthave-metrics/internal/compression"
+	"github.com/Arcadian-Sky/musthave-metrics/internal/envelope"
+	"github.com/Arcadian-Sky/musthave-metrics/internal/keyring"
+	"github.com/Arcadian-Sky/musthave-metrics/internal/proto"
 )
 
 const UpdatePathOne = "/update"
 const UpdatePathPack = "/updates"
 
 type Sender struct {
-	getHash       string
+	keys          *keyring.Keyring
+	keyID         string
 	serverAddress string
 	cryptoKey     *rsa.PublicKey
+	client        *http.Client
+	realIP        string
+	retry         flags.RetryConfig
+	compress      string
+	transport     string
+	grpcConn      *grpc.ClientConn
+	grpcClient    proto.MetricsServiceClient
+	log           *zap.Logger
 }
 
 func NewSender(config *flags.Config) *Sender {
 	cKp, ok := config.GetCryptoKeyPath()
 	sender := Sender{
-		getHash:       config.GetHash(),
+		keyID:         config.GetHashKeyID(),
 		serverAddress: config.GetServerAddress(),
+		transport:     config.GetTransport(),
+		retry:         config.GetRetry(),
+		compress:      config.GetCompress(),
+		log:           zap.L(),
+	}
+	sender.client = &http.Client{
+		Timeout: sender.retry.RequestTimeout,
 	}
 	if ok {
 		sender.cryptoKey = cKp
 	}
+	if hashKey := config.GetHash(); hashKey != "" {
+		keys, err := keyring.New(keyring.Key{ID: sender.keyID, Secret: hashKey})
+		if err != nil {
+			sender.log.Error("Ошибка в ключе подписи", zap.Error(err))
+		}
+		sender.keys = keys
+	}
+	sender.realIP = outboundIP(sender.serverAddress)
+	tlsConfig, err := config.GetTLSConfig()
+	if err != nil {
+		sender.log.Error("Ошибка при загрузке настроек TLS", zap.Error(err))
+	}
+	if tlsConfig != nil {
+		sender.client.Transport = &http.Transport{TLSClientConfig: tlsConfig}
+	}
+	if sender.transport == flags.TransportGRPC {
+		sender.realIP = outboundIP(config.GetGRPCAddress())
+		conn, client, err := newGRPCClient(config.GetGRPCAddress(), tlsConfig)
+		if err != nil {
+			sender.log.Error("Ошибка при создании gRPC клиента", zap.Error(err))
+		} else {
+			sender.grpcConn = conn
+			sender.grpcClient = client
+		}
+	}
 	return &sender
 }
 
-// Отправляем запрос на сервер
-func (s *Sender) SendMetricJSON(m any, method string) error {
-	client := &http.Client{
-		Timeout: 2 * time.Second,
+// SetLogger задает логгер отправителя
+func (s *Sender) SetLogger(logger *zap.Logger) {
+	if logger != nil {
+		s.log = logger
+	}
+}
+
+// logger возвращает логгер отправителя, без заданного - глобальный логгер zap
+func (s *Sender) logger() *zap.Logger {
+	if s.log == nil {
+		return zap.L()
+	}
+	return s.log
+}
+
+// Close закрывает соединение gRPC, если оно было открыто
+func (s *Sender) Close() error {
+	if s.grpcConn != nil {
+		return s.grpcConn.Close()
+	}
+	return nil
+}
+
+// Отправляем запрос на сервер. При временных ошибках запрос повторяется, см. withRetry.
+func (s *Sender) SendMetricJSON(ctx context.Context, m any, method string) error {
+	if s.transport == flags.TransportGRPC {
+		return s.withRetry(ctx, func(ctx context.Context) error {
+			return s.sendGRPC(ctx, m, method)
+		})
 	}
 	jsonData, err := json.Marshal(m)
 	if err != nil {
-		fmt.Println("Error marshaling metrics:", err)
-		return err
+		return fmt.Errorf("error marshaling metrics: %w", err)
+	}
+	if ce := s.logger().Check(zap.DebugLevel, "Отправка метрик"); ce != nil {
+		ce.Write(zap.String("path", method), zap.ByteString("body", jsonData))
 	}
-	fmt.Printf("m: %v\n", string(jsonData))
-	fmt.Printf("m: %v\n", []byte(jsonData))
 
 	// Формируем адрес запроса
 	url := fmt.Sprintf("%s"+method, s.serverAddress)
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(jsonData))
+	body, header, err := s.prepareBody(jsonData)
 	if err != nil {
 		return err
 	}
 
-	if s.cryptoKey != nil {
-		// Шифруем данные
-		encryptedMessage, err := s.encryptMessage([]byte(jsonData), s.cryptoKey)
+	return s.withRetry(ctx, func(ctx context.Context) error {
+		if err := ctx.Err(); err != nil {
+			return notSent(err)
+		}
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
 		if err != nil {
-			log.Fatalf("Ошибка при шифровании сообщения: %v", err)
+			return backoff.Permanent(err)
 		}
-		req, err = http.NewRequest("POST", url, bytes.NewBuffer(encryptedMessage))
+		req.Header = header.Clone()
+		// Время и nonce у каждой попытки свои, иначе сервер отклонит повтор как replay
+		if err := s.signRequest(req, jsonData); err != nil {
+			return backoff.Permanent(err)
+		}
+
+		resp, err := s.client.Do(req)
 		if err != nil {
+			if dialFailed(err) {
+				return notSent(err)
+			}
 			return err
 		}
+		defer resp.Body.Close()
+		if resp.StatusCode >= http.StatusBadRequest {
+			return &StatusError{
+				Code:       resp.StatusCode,
+				RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After"), time.Now()),
+			}
+		}
+		return s.verifyResponse(resp)
+	})
+}
+
+// verifyResponse проверяет подпись ответа сервера тем же ключом, которым подписан запрос,
+// вместе с nonce этой попытки, см. keyring.ResponsePayload. Без ключа подписи ответ не проверяется.
+func (s *Sender) verifyResponse(resp *http.Response) error {
+	if s.keys.Len() == 0 {
+		return nil
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.Header.Get(keyring.KeyIDHeader) != s.keyID {
+		return backoff.Permanent(fmt.Errorf("%w: ключ %q вместо %q", ErrResponseSignature, resp.Header.Get(keyring.KeyIDHeader), s.keyID))
+	}
+	var nonce string
+	if resp.Request != nil {
+		nonce = resp.Request.Header.Get(keyring.NonceHeader)
+	}
+	if err := s.keys.Verify(s.keyID, resp.Header.Get(keyring.HashHeader), keyring.ResponsePayload(nonce, body)); err != nil {
+		return backoff.Permanent(fmt.Errorf("%w: %v", ErrResponseSignature, err))
 	}
+	return nil
+}
 
-	// Создание HTTP-запроса POST
-	req.Header.Set("Content-Type", "application/json")
+// prepareBody готовит тело запроса и заголовки. Порядок преобразований:
+//  1. подпись HashSHA256 считается по исходному JSON ключом HashKeyID, см. signRequest;
+//  2. JSON сжимается, кодировка передается в Content-Encoding;
+//  3. сжатое тело шифруется, сеансовый ключ передается в envelope.KeyHeader.
+//
+// Сервер выполняет обратные шаги: расшифровывает, распаковывает и проверяет подпись.
+// Сжимать нужно до шифрования, зашифрованные данные не сжимаются.
+// Подпись добавляется к каждой попытке отдельно, поэтому prepareBody ее не считает.
+func (s *Sender) prepareBody(jsonData []byte) ([]byte, http.Header, error) {
+	body := jsonData
+	header := make(http.Header)
+	header.Set("Content-Type", "application/json")
+	if s.realIP != "" {
+		header.Set(realIPHeader, s.realIP)
+	}
 
-	hashKey := s.getHash
-	if hashKey != "" {
-		h := hmac.New(sha256.New, []byte(hashKey))
-		h.Write(jsonData)
-		dst := h.Sum(nil)
-		// fmt.Printf("dst: %v\n", hex.EncodeToString(dst))
-		req.Header.Set("HashSHA256", hex.EncodeToString(dst))
+	if s.compress != "" && s.compress != compression.None {
+		compressed, err := compression.Encode(s.compress, body)
+		if err != nil {
+			return nil, nil, fmt.Errorf("ошибка при сжатии сообщения: %w", err)
+		}
+		body = compressed
+		header.Set("Content-Encoding", s.compress)
 	}
 
-	resp, err := client.Do(req)
-	if err != nil {
-		return err
+	if s.cryptoKey != nil {
+		// Шифруем данные
+		encryptedMessage, encryptedKey, err := s.encryptMessage(body, s.cryptoKey)
+		if err != nil {
+			return nil, nil, fmt.Errorf("ошибка при шифровании сообщения: %w", err)
+		}
+		body = encryptedMessage
+		header.Set(envelope.KeyHeader, encryptedKey)
 	}
-	defer resp.Body.Close()
+	return body, header, nil
+}
 
-	//
-	// Отправляем запрос на сервер
-	// resp, err := client.Post(url, "application/json", bytes.NewBuffer(jsonData))
-	// if err != nil {
-	// 	fmt.Printf("Metrics did not sent: \n")
-	// 	return err
-	// }
-	defer resp.Body.Close()
+// sign подписывает метод, адрес и тело запроса вместе с текущим временем и новым nonce, см. keyring.Payload
+func (s *Sender) sign(method, uri string, body []byte) (timestamp, nonce, signature string, err error) {
+	nonce, err = keyring.NewNonce()
+	if err != nil {
+		return "", "", "", fmt.Errorf("ошибка при создании nonce: %w", err)
+	}
+	timestamp = strconv.FormatInt(time.Now().Unix(), 10)
+	signature, err = s.keys.Sign(s.keyID, keyring.Payload(method, uri, timestamp, nonce, body))
+	if err != nil {
+		return "", "", "", fmt.Errorf("ошибка при подписи сообщения: %w", err)
+	}
+	return timestamp, nonce, signature, nil
+}
 
+// signRequest добавляет в заголовки запроса подпись метода, адреса и тела, время, nonce и идентификатор ключа.
+// Без ключа подписи заголовки не меняются.
+func (s *Sender) signRequest(req *http.Request, body []byte) error {
+	if s.keys.Len() == 0 {
+		return nil
+	}
+	timestamp, nonce, signature, err := s.sign(req.Method, req.URL.RequestURI(), body)
+	if err != nil {
+		return err
+	}
+	header := req.Header
+	header.Set(keyring.HashHeader, signature)
+	header.Set(keyring.TimestampHeader, timestamp)
+	header.Set(keyring.NonceHeader, nonce)
+	if s.keyID != "" {
+		header.Set(keyring.KeyIDHeader, s.keyID)
+	}
 	return nil
 }
 
-func (s *Sender) encryptMessage(message []byte, publicKey *rsa.PublicKey) ([]byte, error) {
-	return rsa.EncryptPKCS1v15(rand.Reader, publicKey, message)
+// encryptMessage шифрует сообщение сеансовым ключом AES-GCM,
+// возвращает зашифрованное тело и сеансовый ключ, зашифрованный RSA-OAEP
+func (s *Sender) encryptMessage(message []byte, publicKey *rsa.PublicKey) ([]byte, string, error) {
+	return envelope.Encrypt(publicKey, message)
 }
 
 func (s *Sender) SendMetricValue(mType string, mName string, mValue interface{}) error {
-	client := &http.Client{
-		Timeout: 2 * time.Second,
-	}
-
 	// Формируем адрес запроса
 	url := fmt.Sprintf("%s/update/"+mType+"/%s/%v", s.serverAddress, mName, mValue)
+	req, err := http.NewRequest("POST", url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if s.realIP != "" {
+		req.Header.Set(realIPHeader, s.realIP)
+	}
+	// Сервер в строгом режиме принимает только подписанную запись, метрика передается в подписанном адресе
+	if err := s.signRequest(req, nil); err != nil {
+		return err
+	}
 
 	// Отправляем запрос на сервер
-	resp, err := client.Post(url, "application/json", nil)
+	resp, err := s.client.Do(req)
 	if err != nil {
-		fmt.Printf("Metric did not sent: %s\n", mName)
+		s.logger().Debug("Metric did not sent", zap.String("metric", mName), zap.Error(err))
 		return err
 	}
-
-	// Печатаем результат отправки (для демонстрации, лучше использовать логгер)
-	// fmt.Printf("Metric sent: %s\n", mName)
 	defer resp.Body.Close()
 
 	return nil
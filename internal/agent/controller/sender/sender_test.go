@@ -0,0 +1,223 @@
+package sender
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Arcadian-Sky/musthave-metrics/internal/compression"
+	"github.com/Arcadian-Sky/musthave-metrics/internal/envelope"
+	"github.com/Arcadian-Sky/musthave-metrics/internal/keyring"
+)
+
+func TestPrepareBody(t *testing.T) {
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	jsonData := bytes.Repeat([]byte(`{"id":"Alloc","type":"gauge","value":1.5},`), 50)
+
+	t.Run("plain", func(t *testing.T) {
+		s := &Sender{}
+		body, header, err := s.prepareBody(jsonData)
+		require.NoError(t, err)
+		assert.Equal(t, jsonData, body)
+		assert.Empty(t, header.Get("Content-Encoding"))
+		assert.Empty(t, header.Get("HashSHA256"))
+	})
+
+	for _, encoding := range []string{compression.Gzip, compression.Zstd} {
+		t.Run(encoding+" encrypted", func(t *testing.T) {
+			s := &Sender{keys: newKeys(t, "", "secret"), compress: encoding, cryptoKey: &privateKey.PublicKey}
+			body, header, err := s.prepareBody(jsonData)
+			require.NoError(t, err)
+			assert.Equal(t, encoding, header.Get("Content-Encoding"))
+			assert.Empty(t, header.Get("HashSHA256"), "подпись добавляется к каждой попытке, см. signRequest")
+
+			// Сервер сначала расшифровывает, затем распаковывает
+			compressed, err := envelope.Decrypt(privateKey, body, header.Get(envelope.KeyHeader))
+			require.NoError(t, err)
+			assert.Less(t, len(compressed), len(jsonData))
+			r, err := compression.NewReader(header.Get("Content-Encoding"), bytes.NewReader(compressed))
+			require.NoError(t, err)
+			got, err := io.ReadAll(r)
+			require.NoError(t, err)
+			assert.Equal(t, jsonData, got)
+		})
+	}
+
+	t.Run("none", func(t *testing.T) {
+		s := &Sender{compress: compression.None}
+		body, header, err := s.prepareBody(jsonData)
+		require.NoError(t, err)
+		assert.Equal(t, jsonData, body)
+		assert.Empty(t, header.Get("Content-Encoding"))
+	})
+}
+
+func TestSignRequest(t *testing.T) {
+	jsonData := []byte(`[{"id":"PollCount","type":"counter","delta":1}]`)
+	newRequest := func() *http.Request {
+		return httptest.NewRequest(http.MethodPost, "http://localhost:8080/updates/", nil)
+	}
+
+	t.Run("no key", func(t *testing.T) {
+		req := newRequest()
+		require.NoError(t, (&Sender{}).signRequest(req, jsonData))
+		assert.Empty(t, req.Header)
+	})
+
+	for _, id := range []string{"", "2024-05"} {
+		t.Run("key "+id, func(t *testing.T) {
+			s := &Sender{keys: newKeys(t, id, "secret"), keyID: id}
+			req := newRequest()
+			require.NoError(t, s.signRequest(req, jsonData))
+			header := req.Header
+			assert.Equal(t, id, header.Get("HashKeyID"))
+
+			// Подписываются метод, адрес, время, nonce и исходный JSON
+			timestamp, nonce := header.Get("HashTimestamp"), header.Get("HashNonce")
+			sec, err := strconv.ParseInt(timestamp, 10, 64)
+			require.NoError(t, err)
+			assert.WithinDuration(t, time.Now(), time.Unix(sec, 0), time.Minute)
+			h := hmac.New(sha256.New, []byte("secret"))
+			h.Write([]byte("POST\n/updates/\n" + timestamp + "\n" + nonce + "\n"))
+			h.Write(jsonData)
+			assert.Equal(t, hex.EncodeToString(h.Sum(nil)), header.Get("HashSHA256"))
+
+			next := newRequest()
+			require.NoError(t, s.signRequest(next, jsonData))
+			assert.NotEqual(t, nonce, next.Header.Get("HashNonce"), "nonce у каждого запроса новый")
+		})
+	}
+}
+
+// Повторная попытка подписывается заново, иначе сервер отклонил бы ее как повтор
+func TestSendMetricJSONFreshNonce(t *testing.T) {
+	keys := newKeys(t, "", "secret")
+	var nonces []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		payload := keyring.Payload(r.Method, r.URL.RequestURI(), r.Header.Get(keyring.TimestampHeader), r.Header.Get(keyring.NonceHeader), body)
+		assert.NoError(t, keys.Verify("", r.Header.Get(keyring.HashHeader), payload))
+		nonces = append(nonces, r.Header.Get(keyring.NonceHeader))
+		if len(nonces) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		signature, err := keys.Sign("", keyring.ResponsePayload(r.Header.Get(keyring.NonceHeader), []byte(`[]`)))
+		require.NoError(t, err)
+		w.Header().Set(keyring.HashHeader, signature)
+		_, _ = w.Write([]byte(`[]`))
+	}))
+	defer srv.Close()
+
+	s := &Sender{serverAddress: srv.URL, client: srv.Client(), keys: keys, retry: fastRetry}
+	require.NoError(t, s.SendMetricJSON(context.Background(), []interface{}{}, UpdatePathPack))
+	require.Len(t, nonces, 2)
+	assert.NotEqual(t, nonces[0], nonces[1])
+}
+
+func newKeys(t *testing.T, id, secret string) *keyring.Keyring {
+	keys, err := keyring.New(keyring.Key{ID: id, Secret: secret})
+	require.NoError(t, err)
+	return keys
+}
+
+func TestSendMetricJSONVerifiesResponse(t *testing.T) {
+	server := newKeys(t, "2024-05", "secret")
+	tests := []struct {
+		name    string
+		sign    func(w http.ResponseWriter, nonce string, body []byte)
+		wantErr bool
+	}{
+		{
+			name: "signed",
+			sign: func(w http.ResponseWriter, nonce string, body []byte) {
+				signature, err := server.Sign("2024-05", keyring.ResponsePayload(nonce, body))
+				require.NoError(t, err)
+				w.Header().Set(keyring.HashHeader, signature)
+				w.Header().Set(keyring.KeyIDHeader, "2024-05")
+			},
+		},
+		{
+			name:    "unsigned",
+			sign:    func(http.ResponseWriter, string, []byte) {},
+			wantErr: true,
+		},
+		{
+			// Подписанный ответ на другой запрос
+			name: "other nonce",
+			sign: func(w http.ResponseWriter, _ string, body []byte) {
+				signature, err := server.Sign("2024-05", keyring.ResponsePayload("other", body))
+				require.NoError(t, err)
+				w.Header().Set(keyring.HashHeader, signature)
+				w.Header().Set(keyring.KeyIDHeader, "2024-05")
+			},
+			wantErr: true,
+		},
+		{
+			name: "tampered",
+			sign: func(w http.ResponseWriter, nonce string, body []byte) {
+				signature, err := server.Sign("2024-05", keyring.ResponsePayload(nonce, append(body, ' ')))
+				require.NoError(t, err)
+				w.Header().Set(keyring.HashHeader, signature)
+				w.Header().Set(keyring.KeyIDHeader, "2024-05")
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				reqBody, err := io.ReadAll(r.Body)
+				require.NoError(t, err)
+				payload := keyring.Payload(r.Method, r.URL.RequestURI(), r.Header.Get(keyring.TimestampHeader), r.Header.Get(keyring.NonceHeader), reqBody)
+				assert.NoError(t, server.Verify(r.Header.Get(keyring.KeyIDHeader), r.Header.Get(keyring.HashHeader), payload))
+				body := []byte(`[]`)
+				tt.sign(w, r.Header.Get(keyring.NonceHeader), body)
+				_, _ = w.Write(body)
+			}))
+			defer srv.Close()
+
+			s := &Sender{serverAddress: srv.URL, client: srv.Client(), keys: newKeys(t, "2024-05", "secret"), keyID: "2024-05"}
+			err := s.SendMetricJSON(context.Background(), []interface{}{}, UpdatePathPack)
+			if !tt.wantErr {
+				assert.NoError(t, err)
+				return
+			}
+			assert.ErrorIs(t, err, ErrResponseSignature)
+			assert.False(t, Retryable(err), "пачка уже принята сервером")
+		})
+	}
+}
+
+// Запись через адрес /update/{type}/{name}/{value} подписывает адрес, в котором передается метрика
+func TestSendMetricValueSignsPath(t *testing.T) {
+	keys := newKeys(t, "", "secret")
+	var uri string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		uri = r.URL.RequestURI()
+		payload := keyring.Payload(r.Method, uri, r.Header.Get(keyring.TimestampHeader), r.Header.Get(keyring.NonceHeader), nil)
+		assert.NoError(t, keys.Verify("", r.Header.Get(keyring.HashHeader), payload))
+		tampered := keyring.Payload(r.Method, "/update/counter/PollCount/1000", r.Header.Get(keyring.TimestampHeader), r.Header.Get(keyring.NonceHeader), nil)
+		assert.ErrorIs(t, keys.Verify("", r.Header.Get(keyring.HashHeader), tampered), keyring.ErrHashMismatch)
+	}))
+	defer srv.Close()
+
+	s := &Sender{serverAddress: srv.URL, client: srv.Client(), keys: keys}
+	require.NoError(t, s.SendMetricValue("counter", "PollCount", 1))
+	assert.Equal(t, "/update/counter/PollCount/1", uri)
+}
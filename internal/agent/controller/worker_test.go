@@ -1,15 +1,21 @@
 package controller
 
 import (
-	"math"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
-	"github.com/shirou/gopsutil/cpu"
-	"github.com/shirou/gopsutil/mem"
 	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
 
 	"github.com/Arcadian-Sky/musthave-metrics/internal/agent/flags"
+	"github.com/Arcadian-Sky/musthave-metrics/internal/agent/models"
 	"github.com/Arcadian-Sky/musthave-metrics/internal/agent/repository"
 )
 
@@ -40,7 +46,7 @@ func TestCollectAndSendMetricsService_GetSystemInfo(t *testing.T) {
 	// Запускаем функцию getSystemInfo в отдельной горутине
 	go func() {
 		// Запускаем функцию getSystemInfo в отдельной горутине
-		getSystemInfo(metricsInfoChan, mockMetricsRepo)
+		getSystemInfo(metricsInfoChan, mockMetricsRepo, zap.NewNop())
 		wg.Done()
 	}()
 
@@ -50,86 +56,88 @@ func TestCollectAndSendMetricsService_GetSystemInfo(t *testing.T) {
 	// Ожидаем завершения выполнения горутины
 	wg.Wait()
 
-	// Проверяем, что полученные метрики соответствуют ожидаемым
-	assert.Equal(t, 123, receivedMetrics["ExistingMetric"])
-	// Проверяем другие ожидаемые метрики
-
-	memoryInfo, _ := mem.VirtualMemory()
-	cpuCount, _ := cpu.Counts(false)
-
-	numberRTotal := int(math.Round(receivedMetrics["TotalMemory"].(float64)) / 1000000000)
-	numberTotal := int(math.Round(float64(memoryInfo.Total)) / 1000000000)
-
-	numberRFree := int(math.Round(receivedMetrics["FreeMemory"].(float64)) / 1000000000)
-	numberFree := int(math.Round(float64(memoryInfo.Free)) / 1000000000)
-
-	numberRcpuCount := int(math.Round(receivedMetrics["CPUutilization1"].(float64)) / 1000000000)
-	numbercpuCount := int(math.Round(float64(cpuCount)) / 1000000000)
-
-	// Проверяем, что TotalMemory, FreeMemory и CPUutilization1 установлены относительно правильно
-	assert.Equal(t, numberTotal, numberRTotal)
-	assert.Equal(t, numberFree, numberRFree)
-	assert.Equal(t, numbercpuCount, numberRcpuCount)
-
+	// Проверяем, что метрики репозитория переданы без изменений
+	assert.Equal(t, mockMetricsRepo.metrics, receivedMetrics)
 }
 
 func TestCollectAndSendMetricsService_Init(t *testing.T) {
-	// Создаем экземпляр репозитория метрик
-	metricsRepo := repository.NewInMemoryMetricsRepository()
-
+	var requests int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+	}))
+	defer srv.Close()
+
+	conf := flags.SetDefault()
+	conf.SetConfigServer(srv.URL)
 	// Создаем экземпляр сервиса, который будем тестировать
-	service := NewCollectAndSendMetricsService(flags.SetDefault())
-
-	var pollCount int64 = 5
-	// Инициализируем сервис
-	service.Init(metricsRepo, &pollCount)
+	service := NewCollectAndSendMetricsService(conf)
 
-	// Ожидаем, что количество горутин воркеров будет равно количеству воркеров
-	// expectedWorkers := 5
-	// time.Sleep(time.Millisecond)
-	// actualWorkers := runtime.NumGoroutine() // Получаем количество горутин
+	// Запускаем воркеров и отдаем им три снимка
+	jobs, wait := service.Init(context.Background())
+	for i := 0; i < 3; i++ {
+		jobs <- pollReport{metrics: map[string]interface{}{"Alloc": 1.0}, polls: 1}
+	}
+	close(jobs)
+	wait()
 
-	// if actualWorkers != expectedWorkers+1 {
-	// 	// +1, потому что есть главная горутина исполнения теста
-	// 	t.Errorf("Expected %d workers goroutines, but got %d", expectedWorkers, actualWorkers-1)
-	// }
+	assert.Equal(t, int32(3), atomic.LoadInt32(&requests))
 }
 
 func TestCollectAndSendMetricsService_Push(t *testing.T) {
-	// type fields struct {
-	// 	config flags.Config
-	// 	sender *senderPack.Sender
-	// }
-	// type args struct {
-	// 	metrics   map[string]interface{}
-	// 	pollCount int64
-	// }
-	// tests := []struct {
-	// 	name   string
-	// 	fields fields
-	// 	args   args
-	// }{
-	// 	// TODO: Add test cases.
-	// }
-	// for _, tt := range tests {
-	// 	t.Run(tt.name, func(t *testing.T) {
-	// 		c := &CollectAndSendMetricsService{
-	// 			config: tt.fields.config,
-	// 			sender: tt.fields.sender,
-	// 		}
-	// 		c.Push(tt.args.metrics, tt.args.pollCount)
-	// 	})
-	// }
-
 	metricsRepo := repository.NewInMemoryMetricsRepository()
 
 	// Создаем экземпляр сервиса, который будем тестировать
 	service := NewCollectAndSendMetricsService(flags.SetDefault())
 
-	var pollCount int64 = 5
-
 	metrics, _ := metricsRepo.GetMetrics()
 
-	// Инициализируем сервис
-	service.Push(metrics, &pollCount)
+	// Сервер недоступен, ошибка только выводится
+	service.Push(context.Background(), metrics, 5)
+}
+
+func TestCollectAndSendMetricsService_Run(t *testing.T) {
+	var (
+		mu    sync.Mutex
+		polls []int64
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var batch []models.Metrics
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&batch))
+		mu.Lock()
+		defer mu.Unlock()
+		for _, m := range batch {
+			if m.ID == "PollCount" {
+				polls = append(polls, *m.Delta)
+			}
+		}
+	}))
+	defer srv.Close()
+
+	for _, rateLimit := range []int{0, 2} {
+		t.Run(fmt.Sprintf("rate limit %d", rateLimit), func(t *testing.T) {
+			mu.Lock()
+			polls = nil
+			mu.Unlock()
+
+			conf := flags.SetDefault()
+			conf.SetConfigServer(srv.URL)
+			conf.SetIntervals(20*time.Millisecond, 200*time.Millisecond)
+			conf.SetRateLimit(rateLimit)
+			service := NewCollectAndSendMetricsService(conf)
+
+			ctx, cancel := context.WithTimeout(context.Background(), 650*time.Millisecond)
+			defer cancel()
+			service.Run(ctx)
+			service.Wg.Wait()
+
+			mu.Lock()
+			defer mu.Unlock()
+			// За 650 мс три отправки, в каждой около десяти опросов, а не один и не накопленный итог
+			assert.Len(t, polls, 3)
+			for _, p := range polls {
+				assert.GreaterOrEqual(t, p, int64(5))
+				assert.LessOrEqual(t, p, int64(12))
+			}
+		})
+	}
 }
@@ -2,6 +2,7 @@ package flags
 
 import (
 	"crypto/rsa"
+	"crypto/tls"
 	"crypto/x509"
 	"encoding/json"
 	"encoding/pem"
@@ -10,24 +11,86 @@ import (
 	"os"
 	"strconv"
 	"time"
+
+	"github.com/Arcadian-Sky/musthave-metrics/internal/agent/collector"
+	"github.com/Arcadian-Sky/musthave-metrics/internal/compression"
+	"github.com/Arcadian-Sky/musthave-metrics/internal/labels"
+	"github.com/Arcadian-Sky/musthave-metrics/internal/logging"
+	"github.com/Arcadian-Sky/musthave-metrics/internal/tlsconfig"
+	"go.uber.org/zap"
 )
 
 type Config struct {
 	serverAddress  string
 	cryptoKey      string
 	hashKey        string
+	hashKeyID      string
 	configFilePath string
 	pollInterval   time.Duration
 	reportInterval time.Duration
 	rateLimit      int
+	transport      string
+	grpcAddress    string
+	labels         map[string]string
+	tlsCert        string
+	tlsKey         string
+	tlsCA          string
+	spoolDir       string
+	spoolMaxSize   int64
+	spoolMaxAge    time.Duration
+	retry          RetryConfig
+	compress       string
+	collectors     []string
+	logLevel       string
+	logFormat      string
+	logSampling    bool
 }
 type AgentConfig struct {
-	ServerAddress  string       `json:"server_address"`
-	PollInterval   JSONDuration `json:"poll_interval"`
-	ReportInterval JSONDuration `json:"report_interval"`
-	CryptoKey      string       `json:"crypto_key"`
+	ServerAddress  string            `json:"server_address"`
+	PollInterval   JSONDuration      `json:"poll_interval"`
+	ReportInterval JSONDuration      `json:"report_interval"`
+	CryptoKey      string            `json:"crypto_key"`
+	HashKeyID      string            `json:"hash_key_id"`
+	Transport      string            `json:"transport"`
+	GRPCAddress    string            `json:"grpc_address"`
+	Labels         map[string]string `json:"labels"`
+	TLSCert        string            `json:"tls_cert"`
+	TLSKey         string            `json:"tls_key"`
+	TLSCA          string            `json:"tls_ca"`
+	SpoolDir       string            `json:"spool_dir"`
+	SpoolMaxSize   int64             `json:"spool_max_size"`
+	SpoolMaxAge    JSONDuration      `json:"spool_max_age"`
+	RequestTimeout JSONDuration      `json:"request_timeout"`
+	RetryInitial   JSONDuration      `json:"retry_initial_interval"`
+	RetryMax       JSONDuration      `json:"retry_max_interval"`
+	RetryElapsed   JSONDuration      `json:"retry_max_elapsed_time"`
+	Compress       string            `json:"compress"`
+	Collectors     map[string]bool   `json:"collectors"`
+	LogLevel       string            `json:"log_level"`
+	LogFormat      string            `json:"log_format"`
+	LogSampling    bool              `json:"log_sampling"`
+}
+
+// RetryConfig настройки повторной отправки метрик
+type RetryConfig struct {
+	RequestTimeout  time.Duration // таймаут одной попытки
+	InitialInterval time.Duration // пауза перед первым повтором, к паузам добавляется случайный разброс ±50%
+	MaxInterval     time.Duration // предельная пауза между повторами
+	MaxElapsedTime  time.Duration // сколько всего длятся повторы, не больше интервала отправки
 }
 
+// Настройки очереди неотправленных пачек по умолчанию
+const (
+	DefaultSpoolDir     = "/tmp/metrics-agent-spool"
+	DefaultSpoolMaxSize = 10 << 20
+)
+
+// Виды транспорта для отправки метрик
+const (
+	TransportHTTP = "http"
+	TransportGRPC = "grpc"
+)
+
 type JSONDuration time.Duration
 
 func (d JSONDuration) MarshalJSON() ([]byte, error) {
@@ -63,18 +126,62 @@ func SetDefault() *Config {
 		pollInterval:   time.Second,
 		rateLimit:      10,
 		cryptoKey:      "",
+		transport:      TransportHTTP,
+		grpcAddress:    "localhost:3200",
 	}
 }
 
+// Через флаг -p и переменную окружения POLL_INTERVAL - интервал опроса метрик в секундах (по умолчанию 2)
+// Через флаг -r и переменную окружения REPORT_INTERVAL - интервал отправки метрик в секундах (по умолчанию 10)
+// Через флаг -k и переменную окружения KEY - ключ подписи HMAC-SHA256 запросов, им же проверяется подпись ответов сервера
+// Через флаг -hash-key-id и переменную окружения HASH_KEY_ID - идентификатор ключа -k на сервере, передается в заголовке HashKeyID
+// (по умолчанию пусто, сервер проверяет подпись ключом из своего -k)
 // Через флаг -l=<ЗНАЧЕНИЕ> и переменную окружения RATE_LIMIT. - количество одновременно исходящих запросов на сервер нужно ограничивать «сверху»
+// (при включенной очереди -spool-dir пачки отправляются по одной в порядке снятия, чтобы новая пачка не обогнала старые)
+// Через флаг -transport=<http|grpc> и переменную окружения TRANSPORT - способ отправки метрик на сервер (по умолчанию http)
+// Через флаг -grpc-address и переменную окружения GRPC_ADDRESS - адрес gRPC сервера (по умолчанию localhost:3200)
+// Через флаг -labels=host=a,env=prod и переменную окружения LABELS - метки, добавляемые ко всем метрикам агента
+// Через флаги -tls-cert и -tls-key и переменные окружения TLS_CERT и TLS_KEY - сертификат и ключ агента для mTLS
+// Через флаг -tls-ca и переменную окружения TLS_CA - сертификат центра, которым подписан сертификат сервера
+// Через флаг -request-timeout и переменную окружения REQUEST_TIMEOUT - таймаут одной попытки отправки в секундах (по умолчанию 2)
+// Через флаги -retry-initial-interval и -retry-max-interval и переменные окружения RETRY_INITIAL_INTERVAL и RETRY_MAX_INTERVAL - начальная и предельная паузы между повторами в секундах (по умолчанию 1 и 5)
+// Через флаг -retry-max-elapsed-time и переменную окружения RETRY_MAX_ELAPSED_TIME - сколько всего длятся повторы в секундах (по умолчанию и не больше чем интервал отправки)
+// Через флаг -compress и переменную окружения COMPRESS - сжатие тела запроса: gzip, zstd, br или none (по умолчанию gzip)
+// Через флаг -collectors=disk,network,-memory и переменную окружения COLLECTORS - включение и отключение источников метрик
+// относительно включенных по умолчанию runtime, memory и cpu; в файле конфигурации это объект {"disk": true, "memory": false}
+// Через флаг -spool-dir и переменную окружения SPOOL_DIR - каталог очереди неотправленных пачек (по умолчанию /tmp/metrics-agent-spool)
+// Через флаг -spool-max-size и переменную окружения SPOOL_MAX_SIZE - предельный размер очереди в байтах (по умолчанию 10 МБ)
+// Через флаг -spool-max-age и переменную окружения SPOOL_MAX_AGE - предельный возраст пачки в очереди в секундах (по умолчанию 3600)
+// Через флаг -log-level и переменную окружения LOG_LEVEL - уровень логирования: debug, info, warn или error (по умолчанию info)
+// Через флаг -log-format и переменную окружения LOG_FORMAT - формат логов: json или console (по умолчанию console)
+// Через флаг -log-sampling и переменную окружения LOG_SAMPLING - прореживание одинаковых сообщений лога (по умолчанию false)
 func Parse() (Config, error) {
 	end := flag.String("a", "", "endpoint")
 	key := flag.String("k", "", "hash key")
+	keyIDFlag := flag.String("hash-key-id", "", "идентификатор ключа подписи")
 	cryptoKeyPath := flag.String("crypto-key", "", "crypto-key")
 	repI := flag.Int("r", 0, "reportInterval")
 	polI := flag.Int("p", 0, "pollInterval")
 	rLim := flag.Int("l", 0, "rateLimit")
 	configFileFlag := flag.String("c", "", "Путь к файлу конфигурации JSON")
+	transportFlag := flag.String("transport", "", "transport: http или grpc")
+	grpcAddressFlag := flag.String("grpc-address", "", "gRPC endpoint")
+	labelsFlag := flag.String("labels", "", "метки метрик: host=a,env=prod")
+	tlsCertFlag := flag.String("tls-cert", "", "сертификат агента")
+	tlsKeyFlag := flag.String("tls-key", "", "ключ сертификата агента")
+	tlsCAFlag := flag.String("tls-ca", "", "сертификат центра, подписавшего сертификат сервера")
+	requestTimeoutFlag := flag.Int("request-timeout", 0, "таймаут одной попытки отправки в секундах")
+	retryInitialFlag := flag.Int("retry-initial-interval", 0, "начальная пауза между повторами в секундах")
+	retryMaxFlag := flag.Int("retry-max-interval", 0, "предельная пауза между повторами в секундах")
+	retryElapsedFlag := flag.Int("retry-max-elapsed-time", 0, "сколько всего длятся повторы в секундах")
+	compressFlag := flag.String("compress", "", "сжатие тела запроса: gzip, zstd, br или none")
+	collectorsFlag := flag.String("collectors", "", "источники метрик: disk,network,-memory")
+	spoolDirFlag := flag.String("spool-dir", "", "каталог очереди неотправленных пачек")
+	spoolMaxSizeFlag := flag.Int64("spool-max-size", 0, "предельный размер очереди в байтах")
+	spoolMaxAgeFlag := flag.Int("spool-max-age", 0, "предельный возраст пачки в очереди в секундах")
+	logLevelFlag := flag.String("log-level", "", "уровень логирования")
+	logFormatFlag := flag.String("log-format", "", "формат логов: json или console")
+	logSamplingFlag := flag.Bool("log-sampling", false, "прореживание одинаковых сообщений лога")
 
 	flag.Parse()
 
@@ -99,8 +206,7 @@ func Parse() (Config, error) {
 	if envRLim := os.Getenv("RATE_LIMIT"); envRLim != "" {
 		rateLimit, err := strconv.Atoi(envRLim)
 		if err != nil {
-			fmt.Println("Error parsing REPORT_INTERVAL:", err)
-			return config, err
+			return config, fmt.Errorf("ошибка в RATE_LIMIT: %w", err)
 		}
 		config.rateLimit = rateLimit
 	}
@@ -112,14 +218,51 @@ func Parse() (Config, error) {
 		}
 	}
 
+	config.hashKeyID = getString(*keyIDFlag, os.Getenv("HASH_KEY_ID"), fileConfig.HashKeyID, "", "")
 	config.cryptoKey = getString(*cryptoKeyPath, cryptoKeyEnv, fileConfig.CryptoKey, "", "")
+	config.tlsCert = getString(*tlsCertFlag, os.Getenv("TLS_CERT"), fileConfig.TLSCert, "", "")
+	config.tlsKey = getString(*tlsKeyFlag, os.Getenv("TLS_KEY"), fileConfig.TLSKey, "", "")
+	config.tlsCA = getString(*tlsCAFlag, os.Getenv("TLS_CA"), fileConfig.TLSCA, "", "")
 	prefix := "http://"
-	if config.cryptoKey != "" {
+	if config.cryptoKey != "" || config.TLSEnabled() {
 		prefix = "https://"
 	}
 	config.serverAddress = getString(*end, envRunAddr, fileConfig.ServerAddress, "localhost:8080", prefix)
-	config.pollInterval = getDuration(*polI, envPolI, fileConfig.PollInterval, 10)
-	config.reportInterval = getDuration(*repI, envRepI, fileConfig.ReportInterval, 2)
+	config.pollInterval = getDuration(*polI, envPolI, fileConfig.PollInterval, 2)
+	config.reportInterval = getDuration(*repI, envRepI, fileConfig.ReportInterval, 10)
+	config.transport = getString(*transportFlag, os.Getenv("TRANSPORT"), fileConfig.Transport, TransportHTTP, "")
+	config.grpcAddress = getString(*grpcAddressFlag, os.Getenv("GRPC_ADDRESS"), fileConfig.GRPCAddress, "localhost:3200", "")
+	if config.transport != TransportHTTP && config.transport != TransportGRPC {
+		return config, fmt.Errorf("неизвестный transport: %s", config.transport)
+	}
+	metricLabels, err := getLabels(*labelsFlag, os.Getenv("LABELS"), fileConfig.Labels)
+	if err != nil {
+		return config, err
+	}
+	config.labels = metricLabels
+	config.retry = RetryConfig{
+		RequestTimeout:  getDuration(*requestTimeoutFlag, os.Getenv("REQUEST_TIMEOUT"), fileConfig.RequestTimeout, 2),
+		InitialInterval: getDuration(*retryInitialFlag, os.Getenv("RETRY_INITIAL_INTERVAL"), fileConfig.RetryInitial, 1),
+		MaxInterval:     getDuration(*retryMaxFlag, os.Getenv("RETRY_MAX_INTERVAL"), fileConfig.RetryMax, 5),
+		MaxElapsedTime:  getDuration(*retryElapsedFlag, os.Getenv("RETRY_MAX_ELAPSED_TIME"), fileConfig.RetryElapsed, 0),
+	}
+	config.compress = getString(*compressFlag, os.Getenv("COMPRESS"), fileConfig.Compress, compression.Gzip, "")
+	if config.compress != compression.None && !compression.Supported(config.compress) {
+		return config, fmt.Errorf("неизвестное сжатие: %s", config.compress)
+	}
+	config.collectors, err = collector.Select(getCollectors(*collectorsFlag, os.Getenv("COLLECTORS"), fileConfig.Collectors))
+	if err != nil {
+		return config, err
+	}
+	config.spoolDir = getString(*spoolDirFlag, os.Getenv("SPOOL_DIR"), fileConfig.SpoolDir, DefaultSpoolDir, "")
+	config.spoolMaxSize, err = getInt64(*spoolMaxSizeFlag, os.Getenv("SPOOL_MAX_SIZE"), fileConfig.SpoolMaxSize, DefaultSpoolMaxSize)
+	if err != nil {
+		return config, fmt.Errorf("ошибка в SPOOL_MAX_SIZE: %w", err)
+	}
+	config.spoolMaxAge = getDuration(*spoolMaxAgeFlag, os.Getenv("SPOOL_MAX_AGE"), fileConfig.SpoolMaxAge, 3600)
+	config.logLevel = getString(*logLevelFlag, os.Getenv("LOG_LEVEL"), fileConfig.LogLevel, logging.DefaultLevel, "")
+	config.logFormat = getString(*logFormatFlag, os.Getenv("LOG_FORMAT"), fileConfig.LogFormat, logging.DefaultFormat, "")
+	config.logSampling = getBool(*logSamplingFlag, os.Getenv("LOG_SAMPLING"), fileConfig.LogSampling)
 
 	return config, nil
 }
@@ -137,6 +280,61 @@ func getString(flagValue string, envValue string, fileValue string, defaultValue
 	return prefix + defaultValue
 }
 
+func getLabels(flagValue string, envValue string, fileValue map[string]string) (map[string]string, error) {
+	if envValue != "" {
+		return labels.Parse(envValue)
+	}
+	if flagValue != "" {
+		return labels.Parse(flagValue)
+	}
+	if err := labels.Validate(fileValue); err != nil {
+		return nil, err
+	}
+	return fileValue, nil
+}
+
+// getCollectors возвращает включения и отключения источников метрик.
+// Список из флага или переменной окружения дополняет настройки из файла.
+func getCollectors(flagValue string, envValue string, fileValue map[string]bool) map[string]bool {
+	overrides := make(map[string]bool, len(fileValue))
+	for name, on := range fileValue {
+		overrides[name] = on
+	}
+	value := envValue
+	if value == "" {
+		value = flagValue
+	}
+	for name, on := range collector.ParseOverrides(value) {
+		overrides[name] = on
+	}
+	return overrides
+}
+
+func getBool(flagValue bool, envValue string, fileValue bool) bool {
+	if envValue != "" {
+		if parsed, err := strconv.ParseBool(envValue); err == nil {
+			return parsed
+		}
+	}
+	if flagValue {
+		return flagValue
+	}
+	return fileValue
+}
+
+func getInt64(flagValue int64, envValue string, fileValue int64, defaultValue int64) (int64, error) {
+	if envValue != "" {
+		return strconv.ParseInt(envValue, 10, 64)
+	}
+	if flagValue != 0 {
+		return flagValue, nil
+	}
+	if fileValue != 0 {
+		return fileValue, nil
+	}
+	return defaultValue, nil
+}
+
 func getDuration(flagValue int, envValue string, fileValue JSONDuration, defaultValue int) time.Duration {
 	if envValue != "" {
 		if parsed, err := strconv.Atoi(envValue); err == nil {
@@ -173,6 +371,17 @@ func (c *Config) SetConfigServer(s string) {
 	c.serverAddress = s
 }
 
+// SetIntervals задает интервалы опроса и отправки
+func (c *Config) SetIntervals(poll, report time.Duration) {
+	c.pollInterval = poll
+	c.reportInterval = report
+}
+
+// SetRateLimit задает число одновременных отправок
+func (c *Config) SetRateLimit(limit int) {
+	c.rateLimit = limit
+}
+
 func (c *Config) GetServerAddress() string {
 	return c.serverAddress
 }
@@ -181,7 +390,7 @@ func (c *Config) GetCryptoKeyPath() (*rsa.PublicKey, bool) {
 	if c.cryptoKey != "" {
 		publicKey, err := c.loadCryptoKey(c.cryptoKey)
 		if err != nil {
-			fmt.Println("Ошибка при загрузке публичного ключа:", err)
+			zap.L().Error("Ошибка при загрузке публичного ключа", zap.Error(err))
 			return nil, false
 		}
 		return publicKey, true
@@ -198,6 +407,78 @@ func (c *Config) GetHash() string {
 	return c.hashKey
 }
 
+// GetHashKeyID возвращает идентификатор ключа подписи
+func (c *Config) GetHashKeyID() string {
+	return c.hashKeyID
+}
+
+func (c *Config) GetTransport() string {
+	return c.transport
+}
+
+func (c *Config) GetGRPCAddress() string {
+	return c.grpcAddress
+}
+
+// TLSEnabled сообщает, задан ли сертификат агента или сертификат центра сервера
+func (c *Config) TLSEnabled() bool {
+	return c.tlsCert != "" || c.tlsKey != "" || c.tlsCA != ""
+}
+
+// GetTLSConfig возвращает настройки TLS агента или nil, если TLS не настроен
+func (c *Config) GetTLSConfig() (*tls.Config, error) {
+	if !c.TLSEnabled() {
+		return nil, nil
+	}
+	return tlsconfig.Client(c.tlsCert, c.tlsKey, c.tlsCA)
+}
+
+// GetLabels возвращает метки, добавляемые ко всем метрикам агента
+func (c *Config) GetLabels() map[string]string {
+	return c.labels
+}
+
+// GetRetry возвращает настройки повторной отправки.
+// Повторы укладываются в интервал отправки, чтобы не задерживать следующий цикл.
+func (c *Config) GetRetry() RetryConfig {
+	retry := c.retry
+	if retry.RequestTimeout <= 0 {
+		retry.RequestTimeout = 2 * time.Second
+	}
+	if c.reportInterval > 0 && (retry.MaxElapsedTime <= 0 || retry.MaxElapsedTime > c.reportInterval) {
+		retry.MaxElapsedTime = c.reportInterval
+	}
+	return retry
+}
+
+// GetCompress возвращает кодировку сжатия тела запроса, пустая строка или none отключают сжатие
+func (c *Config) GetCompress() string {
+	return c.compress
+}
+
+// GetSpool возвращает каталог очереди неотправленных пачек, ее предельный размер и возраст пачки.
+// Пустой каталог отключает очередь.
+func (c *Config) GetSpool() (string, int64, time.Duration) {
+	return c.spoolDir, c.spoolMaxSize, c.spoolMaxAge
+}
+
+// GetCollectors возвращает имена включенных источников метрик, по умолчанию collector.Default
+func (c *Config) GetCollectors() []string {
+	if c.collectors == nil {
+		return collector.Default
+	}
+	return c.collectors
+}
+
+// GetLogging возвращает настройки логгера
+func (c *Config) GetLogging() logging.Config {
+	return logging.Config{
+		Level:    c.logLevel,
+		Format:   c.logFormat,
+		Sampling: c.logSampling,
+	}
+}
+
 func (c *Config) GetReportInterval() time.Duration {
 	return c.reportInterval
 }
@@ -4,6 +4,7 @@
 package flags
 
 import (
+	"flag"
 	"fmt"
 	"os"
 	"testing"
@@ -137,6 +138,90 @@ func TestGetHash(t *testing.T) {
 	assert.Equal(t, "secret", config.GetHash())
 }
 
+// TestGetHashKeyID тестирует метод GetHashKeyID
+func TestGetHashKeyID(t *testing.T) {
+	config := &Config{hashKey: "secret", hashKeyID: "2024-05"}
+	assert.Equal(t, "2024-05", config.GetHashKeyID())
+}
+
+// TestGetLabels тестирует разбор и приоритет источников меток
+func TestGetLabels(t *testing.T) {
+	l, err := getLabels("host=flag", "host=env,env=prod", map[string]string{"host": "file"})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"host": "env", "env": "prod"}, l)
+
+	l, err = getLabels("host=flag", "", map[string]string{"host": "file"})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"host": "flag"}, l)
+
+	l, err = getLabels("", "", map[string]string{"host": "file"})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"host": "file"}, l)
+
+	_, err = getLabels("", "", map[string]string{"host-name": "file"})
+	assert.Error(t, err)
+
+	config := &Config{labels: l}
+	assert.Equal(t, map[string]string{"host": "file"}, config.GetLabels())
+}
+
+// TestGetCollectors тестирует объединение настроек источников метрик
+func TestGetCollectors(t *testing.T) {
+	file := map[string]bool{"disk": true, "memory": false}
+	assert.Equal(t, map[string]bool{"disk": false, "memory": false, "load": true}, getCollectors("network", "load,-disk", file))
+	assert.Equal(t, map[string]bool{"disk": true, "memory": false, "network": true}, getCollectors("network", "", file))
+	assert.Equal(t, map[string]bool{"disk": true, "memory": false}, getCollectors("", "", file))
+
+	assert.Equal(t, []string{"runtime", "memory", "cpu"}, (&Config{}).GetCollectors())
+}
+
+func TestGetInt64(t *testing.T) {
+	v, err := getInt64(1, "2", 3, 4)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), v)
+
+	v, err = getInt64(1, "", 3, 4)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), v)
+
+	v, err = getInt64(0, "", 3, 4)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), v)
+
+	v, err = getInt64(0, "", 0, 4)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(4), v)
+
+	_, err = getInt64(0, "big", 0, 4)
+	assert.Error(t, err)
+}
+
+// TestGetRetry тестирует метод GetRetry
+func TestGetRetry(t *testing.T) {
+	config := &Config{
+		reportInterval: 10 * time.Second,
+		retry:          RetryConfig{InitialInterval: time.Second, MaxInterval: 5 * time.Second, MaxElapsedTime: time.Minute},
+	}
+	retry := config.GetRetry()
+	assert.Equal(t, 10*time.Second, retry.MaxElapsedTime, "повторы не выходят за интервал отправки")
+	assert.Equal(t, 2*time.Second, retry.RequestTimeout)
+
+	config.retry.MaxElapsedTime = 3 * time.Second
+	assert.Equal(t, 3*time.Second, config.GetRetry().MaxElapsedTime)
+
+	config.retry.MaxElapsedTime = 0
+	assert.Equal(t, 10*time.Second, config.GetRetry().MaxElapsedTime)
+}
+
+// TestGetSpool тестирует метод GetSpool
+func TestGetSpool(t *testing.T) {
+	config := &Config{spoolDir: "/tmp/spool", spoolMaxSize: 100, spoolMaxAge: time.Minute}
+	dir, size, age := config.GetSpool()
+	assert.Equal(t, "/tmp/spool", dir)
+	assert.Equal(t, int64(100), size)
+	assert.Equal(t, time.Minute, age)
+}
+
 // TestGetReportInterval тестирует метод GetReportInterval
 func TestGetReportInterval(t *testing.T) {
 	config := &Config{reportInterval: 5 * time.Second}
@@ -245,7 +330,8 @@ func TestParseConfig(t *testing.T) {
 		"server_address": "http://file_address:8080",
 		"poll_interval": "15s",
 		"report_interval": "30s",
-		"crypto_key": "/path/to/file_crypto.key"
+		"crypto_key": "/path/to/file_crypto.key",
+		"collectors": {"disk": true, "load": true}
 	}
 	`
 	configFile, err := os.CreateTemp("", "agent_config_*.json")
@@ -261,7 +347,7 @@ func TestParseConfig(t *testing.T) {
 	assert.NoError(t, err)
 
 	// Устанавливаем флаги
-	os.Args = []string{"cmd", "-a", "http://flag_address:8080", "-k", "flag_hash_key", "-crypto-key", "/path/to/flag_crypto.key", "-r", "10", "-p", "20", "-l", "7", "-c", configFile.Name()}
+	os.Args = []string{"cmd", "-a", "http://flag_address:8080", "-k", "flag_hash_key", "-crypto-key", "/path/to/flag_crypto.key", "-r", "10", "-p", "20", "-l", "7", "-collectors", "-load,-memory", "-c", configFile.Name()}
 
 	// Выполняем разбор конфигурации
 	config, err := Parse()
@@ -274,4 +360,24 @@ func TestParseConfig(t *testing.T) {
 	assert.Equal(t, 5, config.rateLimit)                                     // Переменная окружения имеет высший приоритет
 	assert.Equal(t, 25*time.Second, config.reportInterval)                   // Переменная окружения имеет высший приоритет
 	assert.Equal(t, 35*time.Second, config.pollInterval)                     // Переменная окружения имеет высший приоритет
+	assert.Equal(t, time.Second, config.retry.InitialInterval)
+	assert.Equal(t, 5*time.Second, config.retry.MaxInterval)
+	assert.Equal(t, "gzip", config.GetCompress())
+	assert.Equal(t, DefaultSpoolDir, config.spoolDir)
+	assert.Equal(t, int64(DefaultSpoolMaxSize), config.spoolMaxSize)
+	assert.Equal(t, time.Hour, config.spoolMaxAge)
+	assert.Equal(t, []string{"cpu", "disk", "runtime"}, config.GetCollectors())
+}
+
+// TestParseConfigDefaults проверяет интервалы опроса и отправки по умолчанию
+func TestParseConfigDefaults(t *testing.T) {
+	t.Setenv("POLL_INTERVAL", "")
+	t.Setenv("REPORT_INTERVAL", "")
+	flag.CommandLine = flag.NewFlagSet("cmd", flag.ExitOnError)
+	os.Args = []string{"cmd"}
+
+	config, err := Parse()
+	assert.NoError(t, err)
+	assert.Equal(t, 2*time.Second, config.pollInterval)
+	assert.Equal(t, 10*time.Second, config.reportInterval)
 }
@@ -1,10 +1,11 @@
 package repository
 
 import (
-	"log"
-	"math/rand"
-	"runtime"
+	"context"
 	"sync"
+
+	"github.com/Arcadian-Sky/musthave-metrics/internal/agent/collector"
+	"go.uber.org/zap"
 )
 
 // MetricsRepository определяет методы для работы с метриками.
@@ -12,59 +13,53 @@ type MetricsRepository interface {
 	GetMetrics() (map[string]interface{}, error)
 }
 
+// InMemoryMetricsRepository хранит последний снимок метрик и число опросов с последней отправки
 type InMemoryMetricsRepository struct {
-	metrics map[string]interface{}
-	mutex   sync.Mutex
+	collectors []collector.Collector
+	metrics    map[string]interface{}
+	pollCount  int64
+	log        *zap.Logger
+	mutex      sync.Mutex
 }
 
-func NewInMemoryMetricsRepository() *InMemoryMetricsRepository {
+// NewInMemoryMetricsRepository создает репозиторий, который опрашивает переданные источники.
+// Без источников используются включенные по умолчанию collector.Default.
+func NewInMemoryMetricsRepository(collectors ...collector.Collector) *InMemoryMetricsRepository {
+	if len(collectors) == 0 {
+		collectors, _ = collector.New(collector.Default)
+	}
 	return &InMemoryMetricsRepository{
-		metrics: make(map[string]interface{}),
+		collectors: collectors,
+		metrics:    make(map[string]interface{}),
+		log:        zap.L(),
 	}
 }
 
-func (r *InMemoryMetricsRepository) GetMetrics() (map[string]interface{}, error) {
+// SetLogger задает логгер для ошибок источников метрик
+func (r *InMemoryMetricsRepository) SetLogger(logger *zap.Logger) {
+	if logger != nil {
+		r.log = logger
+	}
+}
 
+// GetMetrics опрашивает все источники и объединяет их метрики.
+// Ошибка одного источника не мешает остальным, она только выводится в лог.
+func (r *InMemoryMetricsRepository) GetMetrics() (map[string]interface{}, error) {
 	metrics := make(map[string]interface{})
-
-	var memStats runtime.MemStats
-	runtime.ReadMemStats(&memStats)
-
-	// Собираем метрики из пакета runtime
-	metrics["Alloc"] = float64(memStats.Alloc)
-	metrics["BuckHashSys"] = float64(memStats.BuckHashSys)
-	metrics["Frees"] = float64(memStats.Frees)
-	metrics["GCCPUFraction"] = float64(memStats.GCCPUFraction)
-	metrics["GCSys"] = float64(memStats.GCSys)
-	metrics["HeapAlloc"] = float64(memStats.HeapAlloc)
-	metrics["HeapIdle"] = float64(memStats.HeapIdle)
-	metrics["HeapIdle"] = float64(memStats.HeapIdle)
-	metrics["HeapInuse"] = float64(memStats.HeapInuse)
-	metrics["HeapObjects"] = float64(memStats.HeapObjects)
-	metrics["HeapReleased"] = float64(memStats.HeapReleased)
-	metrics["HeapSys"] = float64(memStats.HeapSys)
-	metrics["LastGC"] = float64(memStats.LastGC)
-	metrics["Lookups"] = float64(memStats.Lookups)
-	metrics["MCacheInuse"] = float64(memStats.MCacheInuse)
-	metrics["MCacheSys"] = float64(memStats.MCacheSys)
-	metrics["MSpanInuse"] = float64(memStats.MSpanInuse)
-	metrics["MSpanSys"] = float64(memStats.MSpanSys)
-	metrics["Mallocs"] = float64(memStats.Mallocs)
-	metrics["NextGC"] = float64(memStats.NextGC)
-	metrics["NumForcedGC"] = float64(memStats.NumForcedGC)
-	metrics["NumGC"] = float64(memStats.NumGC)
-	metrics["OtherSys"] = float64(memStats.OtherSys)
-	metrics["PauseTotalNs"] = float64(memStats.PauseTotalNs)
-	metrics["StackInuse"] = float64(memStats.StackInuse)
-	metrics["StackSys"] = float64(memStats.StackSys)
-	metrics["Sys"] = float64(memStats.Sys)
-	metrics["TotalAlloc"] = float64(memStats.TotalAlloc)
-	// Добавляем дополнительные метрики
-	metrics["RandomValue"] = rand.Float64() // Произвольное значение
+	for _, c := range r.collectors {
+		values, err := c.Collect(context.Background())
+		if err != nil {
+			r.log.Error("Ошибка источника метрик", zap.String("collector", c.Name()), zap.Error(err))
+			continue
+		}
+		for name, value := range values {
+			metrics[name] = value
+		}
+	}
 
 	err := r.SaveMetrics(metrics)
 	if err != nil {
-		log.Println("Error saving metrics:", err)
+		r.log.Error("Error saving metrics", zap.Error(err))
 	}
 	return metrics, nil
 }
@@ -77,3 +72,27 @@ func (r *InMemoryMetricsRepository) SaveMetrics(metrics map[string]interface{})
 	r.metrics = metrics
 	return nil
 }
+
+// RecordPoll сохраняет результат опроса и увеличивает счетчик опросов
+func (r *InMemoryMetricsRepository) RecordPoll(metrics map[string]interface{}) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.metrics = metrics
+	r.pollCount++
+}
+
+// Snapshot возвращает копию последнего снимка метрик и число опросов с предыдущего вызова.
+// Счетчик опросов обнуляется, поэтому каждый опрос попадает ровно в одну отправку.
+func (r *InMemoryMetricsRepository) Snapshot() (map[string]interface{}, int64) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	metrics := make(map[string]interface{}, len(r.metrics))
+	for name, value := range r.metrics {
+		metrics[name] = value
+	}
+	polls := r.pollCount
+	r.pollCount = 0
+	return metrics, polls
+}
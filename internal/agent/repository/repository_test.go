@@ -1,9 +1,23 @@
 package repository
 
 import (
+	"context"
+	"errors"
 	"testing"
 )
 
+type fakeCollector struct {
+	name    string
+	metrics map[string]float64
+	err     error
+}
+
+func (c fakeCollector) Name() string { return c.name }
+
+func (c fakeCollector) Collect(context.Context) (map[string]float64, error) {
+	return c.metrics, c.err
+}
+
 func TestInMemoryMetricsRepository_GetMetrics(t *testing.T) {
 
 	t.Run("GetMetrics", func(t *testing.T) {
@@ -24,3 +38,47 @@ func TestInMemoryMetricsRepository_GetMetrics(t *testing.T) {
 
 	})
 }
+
+func TestInMemoryMetricsRepository_Collectors(t *testing.T) {
+	metricsRepo := NewInMemoryMetricsRepository(
+		fakeCollector{name: "queue", metrics: map[string]float64{"QueueLen": 3}},
+		fakeCollector{name: "broken", err: errors.New("unavailable")},
+	)
+	got, err := metricsRepo.GetMetrics()
+	if err != nil {
+		t.Fatalf("GetMetrics() error = %v", err)
+	}
+	// Метрики неисправного источника пропускаются, остальные собираются
+	if len(got) != 1 || got["QueueLen"] != 3.0 {
+		t.Errorf("GetMetrics() = %v, want only QueueLen=3", got)
+	}
+}
+
+func TestInMemoryMetricsRepository_Snapshot(t *testing.T) {
+	metricsRepo := NewInMemoryMetricsRepository()
+
+	metrics, polls := metricsRepo.Snapshot()
+	if len(metrics) != 0 || polls != 0 {
+		t.Errorf("Snapshot() of empty repository = %v, %d", metrics, polls)
+	}
+
+	metricsRepo.RecordPoll(map[string]interface{}{"Alloc": 1.0})
+	metricsRepo.RecordPoll(map[string]interface{}{"Alloc": 2.0})
+	metrics, polls = metricsRepo.Snapshot()
+	if polls != 2 {
+		t.Errorf("Snapshot() polls = %d, want 2", polls)
+	}
+	if metrics["Alloc"] != 2.0 {
+		t.Errorf("Snapshot() Alloc = %v, want 2", metrics["Alloc"])
+	}
+
+	// Снимок является копией, а счетчик опросов обнулен
+	metrics["Alloc"] = 3.0
+	metrics, polls = metricsRepo.Snapshot()
+	if polls != 0 {
+		t.Errorf("Snapshot() polls after reset = %d, want 0", polls)
+	}
+	if metrics["Alloc"] != 2.0 {
+		t.Errorf("Snapshot() Alloc after copy change = %v, want 2", metrics["Alloc"])
+	}
+}
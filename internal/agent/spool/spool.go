@@ -0,0 +1,281 @@
+// Пакет spool реализует дисковую очередь пачек метрик, которые агент не смог отправить.
+//
+// Каждая пачка хранится в отдельном файле, имя которого задает порядок воспроизведения.
+// Размер очереди и возраст пачек ограничены. При вытеснении старой пачки ее значения
+// gauge отбрасываются, а приращения counter переносятся в следующую пачку,
+// поэтому счетчики вроде PollCount не теряются и не учитываются дважды.
+package spool
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Arcadian-Sky/musthave-metrics/internal/agent/models"
+	"github.com/Arcadian-Sky/musthave-metrics/internal/labels"
+	"go.uber.org/zap"
+)
+
+const fileExt = ".json"
+
+// Spool дисковая очередь пачек метрик
+type Spool struct {
+	dir      string
+	maxBytes int64
+	maxAge   time.Duration
+	seq      uint64
+	now      func() time.Time
+	log      *zap.Logger
+	mu       sync.Mutex
+}
+
+// entry файл пачки в очереди
+type entry struct {
+	path string
+	size int64
+	at   time.Time
+}
+
+// New создает очередь в каталоге dir. Нулевые maxBytes и maxAge снимают соответствующее ограничение.
+func New(dir string, maxBytes int64, maxAge time.Duration) (*Spool, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("не удалось создать каталог очереди: %w", err)
+	}
+	return &Spool{
+		dir:      dir,
+		maxBytes: maxBytes,
+		maxAge:   maxAge,
+		now:      time.Now,
+		log:      zap.L(),
+	}, nil
+}
+
+// SetLogger задает логгер для сообщений о поврежденных и вытесненных пачках
+func (s *Spool) SetLogger(logger *zap.Logger) {
+	if logger != nil {
+		s.log = logger
+	}
+}
+
+// Put сохраняет пачку в конец очереди и применяет ограничения размера и возраста
+func (s *Spool) Put(batch []models.Metrics) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.write(batch); err != nil {
+		return err
+	}
+	return s.enforce()
+}
+
+// Len возвращает количество пачек в очереди
+func (s *Spool) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entries, err := s.list()
+	if err != nil {
+		return 0
+	}
+	return len(entries)
+}
+
+// Replay отправляет пачки по порядку, начиная с самой старой. Отправленная пачка удаляется.
+// На первой ошибке воспроизведение останавливается, ошибка возвращается, оставшиеся пачки сохраняются.
+func (s *Spool) Replay(send func([]models.Metrics) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entries, err := s.list()
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		batch, err := read(e.path)
+		if err != nil {
+			s.log.Warn("Пропускаем поврежденную пачку", zap.String("path", e.path), zap.Error(err))
+			_ = os.Remove(e.path)
+			continue
+		}
+		if err := send(batch); err != nil {
+			return err
+		}
+		if err := os.Remove(e.path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// write атомарно записывает пачку в новый файл
+func (s *Spool) write(batch []models.Metrics) error {
+	data, err := json.Marshal(batch)
+	if err != nil {
+		return err
+	}
+	s.seq++
+	name := fmt.Sprintf("%020d-%06d", s.now().UnixNano(), s.seq%1000000)
+	tmp := filepath.Join(s.dir, name+".tmp")
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(s.dir, name+fileExt))
+}
+
+// enforce вытесняет старые пачки, пока очередь превышает ограничения.
+// Самая новая пачка не вытесняется никогда, в нее в итоге переносятся все счетчики.
+func (s *Spool) enforce() error {
+	entries, err := s.list()
+	if err != nil {
+		return err
+	}
+	var total int64
+	for _, e := range entries {
+		total += e.size
+	}
+	now := s.now()
+	for len(entries) > 1 {
+		oldest := entries[0]
+		expired := s.maxAge > 0 && now.Sub(oldest.at) > s.maxAge
+		oversize := s.maxBytes > 0 && total > s.maxBytes
+		if !expired && !oversize {
+			break
+		}
+		next, err := s.evict(oldest, entries[1])
+		if err != nil {
+			return err
+		}
+		total += next.size - oldest.size - entries[1].size
+		entries[1] = next
+		entries = entries[1:]
+	}
+	return nil
+}
+
+// evict удаляет пачку from, перенося ее приращения счетчиков в пачку to
+func (s *Spool) evict(from, to entry) (entry, error) {
+	dropped, err := read(from.path)
+	if err != nil {
+		s.log.Warn("Пропускаем поврежденную пачку", zap.String("path", from.path), zap.Error(err))
+		dropped = nil
+	}
+	if counters := Counters(dropped); len(counters) > 0 {
+		batch, err := read(to.path)
+		if err != nil {
+			return to, err
+		}
+		data, err := json.Marshal(MergeCounters(batch, counters))
+		if err != nil {
+			return to, err
+		}
+		tmp := to.path + ".tmp"
+		if err := os.WriteFile(tmp, data, 0o644); err != nil {
+			return to, err
+		}
+		if err := os.Rename(tmp, to.path); err != nil {
+			return to, err
+		}
+		to.size = int64(len(data))
+	}
+	s.log.Warn("Пачка вытеснена из очереди", zap.String("file", filepath.Base(from.path)))
+	return to, os.Remove(from.path)
+}
+
+// list возвращает пачки очереди в порядке записи
+func (s *Spool) list() ([]entry, error) {
+	files, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]entry, 0, len(files))
+	for _, f := range files {
+		if f.IsDir() || !strings.HasSuffix(f.Name(), fileExt) {
+			continue
+		}
+		info, err := f.Info()
+		if err != nil {
+			continue
+		}
+		entries = append(entries, entry{
+			path: filepath.Join(s.dir, f.Name()),
+			size: info.Size(),
+			at:   writtenAt(f.Name(), info.ModTime()),
+		})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].path < entries[j].path
+	})
+	return entries, nil
+}
+
+// writtenAt возвращает время записи пачки из имени файла
+func writtenAt(name string, def time.Time) time.Time {
+	prefix, _, _ := strings.Cut(name, "-")
+	nano, err := strconv.ParseInt(prefix, 10, 64)
+	if err != nil {
+		return def
+	}
+	return time.Unix(0, nano)
+}
+
+func read(path string) ([]models.Metrics, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var batch []models.Metrics
+	if err := json.Unmarshal(data, &batch); err != nil {
+		return nil, err
+	}
+	return batch, nil
+}
+
+// Counters суммирует приращения счетчиков пачки по ключу ряда
+func Counters(batch []models.Metrics) map[string]models.Metrics {
+	counters := make(map[string]models.Metrics)
+	for _, m := range batch {
+		if m.MType != "counter" || m.Delta == nil {
+			continue
+		}
+		key := labels.Key(m.ID, m.Labels)
+		delta := *m.Delta
+		if prev, ok := counters[key]; ok {
+			delta += *prev.Delta
+		}
+		m.Delta = &delta
+		counters[key] = m
+	}
+	return counters
+}
+
+// MergeCounters прибавляет приращения counters к счетчикам пачки.
+// Счетчики, которых нет в пачке, добавляются в ее конец. Исходная пачка не изменяется.
+func MergeCounters(batch []models.Metrics, counters map[string]models.Metrics) []models.Metrics {
+	merged := make([]models.Metrics, 0, len(batch)+len(counters))
+	used := make(map[string]bool, len(counters))
+	for _, m := range batch {
+		key := labels.Key(m.ID, m.Labels)
+		if c, ok := counters[key]; ok && m.MType == "counter" && m.Delta != nil && !used[key] {
+			delta := *m.Delta + *c.Delta
+			m.Delta = &delta
+			used[key] = true
+		}
+		merged = append(merged, m)
+	}
+	keys := make([]string, 0, len(counters))
+	for key := range counters {
+		if !used[key] {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		merged = append(merged, counters[key])
+	}
+	return merged
+}
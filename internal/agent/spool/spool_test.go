@@ -0,0 +1,143 @@
+package spool
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/Arcadian-Sky/musthave-metrics/internal/agent/models"
+)
+
+func gauge(id string, v float64) models.Metrics {
+	return models.Metrics{ID: id, MType: "gauge", Value: &v}
+}
+
+func counter(id string, d int64) models.Metrics {
+	return models.Metrics{ID: id, MType: "counter", Delta: &d}
+}
+
+func newTestSpool(t *testing.T, maxBytes int64, maxAge time.Duration) (*Spool, *time.Time) {
+	s, err := New(t.TempDir(), maxBytes, maxAge)
+	assert.NoError(t, err)
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	s.now = func() time.Time { return now }
+	return s, &now
+}
+
+func TestSpool_ReplayOrder(t *testing.T) {
+	s, now := newTestSpool(t, 0, 0)
+	for i := 1; i <= 3; i++ {
+		assert.NoError(t, s.Put([]models.Metrics{gauge("Alloc", float64(i))}))
+		*now = now.Add(time.Second)
+	}
+	assert.Equal(t, 3, s.Len())
+
+	var got []float64
+	err := s.Replay(func(batch []models.Metrics) error {
+		got = append(got, *batch[0].Value)
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []float64{1, 2, 3}, got)
+	assert.Equal(t, 0, s.Len())
+}
+
+func TestSpool_ReplayStopsOnError(t *testing.T) {
+	s, _ := newTestSpool(t, 0, 0)
+	assert.NoError(t, s.Put([]models.Metrics{gauge("Alloc", 1)}))
+	assert.NoError(t, s.Put([]models.Metrics{gauge("Alloc", 2)}))
+
+	sendErr := errors.New("server is down")
+	calls := 0
+	err := s.Replay(func(batch []models.Metrics) error {
+		calls++
+		if *batch[0].Value == 2 {
+			return sendErr
+		}
+		return nil
+	})
+	assert.ErrorIs(t, err, sendErr)
+	assert.Equal(t, 2, calls)
+	assert.Equal(t, 1, s.Len())
+
+	var got []float64
+	assert.NoError(t, s.Replay(func(batch []models.Metrics) error {
+		got = append(got, *batch[0].Value)
+		return nil
+	}))
+	assert.Equal(t, []float64{2}, got)
+}
+
+func TestSpool_AgeEvictionMergesCounters(t *testing.T) {
+	s, now := newTestSpool(t, 0, time.Minute)
+	assert.NoError(t, s.Put([]models.Metrics{gauge("Alloc", 1), counter("PollCount", 5)}))
+	*now = now.Add(30 * time.Second)
+	assert.NoError(t, s.Put([]models.Metrics{gauge("Alloc", 2), counter("PollCount", 3)}))
+	*now = now.Add(time.Minute + time.Second)
+	assert.NoError(t, s.Put([]models.Metrics{gauge("Alloc", 3)}))
+
+	// Обе старые пачки вытеснены, их счетчики перенесены в последнюю
+	var batches [][]models.Metrics
+	assert.NoError(t, s.Replay(func(batch []models.Metrics) error {
+		batches = append(batches, batch)
+		return nil
+	}))
+	assert.Len(t, batches, 1)
+	assert.Equal(t, []models.Metrics{gauge("Alloc", 3), counter("PollCount", 8)}, batches[0])
+}
+
+func TestSpool_SizeEviction(t *testing.T) {
+	s, now := newTestSpool(t, 150, 0)
+	for i := 1; i <= 5; i++ {
+		assert.NoError(t, s.Put([]models.Metrics{gauge("Alloc", float64(i)), counter("PollCount", 1)}))
+		*now = now.Add(time.Second)
+	}
+
+	var total int64
+	var values []float64
+	assert.NoError(t, s.Replay(func(batch []models.Metrics) error {
+		for _, m := range batch {
+			switch m.MType {
+			case "gauge":
+				values = append(values, *m.Value)
+			case "counter":
+				total += *m.Delta
+			}
+		}
+		return nil
+	}))
+	assert.Less(t, len(values), 5)
+	assert.Equal(t, float64(5), values[len(values)-1])
+	assert.Equal(t, int64(5), total, "PollCount не должен теряться при вытеснении")
+}
+
+func TestSpool_CorruptBatchSkipped(t *testing.T) {
+	s, _ := newTestSpool(t, 0, 0)
+	assert.NoError(t, os.WriteFile(filepath.Join(s.dir, "00000000000000000001-000001.json"), []byte("{"), 0o644))
+	assert.NoError(t, s.Put([]models.Metrics{gauge("Alloc", 1)}))
+
+	calls := 0
+	assert.NoError(t, s.Replay(func(batch []models.Metrics) error {
+		calls++
+		return nil
+	}))
+	assert.Equal(t, 1, calls)
+	assert.Equal(t, 0, s.Len())
+}
+
+func TestMergeCounters(t *testing.T) {
+	labeled := counter("PollCount", 2)
+	labeled.Labels = map[string]string{"host": "a"}
+
+	counters := Counters([]models.Metrics{counter("PollCount", 1), counter("PollCount", 2), labeled, gauge("Alloc", 1)})
+	assert.Len(t, counters, 2)
+
+	batch := []models.Metrics{gauge("Alloc", 2), counter("PollCount", 4)}
+	merged := MergeCounters(batch, counters)
+	assert.Equal(t, []models.Metrics{gauge("Alloc", 2), counter("PollCount", 7), labeled}, merged)
+	assert.Equal(t, int64(4), *batch[1].Delta, "исходная пачка не изменяется")
+}
@@ -0,0 +1,86 @@
+// Пакет compression сжимает и распаковывает тела запросов.
+// Используется агентом для сжатия и сервером для распаковки.
+//
+// Поддерживаются значения Content-Encoding gzip, zstd и br (brotli).
+package compression
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Значения заголовка Content-Encoding
+const (
+	Gzip   = "gzip"
+	Zstd   = "zstd"
+	Brotli = "br"
+	// None отключает сжатие
+	None = "none"
+)
+
+// DefaultMaxDecodedSize предельный размер распакованного тела запроса на сервере по умолчанию, 10 МиБ
+const DefaultMaxDecodedSize = 10 << 20
+
+// ErrUnsupported кодировка не поддерживается
+var ErrUnsupported = errors.New("unsupported content encoding")
+
+// Supported сообщает, умеет ли пакет работать с кодировкой
+func Supported(encoding string) bool {
+	switch encoding {
+	case Gzip, Zstd, Brotli:
+		return true
+	}
+	return false
+}
+
+// Encode сжимает данные в указанной кодировке
+func Encode(encoding string, data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	var w io.WriteCloser
+	switch encoding {
+	case Gzip:
+		w = gzip.NewWriter(&buf)
+	case Zstd:
+		zw, err := zstd.NewWriter(&buf)
+		if err != nil {
+			return nil, err
+		}
+		w = zw
+	case Brotli:
+		w = brotli.NewWriter(&buf)
+	default:
+		return nil, fmt.Errorf("%w %q", ErrUnsupported, encoding)
+	}
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// NewReader возвращает распаковывающий reader для кодировки из заголовка Content-Encoding.
+// Закрытие возвращенного reader не закрывает r.
+func NewReader(encoding string, r io.Reader) (io.ReadCloser, error) {
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case Gzip, "x-gzip":
+		return gzip.NewReader(r)
+	case Zstd:
+		zr, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, err
+		}
+		return zr.IOReadCloser(), nil
+	case Brotli:
+		return io.NopCloser(brotli.NewReader(r)), nil
+	}
+	return nil, fmt.Errorf("%w %q", ErrUnsupported, encoding)
+}
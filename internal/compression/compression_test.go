@@ -0,0 +1,41 @@
+package compression
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEncodeAndNewReader(t *testing.T) {
+	data := bytes.Repeat([]byte(`{"id":"Alloc","type":"gauge","value":1.5},`), 100)
+	for _, encoding := range []string{Gzip, Zstd, Brotli} {
+		t.Run(encoding, func(t *testing.T) {
+			assert.True(t, Supported(encoding))
+
+			compressed, err := Encode(encoding, data)
+			require.NoError(t, err)
+			assert.Less(t, len(compressed), len(data))
+
+			r, err := NewReader(encoding, bytes.NewReader(compressed))
+			require.NoError(t, err)
+			defer r.Close()
+			got, err := io.ReadAll(r)
+			require.NoError(t, err)
+			assert.Equal(t, data, got)
+		})
+	}
+}
+
+func TestUnsupported(t *testing.T) {
+	assert.False(t, Supported("deflate"))
+	assert.False(t, Supported(None))
+
+	_, err := Encode("deflate", []byte("x"))
+	assert.ErrorIs(t, err, ErrUnsupported)
+
+	_, err = NewReader("deflate", bytes.NewReader(nil))
+	assert.ErrorIs(t, err, ErrUnsupported)
+}
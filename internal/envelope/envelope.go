@@ -0,0 +1,85 @@
+// Пакет envelope реализует гибридное шифрование тела запроса агента.
+//
+// Тело шифруется AES-256-GCM на случайном ключе, который создается для каждого запроса.
+// Ключ шифруется публичным ключом сервера по схеме RSA-OAEP (SHA-256)
+// и передается в заголовке KeyHeader в кодировке base64.
+// Зашифрованное тело имеет вид nonce || ciphertext.
+package envelope
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+)
+
+// KeyHeader заголовок с зашифрованным сеансовым ключом
+const KeyHeader = "X-Encrypted-Key"
+
+// keySize размер сеансового ключа AES-256
+const keySize = 32
+
+// Encrypt шифрует сообщение на случайном сеансовом ключе.
+// Возвращает зашифрованное тело и значение заголовка KeyHeader.
+func Encrypt(publicKey *rsa.PublicKey, message []byte) ([]byte, string, error) {
+	key := make([]byte, keySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, "", err
+	}
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, "", err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, "", err
+	}
+
+	wrappedKey, err := rsa.EncryptOAEP(sha256.New(), rand.Reader, publicKey, key, nil)
+	if err != nil {
+		return nil, "", fmt.Errorf("ошибка при шифровании сеансового ключа: %w", err)
+	}
+
+	return gcm.Seal(nonce, nonce, message, nil), base64.StdEncoding.EncodeToString(wrappedKey), nil
+}
+
+// Decrypt расшифровывает тело по значению заголовка KeyHeader
+func Decrypt(privateKey *rsa.PrivateKey, body []byte, header string) ([]byte, error) {
+	wrappedKey, err := base64.StdEncoding.DecodeString(header)
+	if err != nil {
+		return nil, fmt.Errorf("неверный формат сеансового ключа: %w", err)
+	}
+	key, err := rsa.DecryptOAEP(sha256.New(), rand.Reader, privateKey, wrappedKey, nil)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка при расшифровке сеансового ключа: %w", err)
+	}
+	if len(key) != keySize {
+		return nil, fmt.Errorf("неверная длина сеансового ключа: %d", len(key))
+	}
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(body) < gcm.NonceSize() {
+		return nil, fmt.Errorf("зашифрованное тело короче nonce")
+	}
+	nonce, ciphertext := body[:gcm.NonceSize()], body[gcm.NonceSize():]
+	return gcm.Open(nil, nonce, ciphertext, nil)
+}
+
+// DecryptLegacy расшифровывает тело, целиком зашифрованное RSA PKCS#1 v1.5.
+// Оставлено для агентов предыдущей версии и будет удалено в следующем релизе.
+func DecryptLegacy(privateKey *rsa.PrivateKey, body []byte) ([]byte, error) {
+	return rsa.DecryptPKCS1v15(rand.Reader, privateKey, body)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,52 @@
+package envelope
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEncryptDecrypt(t *testing.T) {
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	// Сообщение заметно больше размера ключа, RSA напрямую его не зашифрует
+	message := bytes.Repeat([]byte(`{"id":"Alloc","type":"gauge","value":1.5},`), 1000)
+
+	body, header, err := Encrypt(&privateKey.PublicKey, message)
+	require.NoError(t, err)
+	assert.NotEmpty(t, header)
+	assert.NotContains(t, string(body), "Alloc")
+
+	decrypted, err := Decrypt(privateKey, body, header)
+	require.NoError(t, err)
+	assert.Equal(t, message, decrypted)
+
+	// Изменение тела обнаруживается GCM
+	body[len(body)-1] ^= 0xff
+	_, err = Decrypt(privateKey, body, header)
+	assert.Error(t, err)
+
+	_, err = Decrypt(privateKey, body, "not base64")
+	assert.Error(t, err)
+
+	_, err = Decrypt(privateKey, []byte("short"), header)
+	assert.Error(t, err)
+}
+
+func TestDecryptLegacy(t *testing.T) {
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	message := []byte(`{"id":"Alloc","type":"gauge","value":1.5}`)
+	body, err := rsa.EncryptPKCS1v15(rand.Reader, &privateKey.PublicKey, message)
+	require.NoError(t, err)
+
+	decrypted, err := DecryptLegacy(privateKey, body)
+	require.NoError(t, err)
+	assert.Equal(t, message, decrypted)
+}
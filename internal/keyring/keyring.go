@@ -0,0 +1,225 @@
+// Пакет keyring хранит ключи подписи HMAC-SHA256 запросов агента и ответов сервера.
+//
+// Подпись передается в заголовке HashHeader в hex, идентификатор ключа - в заголовке KeyIDHeader.
+// Запрос агента подписывается вместе с методом, адресом, временем отправки и nonce, см. Payload,
+// ответ сервера - вместе с nonce запроса, см. ResponsePayload.
+// Одновременно действуют несколько ключей, поэтому ключ можно сменить без одновременного
+// обновления всех агентов: новый ключ добавляется на сервер, агенты по одному переходят на него,
+// затем старый ключ удаляется или истекает. Ключ из флага -k имеет пустой идентификатор,
+// им проверяются запросы без KeyIDHeader.
+package keyring
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Заголовки подписи
+const (
+	HashHeader      = "HashSHA256"
+	KeyIDHeader     = "HashKeyID"
+	TimestampHeader = "HashTimestamp"
+	NonceHeader     = "HashNonce"
+)
+
+// nonceSize длина nonce в байтах
+const nonceSize = 16
+
+var (
+	// ErrUnknownKey ключа с таким идентификатором нет
+	ErrUnknownKey = errors.New("unknown hash key")
+	// ErrKeyExpired срок действия ключа истек
+	ErrKeyExpired = errors.New("hash key expired")
+	// ErrHashMismatch подпись не совпадает
+	ErrHashMismatch = errors.New("hash not valid")
+	// ErrHashRequired запрос не подписан, а сервер принимает только подписанные запросы
+	ErrHashRequired = errors.New("hash required")
+)
+
+// Key ключ подписи. Нулевой Expires означает бессрочный ключ.
+type Key struct {
+	ID      string    `json:"id"`
+	Secret  string    `json:"secret"`
+	Expires time.Time `json:"expires"`
+}
+
+// file формат файла ключей
+type file struct {
+	Keys []Key `json:"keys"`
+}
+
+// Keyring набор действующих ключей. Методы безопасны для nil и пустого набора, тогда подпись выключена.
+type Keyring struct {
+	mu   sync.RWMutex
+	keys map[string]Key
+	now  func() time.Time
+}
+
+// New создает набор из ключей keys
+func New(keys ...Key) (*Keyring, error) {
+	k := &Keyring{now: time.Now}
+	if err := k.Replace(keys...); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+// Load читает ключи из JSON файла вида {"keys": [{"id": "2024-05", "secret": "...", "expires": "2024-12-31T00:00:00Z"}]}
+func Load(path string) ([]Key, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading hash keys: %w", err)
+	}
+	var f file
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("error parsing hash keys: %w", err)
+	}
+	return f.Keys, nil
+}
+
+// Replace заменяет все ключи набора, например при перечитывании файла по SIGHUP.
+// При ошибке набор не меняется.
+func (k *Keyring) Replace(keys ...Key) error {
+	m := make(map[string]Key, len(keys))
+	for _, key := range keys {
+		if key.Secret == "" {
+			return fmt.Errorf("hash key %q: empty secret", key.ID)
+		}
+		if _, ok := m[key.ID]; ok {
+			return fmt.Errorf("hash key %q: duplicate id", key.ID)
+		}
+		m[key.ID] = key
+	}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.keys = m
+	return nil
+}
+
+// Len возвращает число ключей, включая истекшие
+func (k *Keyring) Len() int {
+	if k == nil {
+		return 0
+	}
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return len(k.keys)
+}
+
+// IDs возвращает отсортированные идентификаторы ключей
+func (k *Keyring) IDs() []string {
+	if k == nil {
+		return nil
+	}
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	ids := make([]string, 0, len(k.keys))
+	for id := range k.keys {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// Has сообщает, есть ли действующий ключ с идентификатором id
+func (k *Keyring) Has(id string) bool {
+	_, err := k.key(id)
+	return err == nil
+}
+
+// Sign возвращает подпись body ключом id в hex
+func (k *Keyring) Sign(id string, body []byte) (string, error) {
+	key, err := k.key(id)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(sum(key.Secret, body)), nil
+}
+
+// Verify проверяет подпись body в hex ключом id
+func (k *Keyring) Verify(id, signature string, body []byte) error {
+	key, err := k.key(id)
+	if err != nil {
+		return err
+	}
+	data, err := hex.DecodeString(signature)
+	if err != nil {
+		return ErrHashMismatch
+	}
+	if !hmac.Equal(sum(key.Secret, body), data) {
+		return ErrHashMismatch
+	}
+	return nil
+}
+
+// key возвращает действующий ключ id
+func (k *Keyring) key(id string) (Key, error) {
+	if k == nil {
+		return Key{}, fmt.Errorf("%w %q", ErrUnknownKey, id)
+	}
+	k.mu.RLock()
+	key, ok := k.keys[id]
+	k.mu.RUnlock()
+	if !ok {
+		return Key{}, fmt.Errorf("%w %q", ErrUnknownKey, id)
+	}
+	if !key.Expires.IsZero() && !k.now().Before(key.Expires) {
+		return Key{}, fmt.Errorf("%w %q", ErrKeyExpired, id)
+	}
+	return key, nil
+}
+
+func sum(secret string, body []byte) []byte {
+	h := hmac.New(sha256.New, []byte(secret))
+	h.Write(body)
+	return h.Sum(nil)
+}
+
+// Payload возвращает подписываемые данные запроса: метод, адрес запроса с параметрами (RequestURI),
+// время отправки в unix-секундах, nonce и тело, разделенные переводом строки. Адрес подписывается,
+// потому что запись /update/{type}/{name}/{value} передает метрику в пути, а не в теле.
+// Время и nonce передаются в заголовках TimestampHeader и NonceHeader.
+// Для gRPC метод - POST, адрес - полное имя метода, как в запросе HTTP/2.
+func Payload(method, uri, timestamp, nonce string, body []byte) []byte {
+	payload := make([]byte, 0, len(method)+len(uri)+len(timestamp)+len(nonce)+len(body)+4)
+	payload = append(payload, method...)
+	payload = append(payload, '\n')
+	payload = append(payload, uri...)
+	payload = append(payload, '\n')
+	payload = append(payload, timestamp...)
+	payload = append(payload, '\n')
+	payload = append(payload, nonce...)
+	payload = append(payload, '\n')
+	return append(payload, body...)
+}
+
+// ResponsePayload возвращает подписываемые данные ответа: nonce запроса и тело, разделенные переводом строки.
+// Nonce связывает ответ с запросом, поэтому перехваченный подписанный ответ нельзя выдать за ответ
+// на другой запрос. Ответ на запрос без nonce от агента предыдущей версии подписывается только по телу.
+func ResponsePayload(nonce string, body []byte) []byte {
+	if nonce == "" {
+		return body
+	}
+	payload := make([]byte, 0, len(nonce)+len(body)+1)
+	payload = append(payload, nonce...)
+	payload = append(payload, '\n')
+	return append(payload, body...)
+}
+
+// NewNonce возвращает случайный nonce запроса в hex
+func NewNonce() (string, error) {
+	b := make([]byte, nonceSize)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,85 @@
+package keyring
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSignVerify(t *testing.T) {
+	keys, err := New(Key{Secret: "default"}, Key{ID: "k1", Secret: "secret"})
+	require.NoError(t, err)
+	body := []byte(`{"id":"Alloc","type":"gauge","value":1}`)
+
+	signature, err := keys.Sign("k1", body)
+	require.NoError(t, err)
+	assert.NoError(t, keys.Verify("k1", signature, body))
+	assert.ErrorIs(t, keys.Verify("", signature, body), ErrHashMismatch)
+	assert.ErrorIs(t, keys.Verify("k1", signature, []byte("other")), ErrHashMismatch)
+	assert.ErrorIs(t, keys.Verify("k1", "not hex", body), ErrHashMismatch)
+	assert.ErrorIs(t, keys.Verify("k2", signature, body), ErrUnknownKey)
+
+	_, err = keys.Sign("k2", body)
+	assert.ErrorIs(t, err, ErrUnknownKey)
+	assert.Equal(t, []string{"", "k1"}, keys.IDs())
+}
+
+func TestExpiredKey(t *testing.T) {
+	now := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	keys, err := New(Key{ID: "old", Secret: "secret", Expires: now.Add(time.Hour)})
+	require.NoError(t, err)
+	keys.now = func() time.Time { return now }
+
+	signature, err := keys.Sign("old", []byte("body"))
+	require.NoError(t, err)
+	assert.True(t, keys.Has("old"))
+
+	now = now.Add(time.Hour)
+	assert.False(t, keys.Has("old"))
+	assert.ErrorIs(t, keys.Verify("old", signature, []byte("body")), ErrKeyExpired)
+	assert.Equal(t, 1, keys.Len())
+}
+
+func TestReplace(t *testing.T) {
+	keys, err := New(Key{ID: "k1", Secret: "secret"})
+	require.NoError(t, err)
+
+	assert.Error(t, keys.Replace(Key{ID: "k2"}))
+	assert.Error(t, keys.Replace(Key{ID: "k2", Secret: "a"}, Key{ID: "k2", Secret: "b"}))
+	// При ошибке ключи не меняются
+	assert.True(t, keys.Has("k1"))
+
+	require.NoError(t, keys.Replace(Key{ID: "k2", Secret: "a"}))
+	assert.False(t, keys.Has("k1"))
+	assert.True(t, keys.Has("k2"))
+}
+
+func TestNilKeyring(t *testing.T) {
+	var keys *Keyring
+	assert.Equal(t, 0, keys.Len())
+	assert.Nil(t, keys.IDs())
+	assert.False(t, keys.Has(""))
+	assert.ErrorIs(t, keys.Verify("", "00", nil), ErrUnknownKey)
+}
+
+func TestLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys.json")
+	require.NoError(t, os.WriteFile(path, []byte(`{"keys":[{"id":"k1","secret":"s1","expires":"2024-12-31T00:00:00Z"},{"id":"k2","secret":"s2"}]}`), 0o600))
+
+	keys, err := Load(path)
+	require.NoError(t, err)
+	require.Len(t, keys, 2)
+	assert.Equal(t, Key{ID: "k1", Secret: "s1", Expires: time.Date(2024, 12, 31, 0, 0, 0, 0, time.UTC)}, keys[0])
+	assert.True(t, keys[1].Expires.IsZero())
+
+	require.NoError(t, os.WriteFile(path, []byte(`{`), 0o600))
+	_, err = Load(path)
+	assert.Error(t, err)
+
+	_, err = Load(filepath.Join(t.TempDir(), "missing.json"))
+	assert.Error(t, err)
+}
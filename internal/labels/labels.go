@@ -0,0 +1,256 @@
+// Пакет labels реализует метки метрик: канонический ключ ряда, разбор меток и селекторы.
+// Используется и сервером, и агентом.
+//
+// Ключ ряда имеет вид name{a="1",b="2"}: метки упорядочены по имени,
+// у метрики без меток ключ совпадает с именем.
+package labels
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Key возвращает канонический ключ ряда для имени метрики и набора меток
+func Key(name string, l map[string]string) string {
+	if len(l) == 0 {
+		return name
+	}
+	names := make([]string, 0, len(l))
+	for k := range l {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	b.WriteString(name)
+	b.WriteByte('{')
+	for i, k := range names {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(k)
+		b.WriteString(`="`)
+		b.WriteString(escape(l[k]))
+		b.WriteByte('"')
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+// ParseKey разбирает ключ ряда на имя метрики и метки.
+// Если ключ не содержит корректного блока меток, он целиком считается именем.
+func ParseKey(key string) (string, map[string]string) {
+	start := strings.IndexByte(key, '{')
+	if start <= 0 || !strings.HasSuffix(key, "}") {
+		return key, nil
+	}
+	l, ok := parseBlock(key[start+1 : len(key)-1])
+	if !ok {
+		return key, nil
+	}
+	return key[:start], l
+}
+
+// parseBlock разбирает содержимое фигурных скобок ключа: a="1",b="2"
+func parseBlock(s string) (map[string]string, bool) {
+	l := make(map[string]string)
+	for len(s) > 0 {
+		eq := strings.Index(s, `="`)
+		if eq <= 0 || !ValidName(s[:eq]) {
+			return nil, false
+		}
+		name := s[:eq]
+		s = s[eq+2:]
+
+		var value strings.Builder
+		closed := false
+		for i := 0; i < len(s); i++ {
+			switch s[i] {
+			case '\\':
+				if i+1 >= len(s) {
+					return nil, false
+				}
+				i++
+				if s[i] == 'n' {
+					value.WriteByte('\n')
+				} else {
+					value.WriteByte(s[i])
+				}
+			case '"':
+				s = s[i+1:]
+				closed = true
+			default:
+				value.WriteByte(s[i])
+			}
+			if closed {
+				break
+			}
+		}
+		if !closed {
+			return nil, false
+		}
+		l[name] = value.String()
+		if len(s) > 0 {
+			if s[0] != ',' {
+				return nil, false
+			}
+			s = s[1:]
+		}
+	}
+	return l, true
+}
+
+func escape(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, `"`, `\"`)
+	return strings.ReplaceAll(s, "\n", `\n`)
+}
+
+// ValidName проверяет имя метки на соответствие [a-zA-Z_][a-zA-Z0-9_]*
+func ValidName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for i, r := range name {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r == '_':
+		case r >= '0' && r <= '9' && i > 0:
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// Sanitize приводит имя метки к виду [a-zA-Z_][a-zA-Z0-9_]*: недопустимые символы заменяются
+// на подчеркивание, перед цифрой в начале имени добавляется подчеркивание
+func Sanitize(name string) string {
+	if ValidName(name) {
+		return name
+	}
+	var b strings.Builder
+	for i, r := range name {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r == '_':
+			b.WriteRune(r)
+		case r >= '0' && r <= '9':
+			if i == 0 {
+				b.WriteRune('_')
+			}
+			b.WriteRune(r)
+		default:
+			b.WriteRune('_')
+		}
+	}
+	if b.Len() == 0 {
+		return "_"
+	}
+	return b.String()
+}
+
+// Validate проверяет имена меток
+func Validate(l map[string]string) error {
+	for name := range l {
+		if !ValidName(name) {
+			return fmt.Errorf("invalid label name %q", name)
+		}
+	}
+	return nil
+}
+
+// Parse разбирает метки из строки вида host=a,env=prod
+func Parse(s string) (map[string]string, error) {
+	l := make(map[string]string)
+	for _, pair := range split(s) {
+		name, value, ok := strings.Cut(pair, "=")
+		name = strings.TrimSpace(name)
+		if !ok || !ValidName(name) {
+			return nil, fmt.Errorf("invalid label %q", pair)
+		}
+		l[name] = unquote(strings.TrimSpace(value))
+	}
+	return l, nil
+}
+
+// Matcher условие на значение одной метки
+type Matcher struct {
+	Name     string
+	Value    string
+	Negative bool
+}
+
+// Matches проверяет набор меток на соответствие условию.
+// Отсутствующая метка считается пустой строкой.
+func (m Matcher) Matches(l map[string]string) bool {
+	return (l[m.Name] == m.Value) != m.Negative
+}
+
+// Selector набор условий, которые должны выполняться одновременно
+type Selector []Matcher
+
+// ParseSelector разбирает селектор вида host=a,env!=dev
+func ParseSelector(s string) (Selector, error) {
+	sel := make(Selector, 0)
+	for _, part := range split(s) {
+		m := Matcher{}
+		name, value, ok := strings.Cut(part, "!=")
+		if ok {
+			m.Negative = true
+		} else if name, value, ok = strings.Cut(part, "="); !ok {
+			return nil, fmt.Errorf("invalid label matcher %q", part)
+		}
+		m.Name = strings.TrimSpace(name)
+		m.Value = unquote(strings.TrimSpace(value))
+		if !ValidName(m.Name) {
+			return nil, fmt.Errorf("invalid label name %q", m.Name)
+		}
+		sel = append(sel, m)
+	}
+	return sel, nil
+}
+
+// Matches проверяет набор меток на соответствие всем условиям селектора
+func (s Selector) Matches(l map[string]string) bool {
+	for _, m := range s {
+		if !m.Matches(l) {
+			return false
+		}
+	}
+	return true
+}
+
+// MatchesKey проверяет ключ ряда на соответствие селектору
+func (s Selector) MatchesKey(key string) bool {
+	_, l := ParseKey(key)
+	return s.Matches(l)
+}
+
+// Equalities возвращает условия на равенство непустым значениям,
+// их можно проверить на стороне базы данных
+func (s Selector) Equalities() map[string]string {
+	eq := make(map[string]string)
+	for _, m := range s {
+		if !m.Negative && m.Value != "" {
+			eq[m.Name] = m.Value
+		}
+	}
+	return eq
+}
+
+func split(s string) []string {
+	parts := make([]string, 0)
+	for _, part := range strings.Split(s, ",") {
+		if strings.TrimSpace(part) != "" {
+			parts = append(parts, part)
+		}
+	}
+	return parts
+}
+
+func unquote(s string) string {
+	if len(s) >= 2 && s[0] == '"' && s[len(s)-1] == '"' {
+		return s[1 : len(s)-1]
+	}
+	return s
+}
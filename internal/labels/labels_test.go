@@ -0,0 +1,108 @@
+package labels
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKeyAndParseKey(t *testing.T) {
+	tests := []struct {
+		name       string
+		metricName string
+		labels     map[string]string
+		key        string
+	}{
+		{
+			name:       "NoLabels",
+			metricName: "Alloc",
+			labels:     nil,
+			key:        "Alloc",
+		},
+		{
+			name:       "Sorted",
+			metricName: "Alloc",
+			labels:     map[string]string{"host": "a", "env": "prod"},
+			key:        `Alloc{env="prod",host="a"}`,
+		},
+		{
+			name:       "Escaped",
+			metricName: "Alloc",
+			labels:     map[string]string{"path": `c:\tmp "x"` + "\n"},
+			key:        `Alloc{path="c:\\tmp \"x\"\n"}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key := Key(tt.metricName, tt.labels)
+			assert.Equal(t, tt.key, key)
+
+			name, l := ParseKey(key)
+			assert.Equal(t, tt.metricName, name)
+			if len(tt.labels) == 0 {
+				assert.Empty(t, l)
+			} else {
+				assert.Equal(t, tt.labels, l)
+			}
+		})
+	}
+}
+
+func TestParseKeyInvalid(t *testing.T) {
+	for _, key := range []string{"a{b", "{x=\"1\"}", `a{1x="1"}`, `a{x="1}`, `a{x="1"y="2"}`} {
+		name, l := ParseKey(key)
+		assert.Equal(t, key, name)
+		assert.Nil(t, l)
+	}
+}
+
+func TestParse(t *testing.T) {
+	l, err := Parse(`host=a, env="prod"`)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"host": "a", "env": "prod"}, l)
+
+	l, err = Parse("")
+	assert.NoError(t, err)
+	assert.Empty(t, l)
+
+	_, err = Parse("host")
+	assert.Error(t, err)
+
+	_, err = Parse("1host=a")
+	assert.Error(t, err)
+}
+
+func TestValidate(t *testing.T) {
+	assert.NoError(t, Validate(map[string]string{"host_1": "a"}))
+	assert.Error(t, Validate(map[string]string{"host-1": "a"}))
+}
+
+func TestSanitize(t *testing.T) {
+	assert.Equal(t, "host_1", Sanitize("host_1"))
+	assert.Equal(t, "host_name", Sanitize("host-name"))
+	assert.Equal(t, "_1zone", Sanitize("1zone"))
+	assert.Equal(t, "_", Sanitize(""))
+}
+
+func TestSelector(t *testing.T) {
+	sel, err := ParseSelector("env=prod,host!=b")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"env": "prod"}, sel.Equalities())
+
+	assert.True(t, sel.Matches(map[string]string{"env": "prod", "host": "a"}))
+	assert.True(t, sel.Matches(map[string]string{"env": "prod"}))
+	assert.False(t, sel.Matches(map[string]string{"env": "prod", "host": "b"}))
+	assert.False(t, sel.Matches(map[string]string{"env": "dev"}))
+
+	assert.True(t, sel.MatchesKey(`Alloc{env="prod"}`))
+	assert.False(t, sel.MatchesKey("Alloc"))
+
+	empty, err := ParseSelector("")
+	assert.NoError(t, err)
+	assert.True(t, empty.MatchesKey("Alloc"))
+
+	_, err = ParseSelector("env")
+	assert.Error(t, err)
+	_, err = ParseSelector("e-nv=1")
+	assert.Error(t, err)
+}
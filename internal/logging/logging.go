@@ -0,0 +1,110 @@
+// Пакет logging создает логгер zap из конфигурации и передает его через контекст.
+//
+// Один логгер создается при старте сервера или агента и внедряется в компоненты.
+// Для запроса к нему добавляется поле request_id, такой логгер кладется в контекст запроса
+// и извлекается через FromContext, поэтому каждая строка лога запроса содержит его идентификатор.
+package logging
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// Форматы вывода
+const (
+	FormatJSON    = "json"
+	FormatConsole = "console"
+)
+
+// Значения по умолчанию
+const (
+	DefaultLevel  = "info"
+	DefaultFormat = FormatConsole
+)
+
+// RequestIDField имя поля с идентификатором запроса
+const RequestIDField = "request_id"
+
+// Config настройки логгера
+type Config struct {
+	Level    string // debug, info, warn или error
+	Format   string // json или console
+	Sampling bool   // в секунду пишутся первые 100 одинаковых сообщений, затем каждое сотое
+}
+
+// New создает логгер по настройкам. Пустые значения заменяются значениями по умолчанию.
+func New(cfg Config) (*zap.Logger, error) {
+	level := zap.NewAtomicLevel()
+	if cfg.Level == "" {
+		cfg.Level = DefaultLevel
+	}
+	if err := level.UnmarshalText([]byte(strings.ToLower(cfg.Level))); err != nil {
+		return nil, fmt.Errorf("неизвестный уровень логирования %q", cfg.Level)
+	}
+
+	var zcfg zap.Config
+	switch cfg.Format {
+	case FormatJSON:
+		zcfg = zap.NewProductionConfig()
+	case FormatConsole, "":
+		zcfg = zap.NewDevelopmentConfig()
+		zcfg.Development = false
+	default:
+		return nil, fmt.Errorf("неизвестный формат логирования %q", cfg.Format)
+	}
+	zcfg.Level = level
+	zcfg.Sampling = nil
+	if cfg.Sampling {
+		zcfg.Sampling = &zap.SamplingConfig{Initial: 100, Thereafter: 100}
+	}
+	zcfg.EncoderConfig.EncodeTime = zapcore.ISO8601TimeEncoder
+	return zcfg.Build()
+}
+
+type loggerKey struct{}
+
+type requestIDKey struct{}
+
+// WithLogger возвращает контекст с логгером
+func WithLogger(ctx context.Context, logger *zap.Logger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, logger)
+}
+
+// FromContext возвращает логгер запроса из контекста.
+// Если его нет, возвращает fallback, а если не задан и он - пустой логгер.
+func FromContext(ctx context.Context, fallback *zap.Logger) *zap.Logger {
+	if logger, ok := ctx.Value(loggerKey{}).(*zap.Logger); ok && logger != nil {
+		return logger
+	}
+	if fallback != nil {
+		return fallback
+	}
+	return zap.NewNop()
+}
+
+// WithRequestID возвращает контекст с идентификатором запроса и логгером, который пишет его в каждую строку
+func WithRequestID(ctx context.Context, logger *zap.Logger, id string) context.Context {
+	ctx = context.WithValue(ctx, requestIDKey{}, id)
+	return WithLogger(ctx, logger.With(zap.String(RequestIDField, id)))
+}
+
+// RequestID возвращает идентификатор запроса из контекста или пустую строку
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// NewRequestID создает случайный идентификатор запроса
+func NewRequestID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(b)
+}
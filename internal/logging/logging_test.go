@@ -0,0 +1,66 @@
+package logging
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     Config
+		level   zapcore.Level
+		wantErr bool
+	}{
+		{name: "defaults", cfg: Config{}, level: zapcore.InfoLevel},
+		{name: "json debug", cfg: Config{Level: "debug", Format: FormatJSON}, level: zapcore.DebugLevel},
+		{name: "console warn sampling", cfg: Config{Level: "WARN", Format: FormatConsole, Sampling: true}, level: zapcore.WarnLevel},
+		{name: "unknown level", cfg: Config{Level: "verbose"}, wantErr: true},
+		{name: "unknown format", cfg: Config{Format: "xml"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logger, err := New(tt.cfg)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.True(t, logger.Core().Enabled(tt.level))
+			assert.False(t, logger.Core().Enabled(tt.level-1))
+		})
+	}
+}
+
+func TestFromContext(t *testing.T) {
+	fallback := zap.NewExample()
+	assert.Same(t, fallback, FromContext(context.Background(), fallback))
+	assert.NotNil(t, FromContext(context.Background(), nil))
+
+	own := zap.NewExample()
+	assert.Same(t, own, FromContext(WithLogger(context.Background(), own), fallback))
+}
+
+func TestWithRequestID(t *testing.T) {
+	core, logs := observer.New(zapcore.InfoLevel)
+	ctx := WithRequestID(context.Background(), zap.New(core), "abc")
+
+	assert.Equal(t, "abc", RequestID(ctx))
+	assert.Empty(t, RequestID(context.Background()))
+
+	FromContext(ctx, nil).Info("handled")
+	require.Equal(t, 1, logs.Len())
+	assert.Equal(t, "abc", logs.All()[0].ContextMap()[RequestIDField])
+}
+
+func TestNewRequestID(t *testing.T) {
+	id := NewRequestID()
+	assert.Len(t, id, 32)
+	assert.NotEqual(t, id, NewRequestID())
+}
@@ -0,0 +1,20 @@
+package proto
+
+// HashMetadataKey ключ метаданных gRPC, в котором передается подпись HMAC-SHA256 запроса и ответа
+const HashMetadataKey = "hashsha256"
+
+// RealIPMetadataKey ключ метаданных gRPC с адресом агента, аналог заголовка X-Real-IP
+const RealIPMetadataKey = "x-real-ip"
+
+// HashKeyIDMetadataKey ключ метаданных gRPC с идентификатором ключа подписи, аналог заголовка HashKeyID
+const HashKeyIDMetadataKey = "hashkeyid"
+
+// HashTimestampMetadataKey ключ метаданных gRPC со временем отправки запроса, аналог заголовка HashTimestamp
+const HashTimestampMetadataKey = "hashtimestamp"
+
+// HashNonceMetadataKey ключ метаданных gRPC с nonce запроса, аналог заголовка HashNonce
+const HashNonceMetadataKey = "hashnonce"
+
+// RetryAfterMetadataKey ключ метаданных gRPC с паузой в секундах перед повтором ответа ResourceExhausted,
+// аналог заголовка Retry-After
+const RetryAfterMetadataKey = "retry-after"
@@ -0,0 +1,234 @@
+// Пакет alerting вычисляет правила алертов по значениям метрик хранилища.
+//
+// Правило проходит состояния inactive -> pending -> firing -> resolved.
+// Условие должно выполняться не меньше For, чтобы алерт перешел из pending в firing.
+// Переходы в firing и resolved отправляются в Notifier.
+package alerting
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Arcadian-Sky/musthave-metrics/internal/server/storage"
+	"go.uber.org/zap"
+)
+
+// State состояние алерта
+type State string
+
+const (
+	StateInactive State = "inactive"
+	StatePending  State = "pending"
+	StateFiring   State = "firing"
+	StateResolved State = "resolved"
+)
+
+// defaultInterval период вычисления правил, если он не задан в конфигурации
+const defaultInterval = 15 * time.Second
+
+// Alert текущее состояние правила, возвращаемое ручкой /alerts и отправляемое в Notifier
+type Alert struct {
+	Name        string     `json:"name"`
+	Expr        string     `json:"expr"`
+	Description string     `json:"description,omitempty"`
+	State       State      `json:"state"`
+	Value       *float64   `json:"value,omitempty"`        // последнее вычисленное значение
+	ActiveSince *time.Time `json:"active_since,omitempty"` // с какого момента выполняется условие
+	FiredAt     *time.Time `json:"fired_at,omitempty"`
+	ResolvedAt  *time.Time `json:"resolved_at,omitempty"`
+}
+
+// sample предыдущее значение метрики для вычисления rate
+type sample struct {
+	value float64
+	at    time.Time
+}
+
+type ruleState struct {
+	rule  Rule
+	expr  Expr
+	alert Alert
+	prev  *sample
+}
+
+// Engine вычисляет правила по хранилищу метрик
+type Engine struct {
+	storage  storage.MetricsStorage
+	notifier Notifier
+	interval time.Duration
+	rules    []*ruleState
+	now      func() time.Time
+	mu       sync.Mutex
+}
+
+// NewEngine создает Engine и разбирает выражения всех правил
+func NewEngine(cfg Config, mStorage storage.MetricsStorage, notifier Notifier) (*Engine, error) {
+	e := &Engine{
+		storage:  mStorage,
+		notifier: notifier,
+		interval: time.Duration(cfg.Interval),
+		now:      time.Now,
+	}
+	if e.interval <= 0 {
+		e.interval = defaultInterval
+	}
+	for _, rule := range cfg.Rules {
+		expr, err := ParseExpr(rule.Expr)
+		if err != nil {
+			return nil, err
+		}
+		e.rules = append(e.rules, &ruleState{
+			rule: rule,
+			expr: expr,
+			alert: Alert{
+				Name:        rule.Name,
+				Expr:        rule.Expr,
+				Description: rule.Description,
+				State:       StateInactive,
+			},
+		})
+	}
+	return e, nil
+}
+
+// NewNotifier собирает уведомители из конфигурации: лог всегда, webhook если задан адрес
+func NewNotifier(cfg Config) Notifier {
+	notifiers := MultiNotifier{LogNotifier{}}
+	if cfg.WebhookURL != "" {
+		notifiers = append(notifiers, NewWebhookNotifier(cfg.WebhookURL))
+	}
+	return notifiers
+}
+
+// Run вычисляет правила с периодом из конфигурации до отмены контекста
+func (e *Engine) Run(ctx context.Context) {
+	ticker := time.NewTicker(e.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			e.Evaluate(ctx)
+		}
+	}
+}
+
+// Evaluate однократно вычисляет все правила и отправляет уведомления о переходах
+func (e *Engine) Evaluate(ctx context.Context) {
+	metrics := e.storage.GetMetrics(ctx)
+
+	e.mu.Lock()
+	now := e.now()
+	notify := make([]Alert, 0)
+	for _, rs := range e.rules {
+		if alert, changed := rs.evaluate(metrics, now); changed {
+			notify = append(notify, alert)
+		}
+	}
+	e.mu.Unlock()
+
+	for _, alert := range notify {
+		if err := e.notifier.Notify(ctx, alert); err != nil {
+			zap.L().Error("Ошибка отправки алерта", zap.String("alert", alert.Name), zap.Error(err))
+		}
+	}
+}
+
+// Alerts возвращает состояние всех правил, отсортированное по имени
+func (e *Engine) Alerts() []Alert {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	alerts := make([]Alert, 0, len(e.rules))
+	for _, rs := range e.rules {
+		alerts = append(alerts, rs.alert)
+	}
+	sort.SliceStable(alerts, func(i, j int) bool {
+		return alerts[i].Name < alerts[j].Name
+	})
+	return alerts
+}
+
+// evaluate вычисляет правило и возвращает алерт, если он перешел в firing или resolved
+func (rs *ruleState) evaluate(metrics map[storage.MetricType]map[string]interface{}, now time.Time) (Alert, bool) {
+	value, ok := rs.value(metrics, now)
+	if ok {
+		rs.alert.Value = &value
+	}
+	active := ok && rs.expr.Matches(value)
+
+	switch {
+	case active && (rs.alert.State == StateInactive || rs.alert.State == StateResolved):
+		rs.alert.State = StatePending
+		rs.alert.ActiveSince = &now
+		rs.alert.FiredAt = nil
+		rs.alert.ResolvedAt = nil
+		if rs.rule.For <= 0 {
+			return rs.fire(now), true
+		}
+	case active && rs.alert.State == StatePending:
+		if now.Sub(*rs.alert.ActiveSince) >= time.Duration(rs.rule.For) {
+			return rs.fire(now), true
+		}
+	case !active && rs.alert.State == StatePending:
+		rs.alert.State = StateInactive
+		rs.alert.ActiveSince = nil
+	case !active && rs.alert.State == StateFiring:
+		rs.alert.State = StateResolved
+		rs.alert.ActiveSince = nil
+		rs.alert.ResolvedAt = &now
+		return rs.alert, true
+	}
+	return rs.alert, false
+}
+
+func (rs *ruleState) fire(now time.Time) Alert {
+	rs.alert.State = StateFiring
+	rs.alert.FiredAt = &now
+	return rs.alert
+}
+
+// value возвращает значение метрики правила или ее скорость роста в секунду.
+// Метрика ищется среди gauge, затем среди counter.
+func (rs *ruleState) value(metrics map[storage.MetricType]map[string]interface{}, now time.Time) (float64, bool) {
+	var current float64
+	found := false
+	for _, mtype := range []storage.MetricType{storage.Gauge, storage.Counter} {
+		if v, ok := toFloat(metrics[mtype][rs.expr.Metric]); ok {
+			current, found = v, true
+			break
+		}
+	}
+	if !found {
+		return 0, false
+	}
+	if !rs.expr.Rate {
+		return current, true
+	}
+
+	prev := rs.prev
+	rs.prev = &sample{value: current, at: now}
+	if prev == nil || !now.After(prev.at) {
+		return 0, false
+	}
+	delta := current - prev.value
+	if delta < 0 {
+		// Счетчик сбросился, например после перезапуска агента
+		delta = current
+	}
+	return delta / now.Sub(prev.at).Seconds(), true
+}
+
+func toFloat(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case float64:
+		return v, true
+	case int64:
+		return float64(v), true
+	case int:
+		return float64(v), true
+	}
+	return 0, false
+}
@@ -0,0 +1,191 @@
+package alerting
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/Arcadian-Sky/musthave-metrics/internal/server/storage/inmemory"
+)
+
+// recorder запоминает отправленные алерты
+type recorder struct {
+	mu     sync.Mutex
+	alerts []Alert
+}
+
+func (r *recorder) Notify(_ context.Context, alert Alert) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.alerts = append(r.alerts, alert)
+	return nil
+}
+
+func newTestEngine(t *testing.T, rule Rule) (*Engine, *inmemory.MemStorage, *recorder, *time.Time) {
+	memStorage := inmemory.NewMemStorage()
+	notifier := &recorder{}
+	engine, err := NewEngine(Config{Rules: []Rule{rule}}, memStorage, notifier)
+	assert.NoError(t, err)
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	engine.now = func() time.Time { return now }
+	return engine, memStorage, notifier, &now
+}
+
+func TestEngine_States(t *testing.T) {
+	ctx := context.Background()
+	engine, memStorage, notifier, now := newTestEngine(t, Rule{
+		Name: "HeapHigh",
+		Expr: "HeapAlloc > 500MB",
+		For:  Duration(2 * time.Minute),
+	})
+
+	engine.Evaluate(ctx)
+	assert.Equal(t, StateInactive, engine.Alerts()[0].State)
+
+	assert.NoError(t, memStorage.UpdateMetric(ctx, "gauge", "HeapAlloc", "600000000"))
+	engine.Evaluate(ctx)
+	assert.Equal(t, StatePending, engine.Alerts()[0].State)
+
+	*now = now.Add(time.Minute)
+	engine.Evaluate(ctx)
+	assert.Equal(t, StatePending, engine.Alerts()[0].State)
+	assert.Empty(t, notifier.alerts)
+
+	*now = now.Add(time.Minute)
+	engine.Evaluate(ctx)
+	alert := engine.Alerts()[0]
+	assert.Equal(t, StateFiring, alert.State)
+	assert.Equal(t, float64(600000000), *alert.Value)
+	assert.Len(t, notifier.alerts, 1)
+	assert.Equal(t, StateFiring, notifier.alerts[0].State)
+
+	assert.NoError(t, memStorage.UpdateMetric(ctx, "gauge", "HeapAlloc", "1000"))
+	*now = now.Add(time.Minute)
+	engine.Evaluate(ctx)
+	alert = engine.Alerts()[0]
+	assert.Equal(t, StateResolved, alert.State)
+	assert.NotNil(t, alert.ResolvedAt)
+	assert.Len(t, notifier.alerts, 2)
+	assert.Equal(t, StateResolved, notifier.alerts[1].State)
+
+	// Повторное срабатывание снова проходит через pending
+	assert.NoError(t, memStorage.UpdateMetric(ctx, "gauge", "HeapAlloc", "600000000"))
+	engine.Evaluate(ctx)
+	alert = engine.Alerts()[0]
+	assert.Equal(t, StatePending, alert.State)
+	assert.Nil(t, alert.ResolvedAt)
+}
+
+func TestEngine_PendingReset(t *testing.T) {
+	ctx := context.Background()
+	engine, memStorage, notifier, now := newTestEngine(t, Rule{
+		Name: "HeapHigh",
+		Expr: "HeapAlloc > 1KB",
+		For:  Duration(time.Minute),
+	})
+
+	assert.NoError(t, memStorage.UpdateMetric(ctx, "gauge", "HeapAlloc", "2048"))
+	engine.Evaluate(ctx)
+	assert.Equal(t, StatePending, engine.Alerts()[0].State)
+
+	assert.NoError(t, memStorage.UpdateMetric(ctx, "gauge", "HeapAlloc", "1"))
+	*now = now.Add(time.Minute)
+	engine.Evaluate(ctx)
+	assert.Equal(t, StateInactive, engine.Alerts()[0].State)
+	assert.Empty(t, notifier.alerts)
+}
+
+func TestEngine_Rate(t *testing.T) {
+	ctx := context.Background()
+	engine, memStorage, notifier, now := newTestEngine(t, Rule{
+		Name: "PollRate",
+		Expr: "rate(PollCount) > 1",
+	})
+
+	assert.NoError(t, memStorage.UpdateMetric(ctx, "counter", "PollCount", "10"))
+	engine.Evaluate(ctx)
+	assert.Equal(t, StateInactive, engine.Alerts()[0].State)
+
+	// 30 за 10 секунд, скорость 3 в секунду
+	assert.NoError(t, memStorage.UpdateMetric(ctx, "counter", "PollCount", "30"))
+	*now = now.Add(10 * time.Second)
+	engine.Evaluate(ctx)
+	alert := engine.Alerts()[0]
+	assert.Equal(t, StateFiring, alert.State)
+	assert.Equal(t, float64(3), *alert.Value)
+	assert.Len(t, notifier.alerts, 1)
+
+	*now = now.Add(10 * time.Second)
+	engine.Evaluate(ctx)
+	alert = engine.Alerts()[0]
+	assert.Equal(t, StateResolved, alert.State)
+	assert.Equal(t, float64(0), *alert.Value)
+}
+
+func TestEngine_Labels(t *testing.T) {
+	ctx := context.Background()
+	engine, memStorage, _, _ := newTestEngine(t, Rule{
+		Name: "HostA",
+		Expr: `Alloc{host="a"} > 1`,
+	})
+
+	assert.NoError(t, memStorage.UpdateMetric(ctx, "gauge", `Alloc{host="b"}`, "5"))
+	engine.Evaluate(ctx)
+	assert.Equal(t, StateInactive, engine.Alerts()[0].State)
+
+	assert.NoError(t, memStorage.UpdateMetric(ctx, "gauge", `Alloc{host="a"}`, "5"))
+	engine.Evaluate(ctx)
+	assert.Equal(t, StateFiring, engine.Alerts()[0].State)
+}
+
+// TestEngine_ConcurrentUpdates вычисляет правила параллельно с записью метрик, гонку ловит go test -race
+func TestEngine_ConcurrentUpdates(t *testing.T) {
+	ctx := context.Background()
+	engine, memStorage, _, _ := newTestEngine(t, Rule{Name: "HeapHigh", Expr: "HeapAlloc > 500MB"})
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 200; i++ {
+			assert.NoError(t, memStorage.UpdateMetric(ctx, "gauge", "HeapAlloc", strconv.Itoa(i)))
+			assert.NoError(t, memStorage.UpdateMetric(ctx, "counter", "PollCount"+strconv.Itoa(i%10), "1"))
+		}
+	}()
+	for i := 0; i < 200; i++ {
+		engine.Evaluate(ctx)
+	}
+	wg.Wait()
+}
+
+func TestNewEngineInvalidRule(t *testing.T) {
+	_, err := NewEngine(Config{Rules: []Rule{{Name: "bad", Expr: "HeapAlloc"}}}, inmemory.NewMemStorage(), &recorder{})
+	assert.Error(t, err)
+}
+
+func TestWebhookNotifier(t *testing.T) {
+	var got Alert
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodPost, r.Method)
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&got))
+		if got.Name == "fail" {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer srv.Close()
+
+	notifier := NewNotifier(Config{WebhookURL: srv.URL})
+	assert.NoError(t, notifier.Notify(context.Background(), Alert{Name: "HeapHigh", State: StateFiring}))
+	assert.Equal(t, "HeapHigh", got.Name)
+	assert.Equal(t, StateFiring, got.State)
+
+	assert.Error(t, notifier.Notify(context.Background(), Alert{Name: "fail", State: StateFiring}))
+}
@@ -0,0 +1,86 @@
+package alerting
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Notifier доставляет уведомления об изменении состояния алертов
+type Notifier interface {
+	Notify(ctx context.Context, alert Alert) error
+}
+
+// LogNotifier пишет алерты в глобальный логгер zap
+type LogNotifier struct{}
+
+// Notify пишет алерт в лог
+func (LogNotifier) Notify(_ context.Context, alert Alert) error {
+	value := "нет данных"
+	if alert.Value != nil {
+		value = strconv.FormatFloat(*alert.Value, 'g', -1, 64)
+	}
+	zap.L().Warn("alert",
+		zap.String("name", alert.Name),
+		zap.String("state", string(alert.State)),
+		zap.String("expr", alert.Expr),
+		zap.String("value", value),
+	)
+	return nil
+}
+
+// WebhookNotifier отправляет алерты POST запросом с JSON телом
+type WebhookNotifier struct {
+	URL    string
+	Client *http.Client
+}
+
+// NewWebhookNotifier создает WebhookNotifier с таймаутом запроса 5 секунд
+func NewWebhookNotifier(url string) *WebhookNotifier {
+	return &WebhookNotifier{
+		URL:    url,
+		Client: &http.Client{Timeout: 5 * time.Second},
+	}
+}
+
+// Notify отправляет алерт на webhook
+func (n *WebhookNotifier) Notify(ctx context.Context, alert Alert) error {
+	body, err := json.Marshal(alert)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := n.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= http.StatusBadRequest {
+		return fmt.Errorf("webhook ответил статусом %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// MultiNotifier рассылает алерт всем уведомителям, ошибки одного не мешают остальным
+type MultiNotifier []Notifier
+
+// Notify рассылает алерт и возвращает первую ошибку
+func (m MultiNotifier) Notify(ctx context.Context, alert Alert) error {
+	var first error
+	for _, n := range m {
+		if err := n.Notify(ctx, alert); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
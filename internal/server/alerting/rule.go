@@ -0,0 +1,138 @@
+package alerting
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Arcadian-Sky/musthave-metrics/internal/labels"
+)
+
+// Config настройки правил из JSON конфигурации сервера
+type Config struct {
+	Interval   Duration `json:"interval"`    // период вычисления правил, по умолчанию 15s
+	WebhookURL string   `json:"webhook_url"` // адрес, на который отправляются алерты, пусто - только в лог
+	Rules      []Rule   `json:"rules"`
+}
+
+// Rule правило алерта, например {"name": "HeapHigh", "expr": "HeapAlloc > 500MB", "for": "2m"}
+type Rule struct {
+	Name        string   `json:"name"`
+	Expr        string   `json:"expr"`
+	For         Duration `json:"for"`         // сколько условие должно выполняться до срабатывания
+	Description string   `json:"description"` // произвольное описание для уведомлений
+}
+
+// Duration длительность в JSON в формате time.ParseDuration
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	duration, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(duration)
+	return nil
+}
+
+// Expr разобранное выражение правила: [rate(]метрика[)] оператор порог
+type Expr struct {
+	Metric    string // ключ ряда, см. labels.Key
+	Rate      bool   // сравнивается скорость роста в секунду, а не значение
+	Op        string
+	Threshold float64
+}
+
+// operators упорядочены так, чтобы двухсимвольные операторы находились раньше односимвольных
+var operators = []string{">=", "<=", "==", "!=", ">", "<"}
+
+// units множители порога, размеры памяти двоичные
+var units = []struct {
+	suffix string
+	factor float64
+}{
+	{"KB", 1 << 10},
+	{"MB", 1 << 20},
+	{"GB", 1 << 30},
+	{"TB", 1 << 40},
+}
+
+// ParseExpr разбирает выражение вида "HeapAlloc > 500MB" или "rate(PollCount) >= 10"
+func ParseExpr(s string) (Expr, error) {
+	var expr Expr
+	// Оператор ищется после блока меток, в значениях меток могут быть любые символы
+	start := strings.LastIndex(s, "}") + 1
+	idx, op := -1, ""
+	for _, candidate := range operators {
+		if i := strings.Index(s[start:], candidate); i >= 0 && (idx < 0 || start+i < idx) {
+			idx, op = start+i, candidate
+		}
+	}
+	if idx < 0 {
+		return expr, fmt.Errorf("в выражении %q нет оператора сравнения", s)
+	}
+	expr.Op = op
+
+	metric := strings.TrimSpace(s[:idx])
+	if strings.HasPrefix(metric, "rate(") && strings.HasSuffix(metric, ")") {
+		expr.Rate = true
+		metric = strings.TrimSpace(metric[len("rate(") : len(metric)-1])
+	}
+	if metric == "" {
+		return expr, fmt.Errorf("в выражении %q не указана метрика", s)
+	}
+	name, l := labels.ParseKey(metric)
+	expr.Metric = labels.Key(name, l)
+
+	threshold, err := parseThreshold(strings.TrimSpace(s[idx+len(op):]))
+	if err != nil {
+		return expr, fmt.Errorf("неверный порог в выражении %q: %w", s, err)
+	}
+	expr.Threshold = threshold
+	return expr, nil
+}
+
+func parseThreshold(s string) (float64, error) {
+	factor := float64(1)
+	for _, unit := range units {
+		if strings.HasSuffix(s, unit.suffix) {
+			s = strings.TrimSpace(strings.TrimSuffix(s, unit.suffix))
+			factor = unit.factor
+			break
+		}
+	}
+	value, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, err
+	}
+	return value * factor, nil
+}
+
+// Matches сравнивает значение с порогом
+func (e Expr) Matches(value float64) bool {
+	switch e.Op {
+	case ">":
+		return value > e.Threshold
+	case ">=":
+		return value >= e.Threshold
+	case "<":
+		return value < e.Threshold
+	case "<=":
+		return value <= e.Threshold
+	case "==":
+		return value == e.Threshold
+	case "!=":
+		return value != e.Threshold
+	}
+	return false
+}
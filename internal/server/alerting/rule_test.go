@@ -0,0 +1,72 @@
+package alerting
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseExpr(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want Expr
+	}{
+		{
+			name: "Units",
+			in:   "HeapAlloc > 500MB",
+			want: Expr{Metric: "HeapAlloc", Op: ">", Threshold: 500 << 20},
+		},
+		{
+			name: "Rate",
+			in:   "rate(PollCount) >= 10",
+			want: Expr{Metric: "PollCount", Rate: true, Op: ">=", Threshold: 10},
+		},
+		{
+			name: "Labels",
+			in:   `Alloc{op=">",host="a"} != 1.5`,
+			want: Expr{Metric: `Alloc{host="a",op=">"}`, Op: "!=", Threshold: 1.5},
+		},
+		{
+			name: "NoSpaces",
+			in:   "FreeMemory<=1KB",
+			want: Expr{Metric: "FreeMemory", Op: "<=", Threshold: 1024},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			expr, err := ParseExpr(tt.in)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, expr)
+		})
+	}
+}
+
+func TestParseExprInvalid(t *testing.T) {
+	for _, in := range []string{"HeapAlloc", "> 10", "HeapAlloc > ten", "HeapAlloc > 10XB"} {
+		_, err := ParseExpr(in)
+		assert.Error(t, err, in)
+	}
+}
+
+func TestExprMatches(t *testing.T) {
+	assert.True(t, Expr{Op: ">", Threshold: 1}.Matches(2))
+	assert.False(t, Expr{Op: ">", Threshold: 1}.Matches(1))
+	assert.True(t, Expr{Op: ">=", Threshold: 1}.Matches(1))
+	assert.True(t, Expr{Op: "<", Threshold: 1}.Matches(0))
+	assert.True(t, Expr{Op: "<=", Threshold: 1}.Matches(1))
+	assert.True(t, Expr{Op: "==", Threshold: 1}.Matches(1))
+	assert.True(t, Expr{Op: "!=", Threshold: 1}.Matches(2))
+}
+
+func TestConfigJSON(t *testing.T) {
+	var cfg Config
+	err := json.Unmarshal([]byte(`{"interval":"10s","rules":[{"name":"a","expr":"x > 1","for":"2m"}]}`), &cfg)
+	assert.NoError(t, err)
+	assert.Equal(t, Duration(10*time.Second), cfg.Interval)
+	assert.Equal(t, Duration(2*time.Minute), cfg.Rules[0].For)
+
+	assert.Error(t, json.Unmarshal([]byte(`{"interval":"soon"}`), &cfg))
+}
@@ -4,7 +4,6 @@
 package caretaker
 
 import (
-	"fmt"
 	"os"
 
 	"github.com/Arcadian-Sky/musthave-metrics/internal/server/storage"
@@ -17,7 +16,6 @@ type Caretaker struct {
 
 // saveToFile сохраняет метрики в файл
 func (app *Caretaker) SaveToFile(m *storage.Memento, filename string) error {
-	fmt.Printf("m: %v\n", m)
 	jsonData, err := m.MarshalJSON()
 	if err != nil {
 		return err
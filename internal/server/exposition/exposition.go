@@ -0,0 +1,236 @@
+// Пакет exposition формирует представление метрик хранилища
+// в текстовом формате Prometheus и в формате OpenMetrics
+package exposition
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/Arcadian-Sky/musthave-metrics/internal/labels"
+	"github.com/Arcadian-Sky/musthave-metrics/internal/server/storage"
+)
+
+// Format формат вывода метрик
+type Format int
+
+const (
+	// FormatText текстовый формат Prometheus 0.0.4
+	FormatText Format = iota
+	// FormatOpenMetrics формат OpenMetrics 1.0.0
+	FormatOpenMetrics
+)
+
+const (
+	// ContentTypeText значение Content-Type для текстового формата Prometheus
+	ContentTypeText = "text/plain; version=0.0.4; charset=utf-8"
+	// ContentTypeOpenMetrics значение Content-Type для формата OpenMetrics
+	ContentTypeOpenMetrics = "application/openmetrics-text; version=1.0.0; charset=utf-8"
+)
+
+// Negotiate выбирает формат по заголовку Accept
+func Negotiate(accept string) Format {
+	if strings.Contains(accept, "application/openmetrics-text") {
+		return FormatOpenMetrics
+	}
+	return FormatText
+}
+
+// ContentType возвращает Content-Type для формата
+func (f Format) ContentType() string {
+	if f == FormatOpenMetrics {
+		return ContentTypeOpenMetrics
+	}
+	return ContentTypeText
+}
+
+// family семейство метрик с одним именем
+type family struct {
+	name     string
+	original string
+	mtype    storage.MetricType
+	samples  []sample
+}
+
+// sample значение одного ряда семейства
+type sample struct {
+	labels map[string]string
+	value  float64
+}
+
+// Source дополнительный источник метрик, которые выводятся после метрик хранилища
+type Source interface {
+	Write(w io.Writer, format Format) error
+}
+
+// Write выводит метрики в выбранном формате.
+// Метрики из sources выводятся после метрик хранилища и до завершающего # EOF.
+func Write(w io.Writer, metrics map[storage.MetricType]map[string]interface{}, format Format, sources ...Source) error {
+	for _, f := range families(metrics) {
+		if err := writeFamily(w, f, format); err != nil {
+			return err
+		}
+	}
+	for _, s := range sources {
+		if err := s.Write(w, format); err != nil {
+			return err
+		}
+	}
+	if format == FormatOpenMetrics {
+		if _, err := io.WriteString(w, "# EOF\n"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// families собирает семейства метрик, отсортированные по имени.
+// Ряды с одним именем и разными метками попадают в одно семейство.
+// При совпадении очищенных имен у метрик разных типов к имени добавляется тип, а если и такое имя
+// занято, например метриками a.b и a_b одного типа, - порядковый номер, см. uniqueName.
+func families(metrics map[storage.MetricType]map[string]interface{}) []family {
+	result := make([]family, 0)
+	used := make(map[string]storage.MetricType)
+	for _, mtype := range []storage.MetricType{storage.Gauge, storage.Counter} {
+		keys := make([]string, 0, len(metrics[mtype]))
+		for key := range metrics[mtype] {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		byName := make(map[string]int)
+		for _, key := range keys {
+			value, ok := toFloat(metrics[mtype][key])
+			if !ok {
+				continue
+			}
+			name, l := labels.ParseKey(key)
+			idx, ok := byName[name]
+			if !ok {
+				sanitized := uniqueName(SanitizeName(name), mtype, used)
+				used[sanitized] = mtype
+				result = append(result, family{
+					name:     sanitized,
+					original: name,
+					mtype:    mtype,
+				})
+				idx = len(result) - 1
+				byName[name] = idx
+			}
+			result[idx].samples = append(result[idx].samples, sample{labels: l, value: value})
+		}
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		return result[i].name < result[j].name
+	})
+	return result
+}
+
+// uniqueName возвращает имя семейства, еще не занятое в used. Имена выдаются в порядке обхода
+// в families: сначала gauge, затем counter, внутри типа по возрастанию ключа, поэтому результат
+// не зависит от порядка обхода map хранилища.
+func uniqueName(name string, mtype storage.MetricType, used map[string]storage.MetricType) string {
+	t, ok := used[name]
+	if !ok {
+		return name
+	}
+	if t != mtype {
+		name = name + "_" + string(mtype)
+		if _, ok := used[name]; !ok {
+			return name
+		}
+	}
+	for i := 2; ; i++ {
+		candidate := name + "_" + strconv.Itoa(i)
+		if _, ok := used[candidate]; !ok {
+			return candidate
+		}
+	}
+}
+
+func writeFamily(w io.Writer, f family, format Format) error {
+	name := f.name
+	if f.mtype == storage.Counter && format == FormatOpenMetrics {
+		name += "_total"
+	}
+	_, err := fmt.Fprintf(w, "# HELP %s %s %s collected by agents.\n# TYPE %s %s\n",
+		f.name, escapeHelp(f.original), f.mtype,
+		f.name, f.mtype,
+	)
+	if err != nil {
+		return err
+	}
+	for _, s := range f.samples {
+		if _, err := fmt.Fprintf(w, "%s %s\n", labels.Key(name, sanitizeLabels(s.labels)), FormatValue(s.value)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sanitizeLabels приводит имена меток к виду [a-zA-Z_][a-zA-Z0-9_]*
+func sanitizeLabels(l map[string]string) map[string]string {
+	if len(l) == 0 {
+		return l
+	}
+	result := make(map[string]string, len(l))
+	for name, value := range l {
+		result[labels.Sanitize(name)] = value
+	}
+	return result
+}
+
+// SanitizeName приводит имя метрики к виду [a-zA-Z_:][a-zA-Z0-9_:]*
+func SanitizeName(name string) string {
+	if name == "" {
+		return "_"
+	}
+	var b strings.Builder
+	for i, r := range name {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r == '_', r == ':':
+			b.WriteRune(r)
+		case r >= '0' && r <= '9':
+			if i == 0 {
+				b.WriteRune('_')
+			}
+			b.WriteRune(r)
+		default:
+			b.WriteRune('_')
+		}
+	}
+	return b.String()
+}
+
+func escapeHelp(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	return strings.ReplaceAll(s, "\n", `\n`)
+}
+
+// FormatValue выводит значение метрики, в том числе NaN и бесконечности
+func FormatValue(v float64) string {
+	switch {
+	case math.IsNaN(v):
+		return "NaN"
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func toFloat(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case float64:
+		return v, true
+	case int64:
+		return float64(v), true
+	case int:
+		return float64(v), true
+	}
+	return 0, false
+}
@@ -0,0 +1,155 @@
+package exposition
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/Arcadian-Sky/musthave-metrics/internal/server/storage"
+)
+
+func TestSanitizeName(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{name: "Valid", in: "HeapAlloc", want: "HeapAlloc"},
+		{name: "Dots and dashes", in: "http.requests-total", want: "http_requests_total"},
+		{name: "Leading digit", in: "1min", want: "_1min"},
+		{name: "Unicode", in: "память", want: "______"},
+		{name: "Empty", in: "", want: "_"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, SanitizeName(tt.in))
+		})
+	}
+}
+
+func TestNegotiate(t *testing.T) {
+	assert.Equal(t, FormatText, Negotiate(""))
+	assert.Equal(t, FormatText, Negotiate("text/plain;version=0.0.4"))
+	assert.Equal(t, FormatOpenMetrics, Negotiate("application/openmetrics-text;version=1.0.0,text/plain;q=0.5"))
+	assert.Equal(t, ContentTypeOpenMetrics, FormatOpenMetrics.ContentType())
+	assert.Equal(t, ContentTypeText, FormatText.ContentType())
+}
+
+func TestWrite(t *testing.T) {
+	metrics := map[storage.MetricType]map[string]interface{}{
+		storage.Gauge: {
+			"Alloc":    1.5,
+			"Free.Mem": float64(2),
+		},
+		storage.Counter: {
+			"PollCount": int64(10),
+			"Alloc":     int64(3),
+		},
+	}
+
+	tests := []struct {
+		name   string
+		format Format
+		want   string
+	}{
+		{
+			name:   "Text",
+			format: FormatText,
+			want: "# HELP Alloc Alloc gauge collected by agents.\n# TYPE Alloc gauge\nAlloc 1.5\n" +
+				"# HELP Alloc_counter Alloc counter collected by agents.\n# TYPE Alloc_counter counter\nAlloc_counter 3\n" +
+				"# HELP Free_Mem Free.Mem gauge collected by agents.\n# TYPE Free_Mem gauge\nFree_Mem 2\n" +
+				"# HELP PollCount PollCount counter collected by agents.\n# TYPE PollCount counter\nPollCount 10\n",
+		},
+		{
+			name:   "OpenMetrics",
+			format: FormatOpenMetrics,
+			want: "# HELP Alloc Alloc gauge collected by agents.\n# TYPE Alloc gauge\nAlloc 1.5\n" +
+				"# HELP Alloc_counter Alloc counter collected by agents.\n# TYPE Alloc_counter counter\nAlloc_counter_total 3\n" +
+				"# HELP Free_Mem Free.Mem gauge collected by agents.\n# TYPE Free_Mem gauge\nFree_Mem 2\n" +
+				"# HELP PollCount PollCount counter collected by agents.\n# TYPE PollCount counter\nPollCount_total 10\n" +
+				"# EOF\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			assert.NoError(t, Write(&buf, metrics, tt.format))
+			assert.Equal(t, tt.want, buf.String())
+		})
+	}
+}
+
+func TestWriteLabels(t *testing.T) {
+	metrics := map[storage.MetricType]map[string]interface{}{
+		storage.Gauge: {
+			`Alloc{host="a"}`: 1.5,
+			`Alloc{host="b"}`: float64(2),
+		},
+		storage.Counter: {
+			`PollCount{env="prod",host="a"}`: int64(10),
+		},
+	}
+
+	want := "# HELP Alloc Alloc gauge collected by agents.\n# TYPE Alloc gauge\n" +
+		"Alloc{host=\"a\"} 1.5\nAlloc{host=\"b\"} 2\n" +
+		"# HELP PollCount PollCount counter collected by agents.\n# TYPE PollCount counter\n" +
+		"PollCount_total{env=\"prod\",host=\"a\"} 10\n" +
+		"# EOF\n"
+
+	var buf bytes.Buffer
+	assert.NoError(t, Write(&buf, metrics, FormatOpenMetrics))
+	assert.Equal(t, want, buf.String())
+}
+
+// TestWriteNameCollision проверяет, что метрики с совпадающими очищенными именами
+// выводятся разными семействами с постоянными именами
+func TestWriteNameCollision(t *testing.T) {
+	metrics := map[storage.MetricType]map[string]interface{}{
+		storage.Gauge: {
+			"a.b":       float64(1),
+			"a_b":       float64(2),
+			"x":         float64(3),
+			"x_counter": float64(4),
+		},
+		storage.Counter: {
+			"a-b": int64(5),
+			"x":   int64(6),
+		},
+	}
+
+	want := "# HELP a_b a.b gauge collected by agents.\n# TYPE a_b gauge\na_b 1\n" +
+		"# HELP a_b_2 a_b gauge collected by agents.\n# TYPE a_b_2 gauge\na_b_2 2\n" +
+		"# HELP a_b_counter a-b counter collected by agents.\n# TYPE a_b_counter counter\na_b_counter 5\n" +
+		"# HELP x x gauge collected by agents.\n# TYPE x gauge\nx 3\n" +
+		"# HELP x_counter x_counter gauge collected by agents.\n# TYPE x_counter gauge\nx_counter 4\n" +
+		"# HELP x_counter_2 x counter collected by agents.\n# TYPE x_counter_2 counter\nx_counter_2 6\n"
+
+	for i := 0; i < 10; i++ {
+		var buf bytes.Buffer
+		assert.NoError(t, Write(&buf, metrics, FormatText))
+		assert.Equal(t, want, buf.String())
+	}
+}
+
+type sourceFunc func(w io.Writer, format Format) error
+
+func (f sourceFunc) Write(w io.Writer, format Format) error {
+	return f(w, format)
+}
+
+func TestWriteSources(t *testing.T) {
+	metrics := map[storage.MetricType]map[string]interface{}{
+		storage.Gauge: {"Alloc": 1.5},
+	}
+	extra := sourceFunc(func(w io.Writer, format Format) error {
+		_, err := io.WriteString(w, "# TYPE up gauge\nup 1\n")
+		return err
+	})
+
+	var buf bytes.Buffer
+	assert.NoError(t, Write(&buf, metrics, FormatOpenMetrics, extra))
+	assert.Equal(t, "# HELP Alloc Alloc gauge collected by agents.\n# TYPE Alloc gauge\nAlloc 1.5\n"+
+		"# TYPE up gauge\nup 1\n# EOF\n", buf.String())
+}
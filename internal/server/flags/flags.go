@@ -2,44 +2,127 @@ package flags
 
 import (
 	"crypto/rsa"
+	"crypto/tls"
 	"crypto/x509"
 	"encoding/json"
 	"encoding/pem"
 	"flag"
 	"fmt"
 	"log"
+	"net"
 	"os"
 	"strconv"
 	"time"
 
 	"github.com/joho/godotenv"
+
+	"github.com/Arcadian-Sky/musthave-metrics/internal/compression"
+	"github.com/Arcadian-Sky/musthave-metrics/internal/keyring"
+	"github.com/Arcadian-Sky/musthave-metrics/internal/logging"
+	"github.com/Arcadian-Sky/musthave-metrics/internal/server/alerting"
+	"github.com/Arcadian-Sky/musthave-metrics/internal/server/ratelimit"
+	"github.com/Arcadian-Sky/musthave-metrics/internal/server/replay"
+	"github.com/Arcadian-Sky/musthave-metrics/internal/tlsconfig"
 )
 
 // Флаг -a, переменная окружения ADDRESS — endpoint address.
+// Флаг -k, переменная окружения KEY — ключ подписи HMAC-SHA256 с пустым идентификатором, им проверяются запросы без заголовка HashKeyID (по умолчанию пусто).
+// Флаг -hash-keys, переменная окружения HASH_KEYS — JSON файл с ключами подписи {"keys": [{"id": "...", "secret": "...", "expires": "RFC3339"}]}, ключ выбирается по заголовку HashKeyID; файл перечитывается по SIGHUP (по умолчанию пусто).
+// Флаг -hash-max-skew, переменная окружения HASH_MAX_SKEW — допустимое расхождение времени подписанного запроса агента с часами сервера в секундах; запросы вне окна и с уже встречавшимся nonce отклоняются (по умолчанию 300, значение 0 в переменной окружения отключает защиту от повтора и разрешает подпись только тела от агентов предыдущей версии).
+// Флаг -hash-nonce-cache, переменная окружения HASH_NONCE_CACHE — сколько последних nonce помнит сервер; должно хватать на все подписанные запросы агентов за удвоенное окно -hash-max-skew (по умолчанию 100000).
+// Флаг -hash-optional, переменная окружения HASH_OPTIONAL — принимать запросы на запись без подписи HashSHA256, например пока агенты переходят на ключ (по умолчанию false: при заданных ключах неподписанные запросы отклоняются с кодом 400).
+// Флаг -max-body-size, переменная окружения MAX_BODY_SIZE — предельный размер распакованного, а также зашифрованного тела запроса в байтах; тело больше предела отклоняется с кодом 413 (по умолчанию 10485760, значение 0 в переменной окружения снимает ограничение).
 // Флаг -i, переменная окружения STORE_INTERVAL — интервал времени в секундах, по истечении которого текущие показания сервера сохраняются на диск (по умолчанию 300 секунд, значение 0 делает запись синхронной).
 // Флаг -f, переменная окружения FILE_STORAGE_PATH — полное имя файла, куда сохраняются текущие значения (по умолчанию /tmp/metrics-db.json, пустое значение отключает функцию записи на диск).
 // Флаг -r, переменная окружения RESTORE — булево значение (true/false), определяющее, загружать или нет ранее сохранённые значения из указанного файла при старте сервера (по умолчанию true).
 // Флаг -d, переменная окружения DATABASE_DSN - cтрока с адресом подключения к БД (по умолчанию пусто).
+// Флаг -grpc-address, переменная окружения GRPC_ADDRESS — адрес gRPC сервера (по умолчанию пусто, gRPC сервер не запускается).
+// Флаг -history-retention, переменная окружения HISTORY_RETENTION — срок хранения истории метрик в секундах (по умолчанию 86400, значение 0 в переменной окружения отключает историю).
+// Флаг -history-resolution, переменная окружения HISTORY_RESOLUTION — минимальный интервал между точками истории в секундах (по умолчанию 10).
+// Флаги -tls-cert и -tls-key, переменные окружения TLS_CERT и TLS_KEY — сертификат и ключ сервера в PEM (по умолчанию пусто, TLS выключен).
+// Флаг -tls-client-ca, переменная окружения TLS_CLIENT_CA — сертификат центра, которым подписаны сертификаты агентов (по умолчанию пусто, сертификат агента не требуется).
+// Флаг -t, переменная окружения TRUSTED_SUBNET — доверенная подсеть агентов в нотации CIDR (по умолчанию пусто, проверка X-Real-IP выключена).
+// Флаги -statsd-udp и -statsd-tcp, переменные окружения STATSD_UDP_ADDRESS и STATSD_TCP_ADDRESS — адреса приема метрик по протоколу StatsD (по умолчанию пусто, прием выключен).
+// Флаг -statsd-flush-interval, переменная окружения STATSD_FLUSH_INTERVAL — интервал сброса метрик StatsD в хранилище в секундах (по умолчанию 10).
+// Параметр statsd_max_batch файла конфигурации — сколько рядов StatsD накопить до внеочередного сброса (по умолчанию 1000).
+// Флаг -log-level, переменная окружения LOG_LEVEL — уровень логирования: debug, info, warn или error (по умолчанию info).
+// Флаг -log-format, переменная окружения LOG_FORMAT — формат логов: json или console (по умолчанию console).
+// Флаг -log-sampling, переменная окружения LOG_SAMPLING — прореживание одинаковых сообщений лога (по умолчанию false).
+// Флаг -shutdown-delay, переменная окружения SHUTDOWN_DELAY — сколько секунд после сигнала остановки /readyz отвечает 503 до закрытия слушателя, чтобы балансировщик успел вывести сервер (по умолчанию 0).
+// Флаги -agent-rps и -agent-mps, переменные окружения AGENT_RPS и AGENT_MPS — сколько запросов и метрик в секунду принимается от одного агента на запись (по умолчанию 0, без ограничений). Сверх лимита сервер отвечает 429 с заголовком Retry-After.
+// Флаги -agent-rps-burst и -agent-mps-burst, переменные окружения AGENT_RPS_BURST и AGENT_MPS_BURST — сколько запросов и метрик агент может прислать разом (по умолчанию равно лимиту в секунду). Пачка метрик больше -agent-mps-burst отклоняется с кодом 413.
+// Агент для лимитов определяется по CN клиентского сертификата, затем по ключу проверенной подписи, иначе по адресу соединения. За балансировщиком адрес соединения общий для всех агентов, поэтому там агентов нужно различать сертификатами или ключами подписи.
+// Раздел alerts файла конфигурации — правила алертов, период их вычисления и адрес webhook (по умолчанию правил нет).
 
 type InitedFlags struct {
-	Endpoint       string        `json:"address"`
-	StoreInterval  time.Duration `json:"store_interval"`
-	FileStorage    string        `json:"store_file"`
-	RestoreMetrics bool          `json:"restore"`
-	DBSettings     string        `json:"database_dsn"`
-	CryptoKeyPath  string        `json:"crypto_key"`
-	StorageType    string
-	HashKey        string
-	ConfigFilePath string
+	Endpoint            string          `json:"address"`
+	StoreInterval       time.Duration   `json:"store_interval"`
+	FileStorage         string          `json:"store_file"`
+	RestoreMetrics      bool            `json:"restore"`
+	DBSettings          string          `json:"database_dsn"`
+	CryptoKeyPath       string          `json:"crypto_key"`
+	GRPCEndpoint        string          `json:"grpc_address"`
+	HistoryRetention    time.Duration   `json:"history_retention"`
+	HistoryResolution   time.Duration   `json:"history_resolution"`
+	TLSCertPath         string          `json:"tls_cert"`
+	TLSKeyPath          string          `json:"tls_key"`
+	TLSClientCAPath     string          `json:"tls_client_ca"`
+	TrustedSubnet       string          `json:"trusted_subnet"`
+	StatsDUDPAddress    string          `json:"statsd_udp_address"`
+	StatsDTCPAddress    string          `json:"statsd_tcp_address"`
+	StatsDFlushInterval time.Duration   `json:"statsd_flush_interval"`
+	StatsDMaxBatch      int             `json:"statsd_max_batch"`
+	LogLevel            string          `json:"log_level"`
+	LogFormat           string          `json:"log_format"`
+	LogSampling         bool            `json:"log_sampling"`
+	ShutdownDelay       time.Duration   `json:"shutdown_delay"`
+	HashKeysPath        string          `json:"hash_keys"`
+	HashOptional        bool            `json:"hash_optional"`
+	HashMaxSkew         time.Duration   `json:"hash_max_skew"`
+	HashNonceCache      int             `json:"hash_nonce_cache"`
+	MaxBodySize         int             `json:"max_body_size"`
+	AgentRPS            int             `json:"agent_rps"`
+	AgentRPSBurst       int             `json:"agent_rps_burst"`
+	AgentMPS            int             `json:"agent_mps"`
+	AgentMPSBurst       int             `json:"agent_mps_burst"`
+	Alerts              alerting.Config `json:"alerts"`
+	StorageType         string
+	HashKey             string
+	ConfigFilePath      string
 }
 
 type fileFlags struct {
-	Endpoint       string       `json:"address"`
-	StoreInterval  JSONDuration `json:"store_interval"`
-	FileStorage    string       `json:"store_file"`
-	RestoreMetrics bool         `json:"restore"`
-	DBSettings     string       `json:"database_dsn"`
-	CryptoKeyPath  string       `json:"crypto_key"`
+	Endpoint            string          `json:"address"`
+	StoreInterval       JSONDuration    `json:"store_interval"`
+	FileStorage         string          `json:"store_file"`
+	RestoreMetrics      bool            `json:"restore"`
+	DBSettings          string          `json:"database_dsn"`
+	CryptoKeyPath       string          `json:"crypto_key"`
+	GRPCEndpoint        string          `json:"grpc_address"`
+	HistoryRetention    JSONDuration    `json:"history_retention"`
+	HistoryResolution   JSONDuration    `json:"history_resolution"`
+	TLSCertPath         string          `json:"tls_cert"`
+	TLSKeyPath          string          `json:"tls_key"`
+	TLSClientCAPath     string          `json:"tls_client_ca"`
+	TrustedSubnet       string          `json:"trusted_subnet"`
+	StatsDUDPAddress    string          `json:"statsd_udp_address"`
+	StatsDTCPAddress    string          `json:"statsd_tcp_address"`
+	StatsDFlushInterval JSONDuration    `json:"statsd_flush_interval"`
+	StatsDMaxBatch      int             `json:"statsd_max_batch"`
+	LogLevel            string          `json:"log_level"`
+	LogFormat           string          `json:"log_format"`
+	LogSampling         bool            `json:"log_sampling"`
+	ShutdownDelay       JSONDuration    `json:"shutdown_delay"`
+	HashKeysPath        string          `json:"hash_keys"`
+	HashOptional        bool            `json:"hash_optional"`
+	HashMaxSkew         JSONDuration    `json:"hash_max_skew"`
+	HashNonceCache      int             `json:"hash_nonce_cache"`
+	MaxBodySize         int             `json:"max_body_size"`
+	AgentRPS            int             `json:"agent_rps"`
+	AgentRPSBurst       int             `json:"agent_rps_burst"`
+	AgentMPS            int             `json:"agent_mps"`
+	AgentMPSBurst       int             `json:"agent_mps_burst"`
+	Alerts              alerting.Config `json:"alerts"`
 }
 
 type JSONDuration time.Duration
@@ -78,8 +161,31 @@ func Parse() *InitedFlags {
 	flagFileStorage := flag.String("f", "", "Путь к файлу для хранения метрик")
 	flagRestoreMetrics := flag.Bool("r", false, "Восстановление метрик при старте сервера")
 	flagHashKey := flag.String("k", "", "hash key")
+	flagHashKeys := flag.String("hash-keys", "", "Путь к JSON файлу ключей подписи")
+	flagHashOptional := flag.Bool("hash-optional", false, "Принимать запросы на запись без подписи")
+	flagHashMaxSkew := flag.Int("hash-max-skew", 0, "Допустимое расхождение времени подписанного запроса в секундах")
+	flagHashNonceCache := flag.Int("hash-nonce-cache", 0, "Сколько последних nonce запросов помнит сервер")
+	flagMaxBodySize := flag.Int("max-body-size", 0, "Предельный размер распакованного тела запроса в байтах")
 	cryptoKeyFlag := flag.String("crypto-key", "", "Путь до файла с публичным ключом для шифрования")
 	configFileFlag := flag.String("c", "", "Путь к файлу конфигурации JSON")
+	flagGRPCAddress := flag.String("grpc-address", "", "Адрес gRPC сервера")
+	flagHistoryRetention := flag.Int("history-retention", 0, "Срок хранения истории метрик в секундах")
+	flagHistoryResolution := flag.Int("history-resolution", 0, "Минимальный интервал между точками истории в секундах")
+	flagTLSCert := flag.String("tls-cert", "", "Путь до сертификата сервера")
+	flagTLSKey := flag.String("tls-key", "", "Путь до ключа сертификата сервера")
+	flagTLSClientCA := flag.String("tls-client-ca", "", "Путь до сертификата центра, подписавшего сертификаты агентов")
+	flagTrustedSubnet := flag.String("t", "", "Доверенная подсеть агентов в нотации CIDR")
+	flagStatsDUDP := flag.String("statsd-udp", "", "Адрес приема метрик StatsD по UDP")
+	flagStatsDTCP := flag.String("statsd-tcp", "", "Адрес приема метрик StatsD по TCP")
+	flagLogLevel := flag.String("log-level", "", "Уровень логирования")
+	flagLogFormat := flag.String("log-format", "", "Формат логов: json или console")
+	flagLogSampling := flag.Bool("log-sampling", false, "Прореживание одинаковых сообщений лога")
+	flagStatsDFlushInterval := flag.Int("statsd-flush-interval", 0, "Интервал сброса метрик StatsD в секундах")
+	flagAgentRPS := flag.Int("agent-rps", 0, "Лимит запросов на запись от одного агента в секунду")
+	flagAgentRPSBurst := flag.Int("agent-rps-burst", 0, "Сколько запросов агент может прислать разом")
+	flagAgentMPS := flag.Int("agent-mps", 0, "Лимит метрик от одного агента в секунду")
+	flagAgentMPSBurst := flag.Int("agent-mps-burst", 0, "Сколько метрик агент может прислать разом")
+	flagShutdownDelay := flag.Int("shutdown-delay", 0, "Задержка закрытия слушателя после сигнала остановки в секундах")
 
 	flag.Parse()
 	_ = godotenv.Load()
@@ -95,6 +201,9 @@ func Parse() *InitedFlags {
 	envCryptoKey := os.Getenv("CRYPTO_KEY")
 	envHashKey := os.Getenv("KEY")
 	configFilePathEnv := os.Getenv("CONFIG")
+	envGRPCAddress := os.Getenv("GRPC_ADDRESS")
+	envHistoryRetention := os.Getenv("HISTORY_RETENTION")
+	envHistoryResolution := os.Getenv("HISTORY_RESOLUTION")
 
 	configFilePath := *configFileFlag
 	if configFilePathEnv != "" {
@@ -106,8 +215,6 @@ func Parse() *InitedFlags {
 		}
 	}
 
-	fmt.Printf("fileConfig: %v\n", fileConfig)
-
 	initedConfig.ConfigFilePath = getString(*configFileFlag, configFilePathEnv, "", "")
 	initedConfig.DBSettings = getString(*flagDBSettings, envRunDBSettings, fileConfig.DBSettings, "")
 	initedConfig.Endpoint = getString(*address, envRunAddr, fileConfig.Endpoint, ":8080")
@@ -115,6 +222,31 @@ func Parse() *InitedFlags {
 	initedConfig.FileStorage = getString(*flagFileStorage, envRunFileStorage, fileConfig.FileStorage, "/tmp/metrics-db.json")
 	initedConfig.CryptoKeyPath = getString(*cryptoKeyFlag, envCryptoKey, fileConfig.CryptoKeyPath, "")
 	initedConfig.HashKey = getString(*flagHashKey, envHashKey, "", "")
+	initedConfig.HashKeysPath = getString(*flagHashKeys, os.Getenv("HASH_KEYS"), fileConfig.HashKeysPath, "")
+	initedConfig.HashOptional = getBool(*flagHashOptional, os.Getenv("HASH_OPTIONAL"), fileConfig.HashOptional)
+	initedConfig.HashMaxSkew = getDuration(*flagHashMaxSkew, os.Getenv("HASH_MAX_SKEW"), fileConfig.HashMaxSkew, 300)
+	initedConfig.HashNonceCache = getInt(*flagHashNonceCache, os.Getenv("HASH_NONCE_CACHE"), fileConfig.HashNonceCache, replay.DefaultCacheSize)
+	initedConfig.MaxBodySize = getInt(*flagMaxBodySize, os.Getenv("MAX_BODY_SIZE"), fileConfig.MaxBodySize, compression.DefaultMaxDecodedSize)
+	initedConfig.GRPCEndpoint = getString(*flagGRPCAddress, envGRPCAddress, fileConfig.GRPCEndpoint, "")
+	initedConfig.HistoryRetention = getDuration(*flagHistoryRetention, envHistoryRetention, fileConfig.HistoryRetention, 86400)
+	initedConfig.HistoryResolution = getDuration(*flagHistoryResolution, envHistoryResolution, fileConfig.HistoryResolution, 10)
+	initedConfig.TLSCertPath = getString(*flagTLSCert, os.Getenv("TLS_CERT"), fileConfig.TLSCertPath, "")
+	initedConfig.TLSKeyPath = getString(*flagTLSKey, os.Getenv("TLS_KEY"), fileConfig.TLSKeyPath, "")
+	initedConfig.TLSClientCAPath = getString(*flagTLSClientCA, os.Getenv("TLS_CLIENT_CA"), fileConfig.TLSClientCAPath, "")
+	initedConfig.TrustedSubnet = getString(*flagTrustedSubnet, os.Getenv("TRUSTED_SUBNET"), fileConfig.TrustedSubnet, "")
+	initedConfig.StatsDUDPAddress = getString(*flagStatsDUDP, os.Getenv("STATSD_UDP_ADDRESS"), fileConfig.StatsDUDPAddress, "")
+	initedConfig.StatsDTCPAddress = getString(*flagStatsDTCP, os.Getenv("STATSD_TCP_ADDRESS"), fileConfig.StatsDTCPAddress, "")
+	initedConfig.StatsDFlushInterval = getDuration(*flagStatsDFlushInterval, os.Getenv("STATSD_FLUSH_INTERVAL"), fileConfig.StatsDFlushInterval, 10)
+	initedConfig.StatsDMaxBatch = fileConfig.StatsDMaxBatch
+	initedConfig.LogLevel = getString(*flagLogLevel, os.Getenv("LOG_LEVEL"), fileConfig.LogLevel, logging.DefaultLevel)
+	initedConfig.LogFormat = getString(*flagLogFormat, os.Getenv("LOG_FORMAT"), fileConfig.LogFormat, logging.DefaultFormat)
+	initedConfig.LogSampling = getBool(*flagLogSampling, os.Getenv("LOG_SAMPLING"), fileConfig.LogSampling)
+	initedConfig.ShutdownDelay = getDuration(*flagShutdownDelay, os.Getenv("SHUTDOWN_DELAY"), fileConfig.ShutdownDelay, 0)
+	initedConfig.AgentRPS = getInt(*flagAgentRPS, os.Getenv("AGENT_RPS"), fileConfig.AgentRPS, 0)
+	initedConfig.AgentRPSBurst = getInt(*flagAgentRPSBurst, os.Getenv("AGENT_RPS_BURST"), fileConfig.AgentRPSBurst, 0)
+	initedConfig.AgentMPS = getInt(*flagAgentMPS, os.Getenv("AGENT_MPS"), fileConfig.AgentMPS, 0)
+	initedConfig.AgentMPSBurst = getInt(*flagAgentMPSBurst, os.Getenv("AGENT_MPS_BURST"), fileConfig.AgentMPSBurst, 0)
+	initedConfig.Alerts = fileConfig.Alerts
 	initedConfig.RestoreMetrics = getBool(*flagRestoreMetrics, envRunRestoreStorage, fileConfig.RestoreMetrics)
 
 	initedConfig.StorageType = "inmemory"
@@ -150,6 +282,21 @@ func getBool(flagValue bool, envValue string, fileValue bool) bool {
 	return fileValue
 }
 
+func getInt(flagValue int, envValue string, fileValue int, defaultValue int) int {
+	if envValue != "" {
+		if parsed, err := strconv.Atoi(envValue); err == nil {
+			return parsed
+		}
+	}
+	if flagValue != 0 {
+		return flagValue
+	}
+	if fileValue != 0 {
+		return fileValue
+	}
+	return defaultValue
+}
+
 func getDuration(flagValue int, envValue string, fileValue JSONDuration, defaultValue int) time.Duration {
 	if envValue != "" {
 		if parsed, err := strconv.Atoi(envValue); err == nil {
@@ -179,6 +326,70 @@ func (i *InitedFlags) GetCryptoKey() (*rsa.PrivateKey, error) {
 	return nil, nil
 }
 
+// GetLogging возвращает настройки логгера
+func (i *InitedFlags) GetLogging() logging.Config {
+	return logging.Config{
+		Level:    i.LogLevel,
+		Format:   i.LogFormat,
+		Sampling: i.LogSampling,
+	}
+}
+
+// GetHashKeys возвращает ключи подписи: ключ из -k с пустым идентификатором и ключи из файла -hash-keys.
+// Файл читается при каждом вызове, чтобы ключи можно было перечитать по SIGHUP.
+func (i *InitedFlags) GetHashKeys() ([]keyring.Key, error) {
+	var keys []keyring.Key
+	if i.HashKey != "" {
+		keys = append(keys, keyring.Key{Secret: i.HashKey})
+	}
+	if i.HashKeysPath != "" {
+		fileKeys, err := keyring.Load(i.HashKeysPath)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, fileKeys...)
+	}
+	return keys, nil
+}
+
+// GetReplay возвращает настройки защиты подписанных запросов от повтора
+func (i *InitedFlags) GetReplay() replay.Config {
+	return replay.Config{
+		MaxSkew:   i.HashMaxSkew,
+		CacheSize: i.HashNonceCache,
+	}
+}
+
+// GetRateLimit возвращает лимиты записи для одного агента
+func (i *InitedFlags) GetRateLimit() ratelimit.Config {
+	return ratelimit.Config{
+		RequestsPerSecond: float64(i.AgentRPS),
+		RequestsBurst:     i.AgentRPSBurst,
+		MetricsPerSecond:  float64(i.AgentMPS),
+		MetricsBurst:      i.AgentMPSBurst,
+	}
+}
+
+// GetTLSConfig возвращает настройки TLS сервера или nil, если сертификат не задан
+func (i *InitedFlags) GetTLSConfig() (*tls.Config, error) {
+	if i.TLSCertPath == "" && i.TLSKeyPath == "" {
+		return nil, nil
+	}
+	return tlsconfig.Server(i.TLSCertPath, i.TLSKeyPath, i.TLSClientCAPath)
+}
+
+// GetTrustedSubnet возвращает доверенную подсеть агентов или nil, если она не задана
+func (i *InitedFlags) GetTrustedSubnet() (*net.IPNet, error) {
+	if i.TrustedSubnet == "" {
+		return nil, nil
+	}
+	_, subnet, err := net.ParseCIDR(i.TrustedSubnet)
+	if err != nil {
+		return nil, err
+	}
+	return subnet, nil
+}
+
 func (i *InitedFlags) loadPrivateKey(path string) (*rsa.PrivateKey, error) {
 	data, err := os.ReadFile(path)
 	if err != nil {
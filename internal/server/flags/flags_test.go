@@ -12,6 +12,8 @@ import (
 	"time"
 
 	"github.com/stretchr/testify/assert"
+
+	"github.com/Arcadian-Sky/musthave-metrics/internal/server/alerting"
 )
 
 // TestParse tests the Parse function for various configurations.
@@ -334,6 +336,54 @@ func TestGetDuration(t *testing.T) {
 	}
 }
 
+func TestGetInt(t *testing.T) {
+	tests := []struct {
+		name        string
+		flagValue   int
+		envValue    string
+		fileValue   int
+		expected    int
+		description string
+	}{
+		{
+			name:        "EnvValue set",
+			flagValue:   10,
+			envValue:    "20",
+			fileValue:   30,
+			expected:    20,
+			description: "Should return value from env",
+		},
+		{
+			name:        "FlagValue set",
+			flagValue:   10,
+			envValue:    "",
+			fileValue:   30,
+			expected:    10,
+			description: "Should return value from flag",
+		},
+		{
+			name:        "FileValue set",
+			flagValue:   0,
+			envValue:    "invalid",
+			fileValue:   30,
+			expected:    30,
+			description: "Should skip invalid env and return value from file",
+		},
+		{
+			name:        "Default",
+			expected:    5,
+			description: "Should return default value",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := getInt(tt.flagValue, tt.envValue, tt.fileValue, 5)
+			assert.Equal(t, tt.expected, result, tt.description)
+		})
+	}
+}
+
 func TestLoadConfig(t *testing.T) {
 	// Create a temporary directory for config files
 	dir, err := os.MkdirTemp("", "config")
@@ -389,6 +439,38 @@ func TestLoadConfig(t *testing.T) {
 			expectedError: true,
 			description:   "Should fail on invalid duration",
 		},
+		{
+			name: "ConfigWithAlerts",
+			configData: `{
+				"alerts": {
+					"interval": "30s",
+					"webhook_url": "http://localhost:9000/hook",
+					"rules": [{"name": "HeapHigh", "expr": "HeapAlloc > 500MB", "for": "2m"}]
+				}
+			}`,
+			expected: &fileFlags{
+				Alerts: alerting.Config{
+					Interval:   alerting.Duration(30 * time.Second),
+					WebhookURL: "http://localhost:9000/hook",
+					Rules: []alerting.Rule{
+						{Name: "HeapHigh", Expr: "HeapAlloc > 500MB", For: alerting.Duration(2 * time.Minute)},
+					},
+				},
+			},
+			expectedError: false,
+			description:   "Should load alerting rules",
+		},
+		{
+			name:       "ConfigWithStatsD",
+			configData: `{"statsd_udp_address": ":8125", "statsd_flush_interval": "5s", "statsd_max_batch": 200}`,
+			expected: &fileFlags{
+				StatsDUDPAddress:    ":8125",
+				StatsDFlushInterval: JSONDuration(5 * time.Second),
+				StatsDMaxBatch:      200,
+			},
+			expectedError: false,
+			description:   "Should load StatsD listener settings",
+		},
 		{
 			name: "ConfigWithDefaultValues",
 			configData: `{
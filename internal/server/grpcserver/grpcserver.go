@@ -0,0 +1,359 @@
+// Пакет grpcserver реализует gRPC сервис приема метрик поверх того же хранилища, что и HTTP ручки
+package grpcserver
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	protobuf "google.golang.org/protobuf/proto"
+
+	"github.com/Arcadian-Sky/musthave-metrics/internal/keyring"
+	"github.com/Arcadian-Sky/musthave-metrics/internal/proto"
+	"github.com/Arcadian-Sky/musthave-metrics/internal/server/flags"
+	"github.com/Arcadian-Sky/musthave-metrics/internal/server/handler/validate"
+	"github.com/Arcadian-Sky/musthave-metrics/internal/server/identity"
+	"github.com/Arcadian-Sky/musthave-metrics/internal/server/models"
+	"github.com/Arcadian-Sky/musthave-metrics/internal/server/ratelimit"
+	"github.com/Arcadian-Sky/musthave-metrics/internal/server/replay"
+	"github.com/Arcadian-Sky/musthave-metrics/internal/server/storage"
+)
+
+// MetricsServer реализует proto.MetricsServiceServer
+type MetricsServer struct {
+	proto.UnimplementedMetricsServiceServer
+	s storage.MetricsStorage
+}
+
+// NewMetricsServer создает экземпляр MetricsServer
+func NewMetricsServer(mStorage storage.MetricsStorage) *MetricsServer {
+	return &MetricsServer{
+		s: mStorage,
+	}
+}
+
+// NewServer создает gRPC сервер с зарегистрированным MetricsService и проверками из конфигурации.
+// limiter ограничивает запись метрик каждым агентом, nil выключает лимиты.
+// keys - ключи подписи, без них используется ключ cnf.HashKey с пустым идентификатором.
+// guard отклоняет повторные подписанные запросы, nil выключает защиту.
+// Дополнительные опции, например транспортные учетные данные TLS, передаются в grpc.NewServer.
+func NewServer(cnf *flags.InitedFlags, mStorage storage.MetricsStorage, limiter *ratelimit.Limiter, keys *keyring.Keyring, guard *replay.Guard, opts ...grpc.ServerOption) *grpc.Server {
+	// Подсеть проверена при разборе конфигурации
+	subnet, _ := cnf.GetTrustedSubnet()
+	if keys == nil && cnf.HashKey != "" {
+		keys, _ = keyring.New(keyring.Key{Secret: cnf.HashKey})
+	}
+	opts = append(opts, grpc.ChainUnaryInterceptor(
+		IdentityInterceptor(),
+		TrustedSubnetInterceptor(subnet),
+		RateLimitInterceptor(limiter),
+		HashInterceptor(keys, guard, !cnf.HashOptional),
+		MetricsQuotaInterceptor(limiter),
+	))
+	server := grpc.NewServer(opts...)
+	proto.RegisterMetricsServiceServer(server, NewMetricsServer(mStorage))
+	return server
+}
+
+// Update обновляет одну метрику и возвращает ее актуальное значение
+func (m *MetricsServer) Update(ctx context.Context, req *proto.UpdateRequest) (*proto.UpdateResponse, error) {
+	if req.GetMetric() == nil {
+		return nil, status.Error(codes.InvalidArgument, "metric not provided")
+	}
+	metric := FromProto(req.GetMetric())
+	if err := validate.CheckMetricTypeAndName(metric.MType, metric.ID); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if err := storage.ValidateMetrics([]models.Metrics{metric}); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	if err := m.s.UpdateJSONMetric(ctx, &metric); err != nil {
+		return nil, updateError(err)
+	}
+	if err := m.s.GetJSONMetric(ctx, &metric); err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	return &proto.UpdateResponse{Metric: ToProto(metric)}, nil
+}
+
+// UpdateBatch обновляет пачку метрик и возвращает их актуальные значения: для счетчиков -
+// накопленную сумму из хранилища, а не присланное приращение
+func (m *MetricsServer) UpdateBatch(ctx context.Context, req *proto.UpdateBatchRequest) (*proto.UpdateBatchResponse, error) {
+	if len(req.GetMetrics()) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "metrics not provided")
+	}
+	metrics := make([]models.Metrics, 0, len(req.GetMetrics()))
+	for _, pm := range req.GetMetrics() {
+		metrics = append(metrics, FromProto(pm))
+	}
+
+	if err := storage.ValidateMetrics(metrics); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	if err := m.s.UpdateJSONMetrics(ctx, &metrics); err != nil {
+		return nil, updateError(err)
+	}
+
+	resp := &proto.UpdateBatchResponse{Metrics: make([]*proto.Metric, 0, len(metrics))}
+	for _, metric := range metrics {
+		if err := m.s.GetJSONMetric(ctx, &metric); err != nil {
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+		resp.Metrics = append(resp.Metrics, ToProto(metric))
+	}
+	return resp, nil
+}
+
+// updateError преобразует ошибку записи в статус gRPC: InvalidArgument для некорректной пачки
+// (*storage.BatchError), Internal для остальных ошибок хранилища. Агент не повторяет запрос
+// ни с тем, ни с другим кодом: при ошибке хранилища пачка могла быть уже применена.
+func updateError(err error) error {
+	var batchErr *storage.BatchError
+	if errors.As(err, &batchErr) {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	return status.Error(codes.Internal, err.Error())
+}
+
+// GetValue возвращает текущее значение метрики
+func (m *MetricsServer) GetValue(ctx context.Context, req *proto.GetValueRequest) (*proto.GetValueResponse, error) {
+	metric := models.Metrics{
+		ID:     req.GetId(),
+		MType:  typeFromProto(req.GetType()),
+		Labels: req.GetLabels(),
+	}
+	if err := validate.CheckMetricTypeAndName(metric.MType, metric.ID); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if err := m.s.GetJSONMetric(ctx, &metric); err != nil {
+		return nil, status.Error(codes.NotFound, err.Error())
+	}
+
+	return &proto.GetValueResponse{Metric: ToProto(metric)}, nil
+}
+
+// IdentityInterceptor сохраняет в контексте CN клиентского сертификата агента
+func IdentityInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		if p, ok := peer.FromContext(ctx); ok {
+			if info, ok := p.AuthInfo.(credentials.TLSInfo); ok {
+				if agent := identity.FromTLS(&info.State); agent != "" {
+					ctx = identity.NewContext(ctx, agent)
+				}
+			}
+		}
+		return handler(ctx, req)
+	}
+}
+
+// TrustedSubnetInterceptor пропускает запросы на запись метрик только от агентов из доверенной подсети.
+// Адрес агента берется из метаданных x-real-ip. Если подсеть не задана, проверка выключена.
+func TrustedSubnetInterceptor(subnet *net.IPNet) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		if subnet == nil || info.FullMethod == proto.MetricsService_GetValue_FullMethodName {
+			return handler(ctx, req)
+		}
+		ip := net.ParseIP(metadataValue(ctx, proto.RealIPMetadataKey))
+		if ip == nil || !subnet.Contains(ip) {
+			return nil, status.Error(codes.PermissionDenied, "agent is outside of trusted subnet")
+		}
+		return handler(ctx, req)
+	}
+}
+
+// RateLimitInterceptor ограничивает частоту запросов записи каждого агента, как middleware.RateLimit в HTTP.
+// Стоит до HashInterceptor, чтобы отклоненный запрос не стоил серверу проверки подписи, поэтому агент
+// определяется по CN клиентского сертификата, а без mTLS - по адресу соединения.
+// При превышении лимита возвращает ResourceExhausted и заголовок retry-after в секундах.
+func RateLimitInterceptor(l *ratelimit.Limiter) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		if l == nil || info.FullMethod == proto.MetricsService_GetValue_FullMethodName {
+			return handler(ctx, req)
+		}
+		if err := limitError(ctx, l.Request(agentKey(ctx))); err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// MetricsQuotaInterceptor учитывает метрики запроса записи в лимитах агента, как middleware.MetricsQuota в HTTP.
+// Стоит после HashInterceptor, чтобы агент с проверенной подписью учитывался по идентификатору своего ключа.
+func MetricsQuotaInterceptor(l *ratelimit.Limiter) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		if l == nil {
+			return handler(ctx, req)
+		}
+		var err error
+		switch req := req.(type) {
+		case *proto.UpdateRequest:
+			err = l.Metrics(agentKey(ctx), 1)
+		case *proto.UpdateBatchRequest:
+			err = l.Metrics(agentKey(ctx), len(req.GetMetrics()))
+		}
+		if err := limitError(ctx, err); err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// limitError преобразует ошибку лимита в статус gRPC: ResourceExhausted с заголовком retry-after
+// для *ratelimit.LimitError и InvalidArgument для ratelimit.ErrBatchTooLarge
+func limitError(ctx context.Context, err error) error {
+	var limitErr *ratelimit.LimitError
+	switch {
+	case errors.As(err, &limitErr):
+		_ = grpc.SetHeader(ctx, metadata.Pairs(proto.RetryAfterMetadataKey, strconv.Itoa(limitErr.RetryAfterSeconds())))
+		return status.Error(codes.ResourceExhausted, err.Error())
+	case err != nil:
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	return nil
+}
+
+// agentKey возвращает ключ агента для лимитов по проверенным данным, см. middleware.AgentKey
+func agentKey(ctx context.Context) string {
+	if agent := identity.FromContext(ctx); agent != "" {
+		return "cn:" + agent
+	}
+	if id := identity.KeyIDFromContext(ctx); id != "" {
+		return "key:" + id
+	}
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		host, _, err := net.SplitHostPort(p.Addr.String())
+		if err != nil {
+			host = p.Addr.String()
+		}
+		return "ip:" + host
+	}
+	return "ip:"
+}
+
+// HashInterceptor проверяет подпись HMAC-SHA256 из метаданных запроса ключом с идентификатором
+// из метаданных hashkeyid и подписывает ответ тем же ключом в заголовке hashsha256.
+// Ответ на запрос без подписи не подписывается.
+// Подписывается детерминированно сериализованное protobuf сообщение, ответ - вместе с nonce запроса,
+// см. keyring.ResponsePayload.
+// Запрос подписывается вместе с именем метода, временем и nonce из метаданных hashtimestamp и hashnonce, см. keyring.Payload,
+// повторы отклоняет guard, как middleware.VerifyHash в HTTP. Идентификатор ключа проверенной подписи
+// сохраняется в контексте, см. identity.KeyIDFromContext.
+// В строгом режиме запись без подписи отклоняется, если на сервере есть ключи; чтение GetValue не проверяется.
+func HashInterceptor(keys *keyring.Keyring, guard *replay.Guard, strict bool) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		if keys.Len() == 0 {
+			return handler(ctx, req)
+		}
+		msg, ok := req.(protobuf.Message)
+		if !ok {
+			return handler(ctx, req)
+		}
+		id := metadataValue(ctx, proto.HashKeyIDMetadataKey)
+		signature := metadataValue(ctx, proto.HashMetadataKey)
+		nonce := metadataValue(ctx, proto.HashNonceMetadataKey)
+		switch {
+		case signature != "":
+			body, err := marshalDeterministic(msg)
+			if err != nil {
+				return nil, status.Error(codes.Internal, err.Error())
+			}
+			timestamp := metadataValue(ctx, proto.HashTimestampMetadataKey)
+			if timestamp != "" || nonce != "" {
+				body = keyring.Payload(http.MethodPost, info.FullMethod, timestamp, nonce, body)
+			}
+			if err := keys.Verify(id, signature, body); err != nil {
+				return nil, status.Error(codes.Unauthenticated, err.Error())
+			}
+			if err := guard.Check(id, timestamp, nonce); err != nil {
+				return nil, status.Error(codes.Unauthenticated, err.Error())
+			}
+			ctx = identity.NewKeyIDContext(ctx, id)
+		case strict && info.FullMethod != proto.MetricsService_GetValue_FullMethodName:
+			return nil, status.Error(codes.Unauthenticated, keyring.ErrHashRequired.Error())
+		}
+		resp, err := handler(ctx, req)
+		if _, verified := identity.VerifiedKeyID(ctx); err != nil || !verified {
+			return resp, err
+		}
+		if respMsg, ok := resp.(protobuf.Message); ok {
+			body, err := marshalDeterministic(respMsg)
+			if err != nil {
+				return nil, status.Error(codes.Internal, err.Error())
+			}
+			signature, err := keys.Sign(id, keyring.ResponsePayload(nonce, body))
+			if err == nil {
+				_ = grpc.SetHeader(ctx, metadata.Pairs(proto.HashMetadataKey, signature, proto.HashKeyIDMetadataKey, id))
+			}
+		}
+		return resp, nil
+	}
+}
+
+func marshalDeterministic(msg protobuf.Message) ([]byte, error) {
+	return protobuf.MarshalOptions{Deterministic: true}.Marshal(msg)
+}
+
+// metadataValue возвращает первое значение ключа из входящих метаданных
+func metadataValue(ctx context.Context, key string) string {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ""
+	}
+	values := md.Get(key)
+	if len(values) == 0 {
+		return ""
+	}
+	return values[0]
+}
+
+// FromProto преобразует proto.Metric в models.Metrics
+func FromProto(pm *proto.Metric) models.Metrics {
+	metric := models.Metrics{
+		ID:     pm.GetId(),
+		MType:  typeFromProto(pm.GetType()),
+		Labels: pm.GetLabels(),
+	}
+	switch pm.GetType() {
+	case proto.MType_GAUGE:
+		value := pm.GetValue()
+		metric.Value = &value
+	case proto.MType_COUNTER:
+		delta := pm.GetDelta()
+		metric.Delta = &delta
+	}
+	return metric
+}
+
+// ToProto преобразует models.Metrics в proto.Metric
+func ToProto(metric models.Metrics) *proto.Metric {
+	pm := &proto.Metric{
+		Id:     metric.ID,
+		Labels: metric.Labels,
+	}
+	if metric.MType == string(storage.Counter) {
+		pm.Type = proto.MType_COUNTER
+	}
+	if metric.Delta != nil {
+		pm.Delta = *metric.Delta
+	}
+	if metric.Value != nil {
+		pm.Value = *metric.Value
+	}
+	return pm
+}
+
+func typeFromProto(t proto.MType) string {
+	return strings.ToLower(t.String())
+}
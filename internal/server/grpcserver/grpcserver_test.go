@@ -0,0 +1,325 @@
+package grpcserver
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	protobuf "google.golang.org/protobuf/proto"
+
+	"github.com/Arcadian-Sky/musthave-metrics/internal/keyring"
+	"github.com/Arcadian-Sky/musthave-metrics/internal/proto"
+	"github.com/Arcadian-Sky/musthave-metrics/internal/server/flags"
+	"github.com/Arcadian-Sky/musthave-metrics/internal/server/models"
+	"github.com/Arcadian-Sky/musthave-metrics/internal/server/ratelimit"
+	"github.com/Arcadian-Sky/musthave-metrics/internal/server/replay"
+	"github.com/Arcadian-Sky/musthave-metrics/internal/server/storage"
+	"github.com/Arcadian-Sky/musthave-metrics/internal/server/storage/inmemory"
+)
+
+func newTestClient(t *testing.T, cnf *flags.InitedFlags) proto.MetricsServiceClient {
+	return newLimitedTestClient(t, cnf, nil)
+}
+
+func newLimitedTestClient(t *testing.T, cnf *flags.InitedFlags, limiter *ratelimit.Limiter) proto.MetricsServiceClient {
+	return dialTestServer(t, NewServer(cnf, inmemory.NewMemStorage(), limiter, nil, nil))
+}
+
+func dialTestServer(t *testing.T, server *grpc.Server) proto.MetricsServiceClient {
+	listen := bufconn.Listen(1024 * 1024)
+	go func() {
+		_ = server.Serve(listen)
+	}()
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listen.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	return proto.NewMetricsServiceClient(conn)
+}
+
+func TestMetricsServer_UpdateAndGetValue(t *testing.T) {
+	client := newTestClient(t, &flags.InitedFlags{})
+	ctx := context.Background()
+
+	for i := 0; i < 2; i++ {
+		_, err := client.Update(ctx, &proto.UpdateRequest{
+			Metric: &proto.Metric{Id: "PollCount", Type: proto.MType_COUNTER, Delta: 5},
+		})
+		require.NoError(t, err)
+	}
+	_, err := client.Update(ctx, &proto.UpdateRequest{
+		Metric: &proto.Metric{Id: "Alloc", Type: proto.MType_GAUGE, Value: 1.5},
+	})
+	require.NoError(t, err)
+
+	counter, err := client.GetValue(ctx, &proto.GetValueRequest{Id: "PollCount", Type: proto.MType_COUNTER})
+	require.NoError(t, err)
+	assert.Equal(t, int64(10), counter.GetMetric().GetDelta())
+
+	gauge, err := client.GetValue(ctx, &proto.GetValueRequest{Id: "Alloc", Type: proto.MType_GAUGE})
+	require.NoError(t, err)
+	assert.Equal(t, 1.5, gauge.GetMetric().GetValue())
+}
+
+// TestMetricsServer_UpdateBatch проверяет, что пачка возвращает значения из хранилища:
+// для счетчика - накопленную сумму, а не присланное приращение
+func TestMetricsServer_UpdateBatch(t *testing.T) {
+	client := newTestClient(t, &flags.InitedFlags{})
+	ctx := context.Background()
+	req := &proto.UpdateBatchRequest{Metrics: []*proto.Metric{
+		{Id: "PollCount", Type: proto.MType_COUNTER, Delta: 5},
+		{Id: "Alloc", Type: proto.MType_GAUGE, Value: 1.5},
+	}}
+
+	for _, want := range []int64{5, 10} {
+		resp, err := client.UpdateBatch(ctx, req)
+		require.NoError(t, err)
+		require.Len(t, resp.GetMetrics(), 2)
+		assert.Equal(t, want, resp.GetMetrics()[0].GetDelta())
+		assert.Equal(t, 1.5, resp.GetMetrics()[1].GetValue())
+	}
+}
+
+func TestMetricsServer_Labels(t *testing.T) {
+	client := newTestClient(t, &flags.InitedFlags{})
+	ctx := context.Background()
+
+	for host, value := range map[string]float64{"a": 1, "b": 2} {
+		_, err := client.Update(ctx, &proto.UpdateRequest{
+			Metric: &proto.Metric{Id: "Alloc", Type: proto.MType_GAUGE, Value: value, Labels: map[string]string{"host": host}},
+		})
+		require.NoError(t, err)
+	}
+
+	gauge, err := client.GetValue(ctx, &proto.GetValueRequest{Id: "Alloc", Type: proto.MType_GAUGE, Labels: map[string]string{"host": "b"}})
+	require.NoError(t, err)
+	assert.Equal(t, 2.0, gauge.GetMetric().GetValue())
+	assert.Equal(t, map[string]string{"host": "b"}, gauge.GetMetric().GetLabels())
+}
+
+func TestMetricsServer_InvalidArgument(t *testing.T) {
+	client := newTestClient(t, &flags.InitedFlags{})
+	ctx := context.Background()
+
+	_, err := client.Update(ctx, &proto.UpdateRequest{})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	_, err = client.UpdateBatch(ctx, &proto.UpdateBatchRequest{})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	_, err = client.GetValue(ctx, &proto.GetValueRequest{})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+// failingStorage отказывает в записи, как недоступная база
+type failingStorage struct {
+	storage.MetricsStorage
+}
+
+func (failingStorage) UpdateJSONMetric(context.Context, *models.Metrics) error {
+	return errors.New("database is down")
+}
+
+func (failingStorage) UpdateJSONMetrics(context.Context, *[]models.Metrics) error {
+	return errors.New("database is down")
+}
+
+func TestMetricsServer_StorageError(t *testing.T) {
+	client := dialTestServer(t, NewServer(&flags.InitedFlags{}, failingStorage{inmemory.NewMemStorage()}, nil, nil, nil))
+	ctx := context.Background()
+	metric := &proto.Metric{Id: "Alloc", Type: proto.MType_GAUGE, Value: 1}
+
+	_, err := client.Update(ctx, &proto.UpdateRequest{Metric: metric})
+	assert.Equal(t, codes.Internal, status.Code(err))
+
+	_, err = client.UpdateBatch(ctx, &proto.UpdateBatchRequest{Metrics: []*proto.Metric{metric}})
+	assert.Equal(t, codes.Internal, status.Code(err))
+
+	// Некорректная пачка отклоняется до обращения к хранилищу
+	invalid := &proto.Metric{Id: "Alloc", Type: proto.MType_GAUGE, Value: 1, Labels: map[string]string{"host-name": "a"}}
+	_, err = client.UpdateBatch(ctx, &proto.UpdateBatchRequest{Metrics: []*proto.Metric{metric, invalid}})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestHashInterceptor(t *testing.T) {
+	key := "secret"
+	client := newTestClient(t, &flags.InitedFlags{HashKey: key})
+	req := &proto.UpdateRequest{
+		Metric: &proto.Metric{Id: "Alloc", Type: proto.MType_GAUGE, Value: 2},
+	}
+
+	body, err := protobuf.MarshalOptions{Deterministic: true}.Marshal(req)
+	require.NoError(t, err)
+	h := hmac.New(sha256.New, []byte(key))
+	h.Write(body)
+
+	ctx := metadata.AppendToOutgoingContext(context.Background(), proto.HashMetadataKey, hex.EncodeToString(h.Sum(nil)))
+	var header metadata.MD
+	resp, err := client.Update(ctx, req, grpc.Header(&header))
+	require.NoError(t, err)
+
+	// Ответ подписан тем же ключом
+	respBody, err := protobuf.MarshalOptions{Deterministic: true}.Marshal(resp)
+	require.NoError(t, err)
+	h = hmac.New(sha256.New, []byte(key))
+	h.Write(respBody)
+	assert.Equal(t, []string{hex.EncodeToString(h.Sum(nil))}, header.Get(proto.HashMetadataKey))
+
+	ctx = metadata.AppendToOutgoingContext(context.Background(), proto.HashKeyIDMetadataKey, "unknown", proto.HashMetadataKey, hex.EncodeToString(h.Sum(nil)))
+	_, err = client.Update(ctx, req)
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	ctx = metadata.AppendToOutgoingContext(context.Background(), proto.HashMetadataKey, hex.EncodeToString([]byte("wrong")))
+	_, err = client.Update(ctx, req)
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	// Запись без подписи отклоняется, чтение без подписи разрешено
+	_, err = client.Update(context.Background(), req)
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	_, err = client.GetValue(context.Background(), &proto.GetValueRequest{Id: "Alloc", Type: proto.MType_GAUGE})
+	assert.NoError(t, err)
+
+	optional := newTestClient(t, &flags.InitedFlags{HashKey: key, HashOptional: true})
+	_, err = optional.Update(context.Background(), req)
+	assert.NoError(t, err)
+}
+
+func TestHashInterceptor_Replay(t *testing.T) {
+	key := "secret"
+	cnf := &flags.InitedFlags{HashKey: key}
+	guard := replay.New(replay.Config{MaxSkew: time.Minute})
+	client := dialTestServer(t, NewServer(cnf, inmemory.NewMemStorage(), nil, nil, guard))
+	req := &proto.UpdateRequest{
+		Metric: &proto.Metric{Id: "PollCount", Type: proto.MType_COUNTER, Delta: 1},
+	}
+	body, err := protobuf.MarshalOptions{Deterministic: true}.Marshal(req)
+	require.NoError(t, err)
+	signed := func(timestamp, nonce string) context.Context {
+		h := hmac.New(sha256.New, []byte(key))
+		h.Write(keyring.Payload(http.MethodPost, proto.MetricsService_Update_FullMethodName, timestamp, nonce, body))
+		return metadata.AppendToOutgoingContext(context.Background(),
+			proto.HashMetadataKey, hex.EncodeToString(h.Sum(nil)),
+			proto.HashTimestampMetadataKey, timestamp,
+			proto.HashNonceMetadataKey, nonce,
+		)
+	}
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+
+	ctx := signed(now, "n1")
+	var header metadata.MD
+	resp, err := client.Update(ctx, req, grpc.Header(&header))
+	require.NoError(t, err)
+	// Ответ подписан вместе с nonce запроса
+	respBody, err := protobuf.MarshalOptions{Deterministic: true}.Marshal(resp)
+	require.NoError(t, err)
+	keys, err := keyring.New(keyring.Key{Secret: key})
+	require.NoError(t, err)
+	assert.NoError(t, keys.Verify("", header.Get(proto.HashMetadataKey)[0], keyring.ResponsePayload("n1", respBody)))
+	// Повтор перехваченного запроса не увеличивает счетчик
+	_, err = client.Update(ctx, req)
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	old := strconv.FormatInt(time.Now().Add(-time.Hour).Unix(), 10)
+	_, err = client.Update(signed(old, "n2"), req)
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	counter, err := client.GetValue(context.Background(), &proto.GetValueRequest{Id: "PollCount", Type: proto.MType_COUNTER})
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), counter.GetMetric().GetDelta())
+}
+
+func TestTrustedSubnetInterceptor(t *testing.T) {
+	client := newTestClient(t, &flags.InitedFlags{TrustedSubnet: "192.168.1.0/24"})
+	req := &proto.UpdateRequest{
+		Metric: &proto.Metric{Id: "Alloc", Type: proto.MType_GAUGE, Value: 2},
+	}
+
+	ctx := metadata.AppendToOutgoingContext(context.Background(), proto.RealIPMetadataKey, "192.168.1.10")
+	_, err := client.Update(ctx, req)
+	assert.NoError(t, err)
+
+	ctx = metadata.AppendToOutgoingContext(context.Background(), proto.RealIPMetadataKey, "10.0.0.1")
+	_, err = client.Update(ctx, req)
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	_, err = client.UpdateBatch(context.Background(), &proto.UpdateBatchRequest{Metrics: []*proto.Metric{req.Metric}})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	// Чтение не ограничено подсетью
+	_, err = client.GetValue(context.Background(), &proto.GetValueRequest{Id: "Alloc", Type: proto.MType_GAUGE})
+	assert.NoError(t, err)
+}
+
+func TestMetricsServer_RateLimit(t *testing.T) {
+	limiter := ratelimit.New(ratelimit.Config{RequestsPerSecond: 0.001, RequestsBurst: 2, MetricsPerSecond: 0.001, MetricsBurst: 3})
+	client := newLimitedTestClient(t, &flags.InitedFlags{}, limiter)
+	ctx := context.Background()
+	metric := func(id string) *proto.Metric {
+		return &proto.Metric{Id: id, Type: proto.MType_GAUGE, Value: 1}
+	}
+
+	_, err := client.UpdateBatch(ctx, &proto.UpdateBatchRequest{Metrics: []*proto.Metric{metric("a"), metric("b"), metric("c"), metric("d")}})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	_, err = client.UpdateBatch(ctx, &proto.UpdateBatchRequest{Metrics: []*proto.Metric{metric("a"), metric("b"), metric("c")}})
+	require.NoError(t, err)
+
+	var header metadata.MD
+	_, err = client.Update(ctx, &proto.UpdateRequest{Metric: metric("a")}, grpc.Header(&header))
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+	assert.NotEmpty(t, header.Get(proto.RetryAfterMetadataKey))
+
+	// Чтение не ограничивается
+	_, err = client.GetValue(ctx, &proto.GetValueRequest{Id: "a", Type: proto.MType_GAUGE})
+	require.NoError(t, err)
+}
+
+// TestMetricsServer_RateLimitKeyID проверяет, что лимит метрик ведется по ключу проверенной подписи,
+// а идентификатор ключа без подписи не выбирает чужие лимиты
+func TestMetricsServer_RateLimitKeyID(t *testing.T) {
+	keys, err := keyring.New(keyring.Key{ID: "k1", Secret: "secret"})
+	require.NoError(t, err)
+	limiter := ratelimit.New(ratelimit.Config{MetricsPerSecond: 0.001, MetricsBurst: 1})
+	client := dialTestServer(t, NewServer(&flags.InitedFlags{HashOptional: true}, inmemory.NewMemStorage(), limiter, keys, nil))
+	req := &proto.UpdateRequest{Metric: &proto.Metric{Id: "a", Type: proto.MType_GAUGE, Value: 1}}
+	body, err := protobuf.MarshalOptions{Deterministic: true}.Marshal(req)
+	require.NoError(t, err)
+	signature, err := keys.Sign("k1", body)
+	require.NoError(t, err)
+	signed := func() context.Context {
+		return metadata.AppendToOutgoingContext(context.Background(), proto.HashKeyIDMetadataKey, "k1", proto.HashMetadataKey, signature)
+	}
+
+	_, err = client.Update(signed(), req)
+	require.NoError(t, err)
+	// Без подписи агент учитывается по адресу соединения
+	unsigned := metadata.AppendToOutgoingContext(context.Background(), proto.HashKeyIDMetadataKey, "k1")
+	var header metadata.MD
+	_, err = client.Update(unsigned, req, grpc.Header(&header))
+	require.NoError(t, err)
+	assert.Empty(t, header.Get(proto.HashMetadataKey), "ответ на запрос без подписи не подписывается")
+	_, err = client.Update(signed(), req)
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+}
@@ -2,18 +2,32 @@
 package handler
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/go-chi/chi/v5"
+	"go.uber.org/zap"
 
+	"github.com/Arcadian-Sky/musthave-metrics/internal/keyring"
+	"github.com/Arcadian-Sky/musthave-metrics/internal/labels"
+	"github.com/Arcadian-Sky/musthave-metrics/internal/logging"
+	"github.com/Arcadian-Sky/musthave-metrics/internal/server/alerting"
+	"github.com/Arcadian-Sky/musthave-metrics/internal/server/exposition"
 	"github.com/Arcadian-Sky/musthave-metrics/internal/server/flags"
 	"github.com/Arcadian-Sky/musthave-metrics/internal/server/handler/validate"
+	"github.com/Arcadian-Sky/musthave-metrics/internal/server/health"
+	"github.com/Arcadian-Sky/musthave-metrics/internal/server/lineprotocol"
 	"github.com/Arcadian-Sky/musthave-metrics/internal/server/models"
+	"github.com/Arcadian-Sky/musthave-metrics/internal/server/ratelimit"
+	"github.com/Arcadian-Sky/musthave-metrics/internal/server/replay"
 	"github.com/Arcadian-Sky/musthave-metrics/internal/server/storage"
 	"github.com/Arcadian-Sky/musthave-metrics/internal/server/storage/utils"
+	"github.com/Arcadian-Sky/musthave-metrics/internal/server/telemetry"
 )
 
 // Сборщик параметров
@@ -34,27 +48,112 @@ func NewMetricParams(r *http.Request) MetricParams {
 
 // Server handlers
 type Handler struct {
-	s   storage.MetricsStorage
-	cfg *flags.InitedFlags
+	s         storage.MetricsStorage
+	cfg       *flags.InitedFlags
+	alerts    *alerting.Engine
+	log       *zap.Logger
+	telemetry *telemetry.Metrics
+	health    *health.Checker
+	limiter   *ratelimit.Limiter
+	keys      *keyring.Keyring
+	replay    *replay.Guard
 }
 
 // NewHandler создает экземпляр Handler
 func NewHandler(mStorage storage.MetricsStorage, cnf *flags.InitedFlags) *Handler {
-	return &Handler{
-		s:   mStorage,
-		cfg: cnf,
+	h := &Handler{
+		s:      mStorage,
+		cfg:    cnf,
+		log:    zap.NewNop(),
+		health: health.New(),
 	}
+	if cnf != nil && cnf.HashKey != "" {
+		// Ключ из -k без идентификатора, ключи из файла задаются через SetKeyring
+		h.keys, _ = keyring.New(keyring.Key{Secret: cnf.HashKey})
+	}
+	return h
+}
+
+// SetLogger задает логгер ручек. В запросах используется логгер из контекста с идентификатором запроса.
+func (h *Handler) SetLogger(logger *zap.Logger) {
+	h.log = logger
+}
+
+// Logger возвращает логгер ручек
+func (h *Handler) Logger() *zap.Logger {
+	return h.log
+}
+
+// logger возвращает логгер запроса или логгер ручек
+func (h *Handler) logger(r *http.Request) *zap.Logger {
+	return logging.FromContext(r.Context(), h.log)
+}
+
+// SetTelemetry подключает собственные метрики сервера, ручка /metrics выводит их после метрик хранилища
+func (h *Handler) SetTelemetry(m *telemetry.Metrics) {
+	h.telemetry = m
+}
+
+// Telemetry возвращает собственные метрики сервера или nil
+func (h *Handler) Telemetry() *telemetry.Metrics {
+	return h.telemetry
+}
+
+// SetHealth задает проверки состояния сервера для ручек /healthz и /readyz
+func (h *Handler) SetHealth(c *health.Checker) {
+	h.health = c
+}
+
+// Health возвращает проверки состояния сервера
+func (h *Handler) Health() *health.Checker {
+	return h.health
+}
+
+// SetRateLimiter задает лимиты агентов на запись метрик, nil выключает лимиты
+func (h *Handler) SetRateLimiter(l *ratelimit.Limiter) {
+	h.limiter = l
+}
+
+// RateLimiter возвращает лимиты агентов или nil
+func (h *Handler) RateLimiter() *ratelimit.Limiter {
+	return h.limiter
+}
+
+// SetKeyring задает ключи подписи запросов
+func (h *Handler) SetKeyring(keys *keyring.Keyring) {
+	h.keys = keys
+}
+
+// Keyring возвращает ключи подписи запросов или nil
+func (h *Handler) Keyring() *keyring.Keyring {
+	return h.keys
+}
+
+// SetReplayGuard задает защиту подписанных запросов от повтора, nil выключает защиту
+func (h *Handler) SetReplayGuard(g *replay.Guard) {
+	h.replay = g
+}
+
+// ReplayGuard возвращает защиту от повтора запросов или nil
+func (h *Handler) ReplayGuard() *replay.Guard {
+	return h.replay
+}
+
+// SetAlertEngine подключает движок алертов, состояние которого отдает ручка /alerts
+func (h *Handler) SetAlertEngine(engine *alerting.Engine) {
+	h.alerts = engine
 }
 
 // Получает метрики.
 //
 // @Summary Получает метрики.
 // @Description Обновляет метрику в хранилище.
+// @Param labels query string false "Селектор меток, например host=a,env!=dev"
 // @Success 200 {string} string "OK"
+// @Failure 400 {string} string "Error"
 // @Router / [get]
 func (h *Handler) MetricsHandlerFunc(w http.ResponseWriter, r *http.Request) {
-	body, _ := io.ReadAll(r.Body)
-	err := validate.CheckHash(validate.GetHashHead(r), body, h.cfg.HashKey)
+	metrics, err := h.selectMetrics(r)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
@@ -64,11 +163,83 @@ func (h *Handler) MetricsHandlerFunc(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 
 	// Выводим данные
-	for name, value := range h.s.GetMetrics(r.Context()) {
+	for name, value := range metrics {
 		fmt.Fprintf(w, "%s: %v\n", name, value)
 	}
 }
 
+// Отдает метрики для Prometheus.
+//
+// @Summary Отдает метрики для Prometheus.
+// @Description Выводит все метрики хранилища в текстовом формате Prometheus или в OpenMetrics, если он запрошен в заголовке Accept.
+// @Produce plain
+// @Param labels query string false "Селектор меток, например host=a,env!=dev"
+// @Success 200 {string} string "OK"
+// @Failure 400 {string} string "Error"
+// @Router /metrics [get]
+func (h *Handler) PrometheusHandlerFunc(w http.ResponseWriter, r *http.Request) {
+	format := exposition.Negotiate(r.Header.Get("Accept"))
+
+	metrics, err := h.selectMetrics(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", format.ContentType())
+	w.WriteHeader(http.StatusOK)
+
+	// Собственные метрики сервера не имеют меток агентов, поэтому при отборе по меткам не выводятся
+	var sources []exposition.Source
+	if h.telemetry != nil && r.URL.Query().Get("labels") == "" {
+		sources = append(sources, h.telemetry)
+	}
+	err = exposition.Write(w, metrics, format, sources...)
+	if err != nil {
+		h.logger(r).Error("Ошибка записи Body", zap.Error(err))
+	}
+}
+
+// selectMetrics возвращает метрики, отобранные селектором из параметра labels.
+// Если хранилище не умеет отбирать метрики по меткам, они фильтруются после выборки.
+func (h *Handler) selectMetrics(r *http.Request) (map[storage.MetricType]map[string]interface{}, error) {
+	selector, err := labels.ParseSelector(r.URL.Query().Get("labels"))
+	if err != nil {
+		return nil, err
+	}
+	if len(selector) == 0 {
+		return h.s.GetMetrics(r.Context()), nil
+	}
+	if labelStorage, ok := h.s.(storage.LabelStorage); ok {
+		return labelStorage.GetMetricsByLabels(r.Context(), selector), nil
+	}
+	metrics := make(map[storage.MetricType]map[string]interface{})
+	for mtype, values := range h.s.GetMetrics(r.Context()) {
+		metrics[mtype] = filterMetrics(values, selector)
+	}
+	return metrics, nil
+}
+
+// filterMetrics оставляет метрики, ключи рядов которых соответствуют селектору
+func filterMetrics(metrics map[string]interface{}, selector labels.Selector) map[string]interface{} {
+	result := make(map[string]interface{})
+	for key, value := range metrics {
+		if selector.MatchesKey(key) {
+			result[key] = value
+		}
+	}
+	return result
+}
+
+// seriesKey возвращает ключ ряда для имени метрики и меток из параметра labels
+func seriesKey(r *http.Request, name string) (string, error) {
+	l, err := labels.Parse(r.URL.Query().Get("labels"))
+	if err != nil {
+		return "", err
+	}
+	return labels.Key(name, l), nil
+}
+
 // Обновляет метрику.
 //
 // @Summary Обновляет метрику.
@@ -85,21 +256,18 @@ func (h *Handler) MetricsHandlerFunc(w http.ResponseWriter, r *http.Request) {
 // @Failure 404 {string} string "Error"
 func (h *Handler) UpdateMetricsHandlerFunc(w http.ResponseWriter, r *http.Request) {
 	params := NewMetricParams(r)
-	body, _ := io.ReadAll(r.Body)
-	err := validate.CheckHash(validate.GetHashHead(r), body, h.cfg.HashKey)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
-		return
-	}
-
 	//Проверякм переданные параметры
-	err = validate.CheckMetricTypeAndName(params.Type, params.Name)
+	err := validate.CheckMetricTypeAndName(params.Type, params.Name)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusNotFound)
 		return
 	}
 
 	ctx := r.Context()
+	if err := ratelimit.TakeMetrics(ctx, 1); err != nil {
+		ratelimit.WriteError(w, err)
+		return
+	}
 	// Обновляем метрику
 	err = h.s.UpdateMetric(ctx, params.Type, params.Name, params.Value)
 	if err != nil {
@@ -123,19 +291,13 @@ func (h *Handler) UpdateMetricsHandlerFunc(w http.ResponseWriter, r *http.Reques
 // @Description Получает метрику в хранилище.
 // @Param type path string true "Тип метрики (gauge или counter)"
 // @Param name path string true "Название метрики"
+// @Param labels query string false "Метки ряда host=a,env=prod; без имени метрики - селектор"
 // @Success 200 {string} string "OK"
 // @Router /value/{type}/{name} [get]
 func (h *Handler) GetMetricHandlerFunc(w http.ResponseWriter, r *http.Request) {
-	body, _ := io.ReadAll(r.Body)
-	err := validate.CheckHash(validate.GetHashHead(r), body, h.cfg.HashKey)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
-		return
-	}
-
 	params := NewMetricParams(r)
 	//Проверякм переданные параметры
-	err = validate.CheckMetricTypeAndName(params.Type, params.Name)
+	err := validate.CheckMetricTypeAndName(params.Type, params.Name)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusNotFound)
 		return
@@ -149,12 +311,16 @@ func (h *Handler) GetMetricHandlerFunc(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Выводим данные
-	fmt.Println("metricTypeID", metricTypeID)
 	currentMetrics := h.s.GetMetric(r.Context(), metricTypeID)
 	if params.Name != "" {
-		fmt.Printf("currentMetrics[metricName]: %v\n", currentMetrics[params.Name])
-		if currentMetrics[params.Name] != nil {
-			_, err = w.Write([]byte(fmt.Sprintf("%v", currentMetrics[params.Name])))
+		key, err := seriesKey(r, params.Name)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		h.logger(r).Debug("Запрошена метрика", zap.String("key", key), zap.Any("value", currentMetrics[key]))
+		if currentMetrics[key] != nil {
+			_, err = w.Write([]byte(fmt.Sprintf("%v", currentMetrics[key])))
 			if err != nil {
 				http.Error(w, "w.Write Error: "+err.Error(), http.StatusNotFound)
 			}
@@ -162,15 +328,210 @@ func (h *Handler) GetMetricHandlerFunc(w http.ResponseWriter, r *http.Request) {
 			http.Error(w, "Metric value not provided", http.StatusNotFound)
 		}
 	} else {
+		selector, err := labels.ParseSelector(r.URL.Query().Get("labels"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
 		w.Header().Set("Content-Type", "text/html")
 		w.WriteHeader(http.StatusOK)
 
-		for name, value := range currentMetrics {
+		for name, value := range filterMetrics(currentMetrics, selector) {
 			fmt.Fprintf(w, "%s: %v\n", name, value)
 		}
 	}
 }
 
+// Получает историю метрики.
+//
+// @Summary Получает историю метрики.
+// @Description Возвращает временной ряд метрики за интервал, прореженный с шагом step.
+// @Produce json
+// @Param type path string true "Тип метрики (gauge или counter)"
+// @Param name path string true "Название метрики"
+// @Param from query string false "Начало интервала, RFC3339 или unix-время (по умолчанию час назад)"
+// @Param to query string false "Конец интервала, RFC3339 или unix-время (по умолчанию сейчас)"
+// @Param step query string false "Шаг прореживания, например 1m или число секунд"
+// @Param labels query string false "Метки ряда, например host=a,env=prod"
+// @Success 200 {object} models.History "OK"
+// @Failure 400 {string} string "Error"
+// @Failure 501 {string} string "История не поддерживается хранилищем"
+// @Router /history/{type}/{name} [get]
+func (h *Handler) GetHistoryHandlerFunc(w http.ResponseWriter, r *http.Request) {
+	params := NewMetricParams(r)
+	err := validate.CheckMetricTypeAndName(params.Type, params.Name)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	metricTypeID, err := utils.GetMetricTypeByCode(params.Type)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	historyStorage, ok := h.s.(storage.HistoryStorage)
+	if !ok {
+		http.Error(w, "history is not supported by storage", http.StatusNotImplemented)
+		return
+	}
+
+	query := r.URL.Query()
+	now := time.Now()
+	to, err := parseTimeParam(query.Get("to"), now)
+	if err != nil {
+		http.Error(w, "invalid to: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	from, err := parseTimeParam(query.Get("from"), to.Add(-time.Hour))
+	if err != nil {
+		http.Error(w, "invalid from: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	step, err := parseStepParam(query.Get("step"))
+	if err != nil {
+		http.Error(w, "invalid step: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	key, err := seriesKey(r, params.Name)
+	if err != nil {
+		http.Error(w, "invalid labels: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if from.After(to) {
+		http.Error(w, "from is after to", http.StatusBadRequest)
+		return
+	}
+
+	points, err := historyStorage.GetHistory(r.Context(), metricTypeID, key, from, to, step)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	_, seriesLabels := labels.ParseKey(key)
+	resp, err := json.Marshal(models.History{
+		ID:     params.Name,
+		MType:  params.Type,
+		Labels: seriesLabels,
+		Step:   step.String(),
+		Points: points,
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_, err = w.Write(resp)
+	if err != nil {
+		h.logger(r).Error("Ошибка записи Body", zap.Error(err))
+	}
+}
+
+// Отдает состояние алертов.
+//
+// @Summary Отдает состояние алертов.
+// @Description Возвращает все правила алертов с текущим состоянием: inactive, pending, firing или resolved.
+// @Produce json
+// @Success 200 {array} alerting.Alert "OK"
+// @Router /alerts [get]
+func (h *Handler) AlertsHandlerFunc(w http.ResponseWriter, r *http.Request) {
+	alerts := make([]alerting.Alert, 0)
+	if h.alerts != nil {
+		alerts = h.alerts.Alerts()
+	}
+
+	resp, err := json.Marshal(alerts)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_, err = w.Write(resp)
+	if err != nil {
+		h.logger(r).Error("Ошибка записи Body", zap.Error(err))
+	}
+}
+
+// readinessTimeout ограничивает время проверки зависимостей в /readyz
+const readinessTimeout = 2 * time.Second
+
+// Проверяет, что процесс жив.
+//
+// @Summary Проверяет, что процесс жив.
+// @Description Возвращает состояние фоновых горутин. 503, если какая-то из них завершилась до остановки сервера.
+// @Produce json
+// @Success 200 {object} health.Report "OK"
+// @Failure 503 {object} health.Report "Fail"
+// @Router /healthz [get]
+func (h *Handler) HealthzHandlerFunc(w http.ResponseWriter, r *http.Request) {
+	h.writeHealth(w, r, h.health.Liveness())
+}
+
+// Проверяет готовность принимать запросы.
+//
+// @Summary Проверяет готовность принимать запросы.
+// @Description Возвращает состояние хранилища, миграций, сохранения снимков и фоновых горутин.
+// @Description 503, если какой-то компонент неисправен или сервер останавливается.
+// @Produce json
+// @Success 200 {object} health.Report "OK"
+// @Failure 503 {object} health.Report "Fail"
+// @Router /readyz [get]
+func (h *Handler) ReadyzHandlerFunc(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), readinessTimeout)
+	defer cancel()
+	h.writeHealth(w, r, h.health.Readiness(ctx))
+}
+
+func (h *Handler) writeHealth(w http.ResponseWriter, r *http.Request, report health.Report) {
+	resp, err := json.Marshal(report)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	status := http.StatusOK
+	if report.Status != health.StatusOK {
+		status = http.StatusServiceUnavailable
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	_, err = w.Write(resp)
+	if err != nil {
+		h.logger(r).Error("Ошибка записи Body", zap.Error(err))
+	}
+}
+
+// parseTimeParam разбирает время в формате RFC3339 или unix-время в секундах
+func parseTimeParam(value string, def time.Time) (time.Time, error) {
+	if value == "" {
+		return def, nil
+	}
+	if unix, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Unix(unix, 0), nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
+
+// parseStepParam разбирает шаг в формате time.Duration или в секундах
+func parseStepParam(value string) (time.Duration, error) {
+	if value == "" {
+		return 0, nil
+	}
+	var step time.Duration
+	if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+		step = time.Duration(seconds) * time.Second
+	} else if step, err = time.ParseDuration(value); err != nil {
+		return 0, err
+	}
+	if step < 0 {
+		return 0, fmt.Errorf("step must be positive")
+	}
+	return step, nil
+}
+
 // Получает метрики через JSON
 //
 // @Summary Получает метрики.
@@ -187,11 +548,6 @@ func (h *Handler) GetMetricsJSONHandlerFunc(w http.ResponseWriter, r *http.Reque
 		http.Error(w, "Failed to read request body: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
-	err = validate.CheckHash(validate.GetHashHead(r), body, h.cfg.HashKey)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
-		return
-	}
 
 	var metrics models.Metrics
 
@@ -204,7 +560,7 @@ func (h *Handler) GetMetricsJSONHandlerFunc(w http.ResponseWriter, r *http.Reque
 	// Декодируем JSON из []byte в структуру Metrics
 	if err := json.Unmarshal(body, &metrics); err != nil {
 		http.Error(w, "Failed to decode JSON: "+err.Error(), http.StatusBadRequest)
-		fmt.Printf("Failed to decode JSON:  err.Error(): %v\n", err.Error())
+		h.logger(r).Debug("Failed to decode JSON", zap.Error(err))
 		return
 	}
 
@@ -213,20 +569,20 @@ func (h *Handler) GetMetricsJSONHandlerFunc(w http.ResponseWriter, r *http.Reque
 		err = h.s.GetJSONMetric(r.Context(), &metrics)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusNotFound)
-			fmt.Printf("GetJSONMetric err.Error(): %v\n", err.Error())
+			h.logger(r).Debug("Метрика не найдена", zap.String("id", metrics.ID), zap.Error(err))
 			return
 		}
 	}
 	resp, err := json.Marshal(&metrics)
 	if err != nil {
-		fmt.Println("Ошибка при преобразовании в JSON:", err)
+		h.logger(r).Error("Ошибка при преобразовании в JSON", zap.Error(err))
 		return
 	}
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
 	_, err = w.Write(resp)
 	if err != nil {
-		fmt.Println("Ошибка записи Body:", err)
+		h.logger(r).Error("Ошибка записи Body", zap.Error(err))
 		return
 	}
 }
@@ -247,11 +603,6 @@ func (h *Handler) UpdateJSONMetricHandlerFunc(w http.ResponseWriter, r *http.Req
 		http.Error(w, "Failed to read request body: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
-	err = validate.CheckHash(validate.GetHashHead(r), body, h.cfg.HashKey)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
-		return
-	}
 
 	var metrics models.Metrics
 
@@ -268,6 +619,10 @@ func (h *Handler) UpdateJSONMetricHandlerFunc(w http.ResponseWriter, r *http.Req
 		return
 	}
 	ctx := r.Context()
+	if err := ratelimit.TakeMetrics(ctx, 1); err != nil {
+		ratelimit.WriteError(w, err)
+		return
+	}
 	// Обновляем метрику
 	err = h.s.UpdateJSONMetric(ctx, &metrics)
 	if err != nil {
@@ -284,7 +639,7 @@ func (h *Handler) UpdateJSONMetricHandlerFunc(w http.ResponseWriter, r *http.Req
 
 	resp, err := json.Marshal(&metrics)
 	if err != nil {
-		fmt.Println("Ошибка при преобразовании в JSON:", err)
+		h.logger(r).Error("Ошибка при преобразовании в JSON", zap.Error(err))
 		return
 	}
 
@@ -292,7 +647,7 @@ func (h *Handler) UpdateJSONMetricHandlerFunc(w http.ResponseWriter, r *http.Req
 	w.WriteHeader(http.StatusOK)
 	_, err = w.Write(resp)
 	if err != nil {
-		fmt.Println("Ошибка записи Body:", err)
+		h.logger(r).Error("Ошибка записи Body", zap.Error(err))
 		return
 	}
 
@@ -300,14 +655,7 @@ func (h *Handler) UpdateJSONMetricHandlerFunc(w http.ResponseWriter, r *http.Req
 
 // Пинг БД
 func (h *Handler) PingDB(w http.ResponseWriter, r *http.Request) {
-	body, _ := io.ReadAll(r.Body)
-	err := validate.CheckHash(validate.GetHashHead(r), body, h.cfg.HashKey)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
-		return
-	}
-
-	err = h.s.Ping()
+	err := h.s.Ping()
 	if err != nil {
 		http.Error(w, "ошибка при проверке подключения к базе данных:"+err.Error(), http.StatusInternalServerError)
 		return
@@ -334,11 +682,6 @@ func (h *Handler) UpdateJSONMetricsHandlerFunc(w http.ResponseWriter, r *http.Re
 		http.Error(w, "Failed to read request body: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
-	// err = validate.CheckHash(validate.GetHashHead(r), body, h.cfg.HashKey)
-	// if err != nil {
-	// 	http.Error(w, err.Error(), http.StatusBadRequest)
-	// 	return
-	// }
 
 	var metrics []models.Metrics
 
@@ -353,6 +696,10 @@ func (h *Handler) UpdateJSONMetricsHandlerFunc(w http.ResponseWriter, r *http.Re
 		http.Error(w, "Failed to decode JSON: "+err.Error(), http.StatusBadRequest)
 		return
 	}
+	if err := ratelimit.TakeMetrics(r.Context(), len(metrics)); err != nil {
+		ratelimit.WriteError(w, err)
+		return
+	}
 	// Обновляем метрики
 	err = h.s.UpdateJSONMetrics(r.Context(), &metrics)
 	if err != nil {
@@ -371,7 +718,7 @@ func (h *Handler) UpdateJSONMetricsHandlerFunc(w http.ResponseWriter, r *http.Re
 
 	resp, err := json.Marshal(&metrics)
 	if err != nil {
-		fmt.Println("Ошибка при преобразовании в JSON:", err)
+		h.logger(r).Error("Ошибка при преобразовании в JSON", zap.Error(err))
 		return
 	}
 
@@ -379,7 +726,43 @@ func (h *Handler) UpdateJSONMetricsHandlerFunc(w http.ResponseWriter, r *http.Re
 	w.WriteHeader(http.StatusOK)
 	_, err = w.Write(resp)
 	if err != nil {
-		fmt.Println("Ошибка записи Body:", err)
+		h.logger(r).Error("Ошибка записи Body", zap.Error(err))
 		return
 	}
 }
+
+// Записывает метрики в строковом протоколе InfluxDB, например от Telegraf.
+// Строки применяются одной пачкой, как в /updates: при ошибке в любой строке не применяется ничего.
+//
+// @Summary Записывает метрики в строковом протоколе InfluxDB.
+// @Description Поля становятся метриками measurement_field, теги - метками. Числовые и логические поля становятся gauge.
+// @Accept plain
+// @Param data body string true "Строки вида cpu,host=a usage_idle=97.5,ctx_switches=120i"
+// @Success 204 {string} string "No Content"
+// @Failure 400 {string} string "Error"
+// @Router /write [post]
+func (h *Handler) WriteLineProtocolHandlerFunc(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "Failed to read request body: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	metrics, err := lineprotocol.Parse(body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := ratelimit.TakeMetrics(r.Context(), len(metrics)); err != nil {
+		ratelimit.WriteError(w, err)
+		return
+	}
+	if len(metrics) > 0 {
+		if err := h.s.UpdateJSONMetrics(r.Context(), &metrics); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
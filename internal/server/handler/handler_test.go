@@ -2,19 +2,28 @@ package handler
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/go-chi/chi/v5"
+	"github.com/golang/mock/gomock"
 	"github.com/stretchr/testify/assert"
 
+	"github.com/Arcadian-Sky/musthave-metrics/internal/server/alerting"
+	"github.com/Arcadian-Sky/musthave-metrics/internal/server/exposition"
 	"github.com/Arcadian-Sky/musthave-metrics/internal/server/flags"
+	"github.com/Arcadian-Sky/musthave-metrics/internal/server/health"
+	"github.com/Arcadian-Sky/musthave-metrics/internal/server/mock"
 	"github.com/Arcadian-Sky/musthave-metrics/internal/server/models"
+	"github.com/Arcadian-Sky/musthave-metrics/internal/server/storage/history"
 	"github.com/Arcadian-Sky/musthave-metrics/internal/server/storage/inmemory"
+	"github.com/Arcadian-Sky/musthave-metrics/internal/server/telemetry"
 )
 
 // func TestUpdateMetricsHandler(t *testing.T) {
@@ -774,8 +783,7 @@ func TestHandler_UpdateMetricsJSONHandlerFunc(t *testing.T) {
 		{
 			name:         "ID and type request",
 			requestBody:  `[{"id": "metric1", "type": "gauge"}]`,
-			expectedCode: http.StatusOK,
-			expJSONData:  `[{"id":"metric1","type":"gauge"}]`,
+			expectedCode: http.StatusBadRequest,
 			// expectedMetrics: []models.Metrics{
 			// 	{
 			// 		ID:    "metric1",
@@ -860,3 +868,329 @@ func TestHandler_UpdateMetricsJSONHandlerFunc(t *testing.T) {
 		})
 	}
 }
+
+func TestHandler_GetHistoryHandlerFunc(t *testing.T) {
+	memStorage := inmemory.NewMemStorage()
+	memStorage.SetHistoryConfig(history.Config{Retention: time.Hour})
+	handler := NewHandler(memStorage, &flags.InitedFlags{})
+
+	r := chi.NewRouter()
+	r.Post("/update/{type}/{name}/{value}", handler.UpdateMetricsHandlerFunc)
+	r.Get("/history/{type}/{name}", handler.GetHistoryHandlerFunc)
+
+	testServer := httptest.NewServer(r)
+	defer testServer.Close()
+
+	for _, value := range []string{"1", "2"} {
+		response, err := http.Post(testServer.URL+"/update/counter/PollCount/"+value, "text/plain", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		response.Body.Close()
+	}
+
+	tests := []struct {
+		name         string
+		requestPath  string
+		expectedCode int
+		expectedLast float64
+	}{
+		{
+			name:         "valid request",
+			requestPath:  "/history/counter/PollCount?step=1h",
+			expectedCode: http.StatusOK,
+			expectedLast: 3,
+		},
+		{
+			name:         "invalid type",
+			requestPath:  "/history/unknown/PollCount",
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			name:         "invalid from",
+			requestPath:  "/history/counter/PollCount?from=yesterday",
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			name:         "invalid step",
+			requestPath:  "/history/counter/PollCount?step=-1",
+			expectedCode: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			response, err := http.Get(testServer.URL + tt.requestPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer response.Body.Close()
+
+			assert.Equal(t, tt.expectedCode, response.StatusCode)
+			if tt.expectedCode != http.StatusOK {
+				return
+			}
+
+			var result models.History
+			if err := json.NewDecoder(response.Body).Decode(&result); err != nil {
+				t.Fatal(err)
+			}
+			assert.Equal(t, "PollCount", result.ID)
+			if assert.NotEmpty(t, result.Points) {
+				assert.Equal(t, tt.expectedLast, result.Points[len(result.Points)-1].Value)
+			}
+		})
+	}
+}
+
+func TestHandler_GetHistoryHandlerFuncNotImplemented(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	handler := NewHandler(mock.NewMockMetricsStorage(ctrl), &flags.InitedFlags{})
+	r := chi.NewRouter()
+	r.Get("/history/{type}/{name}", handler.GetHistoryHandlerFunc)
+
+	request := httptest.NewRequest(http.MethodGet, "/history/gauge/Alloc", nil)
+	recorder := httptest.NewRecorder()
+	r.ServeHTTP(recorder, request)
+
+	assert.Equal(t, http.StatusNotImplemented, recorder.Code)
+}
+
+func TestHandler_PrometheusHandlerFunc(t *testing.T) {
+	memStorage := inmemory.NewMemStorage()
+	handler := NewHandler(memStorage, &flags.InitedFlags{})
+	ctx := context.Background()
+	assert.NoError(t, memStorage.UpdateMetric(ctx, "gauge", "Alloc", "1.5"))
+	assert.NoError(t, memStorage.UpdateMetric(ctx, "counter", "PollCount", "3"))
+
+	tests := []struct {
+		name                string
+		accept              string
+		expectedContentType string
+		expectedBody        []string
+	}{
+		{
+			name:                "prometheus text",
+			accept:              "text/plain",
+			expectedContentType: exposition.ContentTypeText,
+			expectedBody:        []string{"# TYPE Alloc gauge\nAlloc 1.5\n", "# TYPE PollCount counter\nPollCount 3\n"},
+		},
+		{
+			name:                "openmetrics",
+			accept:              "application/openmetrics-text; version=1.0.0",
+			expectedContentType: exposition.ContentTypeOpenMetrics,
+			expectedBody:        []string{"PollCount_total 3\n", "# EOF\n"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+			request.Header.Set("Accept", tt.accept)
+			recorder := httptest.NewRecorder()
+
+			handler.PrometheusHandlerFunc(recorder, request)
+
+			assert.Equal(t, http.StatusOK, recorder.Code)
+			assert.Equal(t, tt.expectedContentType, recorder.Header().Get("Content-Type"))
+			for _, part := range tt.expectedBody {
+				assert.Contains(t, recorder.Body.String(), part)
+			}
+		})
+	}
+}
+
+func TestHandler_PrometheusHandlerFuncTelemetry(t *testing.T) {
+	memStorage := inmemory.NewMemStorage()
+	selfMetrics := telemetry.New()
+	memStorage.SetObserver(selfMetrics)
+	handler := NewHandler(memStorage, &flags.InitedFlags{})
+	handler.SetTelemetry(selfMetrics)
+	assert.NoError(t, memStorage.UpdateMetric(context.Background(), "gauge", "Alloc", "1.5"))
+
+	tests := []struct {
+		name    string
+		target  string
+		contain bool
+	}{
+		{name: "all metrics", target: "/metrics", contain: true},
+		{name: "label selector", target: "/metrics?labels=host%3Da", contain: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			handler.PrometheusHandlerFunc(recorder, httptest.NewRequest(http.MethodGet, tt.target, nil))
+
+			assert.Equal(t, http.StatusOK, recorder.Code)
+			line := `metrics_server_ingested_metrics_total{type="gauge"} 1` + "\n"
+			if tt.contain {
+				assert.Contains(t, recorder.Body.String(), line)
+			} else {
+				assert.NotContains(t, recorder.Body.String(), line)
+			}
+		})
+	}
+}
+
+func TestHandler_Labels(t *testing.T) {
+	memStorage := inmemory.NewMemStorage()
+	handler := NewHandler(memStorage, &flags.InitedFlags{})
+	ctx := context.Background()
+	for host, value := range map[string]float64{"a": 1, "b": 2} {
+		v := value
+		assert.NoError(t, memStorage.UpdateJSONMetric(ctx, &models.Metrics{
+			ID:     "Alloc",
+			MType:  "gauge",
+			Value:  &v,
+			Labels: map[string]string{"host": host},
+		}))
+	}
+
+	t.Run("prometheus selector", func(t *testing.T) {
+		request := httptest.NewRequest(http.MethodGet, "/metrics?labels=host!=b", nil)
+		recorder := httptest.NewRecorder()
+		handler.PrometheusHandlerFunc(recorder, request)
+
+		assert.Equal(t, http.StatusOK, recorder.Code)
+		assert.Contains(t, recorder.Body.String(), "Alloc{host=\"a\"} 1\n")
+		assert.NotContains(t, recorder.Body.String(), "host=\"b\"")
+	})
+
+	t.Run("invalid selector", func(t *testing.T) {
+		request := httptest.NewRequest(http.MethodGet, "/metrics?labels=host", nil)
+		recorder := httptest.NewRecorder()
+		handler.PrometheusHandlerFunc(recorder, request)
+
+		assert.Equal(t, http.StatusBadRequest, recorder.Code)
+	})
+
+	t.Run("value by labels", func(t *testing.T) {
+		r := chi.NewRouter()
+		r.Get("/value/{type}/{name}", handler.GetMetricHandlerFunc)
+
+		request := httptest.NewRequest(http.MethodGet, "/value/gauge/Alloc?labels=host=b", nil)
+		recorder := httptest.NewRecorder()
+		r.ServeHTTP(recorder, request)
+
+		assert.Equal(t, http.StatusOK, recorder.Code)
+		assert.Equal(t, "2", recorder.Body.String())
+	})
+
+	t.Run("json value by labels", func(t *testing.T) {
+		body := `{"id":"Alloc","type":"gauge","labels":{"host":"a"}}`
+		request := httptest.NewRequest(http.MethodPost, "/value/", bytes.NewBufferString(body))
+		recorder := httptest.NewRecorder()
+		handler.GetMetricsJSONHandlerFunc(recorder, request)
+
+		assert.Equal(t, http.StatusOK, recorder.Code)
+		assert.JSONEq(t, `{"id":"Alloc","type":"gauge","value":1,"labels":{"host":"a"}}`, recorder.Body.String())
+	})
+}
+
+func TestHandler_HealthHandlers(t *testing.T) {
+	handler := NewHandler(inmemory.NewMemStorage(), &flags.InitedFlags{})
+	checker := health.New()
+	checker.AddCheck("storage", health.StorageCheck("inmemory", nil))
+	done := checker.Track("statsd")
+	handler.SetHealth(checker)
+
+	get := func(f http.HandlerFunc, target string) (int, health.Report) {
+		recorder := httptest.NewRecorder()
+		f(recorder, httptest.NewRequest(http.MethodGet, target, nil))
+		assert.Equal(t, "application/json", recorder.Header().Get("Content-Type"))
+		var report health.Report
+		assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &report))
+		return recorder.Code, report
+	}
+
+	code, report := get(handler.ReadyzHandlerFunc, "/readyz")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, health.StatusOK, report.Status)
+	assert.Equal(t, "inmemory", report.Components["storage"].Details["type"])
+
+	code, _ = get(handler.HealthzHandlerFunc, "/healthz")
+	assert.Equal(t, http.StatusOK, code)
+
+	checker.SetShuttingDown()
+	code, report = get(handler.ReadyzHandlerFunc, "/readyz")
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, health.StatusFail, report.Components["shutdown"].Status)
+
+	// Процесс жив, пока идет остановка
+	done()
+	code, _ = get(handler.HealthzHandlerFunc, "/healthz")
+	assert.Equal(t, http.StatusOK, code)
+}
+
+func TestHandler_AlertsHandlerFunc(t *testing.T) {
+	memStorage := inmemory.NewMemStorage()
+	handler := NewHandler(memStorage, &flags.InitedFlags{})
+
+	t.Run("no engine", func(t *testing.T) {
+		recorder := httptest.NewRecorder()
+		handler.AlertsHandlerFunc(recorder, httptest.NewRequest(http.MethodGet, "/alerts", nil))
+
+		assert.Equal(t, http.StatusOK, recorder.Code)
+		assert.JSONEq(t, `[]`, recorder.Body.String())
+	})
+
+	t.Run("firing", func(t *testing.T) {
+		assert.NoError(t, memStorage.UpdateMetric(context.Background(), "gauge", "HeapAlloc", "1024"))
+		engine, err := alerting.NewEngine(alerting.Config{
+			Rules: []alerting.Rule{{Name: "HeapHigh", Expr: "HeapAlloc >= 1KB"}, {Name: "HeapLow", Expr: "HeapAlloc < 1KB"}},
+		}, memStorage, alerting.MultiNotifier{})
+		assert.NoError(t, err)
+		engine.Evaluate(context.Background())
+		handler.SetAlertEngine(engine)
+
+		recorder := httptest.NewRecorder()
+		handler.AlertsHandlerFunc(recorder, httptest.NewRequest(http.MethodGet, "/alerts", nil))
+
+		assert.Equal(t, http.StatusOK, recorder.Code)
+		assert.Equal(t, "application/json", recorder.Header().Get("Content-Type"))
+		var alerts []alerting.Alert
+		assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &alerts))
+		assert.Len(t, alerts, 2)
+		assert.Equal(t, "HeapHigh", alerts[0].Name)
+		assert.Equal(t, alerting.StateFiring, alerts[0].State)
+		assert.Equal(t, "HeapLow", alerts[1].Name)
+		assert.Equal(t, alerting.StateInactive, alerts[1].State)
+	})
+}
+
+func TestHandler_WriteLineProtocolHandlerFunc(t *testing.T) {
+	memStorage := inmemory.NewMemStorage()
+	handler := NewHandler(memStorage, &flags.InitedFlags{})
+	ctx := context.Background()
+
+	t.Run("batch", func(t *testing.T) {
+		body := "cpu,host=a usage_idle=97.5,ctx_switches=120i 1700000000000000000\n" +
+			"# comment\n" +
+			"temperature value=21.5\n"
+		recorder := httptest.NewRecorder()
+		handler.WriteLineProtocolHandlerFunc(recorder, httptest.NewRequest(http.MethodPost, "/write?db=telegraf", bytes.NewBufferString(body)))
+
+		assert.Equal(t, http.StatusNoContent, recorder.Code)
+		assert.Equal(t, 97.5, memStorage.GetMetric(ctx, "gauge")[`cpu_usage_idle{host="a"}`])
+		assert.Equal(t, 120.0, memStorage.GetMetric(ctx, "gauge")[`cpu_ctx_switches{host="a"}`])
+		assert.Equal(t, 21.5, memStorage.GetMetric(ctx, "gauge")["temperature"])
+
+		// Telegraf присылает накопленный итог, повторная отправка не удваивает значение
+		recorder = httptest.NewRecorder()
+		handler.WriteLineProtocolHandlerFunc(recorder, httptest.NewRequest(http.MethodPost, "/write", bytes.NewBufferString("cpu,host=a ctx_switches=150i\n")))
+		assert.Equal(t, http.StatusNoContent, recorder.Code)
+		assert.Equal(t, 150.0, memStorage.GetMetric(ctx, "gauge")[`cpu_ctx_switches{host="a"}`])
+	})
+
+	t.Run("invalid line rejects batch", func(t *testing.T) {
+		body := "disk used=10i\ndisk free=abc\n"
+		recorder := httptest.NewRecorder()
+		handler.WriteLineProtocolHandlerFunc(recorder, httptest.NewRequest(http.MethodPost, "/write", bytes.NewBufferString(body)))
+
+		assert.Equal(t, http.StatusBadRequest, recorder.Code)
+		assert.Contains(t, recorder.Body.String(), "line 2")
+		assert.NotContains(t, memStorage.GetMetric(ctx, "gauge"), "disk_used")
+	})
+}
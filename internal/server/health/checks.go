@@ -0,0 +1,45 @@
+package health
+
+import (
+	"context"
+	"fmt"
+)
+
+// StorageCheck проверяет хранилище типа kind. Если ping равна nil, хранилище не требует соединения
+// (например, в памяти) и всегда исправно. ping без контекста выполняется в отдельной горутине,
+// при истечении ctx проверка завершается с ошибкой, не дожидаясь ping.
+func StorageCheck(kind string, ping func() error) CheckFunc {
+	return func(ctx context.Context) Component {
+		details := map[string]any{"type": kind}
+		if ping == nil {
+			return OK(details)
+		}
+		result := make(chan error, 1)
+		go func() { result <- ping() }()
+		select {
+		case err := <-result:
+			if err != nil {
+				return Fail(err, details)
+			}
+			return OK(details)
+		case <-ctx.Done():
+			return Fail(ctx.Err(), details)
+		}
+	}
+}
+
+// MigrationCheck сравнивает версию схемы базы с последней встроенной миграцией.
+// Невыполненные миграции делают сервер не готовым.
+func MigrationCheck(status func(ctx context.Context) (current, latest int64, err error)) CheckFunc {
+	return func(ctx context.Context) Component {
+		current, latest, err := status(ctx)
+		if err != nil {
+			return Fail(err, nil)
+		}
+		details := map[string]any{"version": current, "latest": latest}
+		if current < latest {
+			return Fail(fmt.Errorf("pending migrations: version %d, latest %d", current, latest), details)
+		}
+		return OK(details)
+	}
+}
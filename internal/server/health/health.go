@@ -0,0 +1,182 @@
+// Пакет health проверяет состояние компонентов сервера для /healthz и /readyz.
+//
+// Liveness (/healthz) проверяет, что процесс работает: в нем учитываются только фоновые горутины.
+// Readiness (/readyz) дополнительно проверяет зависимости: хранилище, миграции, сохранение снимков.
+// После SetShuttingDown readiness всегда не проходит, чтобы балансировщик перестал направлять запросы
+// до закрытия слушателя.
+package health
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Статусы компонентов и сервера
+const (
+	StatusOK   = "ok"
+	StatusFail = "fail"
+)
+
+// Component состояние одного компонента
+type Component struct {
+	Status  string         `json:"status"`
+	Error   string         `json:"error,omitempty"`
+	Details map[string]any `json:"details,omitempty"`
+}
+
+// Report состояние сервера по компонентам
+type Report struct {
+	Status     string               `json:"status"`
+	Components map[string]Component `json:"components"`
+}
+
+// OK возвращает исправный компонент с подробностями details
+func OK(details map[string]any) Component {
+	return Component{Status: StatusOK, Details: details}
+}
+
+// Fail возвращает неисправный компонент с ошибкой err
+func Fail(err error, details map[string]any) Component {
+	return Component{Status: StatusFail, Error: err.Error(), Details: details}
+}
+
+// CheckFunc проверяет компонент. Вызывается при каждом запросе к /readyz, должна укладываться в таймаут ctx.
+type CheckFunc func(ctx context.Context) Component
+
+// task состояние фоновой горутины
+type task struct {
+	started time.Time
+	stopped time.Time
+	running bool
+}
+
+// Checker собирает проверки компонентов и состояние фоновых горутин
+type Checker struct {
+	mu       sync.Mutex
+	checks   map[string]CheckFunc
+	tasks    map[string]*task
+	memento  *mementoState
+	shutdown atomic.Bool
+	now      func() time.Time
+}
+
+// New создает Checker без проверок
+func New() *Checker {
+	return &Checker{
+		checks: make(map[string]CheckFunc),
+		tasks:  make(map[string]*task),
+		now:    time.Now,
+	}
+}
+
+// AddCheck добавляет проверку компонента name для readiness
+func (c *Checker) AddCheck(name string, check CheckFunc) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.checks[name] = check
+}
+
+// Track отмечает запуск фоновой горутины name и возвращает функцию, которую горутина вызывает при завершении.
+// Горутина, завершившаяся до SetShuttingDown, считается упавшей, и liveness перестает проходить.
+func (c *Checker) Track(name string) (done func()) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t := &task{started: c.now(), running: true}
+	c.tasks[name] = t
+	return func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		t.running = false
+		t.stopped = c.now()
+	}
+}
+
+// SetShuttingDown переводит сервер в режим остановки, после этого readiness не проходит
+func (c *Checker) SetShuttingDown() {
+	c.shutdown.Store(true)
+}
+
+// ShuttingDown сообщает, начата ли остановка сервера
+func (c *Checker) ShuttingDown() bool {
+	return c.shutdown.Load()
+}
+
+// Liveness возвращает состояние фоновых горутин
+func (c *Checker) Liveness() Report {
+	report := Report{Status: StatusOK, Components: make(map[string]Component)}
+	c.mu.Lock()
+	c.tasksInto(&report)
+	c.mu.Unlock()
+	return report
+}
+
+// Readiness выполняет проверки всех компонентов одновременно и добавляет к ним состояние фоновых горутин
+// и снимков хранилища. Во время остановки сервер не готов независимо от компонентов.
+func (c *Checker) Readiness(ctx context.Context) Report {
+	report := Report{Status: StatusOK, Components: make(map[string]Component)}
+
+	c.mu.Lock()
+	names := make([]string, 0, len(c.checks))
+	for name := range c.checks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	checks := make([]CheckFunc, len(names))
+	for i, name := range names {
+		checks[i] = c.checks[name]
+	}
+	c.mu.Unlock()
+
+	results := make([]Component, len(checks))
+	var wg sync.WaitGroup
+	for i, check := range checks {
+		wg.Add(1)
+		go func(i int, check CheckFunc) {
+			defer wg.Done()
+			results[i] = check(ctx)
+		}(i, check)
+	}
+	wg.Wait()
+	for i, name := range names {
+		report.add(name, results[i])
+	}
+
+	c.mu.Lock()
+	c.tasksInto(&report)
+	if c.memento != nil {
+		report.add("memento", c.memento.component(c.now()))
+	}
+	c.mu.Unlock()
+
+	if c.ShuttingDown() {
+		report.add("shutdown", Component{Status: StatusFail, Error: "server is shutting down"})
+	}
+	return report
+}
+
+// tasksInto добавляет в отчет состояние фоновых горутин, вызывается под блокировкой
+func (c *Checker) tasksInto(report *Report) {
+	for name, t := range c.tasks {
+		details := map[string]any{"started": t.started.Format(time.RFC3339)}
+		switch {
+		case t.running:
+			report.add("goroutine:"+name, OK(details))
+		case c.ShuttingDown():
+			details["stopped"] = t.stopped.Format(time.RFC3339)
+			report.add("goroutine:"+name, OK(details))
+		default:
+			details["stopped"] = t.stopped.Format(time.RFC3339)
+			report.add("goroutine:"+name, Component{Status: StatusFail, Error: "goroutine stopped", Details: details})
+		}
+	}
+}
+
+func (r *Report) add(name string, component Component) {
+	r.Components[name] = component
+	if component.Status != StatusOK {
+		r.Status = StatusFail
+	}
+}
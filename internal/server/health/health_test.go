@@ -0,0 +1,105 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadiness(t *testing.T) {
+	c := New()
+	c.AddCheck("storage", StorageCheck("postgres", func() error { return nil }))
+	c.AddCheck("migrations", MigrationCheck(func(context.Context) (int64, int64, error) { return 3, 3, nil }))
+
+	report := c.Readiness(context.Background())
+	assert.Equal(t, StatusOK, report.Status)
+	assert.Equal(t, "postgres", report.Components["storage"].Details["type"])
+	assert.Equal(t, int64(3), report.Components["migrations"].Details["version"])
+
+	c.AddCheck("migrations", MigrationCheck(func(context.Context) (int64, int64, error) { return 2, 3, nil }))
+	report = c.Readiness(context.Background())
+	assert.Equal(t, StatusFail, report.Status)
+	assert.Equal(t, StatusFail, report.Components["migrations"].Status)
+	assert.Equal(t, StatusOK, report.Components["storage"].Status)
+}
+
+func TestStorageCheck(t *testing.T) {
+	comp := StorageCheck("inmemory", nil)(context.Background())
+	assert.Equal(t, StatusOK, comp.Status)
+
+	comp = StorageCheck("postgres", func() error { return errors.New("connection refused") })(context.Background())
+	assert.Equal(t, StatusFail, comp.Status)
+	assert.Equal(t, "connection refused", comp.Error)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	block := make(chan struct{})
+	defer close(block)
+	comp = StorageCheck("postgres", func() error { <-block; return nil })(ctx)
+	assert.Equal(t, StatusFail, comp.Status)
+	assert.Equal(t, context.DeadlineExceeded.Error(), comp.Error)
+}
+
+func TestShuttingDown(t *testing.T) {
+	c := New()
+	c.AddCheck("storage", StorageCheck("inmemory", nil))
+	done := c.Track("statsd")
+	require.Equal(t, StatusOK, c.Readiness(context.Background()).Status)
+
+	c.SetShuttingDown()
+	report := c.Readiness(context.Background())
+	assert.Equal(t, StatusFail, report.Status)
+	assert.Equal(t, StatusFail, report.Components["shutdown"].Status)
+
+	// Горутины, остановленные при выключении, не считаются упавшими
+	done()
+	assert.Equal(t, StatusOK, c.Liveness().Status)
+}
+
+func TestTrack(t *testing.T) {
+	c := New()
+	done := c.Track("alerting")
+	report := c.Liveness()
+	assert.Equal(t, StatusOK, report.Status)
+	assert.Equal(t, StatusOK, report.Components["goroutine:alerting"].Status)
+
+	done()
+	report = c.Liveness()
+	assert.Equal(t, StatusFail, report.Status)
+	assert.Equal(t, "goroutine stopped", report.Components["goroutine:alerting"].Error)
+	assert.Equal(t, StatusFail, c.Readiness(context.Background()).Status)
+}
+
+func TestMemento(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	c := New()
+	c.now = func() time.Time { return now }
+
+	// Без WatchMemento снимки не проверяются
+	c.ObserveMemento(time.Millisecond, 10, nil)
+	assert.NotContains(t, c.Readiness(context.Background()).Components, "memento")
+
+	c.WatchMemento(time.Minute)
+	now = now.Add(30 * time.Second)
+	comp := c.Readiness(context.Background()).Components["memento"]
+	assert.Equal(t, StatusOK, comp.Status)
+	assert.NotContains(t, comp.Details, "last_success")
+
+	c.ObserveMemento(time.Millisecond, 512, nil)
+	now = now.Add(45 * time.Second)
+	comp = c.Readiness(context.Background()).Components["memento"]
+	assert.Equal(t, StatusOK, comp.Status)
+	assert.Equal(t, int64(512), comp.Details["size_bytes"])
+	assert.Equal(t, "45s", comp.Details["age"])
+
+	c.ObserveMemento(time.Millisecond, 0, errors.New("disk full"))
+	now = now.Add(30 * time.Second)
+	comp = c.Readiness(context.Background()).Components["memento"]
+	assert.Equal(t, StatusFail, comp.Status)
+	assert.Equal(t, errMementoStale.Error(), comp.Error)
+	assert.Equal(t, "disk full", comp.Details["last_error"])
+}
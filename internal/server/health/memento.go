@@ -0,0 +1,61 @@
+package health
+
+import (
+	"errors"
+	"time"
+)
+
+// errMementoStale снимок не сохранялся дольше допустимого
+var errMementoStale = errors.New("memento snapshot is stale")
+
+// mementoState состояние сохранения снимков хранилища в файл
+type mementoState struct {
+	maxAge      time.Duration
+	since       time.Time
+	lastSuccess time.Time
+	lastError   string
+	size        int64
+}
+
+// WatchMemento включает проверку снимков хранилища. Снимок считается устаревшим, если последнее успешное
+// сохранение было раньше, чем maxAge назад. Пока снимок не сохранялся ни разу, отсчет идет от вызова WatchMemento.
+func (c *Checker) WatchMemento(maxAge time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.memento = &mementoState{maxAge: maxAge, since: c.now()}
+}
+
+// ObserveMemento учитывает сохранение снимка хранилища в файл, см. config.MementoObserver
+func (c *Checker) ObserveMemento(_ time.Duration, size int64, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.memento == nil {
+		return
+	}
+	if err != nil {
+		c.memento.lastError = err.Error()
+		return
+	}
+	c.memento.lastSuccess = c.now()
+	c.memento.lastError = ""
+	c.memento.size = size
+}
+
+func (m *mementoState) component(now time.Time) Component {
+	details := map[string]any{"max_age": m.maxAge.String()}
+	last := m.since
+	if !m.lastSuccess.IsZero() {
+		last = m.lastSuccess
+		details["last_success"] = m.lastSuccess.Format(time.RFC3339)
+		details["size_bytes"] = m.size
+	}
+	age := now.Sub(last)
+	details["age"] = age.Round(time.Millisecond).String()
+	if m.lastError != "" {
+		details["last_error"] = m.lastError
+	}
+	if age > m.maxAge {
+		return Fail(errMementoStale, details)
+	}
+	return OK(details)
+}
@@ -0,0 +1,90 @@
+// Пакет identity определяет агента, от которого пришел запрос.
+// Агент идентифицируется по CN клиентского сертификата mTLS, а также по идентификатору
+// ключа подписи, если подпись запроса проверена.
+package identity
+
+import (
+	"context"
+	"crypto/tls"
+	"net/http"
+)
+
+type contextKey struct{}
+
+type keyIDContextKey struct{}
+
+type keyIDHolderContextKey struct{}
+
+// keyIDHolder идентификатор проверенного ключа для обработчиков, стоящих в цепочке до проверки подписи
+type keyIDHolder struct {
+	id       string
+	verified bool
+}
+
+// NewContext возвращает контекст с идентификатором агента
+func NewContext(ctx context.Context, agent string) context.Context {
+	return context.WithValue(ctx, contextKey{}, agent)
+}
+
+// FromContext возвращает идентификатор агента из контекста или пустую строку
+func FromContext(ctx context.Context) string {
+	agent, _ := ctx.Value(contextKey{}).(string)
+	return agent
+}
+
+// NewKeyIDContext возвращает контекст с идентификатором ключа, которым проверена подпись запроса.
+// Идентификатор также записывается в хранилище из NewKeyIDHolderContext, если оно есть в ctx.
+func NewKeyIDContext(ctx context.Context, id string) context.Context {
+	if h, ok := ctx.Value(keyIDHolderContextKey{}).(*keyIDHolder); ok {
+		h.id, h.verified = id, true
+	}
+	return context.WithValue(ctx, keyIDContextKey{}, id)
+}
+
+// NewKeyIDHolderContext возвращает контекст с хранилищем идентификатора проверенного ключа.
+// Подпись проверяется глубже в цепочке обработчиков, и контекст с ее результатом до внешних
+// обработчиков не доходит; через хранилище его видит, например, лог запросов, см. HeldKeyID.
+func NewKeyIDHolderContext(ctx context.Context) context.Context {
+	return context.WithValue(ctx, keyIDHolderContextKey{}, &keyIDHolder{})
+}
+
+// HeldKeyID возвращает идентификатор ключа, записанный NewKeyIDContext в хранилище из NewKeyIDHolderContext,
+// и сообщает, проверена ли подпись
+func HeldKeyID(ctx context.Context) (string, bool) {
+	h, ok := ctx.Value(keyIDHolderContextKey{}).(*keyIDHolder)
+	if !ok {
+		return "", false
+	}
+	return h.id, h.verified
+}
+
+// KeyIDFromContext возвращает идентификатор ключа проверенной подписи или пустую строку.
+// Заголовку с идентификатором ключа без проверки подписи доверять нельзя: его выбирает клиент.
+func KeyIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(keyIDContextKey{}).(string)
+	return id
+}
+
+// VerifiedKeyID возвращает идентификатор ключа проверенной подписи и сообщает, проверена ли подпись.
+// В отличие от KeyIDFromContext отличает подпись ключом с пустым идентификатором от запроса без подписи.
+func VerifiedKeyID(ctx context.Context) (string, bool) {
+	id, ok := ctx.Value(keyIDContextKey{}).(string)
+	return id, ok
+}
+
+// FromTLS возвращает CN проверенного клиентского сертификата
+func FromTLS(state *tls.ConnectionState) string {
+	if state == nil || len(state.VerifiedChains) == 0 || len(state.VerifiedChains[0]) == 0 {
+		return ""
+	}
+	return state.VerifiedChains[0][0].Subject.CommonName
+}
+
+// FromRequest возвращает идентификатор агента из контекста запроса,
+// а если его там нет - из TLS соединения
+func FromRequest(r *http.Request) string {
+	if agent := FromContext(r.Context()); agent != "" {
+		return agent
+	}
+	return FromTLS(r.TLS)
+}
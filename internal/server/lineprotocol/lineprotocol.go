@@ -0,0 +1,204 @@
+// Пакет lineprotocol разбирает строковый протокол InfluxDB в метрики сервера.
+//
+// Строка имеет вид measurement[,tag=value...] field=value[,field=value...] [timestamp].
+// Каждое поле становится отдельной метрикой с именем measurement_field, поле value
+// дает метрику с именем measurement. Теги становятся метками метрики, недопустимые символы в именах
+// тегов заменяются на подчеркивание, см. labels.Sanitize. Метка времени игнорируется.
+//
+// Все числа, в том числе целые (суффиксы i и u), становятся gauge, логические значения - gauge 0 или 1.
+// Целые поля Telegraf - это накопленные итоги, а не приращения, поэтому counter из них
+// прибавлял бы весь итог при каждой отправке. Строковые поля пропускаются, в модели метрик им нет места.
+package lineprotocol
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/Arcadian-Sky/musthave-metrics/internal/labels"
+	"github.com/Arcadian-Sky/musthave-metrics/internal/server/models"
+	"github.com/Arcadian-Sky/musthave-metrics/internal/server/storage"
+)
+
+// LineError ошибка разбора строки. Пачка с такой ошибкой не применяется.
+type LineError struct {
+	Line int // номер строки, начиная с единицы
+	Err  error
+}
+
+func (e *LineError) Error() string {
+	return fmt.Sprintf("line %d: %v", e.Line, e.Err)
+}
+
+func (e *LineError) Unwrap() error {
+	return e.Err
+}
+
+// Parse разбирает тело запроса. Пустые строки и комментарии # пропускаются.
+// Возвращает *LineError для первой некорректной строки.
+func Parse(body []byte) ([]models.Metrics, error) {
+	var metrics []models.Metrics
+	scanner := bufio.NewScanner(bytes.NewReader(body))
+	scanner.Buffer(make([]byte, 64*1024), len(body)+1)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		parsed, err := ParseLine(line)
+		if err != nil {
+			return nil, &LineError{Line: n, Err: err}
+		}
+		metrics = append(metrics, parsed...)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return metrics, nil
+}
+
+// ParseLine разбирает одну строку протокола
+func ParseLine(line string) ([]models.Metrics, error) {
+	sections := split(line, ' ')
+	if len(sections) < 2 || len(sections) > 3 {
+		return nil, errors.New("expected measurement, fields and optional timestamp")
+	}
+
+	series := split(sections[0], ',')
+	measurement := unescape(series[0])
+	if measurement == "" {
+		return nil, errors.New("measurement not provided")
+	}
+	var tags map[string]string
+	if len(series) > 1 {
+		tags = make(map[string]string, len(series)-1)
+		for _, tag := range series[1:] {
+			key, value, ok := cutUnescaped(tag, '=')
+			if !ok || key == "" {
+				return nil, fmt.Errorf("invalid tag %q", tag)
+			}
+			tags[labels.Sanitize(unescape(key))] = unescape(value)
+		}
+	}
+
+	fields := split(sections[1], ',')
+	metrics := make([]models.Metrics, 0, len(fields))
+	for _, field := range fields {
+		key, value, ok := cutUnescaped(field, '=')
+		if !ok || key == "" || value == "" {
+			return nil, fmt.Errorf("invalid field %q", field)
+		}
+		metric := models.Metrics{ID: metricID(measurement, unescape(key)), Labels: tags}
+		skip, err := setValue(&metric, value)
+		if err != nil {
+			return nil, fmt.Errorf("field %q: %w", unescape(key), err)
+		}
+		if !skip {
+			metrics = append(metrics, metric)
+		}
+	}
+	return metrics, nil
+}
+
+// metricID возвращает имя метрики для поля
+func metricID(measurement, field string) string {
+	if field == "value" {
+		return measurement
+	}
+	return measurement + "_" + field
+}
+
+// setValue заполняет значение метрики по значению поля. Для строковых полей возвращает skip.
+func setValue(metric *models.Metrics, value string) (skip bool, err error) {
+	switch {
+	case strings.HasPrefix(value, `"`):
+		return true, nil
+	case strings.HasSuffix(value, "i"):
+		i, err := strconv.ParseInt(strings.TrimSuffix(value, "i"), 10, 64)
+		if err != nil {
+			return false, err
+		}
+		setGauge(metric, float64(i))
+		return false, nil
+	case strings.HasSuffix(value, "u"):
+		u, err := strconv.ParseUint(strings.TrimSuffix(value, "u"), 10, 64)
+		if err != nil {
+			return false, err
+		}
+		setGauge(metric, float64(u))
+		return false, nil
+	}
+	switch value {
+	case "t", "T", "true", "True", "TRUE":
+		setGauge(metric, 1)
+		return false, nil
+	case "f", "F", "false", "False", "FALSE":
+		setGauge(metric, 0)
+		return false, nil
+	}
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return false, err
+	}
+	setGauge(metric, f)
+	return false, nil
+}
+
+func setGauge(metric *models.Metrics, value float64) {
+	metric.MType = string(storage.Gauge)
+	metric.Value = &value
+}
+
+// split делит строку по разделителю sep, пропуская экранированные символы и строки в кавычках
+func split(s string, sep byte) []string {
+	var parts []string
+	start, quoted := 0, false
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '"':
+			quoted = !quoted
+		case sep:
+			if !quoted {
+				parts = append(parts, s[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(parts, s[start:])
+}
+
+// cutUnescaped делит строку по первому неэкранированному символу sep
+func cutUnescaped(s string, sep byte) (string, string, bool) {
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case sep:
+			return s[:i], s[i+1:], true
+		}
+	}
+	return s, "", false
+}
+
+// unescape убирает экранирование запятых, пробелов и знаков равенства
+func unescape(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) {
+			switch s[i+1] {
+			case ',', ' ', '=', '\\':
+				i++
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
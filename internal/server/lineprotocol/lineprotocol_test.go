@@ -0,0 +1,79 @@
+package lineprotocol
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/Arcadian-Sky/musthave-metrics/internal/server/models"
+)
+
+func gauge(id string, v float64, l map[string]string) models.Metrics {
+	return models.Metrics{ID: id, MType: "gauge", Value: &v, Labels: l}
+}
+
+func TestParseLine(t *testing.T) {
+	tests := []struct {
+		name    string
+		line    string
+		want    []models.Metrics
+		wantErr bool
+	}{
+		{
+			name: "fields and tags",
+			line: "cpu,host=a,region=eu usage_idle=97.5,ctx_switches=120i 1700000000000000000",
+			want: []models.Metrics{
+				gauge("cpu_usage_idle", 97.5, map[string]string{"host": "a", "region": "eu"}),
+				gauge("cpu_ctx_switches", 120, map[string]string{"host": "a", "region": "eu"}),
+			},
+		},
+		{
+			name: "value field",
+			line: "temperature value=21.5",
+			want: []models.Metrics{gauge("temperature", 21.5, nil)},
+		},
+		{
+			name: "unsigned, boolean and string fields",
+			line: `proc total=3u,running=t,state="sleeping, idle"`,
+			want: []models.Metrics{gauge("proc_total", 3, nil), gauge("proc_running", 1, nil)},
+		},
+		{
+			name: "escaped characters",
+			line: `disk\ io,path=/var\,log read\=bytes=10i`,
+			want: []models.Metrics{gauge("disk io_read=bytes", 10, map[string]string{"path": "/var,log"})},
+		},
+		{name: "no fields", line: "cpu", wantErr: true},
+		{name: "bad integer", line: "cpu ctx=1.5i", wantErr: true},
+		{name: "bad float", line: "cpu usage=abc", wantErr: true},
+		{
+			name: "invalid tag names",
+			line: "cpu,host-name=a,1zone=b usage=1",
+			want: []models.Metrics{gauge("cpu_usage", 1, map[string]string{"host_name": "a", "_1zone": "b"})},
+		},
+		{name: "tag without value", line: "cpu,host usage=1", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseLine(tt.line)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestParse(t *testing.T) {
+	body := []byte("# telegraf\n\nmem used=10i\nmem free=oops\n")
+	_, err := Parse(body)
+	var lineErr *LineError
+	assert.True(t, errors.As(err, &lineErr))
+	assert.Equal(t, 4, lineErr.Line)
+
+	metrics, err := Parse([]byte("mem used=10i\r\nload value=0.5\n"))
+	assert.NoError(t, err)
+	assert.Equal(t, []models.Metrics{gauge("mem_used", 10, nil), gauge("load", 0.5, nil)}, metrics)
+}
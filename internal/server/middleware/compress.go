@@ -1,57 +1,86 @@
 package middleware
 
 import (
+	"bytes"
 	"compress/gzip"
-	"fmt"
+	"errors"
 	"io"
 	"net/http"
+	"strconv"
 	"strings"
+
+	"github.com/Arcadian-Sky/musthave-metrics/internal/compression"
 )
 
+// GzipMiddleware распаковывает тела запросов в gzip, zstd и brotli и сжимает ответы в gzip.
+// Стоит после DecryptMiddleware: агент сначала сжимает тело, затем шифрует.
+// Распакованное тело больше maxBodySize байт отклоняется с кодом 413, чтобы небольшой сжатый
+// запрос не занял всю память сервера. Значение 0 снимает ограничение.
+//
 // Добавьте поддержку gzip в код сервера и агента. Научите:
 // Агент передавать данные в формате gzip.
 // Сервер опционально принимать запросы в сжатом формате (при наличии соответствующего HTTP-заголовка Content-Encoding).
 // Отдавать сжатый ответ клиенту, который поддерживает обработку сжатых ответов (с HTTP-заголовком Accept-Encoding).
 // Функция сжатия должна работать для контента с типами application/json и text/html.
 
-func GzipMiddleware(h http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// по умолчанию устанавливаем оригинальный http.ResponseWriter как тот,
-		// который будем передавать следующей функции
-		ow := w
-
-		// проверяем, что клиент умеет получать от сервера сжатые данные в формате gzip
-		acceptContentType := r.Header.Get("Accept")
-		acceptEncoding := r.Header.Get("Accept-Encoding")
-		supportsGzip := strings.Contains(acceptEncoding, "gzip")
-		if supportsGzip && (acceptContentType == "application/json" || acceptContentType == "html/text") {
-			// оборачиваем оригинальный http.ResponseWriter новым с поддержкой сжатия
-			cw := newCompressWriter(w)
-			// меняем оригинальный http.ResponseWriter на новый
-			ow = cw
-			// не забываем отправить клиенту все сжатые данные после завершения middleware
-			defer cw.Close()
-		}
-
-		// проверяем, что клиент отправил серверу сжатые данные в формате gzip
-		contentEncoding := r.Header.Get("Content-Encoding")
-		sendsGzip := strings.Contains(contentEncoding, "gzip")
-		if sendsGzip {
-			// оборачиваем тело запроса в io.Reader с поддержкой декомпрессии
-			cr, err := newCompressReader(r.Body)
-			fmt.Printf("err: %v\n", err)
-			if err != nil {
-				w.WriteHeader(http.StatusInternalServerError)
-				return
+func GzipMiddleware(maxBodySize int64) func(http.Handler) http.Handler {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// по умолчанию устанавливаем оригинальный http.ResponseWriter как тот,
+			// который будем передавать следующей функции
+			ow := w
+
+			// проверяем, что клиент умеет получать от сервера сжатые данные в формате gzip
+			acceptContentType := r.Header.Get("Accept")
+			acceptEncoding := r.Header.Get("Accept-Encoding")
+			supportsGzip := strings.Contains(acceptEncoding, "gzip")
+			if supportsGzip && (acceptContentType == "application/json" || acceptContentType == "html/text") {
+				// оборачиваем оригинальный http.ResponseWriter новым с поддержкой сжатия
+				cw := newCompressWriter(w)
+				// меняем оригинальный http.ResponseWriter на новый
+				ow = cw
+				// не забываем отправить клиенту все сжатые данные после завершения middleware
+				defer cw.Close()
+			}
+
+			// проверяем, что клиент отправил серверу сжатые данные: gzip, zstd или brotli
+			contentEncoding := r.Header.Get("Content-Encoding")
+			if contentEncoding != "" && contentEncoding != "identity" {
+				// оборачиваем тело запроса в io.Reader с поддержкой декомпрессии
+				cr, err := newCompressReader(r.Body, contentEncoding)
+				if errors.Is(err, compression.ErrUnsupported) {
+					http.Error(w, err.Error(), http.StatusUnsupportedMediaType)
+					return
+				}
+				if err != nil {
+					http.Error(w, "Ошибка при распаковке данных", http.StatusBadRequest)
+					return
+				}
+				// распаковываем тело целиком, читая не больше maxBodySize байт и еще один сверх предела
+				var decoded io.Reader = cr
+				if maxBodySize > 0 {
+					decoded = io.LimitReader(cr, maxBodySize+1)
+				}
+				body, err := io.ReadAll(decoded)
+				cr.Close()
+				if err != nil {
+					http.Error(w, "Ошибка при распаковке данных", http.StatusBadRequest)
+					return
+				}
+				if maxBodySize > 0 && int64(len(body)) > maxBodySize {
+					http.Error(w, "Распакованное тело запроса больше "+strconv.FormatInt(maxBodySize, 10)+" байт", http.StatusRequestEntityTooLarge)
+					return
+				}
+				// меняем тело запроса на распакованное
+				r.Body = io.NopCloser(bytes.NewReader(body))
+				r.ContentLength = int64(len(body))
+				r.Header.Del("Content-Encoding")
 			}
-			// меняем тело запроса на новое
-			r.Body = cr
-			defer cr.Close()
-		}
-
-		// передаём управление хендлеру
-		h.ServeHTTP(ow, r)
-	})
+
+			// передаём управление хендлеру
+			h.ServeHTTP(ow, r)
+		})
+	}
 }
 
 // compressWriter реализует интерфейс http.ResponseWriter и позволяет прозрачно для сервера
@@ -91,11 +120,11 @@ func (c *compressWriter) Close() error {
 // декомпрессировать получаемые от клиента данные
 type compressReader struct {
 	r  io.ReadCloser
-	zr *gzip.Reader
+	zr io.ReadCloser
 }
 
-func newCompressReader(r io.ReadCloser) (*compressReader, error) {
-	zr, err := gzip.NewReader(r)
+func newCompressReader(r io.ReadCloser, encoding string) (*compressReader, error) {
+	zr, err := compression.NewReader(encoding, r)
 	if err != nil {
 		return nil, err
 	}
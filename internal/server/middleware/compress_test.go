@@ -8,6 +8,11 @@ import (
 	"net/http/httptest"
 	"strings"
 	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Arcadian-Sky/musthave-metrics/internal/compression"
 )
 
 func TestGzipMiddleware(t *testing.T) {
@@ -32,7 +37,7 @@ func TestGzipMiddleware(t *testing.T) {
 	})
 
 	// Применяем Middleware к обработчику и выполняем запрос
-	GzipMiddleware(handler).ServeHTTP(rr, req)
+	GzipMiddleware(0)(handler).ServeHTTP(rr, req)
 
 	// Проверяем статус код ответа
 	if status := rr.Code; status != http.StatusOK {
@@ -66,7 +71,7 @@ func TestGzipMiddlewareWithGzipRequestBody(t *testing.T) {
 	rr := httptest.NewRecorder()
 
 	// Запускаем Middleware
-	GzipMiddleware(handler).ServeHTTP(rr, req)
+	GzipMiddleware(0)(handler).ServeHTTP(rr, req)
 }
 
 func TestWriteHeader(t *testing.T) {
@@ -96,3 +101,71 @@ func compressString(s string) *bytes.Buffer {
 	}
 	return &b
 }
+
+func TestGzipMiddlewareEncodings(t *testing.T) {
+	message := []byte(`[{"id":"Alloc","type":"gauge","value":1.5}]`)
+
+	tests := []struct {
+		name         string
+		encoding     string
+		body         []byte
+		expectedCode int
+	}{
+		{name: "zstd", encoding: compression.Zstd, expectedCode: http.StatusOK},
+		{name: "brotli", encoding: compression.Brotli, expectedCode: http.StatusOK},
+		{name: "gzip", encoding: compression.Gzip, expectedCode: http.StatusOK},
+		{name: "unsupported", encoding: "deflate", body: message, expectedCode: http.StatusUnsupportedMediaType},
+		{name: "corrupted", encoding: compression.Gzip, body: message, expectedCode: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := tt.body
+			if body == nil {
+				var err error
+				body, err = compression.Encode(tt.encoding, message)
+				require.NoError(t, err)
+			}
+
+			var received []byte
+			handler := GzipMiddleware(0)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				received, _ = io.ReadAll(r.Body)
+				assert.Empty(t, r.Header.Get("Content-Encoding"))
+			}))
+			req := httptest.NewRequest(http.MethodPost, "/updates", bytes.NewReader(body))
+			req.Header.Set("Content-Encoding", tt.encoding)
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+
+			assert.Equal(t, tt.expectedCode, rr.Code)
+			if tt.expectedCode == http.StatusOK {
+				assert.Equal(t, message, received)
+			}
+		})
+	}
+}
+
+// TestGzipMiddlewareMaxBodySize проверяет, что распакованное тело больше предела отклоняется с кодом 413
+func TestGzipMiddlewareMaxBodySize(t *testing.T) {
+	message := bytes.Repeat([]byte("0"), 1<<20)
+	for _, encoding := range []string{compression.Gzip, compression.Zstd, compression.Brotli} {
+		t.Run(encoding, func(t *testing.T) {
+			body, err := compression.Encode(encoding, message)
+			require.NoError(t, err)
+			serve := func(maxBodySize int64) int {
+				handler := GzipMiddleware(maxBodySize)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					received, err := io.ReadAll(r.Body)
+					assert.NoError(t, err)
+					assert.Len(t, received, len(message))
+				}))
+				req := httptest.NewRequest(http.MethodPost, "/updates", bytes.NewReader(body))
+				req.Header.Set("Content-Encoding", encoding)
+				rr := httptest.NewRecorder()
+				handler.ServeHTTP(rr, req)
+				return rr.Code
+			}
+
+			assert.Equal(t, http.StatusRequestEntityTooLarge, serve(1024))
+			assert.Equal(t, http.StatusOK, serve(int64(len(message))))
+		})
+	}
+}
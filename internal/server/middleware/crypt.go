@@ -2,36 +2,66 @@ package middleware
 
 import (
 	"bytes"
-	"crypto/rand"
 	"crypto/rsa"
+	"errors"
 	"io"
 	"net/http"
+	"strconv"
 
+	"github.com/Arcadian-Sky/musthave-metrics/internal/envelope"
 	"github.com/Arcadian-Sky/musthave-metrics/internal/server/flags"
 )
 
+// DecryptMiddleware расшифровывает тело запроса приватным ключом сервера.
+// Сеансовый ключ AES-GCM передается в заголовке envelope.KeyHeader.
+// Запросы без заголовка расшифровываются целиком RSA PKCS#1 v1.5, как у агентов предыдущей версии.
+// Запросы GET и HEAD и запросы с пустым телом не расшифровываются: агент их не шифрует,
+// а проверки /healthz, /readyz и сбор /metrics не должны зависеть от ключа шифрования.
+// Зашифрованное тело больше c.MaxBodySize байт отклоняется с кодом 413, иначе предел GzipMiddleware
+// обходился бы шифрованием. Тело, которое не удалось расшифровать, отклоняется с кодом 400:
+// повтор его не исправит.
 func DecryptMiddleware(c flags.InitedFlags) func(http.Handler) http.Handler {
 	return func(h http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == http.MethodGet || r.Method == http.MethodHead || r.ContentLength == 0 {
+				h.ServeHTTP(w, r)
+				return
+			}
 			privateKey, _ := c.GetCryptoKey()
 			if privateKey != nil {
 				// Читаем зашифрованные данные из тела запроса
+				if c.MaxBodySize > 0 {
+					r.Body = http.MaxBytesReader(w, r.Body, int64(c.MaxBodySize))
+				}
 				encryptedData, err := io.ReadAll(r.Body)
+				var maxBytesErr *http.MaxBytesError
+				if errors.As(err, &maxBytesErr) {
+					http.Error(w, "Тело запроса больше "+strconv.FormatInt(maxBytesErr.Limit, 10)+" байт", http.StatusRequestEntityTooLarge)
+					return
+				}
 				if err != nil {
 					http.Error(w, "Ошибка при чтении данных", http.StatusBadRequest)
 					return
 				}
 				defer r.Body.Close()
+				// Тело без Content-Length может оказаться пустым
+				if len(encryptedData) == 0 {
+					r.Body = http.NoBody
+					h.ServeHTTP(w, r)
+					return
+				}
 
 				// Расшифровываем данные
-				decryptedData, err := decryptMessage(encryptedData, privateKey)
+				decryptedData, err := decryptMessage(encryptedData, r.Header.Get(envelope.KeyHeader), privateKey)
 				if err != nil {
-					http.Error(w, "Ошибка при расшифровке данных", http.StatusInternalServerError)
+					http.Error(w, "Ошибка при расшифровке данных", http.StatusBadRequest)
 					return
 				}
 
 				// Подменяем тело запроса на расшифрованные данные
 				r.Body = io.NopCloser(bytes.NewReader(decryptedData))
+				r.ContentLength = int64(len(decryptedData))
+				r.Header.Del(envelope.KeyHeader)
 			}
 
 			// Передаем управление следующему обработчику
@@ -40,6 +70,10 @@ func DecryptMiddleware(c flags.InitedFlags) func(http.Handler) http.Handler {
 	}
 }
 
-func decryptMessage(encryptedMessage []byte, privateKey *rsa.PrivateKey) ([]byte, error) {
-	return rsa.DecryptPKCS1v15(rand.Reader, privateKey, encryptedMessage)
+func decryptMessage(encryptedMessage []byte, keyHeader string, privateKey *rsa.PrivateKey) ([]byte, error) {
+	if keyHeader != "" {
+		return envelope.Decrypt(privateKey, encryptedMessage, keyHeader)
+	}
+	// TODO: убрать после перехода всех агентов на envelope
+	return envelope.DecryptLegacy(privateKey, encryptedMessage)
 }
@@ -0,0 +1,97 @@
+package middleware
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Arcadian-Sky/musthave-metrics/internal/envelope"
+	"github.com/Arcadian-Sky/musthave-metrics/internal/server/flags"
+)
+
+func TestDecryptMiddleware(t *testing.T) {
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	keyPath := filepath.Join(t.TempDir(), "private.pem")
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: flags.RSAPrivateKeyType, Bytes: x509.MarshalPKCS1PrivateKey(privateKey)})
+	require.NoError(t, os.WriteFile(keyPath, keyPEM, 0600))
+
+	message := bytes.Repeat([]byte(`{"id":"Alloc","type":"gauge","value":1.5},`), 100)
+	legacyMessage := []byte(`{"id":"Alloc","type":"gauge","value":1.5}`)
+
+	body, header, err := envelope.Encrypt(&privateKey.PublicKey, message)
+	require.NoError(t, err)
+	legacyBody, err := rsa.EncryptPKCS1v15(rand.Reader, &privateKey.PublicKey, legacyMessage)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name         string
+		body         []byte
+		header       string
+		maxBodySize  int
+		expectedCode int
+		expectedBody []byte
+	}{
+		{name: "envelope", body: body, header: header, expectedCode: http.StatusOK, expectedBody: message},
+		{name: "legacy", body: legacyBody, expectedCode: http.StatusOK, expectedBody: legacyMessage},
+		{name: "corrupted", body: body[1:], header: header, expectedCode: http.StatusBadRequest},
+		{name: "too large", body: body, header: header, maxBodySize: len(body) - 1, expectedCode: http.StatusRequestEntityTooLarge},
+		{name: "at limit", body: body, header: header, maxBodySize: len(body), expectedCode: http.StatusOK, expectedBody: message},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var received []byte
+			handler := DecryptMiddleware(flags.InitedFlags{CryptoKeyPath: keyPath, MaxBodySize: tt.maxBodySize})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				received, _ = io.ReadAll(r.Body)
+				assert.Empty(t, r.Header.Get(envelope.KeyHeader))
+			}))
+
+			req := httptest.NewRequest(http.MethodPost, "/updates", bytes.NewReader(tt.body))
+			if tt.header != "" {
+				req.Header.Set(envelope.KeyHeader, tt.header)
+			}
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+
+			assert.Equal(t, tt.expectedCode, rr.Code)
+			if tt.expectedBody != nil {
+				assert.Equal(t, tt.expectedBody, received)
+			}
+		})
+	}
+}
+
+func TestDecryptMiddlewareSkipsBodiless(t *testing.T) {
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	keyPath := filepath.Join(t.TempDir(), "private.pem")
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: flags.RSAPrivateKeyType, Bytes: x509.MarshalPKCS1PrivateKey(privateKey)})
+	require.NoError(t, os.WriteFile(keyPath, keyPEM, 0600))
+
+	handler := DecryptMiddleware(flags.InitedFlags{CryptoKeyPath: keyPath})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	for _, req := range []*http.Request{
+		httptest.NewRequest(http.MethodGet, "/healthz", nil),
+		httptest.NewRequest(http.MethodHead, "/", nil),
+		httptest.NewRequest(http.MethodPost, "/update/gauge/Alloc/1", nil),
+		// Пустое тело без Content-Length
+		httptest.NewRequest(http.MethodPost, "/update/gauge/Alloc/1", io.NopCloser(bytes.NewReader(nil))),
+	} {
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		assert.Equal(t, http.StatusOK, rr.Code, req.Method+" "+req.URL.Path)
+	}
+}
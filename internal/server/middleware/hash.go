@@ -0,0 +1,74 @@
+package middleware
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+
+	"github.com/Arcadian-Sky/musthave-metrics/internal/keyring"
+	"github.com/Arcadian-Sky/musthave-metrics/internal/server/identity"
+	"github.com/Arcadian-Sky/musthave-metrics/internal/server/replay"
+)
+
+// VerifyHash проверяет подпись keyring.HashHeader запроса ключом из заголовка keyring.KeyIDHeader
+// и отвечает 400, если подпись не совпала или ключ неизвестен. Подпись считается агентом по исходному
+// телу, поэтому middleware стоит после DecryptMiddleware и GzipMiddleware. Запросы без подписи
+// пропускаются, обязательность подписи для записи проверяет RequireHash. Без ключей проверка выключена.
+//
+// Подписываются метод, адрес запроса, время отправки и nonce из заголовков keyring.TimestampHeader
+// и keyring.NonceHeader вместе с телом, см. keyring.Payload. После проверки подписи guard отклоняет запросы вне окна
+// расхождения часов и с повторным nonce. Без guard принимается и подпись только тела от агентов
+// предыдущей версии. Идентификатор ключа проверенной подписи сохраняется в контексте запроса,
+// см. identity.VerifiedKeyID.
+func VerifyHash(keys *keyring.Keyring, guard *replay.Guard) func(http.Handler) http.Handler {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			signature := r.Header.Get(keyring.HashHeader)
+			if signature == "" || keys.Len() == 0 {
+				h.ServeHTTP(w, r)
+				return
+			}
+			body, err := io.ReadAll(r.Body)
+			if err != nil {
+				http.Error(w, "Failed to read request body: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+			r.Body.Close()
+			id := r.Header.Get(keyring.KeyIDHeader)
+			timestamp, nonce := r.Header.Get(keyring.TimestampHeader), r.Header.Get(keyring.NonceHeader)
+			payload := body
+			if timestamp != "" || nonce != "" {
+				payload = keyring.Payload(r.Method, r.URL.RequestURI(), timestamp, nonce, body)
+			}
+			if err := keys.Verify(id, signature, payload); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			if err := guard.Check(id, timestamp, nonce); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+			r = r.WithContext(identity.NewKeyIDContext(r.Context(), id))
+			h.ServeHTTP(w, r)
+		})
+	}
+}
+
+// RequireHash в строгом режиме отклоняет с кодом 400 запросы без заголовка keyring.HashHeader,
+// если на сервере есть ключи подписи. Саму подпись проверяет VerifyHash раньше в цепочке.
+// Ключи проверяются при каждом запросе, поэтому строгий режим включается и ключами, добавленными по SIGHUP.
+func RequireHash(keys *keyring.Keyring, strict bool) func(http.Handler) http.Handler {
+	return func(h http.Handler) http.Handler {
+		if !strict {
+			return h
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get(keyring.HashHeader) == "" && keys.Len() > 0 {
+				http.Error(w, keyring.ErrHashRequired.Error(), http.StatusBadRequest)
+				return
+			}
+			h.ServeHTTP(w, r)
+		})
+	}
+}
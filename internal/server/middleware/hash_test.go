@@ -0,0 +1,132 @@
+package middleware
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Arcadian-Sky/musthave-metrics/internal/keyring"
+	"github.com/Arcadian-Sky/musthave-metrics/internal/server/replay"
+)
+
+func TestVerifyHash(t *testing.T) {
+	keys, err := keyring.New(keyring.Key{Secret: "default"}, keyring.Key{ID: "k1", Secret: "secret"})
+	require.NoError(t, err)
+	body := `{"id":"PollCount","type":"counter","delta":1}`
+	handler := VerifyHash(keys, nil)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Ручка получает тело целиком после проверки
+		data, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		assert.Equal(t, body, string(data))
+		w.WriteHeader(http.StatusOK)
+	}))
+	serve := func(id, signature string) int {
+		req := httptest.NewRequest(http.MethodPost, "/update/", strings.NewReader(body))
+		if id != "" {
+			req.Header.Set(keyring.KeyIDHeader, id)
+		}
+		if signature != "" {
+			req.Header.Set(keyring.HashHeader, signature)
+		}
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr.Code
+	}
+	sign := func(id string) string {
+		signature, err := keys.Sign(id, []byte(body))
+		require.NoError(t, err)
+		return signature
+	}
+
+	assert.Equal(t, http.StatusOK, serve("", sign("")))
+	assert.Equal(t, http.StatusOK, serve("k1", sign("k1")))
+	assert.Equal(t, http.StatusBadRequest, serve("", sign("k1")))
+	assert.Equal(t, http.StatusBadRequest, serve("k2", sign("k1")))
+	// Без подписи запрос пропускается, обязательность проверяет RequireHash
+	assert.Equal(t, http.StatusOK, serve("", ""))
+}
+
+func TestVerifyHashReplay(t *testing.T) {
+	keys, err := keyring.New(keyring.Key{Secret: "secret"})
+	require.NoError(t, err)
+	guard := replay.New(replay.Config{MaxSkew: time.Minute})
+	body := `{"id":"PollCount","type":"counter","delta":1}`
+	handler := VerifyHash(keys, guard)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	serve := func(uri, timestamp, nonce string, signed []byte) *httptest.ResponseRecorder {
+		signature, err := keys.Sign("", signed)
+		require.NoError(t, err)
+		req := httptest.NewRequest(http.MethodPost, uri, strings.NewReader(body))
+		req.Header.Set(keyring.HashHeader, signature)
+		if timestamp != "" {
+			req.Header.Set(keyring.TimestampHeader, timestamp)
+			req.Header.Set(keyring.NonceHeader, nonce)
+		}
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+	payload := func(uri, timestamp, nonce string) []byte {
+		return keyring.Payload(http.MethodPost, uri, timestamp, nonce, []byte(body))
+	}
+
+	assert.Equal(t, http.StatusOK, serve("/update/", now, "n1", payload("/update/", now, "n1")).Code)
+	// Перехваченный запрос повторяется без изменений
+	rr := serve("/update/", now, "n1", payload("/update/", now, "n1"))
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Contains(t, rr.Body.String(), replay.ErrReplayed.Error())
+
+	// Время из подписи нельзя подменить
+	assert.Equal(t, http.StatusBadRequest, serve("/update/", now, "n2", payload("/update/", "1", "n2")).Code)
+
+	// Метрика в пути подписана: подмена адреса не проходит проверку
+	signed := payload("/update/counter/PollCount/1", now, "n4")
+	rr = serve("/update/counter/PollCount/1000", now, "n4", signed)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Contains(t, rr.Body.String(), keyring.ErrHashMismatch.Error())
+	assert.Equal(t, http.StatusOK, serve("/update/counter/PollCount/1", now, "n4", signed).Code)
+
+	old := strconv.FormatInt(time.Now().Add(-time.Hour).Unix(), 10)
+	rr = serve("/update/", old, "n3", payload("/update/", old, "n3"))
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Contains(t, rr.Body.String(), replay.ErrStale.Error())
+
+	// Подпись только тела от агента предыдущей версии при включенной защите не принимается
+	rr = serve("/update/", "", "", []byte(body))
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Contains(t, rr.Body.String(), replay.ErrMissing.Error())
+}
+
+func TestRequireHash(t *testing.T) {
+	keys, err := keyring.New(keyring.Key{Secret: "secret"})
+	require.NoError(t, err)
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	serve := func(h http.Handler, signature string) int {
+		req := httptest.NewRequest(http.MethodPost, "/update/gauge/Alloc/1", nil)
+		if signature != "" {
+			req.Header.Set(keyring.HashHeader, signature)
+		}
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		return rr.Code
+	}
+
+	strict := RequireHash(keys, true)(ok)
+	assert.Equal(t, http.StatusBadRequest, serve(strict, ""))
+	assert.Equal(t, http.StatusOK, serve(strict, "00"))
+
+	assert.Equal(t, http.StatusOK, serve(RequireHash(keys, false)(ok), ""))
+	// Без ключей подпись не требуется
+	assert.Equal(t, http.StatusOK, serve(RequireHash(nil, true)(ok), ""))
+}
@@ -0,0 +1,17 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/Arcadian-Sky/musthave-metrics/internal/server/identity"
+)
+
+// AgentIdentity сохраняет в контексте запроса CN клиентского сертификата агента
+func AgentIdentity(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if agent := identity.FromTLS(r.TLS); agent != "" {
+			r = r.WithContext(identity.NewContext(r.Context(), agent))
+		}
+		h.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,32 @@
+package middleware
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/Arcadian-Sky/musthave-metrics/internal/server/identity"
+)
+
+func TestAgentIdentity(t *testing.T) {
+	var agent string
+	handler := AgentIdentity(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		agent = identity.FromContext(r.Context())
+	}))
+
+	req := httptest.NewRequest(http.MethodPost, "/updates", nil)
+	req.TLS = &tls.ConnectionState{
+		VerifiedChains: [][]*x509.Certificate{{{Subject: pkix.Name{CommonName: "agent-1"}}}},
+	}
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	assert.Equal(t, "agent-1", agent)
+
+	// Без TLS агент не определен
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/updates", nil))
+	assert.Equal(t, "", agent)
+}
@@ -0,0 +1,40 @@
+package middleware
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/Arcadian-Sky/musthave-metrics/internal/server/telemetry"
+)
+
+// unmatchedRoute значение метки route для запросов, не попавших ни в один маршрут
+const unmatchedRoute = "unmatched"
+
+// Instrument учитывает каждый запрос в собственных метриках сервера: число запросов
+// по маршруту, методу и коду ответа и гистограмму длительности.
+// В метку route попадает шаблон маршрута chi, а не путь, чтобы число рядов не зависело от имен метрик.
+func Instrument(m *telemetry.Metrics) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if m == nil {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			lw := &loggingResponseWriter{ResponseWriter: w, responseData: &responseData{}}
+
+			next.ServeHTTP(lw, r)
+
+			status := lw.responseData.status
+			if status == 0 {
+				status = http.StatusOK
+			}
+			route := unmatchedRoute
+			if rctx := chi.RouteContext(r.Context()); rctx != nil && rctx.RoutePattern() != "" {
+				route = rctx.RoutePattern()
+			}
+			m.ObserveRequest(route, r.Method, status, time.Since(start))
+		})
+	}
+}
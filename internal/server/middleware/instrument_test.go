@@ -0,0 +1,49 @@
+package middleware
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Arcadian-Sky/musthave-metrics/internal/server/exposition"
+	"github.com/Arcadian-Sky/musthave-metrics/internal/server/telemetry"
+)
+
+func TestInstrument(t *testing.T) {
+	m := telemetry.New()
+	r := chi.NewRouter()
+	r.Use(Instrument(m))
+	r.Post("/update/{type}/{name}/{value}", func(w http.ResponseWriter, r *http.Request) {})
+	r.Get("/value/{type}/{name}", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	})
+
+	for _, req := range []*http.Request{
+		httptest.NewRequest(http.MethodPost, "/update/gauge/a/1", nil),
+		httptest.NewRequest(http.MethodPost, "/update/gauge/b/2", nil),
+		httptest.NewRequest(http.MethodGet, "/value/gauge/a", nil),
+		httptest.NewRequest(http.MethodGet, "/missing", nil),
+	} {
+		r.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	var buf bytes.Buffer
+	require.NoError(t, m.Write(&buf, exposition.FormatText))
+	out := buf.String()
+	assert.Contains(t, out, `metrics_server_http_requests_total{code="200",method="POST",route="/update/{type}/{name}/{value}"} 2`)
+	assert.Contains(t, out, `metrics_server_http_requests_total{code="404",method="GET",route="/value/{type}/{name}"} 1`)
+	assert.Contains(t, out, `metrics_server_http_requests_total{code="404",method="GET",route="unmatched"} 1`)
+}
+
+func TestInstrumentNil(t *testing.T) {
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	handler := Instrument(nil)(next)
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.Equal(t, http.StatusOK, rr.Code)
+}
@@ -5,6 +5,9 @@ import (
 	"time"
 
 	"go.uber.org/zap"
+
+	"github.com/Arcadian-Sky/musthave-metrics/internal/logging"
+	"github.com/Arcadian-Sky/musthave-metrics/internal/server/identity"
 )
 
 type (
@@ -42,48 +45,44 @@ func (r *loggingResponseWriter) WriteHeader(statusCode int) {
 	}
 }
 
-// getLogger возвращает экземпляр логгера.
-func GetLogger() *zap.Logger {
-	logger, _ := zap.NewDevelopment()
-	return logger
-}
-
-// LoggingMiddleware добавляет логирование для каждого запроса и ответа.
-func Logger(h http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		start := time.Now()
-		// Захватываем все действия, которые выполняются после обработки запроса
-		// и передачи управления следующему обработчику.
-		lw := loggingResponseWriter{
-			ResponseWriter: w, // встраиваем оригинальный http.ResponseWriter
-			responseData: &responseData{
-				status: 0,
-				size:   0,
-				// data:   "",
-			},
-		}
+// Logger пишет в лог каждый запрос: метод, URI, код и размер ответа, длительность, идентификатор агента
+// и идентификатор ключа, которым VerifyHash проверил подпись запроса, см. identity.HeldKeyID.
+// Используется логгер запроса из контекста, см. RequestID, а если его нет - переданный logger.
+func Logger(logger *zap.Logger) func(http.Handler) http.Handler {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			// Захватываем все действия, которые выполняются после обработки запроса
+			// и передачи управления следующему обработчику.
+			lw := loggingResponseWriter{
+				ResponseWriter: w, // встраиваем оригинальный http.ResponseWriter
+				responseData: &responseData{
+					status: 0,
+					size:   0,
+				},
+			}
 
-		h.ServeHTTP(&lw, r)
+			r = r.WithContext(identity.NewKeyIDHolderContext(r.Context()))
+			h.ServeHTTP(&lw, r)
 
-		duration := time.Since(start)
+			duration := time.Since(start)
+			if lw.responseData.status == 0 {
+				// Ручка ничего не записала, net/http ответит 200
+				lw.responseData.status = http.StatusOK
+			}
 
-		logger := GetLogger()
-
-		logger.Info("Request processed",
-			zap.String("uri", r.RequestURI),
-			zap.String("method", r.Method),
-			zap.Int("status", lw.responseData.status),
-			zap.Duration("duration", duration),
-			zap.Int("response_size", lw.responseData.size),
-			// zap.String("response", lw.responseData.data),
-		)
-
-		// logger.Info(
-		// 	"uri", r.RequestURI,
-		// 	"method", r.Method,
-		// 	"status", responseData.status,
-		// 	"duration", duration,
-		// 	"size", responseData.size,
-		// )
-	})
+			fields := []zap.Field{
+				zap.String("uri", r.RequestURI),
+				zap.String("method", r.Method),
+				zap.Int("status", lw.responseData.status),
+				zap.Duration("duration", duration),
+				zap.Int("response_size", lw.responseData.size),
+				zap.String("agent", identity.FromRequest(r)),
+			}
+			if id, verified := identity.HeldKeyID(r.Context()); verified {
+				fields = append(fields, zap.String("key_id", id))
+			}
+			logging.FromContext(r.Context(), logger).Info("Request processed", fields...)
+		})
+	}
 }
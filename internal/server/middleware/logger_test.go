@@ -1,20 +1,21 @@
 package middleware
 
 import (
+	"bytes"
+	"context"
 	"net/http"
 	"net/http/httptest"
 	"testing"
 
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
-)
+	"go.uber.org/zap/zaptest/observer"
 
-func TesLoggingResponseWriterGetLogger(t *testing.T) {
-	logger := GetLogger()
-	if logger == nil {
-		t.Error("Expected non-nil logger, got nil")
-	}
-}
+	"github.com/Arcadian-Sky/musthave-metrics/internal/keyring"
+	"github.com/Arcadian-Sky/musthave-metrics/internal/server/identity"
+)
 
 // Тест для метода Write
 func TesLoggingResponseWriterTWrite(t *testing.T) {
@@ -110,7 +111,7 @@ func TestHandler_Logger(t *testing.T) {
 		_, _ = w.Write([]byte("OK"))
 	})
 
-	loggerHandler := Logger(handler)
+	loggerHandler := Logger(mockLogger)(handler)
 
 	req, err := http.NewRequest("GET", "/test", nil)
 	if err != nil {
@@ -131,3 +132,62 @@ func TestHandler_Logger(t *testing.T) {
 	}
 
 }
+
+func TestLoggerFields(t *testing.T) {
+	tests := []struct {
+		name   string
+		write  func(w http.ResponseWriter)
+		status int
+		size   int64
+	}{
+		{name: "body", write: func(w http.ResponseWriter) { _, _ = w.Write([]byte("OK")) }, status: http.StatusOK, size: 2},
+		{name: "status only", write: func(w http.ResponseWriter) { w.WriteHeader(http.StatusNotFound) }, status: http.StatusNotFound},
+		{name: "nothing written", write: func(w http.ResponseWriter) {}, status: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			core, logs := observer.New(zapcore.InfoLevel)
+			handler := Logger(zap.New(core))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				tt.write(w)
+			}))
+
+			req := httptest.NewRequest(http.MethodPost, "/update/gauge/a/1", nil)
+			req = req.WithContext(identity.NewContext(context.Background(), "agent-1"))
+			handler.ServeHTTP(httptest.NewRecorder(), req)
+
+			require.Equal(t, 1, logs.Len())
+			fields := logs.All()[0].ContextMap()
+			assert.Equal(t, http.MethodPost, fields["method"])
+			assert.Equal(t, "/update/gauge/a/1", fields["uri"])
+			assert.Equal(t, int64(tt.status), fields["status"])
+			assert.Equal(t, tt.size, fields["response_size"])
+			assert.Equal(t, "agent-1", fields["agent"])
+			assert.Contains(t, fields, "duration")
+		})
+	}
+}
+
+// TestLoggerKeyID проверяет, что в лог попадает ключ, которым VerifyHash проверил подпись глубже в цепочке
+func TestLoggerKeyID(t *testing.T) {
+	keys, err := keyring.New(keyring.Key{ID: "k1", Secret: "secret"})
+	require.NoError(t, err)
+	core, logs := observer.New(zapcore.InfoLevel)
+	handler := Logger(zap.New(core))(VerifyHash(keys, nil)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})))
+
+	body := []byte(`[]`)
+	signature, err := keys.Sign("k1", body)
+	require.NoError(t, err)
+	req := httptest.NewRequest(http.MethodPost, "/updates/", bytes.NewReader(body))
+	req.Header.Set(keyring.KeyIDHeader, "k1")
+	req.Header.Set(keyring.HashHeader, signature)
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	// Без подписи идентификатор ключа из заголовка в лог не попадает
+	req = httptest.NewRequest(http.MethodPost, "/updates/", bytes.NewReader(body))
+	req.Header.Set(keyring.KeyIDHeader, "k1")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	require.Equal(t, 2, logs.Len())
+	assert.Equal(t, "k1", logs.All()[0].ContextMap()["key_id"])
+	assert.NotContains(t, logs.All()[1].ContextMap(), "key_id")
+}
@@ -0,0 +1,62 @@
+package middleware
+
+import (
+	"net"
+	"net/http"
+
+	"github.com/Arcadian-Sky/musthave-metrics/internal/server/identity"
+	"github.com/Arcadian-Sky/musthave-metrics/internal/server/ratelimit"
+)
+
+// RateLimit ограничивает частоту запросов каждого агента и при превышении отвечает 429 с Retry-After.
+// Стоит до DecryptMiddleware и GzipMiddleware, чтобы отклоненный запрос не стоил серверу расшифровки
+// и распаковки тела. Подпись в этот момент еще не проверена, поэтому агент определяется только
+// по CN клиентского сертификата или адресу соединения, см. AgentKey.
+// Если лимиты не заданы, запросы не проверяются.
+func RateLimit(l *ratelimit.Limiter) func(http.Handler) http.Handler {
+	return func(h http.Handler) http.Handler {
+		if l == nil {
+			return h
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if err := l.Request(AgentKey(r)); err != nil {
+				ratelimit.WriteError(w, err)
+				return
+			}
+			h.ServeHTTP(w, r)
+		})
+	}
+}
+
+// MetricsQuota передает лимит метрик агента в контекст запроса, где его учитывают ручки записи,
+// см. ratelimit.TakeMetrics. Стоит после VerifyHash, чтобы агент с проверенной подписью
+// учитывался по идентификатору своего ключа. Если лимиты не заданы, метрики не учитываются.
+func MetricsQuota(l *ratelimit.Limiter) func(http.Handler) http.Handler {
+	return func(h http.Handler) http.Handler {
+		if l == nil {
+			return h
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			h.ServeHTTP(w, r.WithContext(ratelimit.NewContext(r.Context(), l, AgentKey(r))))
+		})
+	}
+}
+
+// AgentKey возвращает ключ агента для лимитов по проверенным данным: CN клиентского сертификата,
+// затем идентификатор ключа, которым VerifyHash проверил подпись, а иначе адрес соединения.
+// Заголовки X-Real-IP и keyring.KeyIDHeader сами по себе не используются, потому что их заполняет агент.
+// За балансировщиком или прокси адрес соединения - это адрес прокси, и все агенты без mTLS и подписи
+// делят одни лимиты; в такой схеме агентов нужно различать сертификатами или ключами подписи.
+func AgentKey(r *http.Request) string {
+	if agent := identity.FromRequest(r); agent != "" {
+		return "cn:" + agent
+	}
+	if id := identity.KeyIDFromContext(r.Context()); id != "" {
+		return "key:" + id
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return "ip:" + host
+}
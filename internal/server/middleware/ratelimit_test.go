@@ -0,0 +1,106 @@
+package middleware
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Arcadian-Sky/musthave-metrics/internal/keyring"
+	"github.com/Arcadian-Sky/musthave-metrics/internal/server/identity"
+	"github.com/Arcadian-Sky/musthave-metrics/internal/server/ratelimit"
+)
+
+func TestAgentKey(t *testing.T) {
+	req := httptest.NewRequest(http.MethodPost, "/updates", nil)
+	req.RemoteAddr = "10.0.0.7:51234"
+	req.Header.Set(RealIPHeader, "192.168.1.10")
+	assert.Equal(t, "ip:10.0.0.7", AgentKey(req))
+
+	// Заголовок с идентификатором ключа без проверенной подписи не меняет ключ агента
+	req.Header.Set(keyring.KeyIDHeader, "k1")
+	assert.Equal(t, "ip:10.0.0.7", AgentKey(req))
+	req = req.WithContext(identity.NewKeyIDContext(req.Context(), "k1"))
+	assert.Equal(t, "key:k1", AgentKey(req))
+
+	req = req.WithContext(identity.NewContext(req.Context(), "agent-1"))
+	assert.Equal(t, "cn:agent-1", AgentKey(req))
+}
+
+// TestRateLimitKeyID проверяет, что лимит метрик ведется по ключу, которым VerifyHash проверил подпись,
+// а неподписанный запрос с чужим идентификатором ключа попадает в лимиты своего адреса
+func TestRateLimitKeyID(t *testing.T) {
+	keys, err := keyring.New(keyring.Key{ID: "k1", Secret: "secret"})
+	require.NoError(t, err)
+	limiter := ratelimit.New(ratelimit.Config{MetricsPerSecond: 0.001, MetricsBurst: 1})
+	handler := VerifyHash(keys, nil)(MetricsQuota(limiter)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := ratelimit.TakeMetrics(r.Context(), 1); err != nil {
+			ratelimit.WriteError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	})))
+	serve := func(remoteAddr string, signed bool) int {
+		body := []byte(`[]`)
+		req := httptest.NewRequest(http.MethodPost, "/updates/", bytes.NewReader(body))
+		req.RemoteAddr = remoteAddr
+		req.Header.Set(keyring.KeyIDHeader, "k1")
+		if signed {
+			signature, err := keys.Sign("k1", body)
+			require.NoError(t, err)
+			req.Header.Set(keyring.HashHeader, signature)
+		}
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr.Code
+	}
+
+	assert.Equal(t, http.StatusOK, serve("10.0.0.1:1000", true))
+	// Тот же ключ с другого адреса делит лимит
+	assert.Equal(t, http.StatusTooManyRequests, serve("10.0.0.2:1000", true))
+	// Без подписи заголовок HashKeyID не выбирает лимиты ключа k1
+	assert.Equal(t, http.StatusOK, serve("10.0.0.3:1000", false))
+}
+
+func TestRateLimit(t *testing.T) {
+	limiter := ratelimit.New(ratelimit.Config{RequestsPerSecond: 0.001, RequestsBurst: 2, MetricsPerSecond: 0.001, MetricsBurst: 1})
+	handler := RateLimit(limiter)(MetricsQuota(limiter)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := ratelimit.TakeMetrics(r.Context(), 1); err != nil {
+			ratelimit.WriteError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	})))
+	serve := func(remoteAddr string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/updates", nil)
+		req.RemoteAddr = remoteAddr
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	assert.Equal(t, http.StatusOK, serve("10.0.0.1:1000").Code)
+	// Лимит метрик исчерпан первым запросом
+	rr := serve("10.0.0.1:1001")
+	assert.Equal(t, http.StatusTooManyRequests, rr.Code)
+	assert.NotEmpty(t, rr.Header().Get("Retry-After"))
+	// Лимит запросов исчерпан
+	assert.Equal(t, http.StatusTooManyRequests, serve("10.0.0.1:1002").Code)
+	// Другой агент не затронут
+	assert.Equal(t, http.StatusOK, serve("10.0.0.2:1000").Code)
+}
+
+func TestRateLimitDisabled(t *testing.T) {
+	handler := RateLimit(nil)(MetricsQuota(nil)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.NoError(t, ratelimit.TakeMetrics(r.Context(), 1000))
+		w.WriteHeader(http.StatusOK)
+	})))
+	for i := 0; i < 3; i++ {
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/updates", nil))
+		assert.Equal(t, http.StatusOK, rr.Code)
+	}
+}
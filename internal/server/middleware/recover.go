@@ -0,0 +1,37 @@
+package middleware
+
+import (
+	"net/http"
+	"runtime/debug"
+
+	"go.uber.org/zap"
+
+	"github.com/Arcadian-Sky/musthave-metrics/internal/logging"
+)
+
+// Recoverer перехватывает панику в обработчике, пишет ее в лог со стеком и отвечает 500,
+// чтобы некорректный запрос не завершал сервер.
+// Паника http.ErrAbortHandler пробрасывается дальше, ей net/http обрывает соединение.
+func Recoverer(logger *zap.Logger) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			defer func() {
+				rec := recover()
+				if rec == nil {
+					return
+				}
+				if rec == http.ErrAbortHandler {
+					panic(rec)
+				}
+				logging.FromContext(r.Context(), logger).Error("Panic in handler",
+					zap.Any("panic", rec),
+					zap.String("method", r.Method),
+					zap.String("uri", r.RequestURI),
+					zap.ByteString("stack", debug.Stack()),
+				)
+				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			}()
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,41 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestRecoverer(t *testing.T) {
+	core, logs := observer.New(zapcore.ErrorLevel)
+	handler := Recoverer(zap.New(core))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var m map[string]int
+		m["boom"]++
+	}))
+
+	rr := httptest.NewRecorder()
+	require.NotPanics(t, func() {
+		handler.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/update/", nil))
+	})
+
+	assert.Equal(t, http.StatusInternalServerError, rr.Code)
+	require.Equal(t, 1, logs.Len())
+	fields := logs.All()[0].ContextMap()
+	assert.Equal(t, "/update/", fields["uri"])
+	assert.Contains(t, fields["stack"], "recover_test.go")
+}
+
+func TestRecovererAbortHandler(t *testing.T) {
+	handler := Recoverer(zap.NewNop())(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic(http.ErrAbortHandler)
+	}))
+	assert.PanicsWithValue(t, http.ErrAbortHandler, func() {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	})
+}
@@ -0,0 +1,45 @@
+package middleware
+
+import (
+	"net/http"
+
+	"go.uber.org/zap"
+
+	"github.com/Arcadian-Sky/musthave-metrics/internal/logging"
+)
+
+// RequestIDHeader заголовок с идентификатором запроса
+const RequestIDHeader = "X-Request-ID"
+
+// maxRequestIDLen предельная длина идентификатора запроса из заголовка
+const maxRequestIDLen = 128
+
+// RequestID берет идентификатор запроса из заголовка X-Request-ID или создает новый,
+// возвращает его в том же заголовке ответа и кладет в контекст логгер, который пишет идентификатор в каждую строку.
+// Логгер запроса возвращает logging.FromContext.
+func RequestID(logger *zap.Logger) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			id := r.Header.Get(RequestIDHeader)
+			if !validRequestID(id) {
+				id = logging.NewRequestID()
+			}
+			w.Header().Set(RequestIDHeader, id)
+			next.ServeHTTP(w, r.WithContext(logging.WithRequestID(r.Context(), logger, id)))
+		})
+	}
+}
+
+// validRequestID проверяет, что идентификатор из заголовка непустой, не слишком длинный
+// и состоит из видимых символов ASCII, чтобы его нельзя было использовать для подделки строк лога
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLen {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] <= ' ' || id[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,57 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+
+	"github.com/Arcadian-Sky/musthave-metrics/internal/logging"
+)
+
+func TestRequestID(t *testing.T) {
+	tests := []struct {
+		name   string
+		header string
+		keep   bool
+	}{
+		{name: "propagated", header: "req-42", keep: true},
+		{name: "generated", header: ""},
+		{name: "with spaces", header: "a b"},
+		{name: "with newline", header: "a\nfake line"},
+		{name: "too long", header: strings.Repeat("x", maxRequestIDLen+1)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			core, logs := observer.New(zapcore.InfoLevel)
+			var id string
+			handler := RequestID(zap.New(core))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				id = logging.RequestID(r.Context())
+				logging.FromContext(r.Context(), nil).Info("handled")
+			}))
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.header != "" {
+				req.Header.Set(RequestIDHeader, tt.header)
+			}
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+
+			require.NotEmpty(t, id)
+			assert.Equal(t, id, rr.Header().Get(RequestIDHeader))
+			if tt.keep {
+				assert.Equal(t, tt.header, id)
+			} else {
+				assert.NotEqual(t, tt.header, id)
+			}
+			require.Equal(t, 1, logs.Len())
+			assert.Equal(t, id, logs.All()[0].ContextMap()[logging.RequestIDField])
+		})
+	}
+}
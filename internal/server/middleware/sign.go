@@ -0,0 +1,59 @@
+package middleware
+
+import (
+	"bytes"
+	"net/http"
+
+	"github.com/Arcadian-Sky/musthave-metrics/internal/keyring"
+	"github.com/Arcadian-Sky/musthave-metrics/internal/server/identity"
+)
+
+// SignResponse подписывает тело ответа вместе с nonce запроса из заголовка keyring.NonceHeader,
+// см. keyring.ResponsePayload, ключом, которым VerifyHash проверил подпись запроса, см. identity.VerifiedKeyID.
+// Подпись передается в заголовке keyring.HashHeader, идентификатор ключа - в keyring.KeyIDHeader.
+// Ответ на запрос без проверенной подписи не подписывается: иначе любой клиент мог бы получить подпись
+// сервера, указав идентификатор ключа в заголовке. Стоит после VerifyHash и GzipMiddleware,
+// чтобы подпись считалась по несжатому телу.
+//
+// Подписываемый ответ целиком собирается в памяти.
+func SignResponse(keys *keyring.Keyring) func(http.Handler) http.Handler {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			id, verified := identity.VerifiedKeyID(r.Context())
+			if !verified {
+				h.ServeHTTP(w, r)
+				return
+			}
+			sw := &signingResponseWriter{ResponseWriter: w}
+			h.ServeHTTP(sw, r)
+
+			body := sw.body.Bytes()
+			if signature, err := keys.Sign(id, keyring.ResponsePayload(r.Header.Get(keyring.NonceHeader), body)); err == nil {
+				w.Header().Set(keyring.HashHeader, signature)
+				w.Header().Set(keyring.KeyIDHeader, id)
+			}
+			if sw.status == 0 {
+				sw.status = http.StatusOK
+			}
+			w.WriteHeader(sw.status)
+			_, _ = w.Write(body)
+		})
+	}
+}
+
+// signingResponseWriter задерживает статус и тело ответа до подсчета подписи
+type signingResponseWriter struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (s *signingResponseWriter) WriteHeader(statusCode int) {
+	if s.status == 0 {
+		s.status = statusCode
+	}
+}
+
+func (s *signingResponseWriter) Write(b []byte) (int, error) {
+	return s.body.Write(b)
+}
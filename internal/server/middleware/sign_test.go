@@ -0,0 +1,69 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Arcadian-Sky/musthave-metrics/internal/keyring"
+	"github.com/Arcadian-Sky/musthave-metrics/internal/server/identity"
+)
+
+func TestSignResponse(t *testing.T) {
+	keys, err := keyring.New(keyring.Key{Secret: "default"}, keyring.Key{ID: "k1", Secret: "secret"})
+	require.NoError(t, err)
+	handler := SignResponse(keys)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+		_, _ = w.Write([]byte(`{"status":"ok"}`))
+	}))
+	serve := func(id, nonce string, verified bool) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/update/", nil)
+		if nonce != "" {
+			req.Header.Set(keyring.NonceHeader, nonce)
+		}
+		if id != "" {
+			req.Header.Set(keyring.KeyIDHeader, id)
+		}
+		if verified {
+			req = req.WithContext(identity.NewKeyIDContext(req.Context(), id))
+		}
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	for _, id := range []string{"", "k1"} {
+		rr := serve(id, "n1", true)
+		assert.Equal(t, http.StatusCreated, rr.Code)
+		assert.Equal(t, `{"status":"ok"}`, rr.Body.String())
+		assert.Equal(t, id, rr.Header().Get(keyring.KeyIDHeader))
+		assert.NoError(t, keys.Verify(id, rr.Header().Get(keyring.HashHeader), keyring.ResponsePayload("n1", rr.Body.Bytes())))
+		assert.ErrorIs(t, keys.Verify(id, rr.Header().Get(keyring.HashHeader), rr.Body.Bytes()), keyring.ErrHashMismatch, "подпись связана с nonce запроса")
+	}
+
+	// Запрос без nonce от агента предыдущей версии: подписывается только тело
+	rr := serve("", "", true)
+	assert.NoError(t, keys.Verify("", rr.Header().Get(keyring.HashHeader), rr.Body.Bytes()))
+
+	// Подпись запроса не проверена: ответ не подписывается, даже если ключ из заголовка есть на сервере
+	for _, id := range []string{"", "k1"} {
+		rr = serve(id, "n1", false)
+		assert.Equal(t, http.StatusCreated, rr.Code)
+		assert.Equal(t, `{"status":"ok"}`, rr.Body.String())
+		assert.Empty(t, rr.Header().Get(keyring.HashHeader))
+		assert.Empty(t, rr.Header().Get(keyring.KeyIDHeader))
+	}
+}
+
+func TestSignResponseWithoutKeys(t *testing.T) {
+	handler := SignResponse(nil)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("ok"))
+	}))
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Empty(t, rr.Header().Get(keyring.HashHeader))
+}
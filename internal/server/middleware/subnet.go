@@ -0,0 +1,28 @@
+package middleware
+
+import (
+	"net"
+	"net/http"
+)
+
+// RealIPHeader заголовок с адресом агента, который заполняет сам агент
+const RealIPHeader = "X-Real-IP"
+
+// TrustedSubnet пропускает запросы только от агентов из доверенной подсети.
+// Адрес агента берется из заголовка X-Real-IP, запросы без заголовка или с адресом
+// вне подсети отклоняются с кодом 403. Если подсеть не задана, проверка выключена.
+func TrustedSubnet(subnet *net.IPNet) func(http.Handler) http.Handler {
+	return func(h http.Handler) http.Handler {
+		if subnet == nil {
+			return h
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ip := net.ParseIP(r.Header.Get(RealIPHeader))
+			if ip == nil || !subnet.Contains(ip) {
+				http.Error(w, "Forbidden", http.StatusForbidden)
+				return
+			}
+			h.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,43 @@
+package middleware
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTrustedSubnet(t *testing.T) {
+	_, subnet, err := net.ParseCIDR("192.168.1.0/24")
+	assert.NoError(t, err)
+
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	tests := []struct {
+		name         string
+		subnet       *net.IPNet
+		realIP       string
+		expectedCode int
+	}{
+		{name: "InSubnet", subnet: subnet, realIP: "192.168.1.10", expectedCode: http.StatusOK},
+		{name: "OutOfSubnet", subnet: subnet, realIP: "10.0.0.1", expectedCode: http.StatusForbidden},
+		{name: "NoHeader", subnet: subnet, realIP: "", expectedCode: http.StatusForbidden},
+		{name: "InvalidHeader", subnet: subnet, realIP: "not-an-ip", expectedCode: http.StatusForbidden},
+		{name: "Disabled", subnet: nil, realIP: "", expectedCode: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/updates", nil)
+			if tt.realIP != "" {
+				req.Header.Set(RealIPHeader, tt.realIP)
+			}
+			rr := httptest.NewRecorder()
+			TrustedSubnet(tt.subnet)(next).ServeHTTP(rr, req)
+			assert.Equal(t, tt.expectedCode, rr.Code)
+		})
+	}
+}
@@ -1,13 +1,31 @@
 package models
 
+import "time"
+
 // В теле ответа отправляйте JSON той же структуры с актуальным (изменённым) значением Value(/Delta).
 // В теле запроса должен быть описанный выше JSON с заполненными полями ID и MType
 // Metrics структура для передачи метрик.
 // @Summary Метрики
 // @Description Структура для передачи метрик
 type Metrics struct {
-	ID    string   `json:"id"`              // имя метрики
-	MType string   `json:"type"`            // параметр, принимающий значение gauge или counter
-	Delta *int64   `json:"delta,omitempty"` // значение метрики в случае передачи counter
-	Value *float64 `json:"value,omitempty"` // значение метрики в случае передачи gauge
+	ID     string            `json:"id"`               // имя метрики
+	MType  string            `json:"type"`             // параметр, принимающий значение gauge или counter
+	Delta  *int64            `json:"delta,omitempty"`  // значение метрики в случае передачи counter
+	Value  *float64          `json:"value,omitempty"`  // значение метрики в случае передачи gauge
+	Labels map[string]string `json:"labels,omitempty"` // метки метрики, например host и env
+}
+
+// HistoryPoint точка временного ряда метрики
+type HistoryPoint struct {
+	Time  time.Time `json:"time"`  // момент записи значения
+	Value float64   `json:"value"` // значение gauge или накопленное значение counter
+}
+
+// History временной ряд метрики, возвращаемый ручкой /history
+type History struct {
+	ID     string            `json:"id"`               // имя метрики
+	MType  string            `json:"type"`             // тип метрики
+	Labels map[string]string `json:"labels,omitempty"` // метки ряда
+	Step   string            `json:"step"`             // шаг прореживания
+	Points []HistoryPoint    `json:"points"`           // точки ряда в порядке возрастания времени
 }
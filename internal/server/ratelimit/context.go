@@ -0,0 +1,46 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+)
+
+type contextKey struct{}
+
+// quota лимиты агента, от которого пришел запрос
+type quota struct {
+	limiter *Limiter
+	agent   string
+}
+
+// NewContext возвращает контекст, в котором метрики запроса учитываются в лимитах агента agent
+func NewContext(ctx context.Context, l *Limiter, agent string) context.Context {
+	if l == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, contextKey{}, quota{limiter: l, agent: agent})
+}
+
+// TakeMetrics учитывает n метрик в лимитах агента из контекста запроса.
+// Если лимиты в контексте не заданы, ничего не проверяет.
+func TakeMetrics(ctx context.Context, n int) error {
+	q, ok := ctx.Value(contextKey{}).(quota)
+	if !ok {
+		return nil
+	}
+	return q.limiter.Metrics(q.agent, n)
+}
+
+// WriteError отвечает на превышение лимита: 429 с заголовком Retry-After для *LimitError
+// и 413 для ErrBatchTooLarge
+func WriteError(w http.ResponseWriter, err error) {
+	var limitErr *LimitError
+	if errors.As(err, &limitErr) {
+		w.Header().Set("Retry-After", strconv.Itoa(limitErr.RetryAfterSeconds()))
+		http.Error(w, err.Error(), http.StatusTooManyRequests)
+		return
+	}
+	http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+}
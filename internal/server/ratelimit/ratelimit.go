@@ -0,0 +1,193 @@
+// Пакет ratelimit ограничивает частоту записи метрик каждым агентом.
+//
+// Для каждого агента ведутся два token bucket: запросов в секунду и метрик в секунду.
+// Запрос сверх лимита отклоняется сразу, без ожидания, а клиенту сообщается, через сколько
+// повторить попытку. Так один агент не может занять все соединения с базой.
+// Агенты, не присылавшие запросов дольше IdleTimeout, забываются.
+package ratelimit
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+
+	"github.com/Arcadian-Sky/musthave-metrics/internal/server/telemetry"
+)
+
+// DefaultIdleTimeout время, после которого лимиты неактивного агента удаляются
+const DefaultIdleTimeout = 10 * time.Minute
+
+// Виды лимитов
+const (
+	KindRequests = "requests"
+	KindMetrics  = "metrics"
+)
+
+// ErrBatchTooLarge пачка метрик больше, чем разрешено агенту за раз, и не будет принята никогда
+var ErrBatchTooLarge = errors.New("batch exceeds metrics burst")
+
+// LimitError лимит агента исчерпан, повторить запрос можно через RetryAfter
+type LimitError struct {
+	Kind       string
+	RetryAfter time.Duration
+}
+
+func (e *LimitError) Error() string {
+	return fmt.Sprintf("%s rate limit exceeded, retry after %s", e.Kind, e.RetryAfter)
+}
+
+// RetryAfterSeconds возвращает RetryAfter в целых секундах с округлением вверх для заголовка Retry-After
+func (e *LimitError) RetryAfterSeconds() int {
+	return int(math.Ceil(e.RetryAfter.Seconds()))
+}
+
+// Config лимиты одного агента. Нулевая частота выключает соответствующий лимит.
+// Нулевой burst равен частоте, но не меньше единицы.
+type Config struct {
+	RequestsPerSecond float64
+	RequestsBurst     int
+	MetricsPerSecond  float64
+	MetricsBurst      int
+	IdleTimeout       time.Duration
+}
+
+// Enabled сообщает, задан ли хотя бы один лимит
+func (c Config) Enabled() bool {
+	return c.RequestsPerSecond > 0 || c.MetricsPerSecond > 0
+}
+
+// buckets лимиты одного агента
+type buckets struct {
+	requests *rate.Limiter
+	metrics  *rate.Limiter
+	seen     time.Time
+}
+
+// Limiter лимиты всех агентов. Методы безопасны для nil, тогда лимиты не проверяются.
+type Limiter struct {
+	cfg Config
+	now func() time.Time
+
+	mu        sync.Mutex
+	agents    map[string]*buckets
+	lastSweep time.Time
+
+	allowed  *telemetry.Counter
+	rejected *telemetry.Counter
+}
+
+// New создает лимиты агентов. Если ни один лимит не задан, возвращает nil.
+func New(cfg Config) *Limiter {
+	if !cfg.Enabled() {
+		return nil
+	}
+	if cfg.RequestsBurst <= 0 {
+		cfg.RequestsBurst = max(int(math.Ceil(cfg.RequestsPerSecond)), 1)
+	}
+	if cfg.MetricsBurst <= 0 {
+		cfg.MetricsBurst = max(int(math.Ceil(cfg.MetricsPerSecond)), 1)
+	}
+	if cfg.IdleTimeout <= 0 {
+		cfg.IdleTimeout = DefaultIdleTimeout
+	}
+	return &Limiter{
+		cfg:       cfg,
+		now:       time.Now,
+		agents:    make(map[string]*buckets),
+		lastSweep: time.Now(),
+	}
+}
+
+// SetRegistry регистрирует метрики лимитов: принятые и отклоненные запросы и метрики по виду лимита
+// и число агентов, для которых ведутся лимиты
+func (l *Limiter) SetRegistry(reg *telemetry.Registry) {
+	if l == nil || reg == nil {
+		return
+	}
+	l.allowed = reg.Counter(telemetry.Namespace+"ratelimit_allowed",
+		"Requests and metrics admitted by per-agent rate limits.", "kind")
+	l.rejected = reg.Counter(telemetry.Namespace+"ratelimit_rejected",
+		"Requests and metrics rejected by per-agent rate limits.", "kind")
+	agents := reg.Gauge(telemetry.Namespace+"ratelimit_agents",
+		"Agents with active rate limit buckets.")
+	reg.OnCollect(func() {
+		l.mu.Lock()
+		n := len(l.agents)
+		l.mu.Unlock()
+		agents.Set(float64(n))
+	})
+}
+
+// Request учитывает запрос агента agent. Возвращает *LimitError, если лимит запросов исчерпан.
+func (l *Limiter) Request(agent string) error {
+	if l == nil || l.cfg.RequestsPerSecond <= 0 {
+		return nil
+	}
+	return l.take(agent, KindRequests, 1)
+}
+
+// Metrics учитывает n метрик агента agent. Возвращает *LimitError, если лимит метрик исчерпан,
+// и ErrBatchTooLarge, если n больше MetricsBurst.
+func (l *Limiter) Metrics(agent string, n int) error {
+	if l == nil || l.cfg.MetricsPerSecond <= 0 || n <= 0 {
+		return nil
+	}
+	return l.take(agent, KindMetrics, n)
+}
+
+func (l *Limiter) take(agent, kind string, n int) error {
+	now := l.now()
+	l.mu.Lock()
+	b := l.bucketsFor(agent, now)
+	l.mu.Unlock()
+
+	bucket := b.requests
+	if kind == KindMetrics {
+		bucket = b.metrics
+	}
+	r := bucket.ReserveN(now, n)
+	if !r.OK() {
+		l.count(l.rejected, kind, n)
+		return ErrBatchTooLarge
+	}
+	if delay := r.DelayFrom(now); delay > 0 {
+		// Токены не тратятся на отклоненный запрос
+		r.CancelAt(now)
+		l.count(l.rejected, kind, n)
+		return &LimitError{Kind: kind, RetryAfter: delay}
+	}
+	l.count(l.allowed, kind, n)
+	return nil
+}
+
+// bucketsFor возвращает лимиты агента, создавая их при первом запросе. Вызывается под блокировкой.
+func (l *Limiter) bucketsFor(agent string, now time.Time) *buckets {
+	if now.Sub(l.lastSweep) >= l.cfg.IdleTimeout {
+		for name, b := range l.agents {
+			if now.Sub(b.seen) >= l.cfg.IdleTimeout {
+				delete(l.agents, name)
+			}
+		}
+		l.lastSweep = now
+	}
+	b, ok := l.agents[agent]
+	if !ok {
+		b = &buckets{
+			requests: rate.NewLimiter(rate.Limit(l.cfg.RequestsPerSecond), l.cfg.RequestsBurst),
+			metrics:  rate.NewLimiter(rate.Limit(l.cfg.MetricsPerSecond), l.cfg.MetricsBurst),
+		}
+		l.agents[agent] = b
+	}
+	b.seen = now
+	return b
+}
+
+func (l *Limiter) count(c *telemetry.Counter, kind string, n int) {
+	if c != nil {
+		c.Add(float64(n), kind)
+	}
+}
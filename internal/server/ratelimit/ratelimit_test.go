@@ -0,0 +1,133 @@
+package ratelimit
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Arcadian-Sky/musthave-metrics/internal/server/exposition"
+	"github.com/Arcadian-Sky/musthave-metrics/internal/server/telemetry"
+)
+
+func newTestLimiter(cfg Config) (*Limiter, *time.Time) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	l := New(cfg)
+	l.now = func() time.Time { return now }
+	l.lastSweep = now
+	return l, &now
+}
+
+func TestNewDisabled(t *testing.T) {
+	l := New(Config{})
+	assert.Nil(t, l)
+	assert.NoError(t, l.Request("agent"))
+	assert.NoError(t, l.Metrics("agent", 1000))
+	assert.NotPanics(t, func() { l.SetRegistry(telemetry.NewRegistry()) })
+}
+
+func TestRequest(t *testing.T) {
+	l, now := newTestLimiter(Config{RequestsPerSecond: 2, RequestsBurst: 2})
+
+	require.NoError(t, l.Request("a"))
+	require.NoError(t, l.Request("a"))
+	err := l.Request("a")
+	var limitErr *LimitError
+	require.ErrorAs(t, err, &limitErr)
+	assert.Equal(t, KindRequests, limitErr.Kind)
+	assert.Equal(t, 500*time.Millisecond, limitErr.RetryAfter)
+	assert.Equal(t, 1, limitErr.RetryAfterSeconds())
+
+	// Лимиты агентов независимы
+	assert.NoError(t, l.Request("b"))
+
+	*now = now.Add(500 * time.Millisecond)
+	assert.NoError(t, l.Request("a"))
+	// Лимит метрик не задан
+	assert.NoError(t, l.Metrics("a", 1000))
+}
+
+func TestMetrics(t *testing.T) {
+	l, now := newTestLimiter(Config{MetricsPerSecond: 10, MetricsBurst: 20})
+
+	assert.ErrorIs(t, l.Metrics("a", 21), ErrBatchTooLarge)
+	require.NoError(t, l.Metrics("a", 15))
+
+	// Отклоненная пачка не тратит токены
+	var limitErr *LimitError
+	require.ErrorAs(t, l.Metrics("a", 10), &limitErr)
+	assert.Equal(t, 500*time.Millisecond, limitErr.RetryAfter)
+	require.NoError(t, l.Metrics("a", 5))
+
+	*now = now.Add(time.Second)
+	assert.NoError(t, l.Metrics("a", 10))
+}
+
+func TestDefaultBurstAndSweep(t *testing.T) {
+	l, now := newTestLimiter(Config{RequestsPerSecond: 0.5, MetricsPerSecond: 100, IdleTimeout: time.Minute})
+	assert.Equal(t, 1, l.cfg.RequestsBurst)
+	assert.Equal(t, 100, l.cfg.MetricsBurst)
+
+	require.NoError(t, l.Request("a"))
+	require.NoError(t, l.Request("b"))
+	*now = now.Add(30 * time.Second)
+	require.NoError(t, l.Request("b"))
+	*now = now.Add(40 * time.Second)
+	require.NoError(t, l.Request("c"))
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	assert.NotContains(t, l.agents, "a")
+	assert.Contains(t, l.agents, "b")
+	assert.Contains(t, l.agents, "c")
+}
+
+func TestSetRegistry(t *testing.T) {
+	l, _ := newTestLimiter(Config{RequestsPerSecond: 1, MetricsPerSecond: 5})
+	reg := telemetry.NewRegistry()
+	l.SetRegistry(reg)
+
+	require.NoError(t, l.Request("a"))
+	assert.Error(t, l.Request("a"))
+	require.NoError(t, l.Metrics("a", 3))
+	assert.Error(t, l.Metrics("a", 3))
+
+	var buf bytes.Buffer
+	require.NoError(t, reg.Write(&buf, exposition.FormatText))
+	for _, want := range []string{
+		`metrics_server_ratelimit_allowed_total{kind="requests"} 1`,
+		`metrics_server_ratelimit_rejected_total{kind="requests"} 1`,
+		`metrics_server_ratelimit_allowed_total{kind="metrics"} 3`,
+		`metrics_server_ratelimit_rejected_total{kind="metrics"} 3`,
+		`metrics_server_ratelimit_agents 1`,
+	} {
+		assert.Contains(t, buf.String(), want+"\n")
+	}
+}
+
+func TestTakeMetrics(t *testing.T) {
+	assert.NoError(t, TakeMetrics(context.Background(), 100))
+
+	l, _ := newTestLimiter(Config{MetricsPerSecond: 1, MetricsBurst: 2})
+	ctx := NewContext(context.Background(), l, "a")
+	assert.NoError(t, TakeMetrics(ctx, 2))
+	assert.Error(t, TakeMetrics(ctx, 1))
+	assert.NoError(t, TakeMetrics(NewContext(context.Background(), l, "b"), 1))
+}
+
+func TestWriteError(t *testing.T) {
+	rr := httptest.NewRecorder()
+	WriteError(rr, &LimitError{Kind: KindMetrics, RetryAfter: 1500 * time.Millisecond})
+	assert.Equal(t, http.StatusTooManyRequests, rr.Code)
+	assert.Equal(t, "2", rr.Header().Get("Retry-After"))
+
+	rr = httptest.NewRecorder()
+	WriteError(rr, ErrBatchTooLarge)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, rr.Code)
+	assert.Empty(t, rr.Header().Get("Retry-After"))
+}
@@ -0,0 +1,98 @@
+// Пакет replay защищает подписанные запросы агентов от повторной отправки.
+//
+// Агент подписывает вместе с методом, адресом и телом время отправки и случайный nonce, см. keyring.Payload.
+// Запрос принимается, если его время отличается от времени сервера не больше чем на MaxSkew
+// и nonce еще не встречался. Перехваченный запрос можно повторить только в пределах окна,
+// а в окне его отклонит кеш nonce. Без этой проверки повтор запроса /update с counter
+// каждый раз увеличивал бы счетчик.
+//
+// Кеш nonce ограничен CacheSize записями, при переполнении вытесняются самые старые.
+// Размер нужно выбирать с запасом на число подписанных запросов всех агентов за 2*MaxSkew,
+// иначе вытесненный nonce можно повторить, пока запрос еще попадает в окно.
+package replay
+
+import (
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// DefaultCacheSize размер кеша nonce по умолчанию
+const DefaultCacheSize = 100000
+
+var (
+	// ErrMissing в запросе нет времени отправки или nonce
+	ErrMissing = errors.New("request timestamp and nonce required")
+	// ErrStale время отправки запроса вне допустимого расхождения часов
+	ErrStale = errors.New("request timestamp outside allowed clock skew")
+	// ErrReplayed nonce уже использован
+	ErrReplayed = errors.New("request nonce already used")
+)
+
+// Config настройки защиты. Нулевой MaxSkew выключает защиту, нулевой CacheSize равен DefaultCacheSize.
+type Config struct {
+	MaxSkew   time.Duration
+	CacheSize int
+}
+
+// Guard проверяет время и nonce запросов. Методы безопасны для nil, тогда проверка выключена.
+type Guard struct {
+	maxSkew time.Duration
+	now     func() time.Time
+
+	mu    sync.Mutex
+	seen  map[string]struct{}
+	order []string
+	next  int
+}
+
+// New создает защиту по настройкам cfg или возвращает nil, если она выключена
+func New(cfg Config) *Guard {
+	if cfg.MaxSkew <= 0 {
+		return nil
+	}
+	if cfg.CacheSize <= 0 {
+		cfg.CacheSize = DefaultCacheSize
+	}
+	return &Guard{
+		maxSkew: cfg.MaxSkew,
+		now:     time.Now,
+		seen:    make(map[string]struct{}, cfg.CacheSize),
+		order:   make([]string, cfg.CacheSize),
+	}
+}
+
+// Check проверяет время отправки timestamp в unix-секундах и запоминает nonce для ключа id.
+// Вызывается после проверки подписи, чтобы неподписанные запросы не вытесняли nonce из кеша.
+func (g *Guard) Check(id, timestamp, nonce string) error {
+	if g == nil {
+		return nil
+	}
+	if timestamp == "" || nonce == "" {
+		return ErrMissing
+	}
+	sec, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return ErrStale
+	}
+	skew := g.now().Sub(time.Unix(sec, 0))
+	if skew > g.maxSkew || skew < -g.maxSkew {
+		return ErrStale
+	}
+
+	key := id + "\x00" + nonce
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if _, ok := g.seen[key]; ok {
+		return ErrReplayed
+	}
+	// Кольцевой буфер хранит порядок добавления, самый старый nonce вытесняется
+	if old := g.order[g.next]; old != "" {
+		delete(g.seen, old)
+	}
+	g.order[g.next] = key
+	g.next = (g.next + 1) % len(g.order)
+	g.seen[key] = struct{}{}
+	return nil
+}
@@ -0,0 +1,54 @@
+package replay
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGuard(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	g := New(Config{MaxSkew: time.Minute, CacheSize: 10})
+	g.now = func() time.Time { return now }
+	ts := func(d time.Duration) string {
+		return strconv.FormatInt(now.Add(d).Unix(), 10)
+	}
+
+	assert.NoError(t, g.Check("", ts(0), "a"))
+	assert.ErrorIs(t, g.Check("", ts(0), "a"), ErrReplayed)
+	// nonce учитывается отдельно для каждого ключа
+	assert.NoError(t, g.Check("k1", ts(0), "a"))
+
+	assert.NoError(t, g.Check("", ts(-time.Minute), "b"))
+	assert.NoError(t, g.Check("", ts(time.Minute), "c"))
+	assert.ErrorIs(t, g.Check("", ts(-time.Minute-time.Second), "d"), ErrStale)
+	assert.ErrorIs(t, g.Check("", ts(time.Minute+time.Second), "e"), ErrStale)
+	assert.ErrorIs(t, g.Check("", "yesterday", "f"), ErrStale)
+
+	assert.ErrorIs(t, g.Check("", "", "g"), ErrMissing)
+	assert.ErrorIs(t, g.Check("", ts(0), ""), ErrMissing)
+}
+
+func TestGuardCacheBounded(t *testing.T) {
+	g := New(Config{MaxSkew: time.Minute, CacheSize: 2})
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+
+	assert.NoError(t, g.Check("", ts, "a"))
+	assert.NoError(t, g.Check("", ts, "b"))
+	assert.NoError(t, g.Check("", ts, "c"))
+	assert.Len(t, g.seen, 2)
+	// Самый старый nonce вытеснен
+	assert.NoError(t, g.Check("", ts, "a"))
+	assert.ErrorIs(t, g.Check("", ts, "c"), ErrReplayed)
+}
+
+func TestGuardDisabled(t *testing.T) {
+	g := New(Config{})
+	assert.Nil(t, g)
+	assert.NoError(t, g.Check("", "", ""))
+	assert.NoError(t, g.Check("", "", ""))
+
+	assert.Len(t, New(Config{MaxSkew: time.Second}).order, DefaultCacheSize)
+}
@@ -1,6 +1,7 @@
 package server
 
 import (
+	"fmt"
 	"net/http"
 	"net/http/pprof"
 
@@ -27,61 +28,103 @@ import (
 
 // @externalDocs.description  OpenAPI
 // @externalDocs.url          https://swagger.io/resources/open-api/
-func InitRouter(handler handler.Handler, config flags.InitedFlags) chi.Router {
-	r := chi.NewRouter()
-	// r.Use(packmiddleware.Logger)
+func InitRouter(handler handler.Handler, config flags.InitedFlags) (chi.Router, error) {
+	// Запись метрик разрешена только агентам из доверенной подсети
+	subnet, err := config.GetTrustedSubnet()
+	if err != nil {
+		return nil, fmt.Errorf("ошибка в доверенной подсети: %w", err)
+	}
 
-	// r.Use(middleware.Logger)
+	r := chi.NewRouter()
 	// r.Use(packmiddleware.ContentTypeSet("application/json"))
 	// r.Use(middleware.RealIP)
-	// r.Use(middleware.Recoverer)
-	r.Use(packmiddleware.GzipMiddleware)
-	r.Use(packmiddleware.DecryptMiddleware(config))
-
-	r.Head("/", func(rw http.ResponseWriter, r *http.Request) {
-		r.Header.Set("Content-Type", "Content-Type: application/json")
-	})
-	// GET http://localhost:8080/value/counter/testSetGet163
-	// app.HandleRequest()
-	r.Get("/", handler.MetricsHandlerFunc)
-	r.Get("/ping", handler.PingDB)
-	r.Post("/updates", handler.UpdateJSONMetricsHandlerFunc)
-	r.Post("/updates/", handler.UpdateJSONMetricsHandlerFunc)
-
-	r.Route("/update", func(r chi.Router) {
-		r.Post("/", handler.UpdateJSONMetricHandlerFunc)
-		r.Route("/{type}", func(r chi.Router) {
-			r.Post("/", handler.UpdateMetricsHandlerFunc)
-			r.Post("/{name}", handler.UpdateMetricsHandlerFunc)
-			r.Post("/{name}/", handler.UpdateMetricsHandlerFunc)
-			r.Post("/{name}/{value}", handler.UpdateMetricsHandlerFunc)
-			r.Post("/{name}/{value}/", handler.UpdateMetricsHandlerFunc)
-			r.Get("/{name}/{value}/", handler.UpdateMetricsHandlerFunc)
-
+	// Идентификатор запроса попадает в каждую строку лога ручек и хранилищ
+	r.Use(packmiddleware.RequestID(handler.Logger()))
+	r.Use(packmiddleware.AgentIdentity)
+	// Recoverer стоит внутри Logger, чтобы в лог запроса попал ответ 500 после паники
+	r.Use(packmiddleware.Logger(handler.Logger()))
+	r.Use(packmiddleware.Instrument(handler.Telemetry()))
+	r.Use(packmiddleware.Recoverer(handler.Logger()))
+	// Агент сжимает тело и затем шифрует его, поэтому сервер сначала расшифровывает, затем распаковывает.
+	// Подпись HashSHA256 считается по исходному JSON, поэтому VerifyHash проверяет ее после распаковки
+	// ключом из заголовка HashKeyID и отклоняет повторы по времени и nonce запроса. Ответ подписывается тем же ключом по несжатому телу,
+	// поэтому SignResponse тоже стоит после GzipMiddleware.
+	// Порядок: DecryptMiddleware -> GzipMiddleware -> VerifyHash -> SignResponse -> ручка.
+	body := []func(http.Handler) http.Handler{
+		packmiddleware.DecryptMiddleware(config),
+		packmiddleware.GzipMiddleware(int64(config.MaxBodySize)),
+		packmiddleware.VerifyHash(handler.Keyring(), handler.ReplayGuard()),
+		packmiddleware.SignResponse(handler.Keyring()),
+	}
+
+	r.Group(func(r chi.Router) {
+		r.Use(body...)
+
+		r.Head("/", func(rw http.ResponseWriter, r *http.Request) {
+			r.Header.Set("Content-Type", "Content-Type: application/json")
 		})
-	})
-	r.Route("/value", func(r chi.Router) {
-		r.Post("/", handler.GetMetricsJSONHandlerFunc)
-		r.Get("/", handler.GetMetricHandlerFunc)
-		r.Route("/{type}", func(r chi.Router) {
+		// GET http://localhost:8080/value/counter/testSetGet163
+		// app.HandleRequest()
+		r.Get("/", handler.MetricsHandlerFunc)
+		r.Get("/ping", handler.PingDB)
+		r.Get("/metrics", handler.PrometheusHandlerFunc)
+		r.Get("/healthz", handler.HealthzHandlerFunc)
+		r.Get("/readyz", handler.ReadyzHandlerFunc)
+
+		r.Route("/value", func(r chi.Router) {
+			r.Post("/", handler.GetMetricsJSONHandlerFunc)
 			r.Get("/", handler.GetMetricHandlerFunc)
-			r.Get("/{name}", handler.GetMetricHandlerFunc)
-			r.Get("/{name}/", handler.GetMetricHandlerFunc)
+			r.Route("/{type}", func(r chi.Router) {
+				r.Get("/", handler.GetMetricHandlerFunc)
+				r.Get("/{name}", handler.GetMetricHandlerFunc)
+				r.Get("/{name}/", handler.GetMetricHandlerFunc)
+			})
 		})
-	})
 
-	r.Get("/swagger/*", httpSwagger.Handler(
-		httpSwagger.URL("./doc.json"), // Ссылка на ваш swagger.json
-	))
+		r.Get("/history/{type}/{name}", handler.GetHistoryHandlerFunc)
+		r.Get("/alerts", handler.AlertsHandlerFunc)
+
+		r.Get("/swagger/*", httpSwagger.Handler(
+			httpSwagger.URL("./doc.json"), // Ссылка на ваш swagger.json
+		))
+
+		r.Get("/debug/pprof/", pprof.Index)
+		r.Get("/debug/pprof/cmdline", pprof.Cmdline)
+		r.Get("/debug/pprof/profile", pprof.Profile)
+		r.Get("/debug/pprof/symbol", pprof.Symbol)
+		r.Get("/debug/pprof/trace", pprof.Trace)
+	})
 
-	r.Get("/debug/pprof/", pprof.Index)
-	r.Get("/debug/pprof/cmdline", pprof.Cmdline)
-	r.Get("/debug/pprof/profile", pprof.Profile)
-	r.Get("/debug/pprof/symbol", pprof.Symbol)
-	r.Get("/debug/pprof/trace", pprof.Trace)
+	r.Group(func(r chi.Router) {
+		// Подсеть и частота запросов проверяются до расшифровки и распаковки тела,
+		// чтобы лишние запросы агента не тратили ресурсы сервера
+		r.Use(packmiddleware.TrustedSubnet(subnet))
+		r.Use(packmiddleware.RateLimit(handler.RateLimiter()))
+		r.Use(body...)
+		// Без флага -hash-optional запись без подписи отклоняется, если на сервере есть ключи
+		r.Use(packmiddleware.RequireHash(handler.Keyring(), !config.HashOptional))
+		// Лимит метрик ведется для каждого агента, чтобы один агент не занял всю базу
+		r.Use(packmiddleware.MetricsQuota(handler.RateLimiter()))
+
+		r.Post("/updates", handler.UpdateJSONMetricsHandlerFunc)
+		r.Post("/updates/", handler.UpdateJSONMetricsHandlerFunc)
+		r.Post("/write", handler.WriteLineProtocolHandlerFunc)
+
+		r.Route("/update", func(r chi.Router) {
+			r.Post("/", handler.UpdateJSONMetricHandlerFunc)
+			r.Route("/{type}", func(r chi.Router) {
+				r.Post("/", handler.UpdateMetricsHandlerFunc)
+				r.Post("/{name}", handler.UpdateMetricsHandlerFunc)
+				r.Post("/{name}/", handler.UpdateMetricsHandlerFunc)
+				r.Post("/{name}/{value}", handler.UpdateMetricsHandlerFunc)
+				r.Post("/{name}/{value}/", handler.UpdateMetricsHandlerFunc)
+				// Запись только методом POST: GET может повторить прокси или кеш
+			})
+		})
+	})
 
 	// log.Fatal(http.ListenAndServe(flags.Parse(), r))
-	return r
+	return r, nil
 }
 
 // func InitPprof() chi.Router {
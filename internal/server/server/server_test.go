@@ -1,13 +1,39 @@
 package server
 
 import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
 	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
 	"testing"
+	"time"
 
 	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
 
+	"github.com/Arcadian-Sky/musthave-metrics/internal/compression"
+	"github.com/Arcadian-Sky/musthave-metrics/internal/envelope"
+	"github.com/Arcadian-Sky/musthave-metrics/internal/keyring"
+	"github.com/Arcadian-Sky/musthave-metrics/internal/logging"
 	"github.com/Arcadian-Sky/musthave-metrics/internal/server/flags"
 	"github.com/Arcadian-Sky/musthave-metrics/internal/server/handler"
+	"github.com/Arcadian-Sky/musthave-metrics/internal/server/ratelimit"
+	"github.com/Arcadian-Sky/musthave-metrics/internal/server/replay"
+	"github.com/Arcadian-Sky/musthave-metrics/internal/server/storage"
 	"github.com/Arcadian-Sky/musthave-metrics/internal/server/storage/inmemory"
 )
 
@@ -15,7 +41,8 @@ func TestInitRouter(t *testing.T) {
 	f := flags.InitedFlags{}
 	fakeHandler := handler.NewHandler(inmemory.NewMemStorage(), &f)
 	// Получаем роутер с помощью InitRouter
-	router := InitRouter(*fakeHandler, flags.InitedFlags{})
+	router, err := InitRouter(*fakeHandler, flags.InitedFlags{})
+	require.NoError(t, err)
 	expectedPaths := []string{
 		"/",
 		"/update/",
@@ -25,11 +52,17 @@ func TestInitRouter(t *testing.T) {
 		"/value/",
 		"/value/{type}/",
 		"/value/{type}/{name}/",
+		"/history/{type}/{name}",
+		"/alerts",
+		"/metrics",
+		"/healthz",
+		"/readyz",
+		"/write",
 	}
 	foundPaths := make(map[string]bool)
 
 	// Используем Walk для обхода всех маршрутов и проверки, что они содержатся в ожидаемом списке
-	err := chi.Walk(router, func(method, route string, handler http.Handler, middlewares ...func(http.Handler) http.Handler) error {
+	err = chi.Walk(router, func(method, route string, handler http.Handler, middlewares ...func(http.Handler) http.Handler) error {
 		// Добавляем путь в мапу найденных путей
 		foundPaths[route] = true
 		return nil
@@ -63,3 +96,223 @@ func TestInitRouter(t *testing.T) {
 // 	}
 // 	return false
 // }
+
+// TestInitRouterNoGetWrites проверяет, что метрику нельзя записать запросом GET
+func TestInitRouterNoGetWrites(t *testing.T) {
+	f := flags.InitedFlags{}
+	memStorage := inmemory.NewMemStorage()
+	router, err := InitRouter(*handler.NewHandler(memStorage, &f), f)
+	require.NoError(t, err)
+
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/update/gauge/Alloc/1/", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, rr.Code)
+	assert.Empty(t, memStorage.GetMetric(context.Background(), storage.Gauge))
+}
+
+func TestInitRouterTrustedSubnet(t *testing.T) {
+	f := flags.InitedFlags{TrustedSubnet: "192.168.1.0/24"}
+	fakeHandler := handler.NewHandler(inmemory.NewMemStorage(), &f)
+	router, err := InitRouter(*fakeHandler, f)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name         string
+		method       string
+		path         string
+		realIP       string
+		expectedCode int
+	}{
+		{name: "UpdateTrusted", method: http.MethodPost, path: "/update/gauge/Alloc/1", realIP: "192.168.1.10", expectedCode: http.StatusOK},
+		{name: "UpdateUntrusted", method: http.MethodPost, path: "/update/gauge/Alloc/1", realIP: "10.0.0.1", expectedCode: http.StatusForbidden},
+		{name: "UpdatesUntrusted", method: http.MethodPost, path: "/updates", realIP: "10.0.0.1", expectedCode: http.StatusForbidden},
+		{name: "ReadUntrusted", method: http.MethodGet, path: "/metrics", realIP: "10.0.0.1", expectedCode: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, nil)
+			req.Header.Set("X-Real-IP", tt.realIP)
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, req)
+			if rr.Code != tt.expectedCode {
+				t.Errorf("Expected status %d, got %d", tt.expectedCode, rr.Code)
+			}
+		})
+	}
+}
+
+func TestInitRouterInvalidTrustedSubnet(t *testing.T) {
+	f := flags.InitedFlags{TrustedSubnet: "192.168.1.0"}
+	fakeHandler := handler.NewHandler(inmemory.NewMemStorage(), &f)
+	_, err := InitRouter(*fakeHandler, f)
+	assert.Error(t, err)
+}
+
+// TestInitRouterRequestLog проверяет, что запрос пишется в лог с идентификатором из X-Request-ID
+func TestInitRouterRequestLog(t *testing.T) {
+	core, logs := observer.New(zapcore.InfoLevel)
+	f := flags.InitedFlags{}
+	fakeHandler := handler.NewHandler(inmemory.NewMemStorage(), &f)
+	fakeHandler.SetLogger(zap.New(core))
+	router, err := InitRouter(*fakeHandler, f)
+	require.NoError(t, err)
+
+	req := httptest.NewRequest(http.MethodPost, "/update/gauge/Alloc/1", nil)
+	req.Header.Set("X-Request-ID", "req-1")
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "req-1", rr.Header().Get("X-Request-ID"))
+	entries := logs.FilterMessage("Request processed").All()
+	require.Len(t, entries, 1)
+	fields := entries[0].ContextMap()
+	assert.Equal(t, "req-1", fields[logging.RequestIDField])
+	assert.Equal(t, int64(http.StatusOK), fields["status"])
+	assert.Equal(t, "/update/gauge/Alloc/1", fields["uri"])
+}
+
+// TestInitRouterCompressedEncrypted проверяет порядок middleware: тело сжато и затем зашифровано,
+// подпись посчитана по исходному JSON
+func TestInitRouterCompressedEncrypted(t *testing.T) {
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	keyPath := filepath.Join(t.TempDir(), "private.pem")
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: flags.RSAPrivateKeyType, Bytes: x509.MarshalPKCS1PrivateKey(privateKey)})
+	require.NoError(t, os.WriteFile(keyPath, keyPEM, 0600))
+
+	f := flags.InitedFlags{CryptoKeyPath: keyPath, HashKey: "secret"}
+	memStorage := inmemory.NewMemStorage()
+	router, err := InitRouter(*handler.NewHandler(memStorage, &f), f)
+	require.NoError(t, err)
+
+	message := []byte(`[{"id":"Alloc","type":"gauge","value":1.5},{"id":"PollCount","type":"counter","delta":2}]`)
+	h := hmac.New(sha256.New, []byte(f.HashKey))
+	h.Write(message)
+
+	for _, encoding := range []string{compression.Gzip, compression.Zstd, compression.Brotli} {
+		t.Run(encoding, func(t *testing.T) {
+			compressed, err := compression.Encode(encoding, message)
+			require.NoError(t, err)
+			body, key, err := envelope.Encrypt(&privateKey.PublicKey, compressed)
+			require.NoError(t, err)
+
+			req := httptest.NewRequest(http.MethodPost, "/updates", bytes.NewReader(body))
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set("Content-Encoding", encoding)
+			req.Header.Set(envelope.KeyHeader, key)
+			req.Header.Set("HashSHA256", hex.EncodeToString(h.Sum(nil)))
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, req)
+
+			assert.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+		})
+	}
+
+	// Без подписи и с подписью чужого тела запись отклоняется
+	for name, signature := range map[string]string{"unsigned": "", "tampered": hex.EncodeToString([]byte("wrong"))} {
+		t.Run(name, func(t *testing.T) {
+			body, key, err := envelope.Encrypt(&privateKey.PublicKey, message)
+			require.NoError(t, err)
+
+			req := httptest.NewRequest(http.MethodPost, "/updates", bytes.NewReader(body))
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set(envelope.KeyHeader, key)
+			if signature != "" {
+				req.Header.Set("HashSHA256", signature)
+			}
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, req)
+
+			assert.Equal(t, http.StatusBadRequest, rr.Code)
+		})
+	}
+
+	assert.Equal(t, int64(6), memStorage.GetMetric(context.Background(), storage.Counter)["PollCount"])
+}
+
+// TestInitRouterReplay проверяет, что повтор перехваченного подписанного запроса не увеличивает счетчик
+func TestInitRouterReplay(t *testing.T) {
+	f := flags.InitedFlags{HashKey: "secret"}
+	memStorage := inmemory.NewMemStorage()
+	h := handler.NewHandler(memStorage, &f)
+	h.SetReplayGuard(replay.New(replay.Config{MaxSkew: time.Minute}))
+	router, err := InitRouter(*h, f)
+	require.NoError(t, err)
+
+	message := []byte(`{"id":"PollCount","type":"counter","delta":2}`)
+	timestamp, nonce := strconv.FormatInt(time.Now().Unix(), 10), "0123456789abcdef"
+	signature, err := h.Keyring().Sign("", keyring.Payload(http.MethodPost, "/update/", timestamp, nonce, message))
+	require.NoError(t, err)
+
+	for i, want := range []int{http.StatusOK, http.StatusBadRequest} {
+		req := httptest.NewRequest(http.MethodPost, "/update/", bytes.NewReader(message))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(keyring.HashHeader, signature)
+		req.Header.Set(keyring.TimestampHeader, timestamp)
+		req.Header.Set(keyring.NonceHeader, nonce)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		assert.Equal(t, want, rr.Code, "попытка %d: %s", i+1, rr.Body.String())
+	}
+
+	assert.Equal(t, int64(2), memStorage.GetMetric(context.Background(), storage.Counter)["PollCount"])
+}
+
+// TestInitRouterProbesWithCryptoKey проверяет, что проверки состояния и сбор метрик без тела не расшифровываются
+func TestInitRouterProbesWithCryptoKey(t *testing.T) {
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	keyPath := filepath.Join(t.TempDir(), "private.pem")
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: flags.RSAPrivateKeyType, Bytes: x509.MarshalPKCS1PrivateKey(privateKey)})
+	require.NoError(t, os.WriteFile(keyPath, keyPEM, 0600))
+
+	f := flags.InitedFlags{CryptoKeyPath: keyPath}
+	memStorage := inmemory.NewMemStorage()
+	require.NoError(t, memStorage.UpdateMetric(context.Background(), "gauge", "Alloc", "1.5"))
+	router, err := InitRouter(*handler.NewHandler(memStorage, &f), f)
+	require.NoError(t, err)
+
+	for _, path := range []string{"/healthz", "/readyz", "/alerts"} {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, path, nil))
+		assert.Equal(t, http.StatusOK, rr.Code, path+": "+rr.Body.String())
+	}
+
+	// Prometheus собирает /metrics запросом GET без тела
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	assert.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	assert.Contains(t, rr.Body.String(), "Alloc 1.5")
+}
+
+// TestInitRouterRateLimitBeforeDecrypt проверяет, что лишний запрос агента отклоняется
+// до расшифровки тела: нерасшифровываемое тело получает 429, а не ошибку расшифровки
+func TestInitRouterRateLimitBeforeDecrypt(t *testing.T) {
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	keyPath := filepath.Join(t.TempDir(), "private.pem")
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: flags.RSAPrivateKeyType, Bytes: x509.MarshalPKCS1PrivateKey(privateKey)})
+	require.NoError(t, os.WriteFile(keyPath, keyPEM, 0600))
+
+	f := flags.InitedFlags{CryptoKeyPath: keyPath}
+	h := handler.NewHandler(inmemory.NewMemStorage(), &f)
+	h.SetRateLimiter(ratelimit.New(ratelimit.Config{RequestsPerSecond: 0.001, RequestsBurst: 1}))
+	router, err := InitRouter(*h, f)
+	require.NoError(t, err)
+
+	serve := func() *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/updates/", bytes.NewBufferString("not encrypted")))
+		return rr
+	}
+	assert.NotEqual(t, http.StatusTooManyRequests, serve().Code)
+	rr := serve()
+	assert.Equal(t, http.StatusTooManyRequests, rr.Code, rr.Body.String())
+	assert.NotEmpty(t, rr.Header().Get("Retry-After"))
+
+	// Чтение не ограничивается
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	assert.Equal(t, http.StatusOK, rr.Code)
+}
@@ -0,0 +1,92 @@
+package statsd
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/Arcadian-Sky/musthave-metrics/internal/labels"
+	"github.com/Arcadian-Sky/musthave-metrics/internal/server/storage"
+)
+
+// ErrUnsupportedType тип метрики StatsD не поддерживается, принимаются только c и g
+var ErrUnsupportedType = errors.New("unsupported statsd metric type")
+
+// Sample одно значение из строки протокола StatsD
+type Sample struct {
+	Name     string
+	Type     storage.MetricType
+	Value    float64 // для счетчика уже поделено на частоту выборки
+	Relative bool    // gauge со знаком + или - изменяет текущее значение, а не задает его
+	Labels   map[string]string
+}
+
+// ParseLine разбирает строку вида name:value|type[|@rate][|#tag:value,...].
+//
+// Для счетчика (c) значение делится на частоту выборки @rate.
+// Для gauge (g) значение со знаком + или - является приращением, частота выборки игнорируется.
+// Теги в формате DogStatsD становятся метками метрики.
+func ParseLine(line string) (Sample, error) {
+	var sample Sample
+	name, rest, ok := strings.Cut(line, ":")
+	if !ok || name == "" {
+		return sample, fmt.Errorf("metric name not provided in %q", line)
+	}
+	fields := strings.Split(rest, "|")
+	if len(fields) < 2 {
+		return sample, fmt.Errorf("metric type not provided in %q", line)
+	}
+	sample.Name = name
+
+	raw := fields[0]
+	value, err := strconv.ParseFloat(raw, 64)
+	if err != nil {
+		return sample, fmt.Errorf("invalid value %q: %w", raw, err)
+	}
+
+	rate := 1.0
+	for _, field := range fields[2:] {
+		switch {
+		case strings.HasPrefix(field, "@"):
+			rate, err = strconv.ParseFloat(field[1:], 64)
+			if err != nil || rate <= 0 || rate > 1 {
+				return sample, fmt.Errorf("invalid sample rate %q", field)
+			}
+		case strings.HasPrefix(field, "#"):
+			sample.Labels, err = parseTags(field[1:])
+			if err != nil {
+				return sample, err
+			}
+		}
+	}
+
+	switch fields[1] {
+	case "c":
+		sample.Type = storage.Counter
+		sample.Value = value / rate
+	case "g":
+		sample.Type = storage.Gauge
+		sample.Value = value
+		sample.Relative = strings.HasPrefix(raw, "+") || strings.HasPrefix(raw, "-")
+	default:
+		return sample, fmt.Errorf("%w %q", ErrUnsupportedType, fields[1])
+	}
+	return sample, nil
+}
+
+// parseTags разбирает теги вида env:prod,host:a. Тег без значения получает пустое значение.
+func parseTags(s string) (map[string]string, error) {
+	tags := make(map[string]string)
+	for _, tag := range strings.Split(s, ",") {
+		if tag == "" {
+			continue
+		}
+		name, value, _ := strings.Cut(tag, ":")
+		tags[name] = value
+	}
+	if err := labels.Validate(tags); err != nil {
+		return nil, err
+	}
+	return tags, nil
+}
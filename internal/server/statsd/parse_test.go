@@ -0,0 +1,46 @@
+package statsd
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/Arcadian-Sky/musthave-metrics/internal/server/storage"
+)
+
+func TestParseLine(t *testing.T) {
+	tests := []struct {
+		name    string
+		line    string
+		want    Sample
+		wantErr bool
+	}{
+		{name: "counter", line: "requests:3|c", want: Sample{Name: "requests", Type: storage.Counter, Value: 3}},
+		{name: "counter with sample rate", line: "requests:1|c|@0.1", want: Sample{Name: "requests", Type: storage.Counter, Value: 10}},
+		{name: "gauge", line: "temperature:21.5|g", want: Sample{Name: "temperature", Type: storage.Gauge, Value: 21.5}},
+		{name: "gauge increment", line: "queue:+4|g", want: Sample{Name: "queue", Type: storage.Gauge, Value: 4, Relative: true}},
+		{name: "gauge decrement", line: "queue:-2.5|g|@0.5", want: Sample{Name: "queue", Type: storage.Gauge, Value: -2.5, Relative: true}},
+		{
+			name: "tags",
+			line: "requests:1|c|#env:prod,host:a",
+			want: Sample{Name: "requests", Type: storage.Counter, Value: 1, Labels: map[string]string{"env": "prod", "host": "a"}},
+		},
+		{name: "timer", line: "latency:320|ms", wantErr: true},
+		{name: "no type", line: "requests:1", wantErr: true},
+		{name: "no name", line: ":1|c", wantErr: true},
+		{name: "bad value", line: "requests:x|c", wantErr: true},
+		{name: "bad rate", line: "requests:1|c|@2", wantErr: true},
+		{name: "bad tag", line: "requests:1|c|#host-name:a", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseLine(tt.line)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
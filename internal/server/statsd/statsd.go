@@ -0,0 +1,276 @@
+// Пакет statsd принимает метрики по протоколу StatsD через UDP и TCP
+// и записывает их в storage.MetricsStorage.
+//
+// Принимаются счетчики (c) с частотой выборки и gauge (g), в том числе приращения +N и -N.
+// Значения копятся в памяти и сбрасываются в хранилище одной пачкой UpdateJSONMetrics
+// раз в интервал или при накоплении MaxBatch рядов, чтобы не делать запрос к базе на каждую строку.
+package statsd
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"math"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/Arcadian-Sky/musthave-metrics/internal/labels"
+	"github.com/Arcadian-Sky/musthave-metrics/internal/server/models"
+	"github.com/Arcadian-Sky/musthave-metrics/internal/server/storage"
+	"go.uber.org/zap"
+)
+
+// Значения по умолчанию
+const (
+	DefaultFlushInterval = 10 * time.Second
+	DefaultMaxBatch      = 1000
+	// maxPacketSize предельный размер UDP датаграммы
+	maxPacketSize = 64 * 1024
+)
+
+// counter накопленное приращение счетчика. Дробная часть переносится в следующий сброс.
+type counter struct {
+	name   string
+	labels map[string]string
+	delta  float64
+}
+
+// gauge накопленное значение gauge. Если значение не задано, к текущему значению в хранилище прибавляется delta.
+type gauge struct {
+	name   string
+	labels map[string]string
+	value  float64
+	set    bool
+	delta  float64
+}
+
+// Listener копит значения StatsD и сбрасывает их в хранилище
+type Listener struct {
+	s             storage.MetricsStorage
+	flushInterval time.Duration
+	maxBatch      int
+	log           *zap.Logger
+
+	mu       sync.Mutex
+	counters map[string]*counter
+	gauges   map[string]*gauge
+	full     chan struct{}
+}
+
+// New создает Listener. Неположительные значения заменяются значениями по умолчанию.
+func New(s storage.MetricsStorage, flushInterval time.Duration, maxBatch int) *Listener {
+	if flushInterval <= 0 {
+		flushInterval = DefaultFlushInterval
+	}
+	if maxBatch <= 0 {
+		maxBatch = DefaultMaxBatch
+	}
+	return &Listener{
+		s:             s,
+		flushInterval: flushInterval,
+		maxBatch:      maxBatch,
+		log:           zap.NewNop(),
+		counters:      make(map[string]*counter),
+		gauges:        make(map[string]*gauge),
+		full:          make(chan struct{}, 1),
+	}
+}
+
+// SetLogger задает логгер для ошибок сброса и пропущенных строк
+func (l *Listener) SetLogger(logger *zap.Logger) {
+	if logger != nil {
+		l.log = logger
+	}
+}
+
+// Handle разбирает строку протокола и добавляет значение к накопленным.
+// Пустые строки пропускаются.
+func (l *Listener) Handle(line string) error {
+	if line == "" {
+		return nil
+	}
+	sample, err := ParseLine(line)
+	if err != nil {
+		return err
+	}
+	l.add(sample)
+	return nil
+}
+
+func (l *Listener) add(sample Sample) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	key := labels.Key(sample.Name, sample.Labels)
+	switch sample.Type {
+	case storage.Counter:
+		c, ok := l.counters[key]
+		if !ok {
+			c = &counter{name: sample.Name, labels: sample.Labels}
+			l.counters[key] = c
+		}
+		c.delta += sample.Value
+	case storage.Gauge:
+		g, ok := l.gauges[key]
+		if !ok {
+			g = &gauge{name: sample.Name, labels: sample.Labels}
+			l.gauges[key] = g
+		}
+		if sample.Relative {
+			g.delta += sample.Value
+		} else {
+			g.value, g.set, g.delta = sample.Value, true, 0
+		}
+	}
+
+	if len(l.counters)+len(l.gauges) >= l.maxBatch {
+		select {
+		case l.full <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Run сбрасывает накопленные значения раз в интервал и при заполнении пачки.
+// После отмены ctx выполняет последний сброс и завершается.
+func (l *Listener) Run(ctx context.Context) {
+	ticker := time.NewTicker(l.flushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			// Контекст уже отменен, последний сброс выполняем с новым
+			if err := l.Flush(context.Background()); err != nil {
+				l.log.Error("StatsD: ошибка при последнем сбросе метрик", zap.Error(err))
+			}
+			return
+		case <-ticker.C:
+		case <-l.full:
+		}
+		if err := l.Flush(ctx); err != nil {
+			l.log.Error("StatsD: ошибка при сбросе метрик", zap.Error(err))
+		}
+	}
+}
+
+// Flush записывает накопленные значения в хранилище одной пачкой.
+// При ошибке хранилища приращения счетчиков и значения gauge возвращаются в накопленные
+// и уйдут со следующим сбросом, см. requeueGauges.
+// Приращение gauge без заданного значения прибавляется к значению, прочитанному из хранилища
+// перед записью, без блокировки хранилища: если между чтением и записью этот gauge изменит
+// другой клиент, например через HTTP, его значение будет перезаписано.
+func (l *Listener) Flush(ctx context.Context) error {
+	l.mu.Lock()
+	counters, gauges := l.counters, l.gauges
+	l.counters = make(map[string]*counter)
+	l.gauges = make(map[string]*gauge)
+	l.mu.Unlock()
+
+	batch := make([]models.Metrics, 0, len(counters)+len(gauges))
+	sent := make(map[string]int64, len(counters))
+	for key, c := range counters {
+		delta := int64(math.Round(c.delta))
+		if rest := c.delta - float64(delta); rest != 0 {
+			l.add(Sample{Name: c.name, Type: storage.Counter, Value: rest, Labels: c.labels})
+		}
+		if delta == 0 {
+			continue
+		}
+		sent[key] = delta
+		batch = append(batch, models.Metrics{ID: c.name, MType: string(storage.Counter), Delta: &delta, Labels: c.labels})
+	}
+	for _, g := range gauges {
+		value := g.value
+		if !g.set {
+			// Приращение без заданного значения считается от текущего значения в хранилище, отсутствующий gauge равен нулю
+			current := models.Metrics{ID: g.name, MType: string(storage.Gauge), Labels: g.labels}
+			if err := l.s.GetJSONMetric(ctx, &current); err == nil && current.Value != nil {
+				value = *current.Value
+			}
+		}
+		value += g.delta
+		batch = append(batch, models.Metrics{ID: g.name, MType: string(storage.Gauge), Value: &value, Labels: g.labels})
+	}
+	if len(batch) == 0 {
+		return nil
+	}
+
+	if err := l.s.UpdateJSONMetrics(ctx, &batch); err != nil {
+		for key, delta := range sent {
+			c := counters[key]
+			l.add(Sample{Name: c.name, Type: storage.Counter, Value: float64(delta), Labels: c.labels})
+		}
+		l.requeueGauges(gauges)
+		return err
+	}
+	return nil
+}
+
+// requeueGauges возвращает в накопленные gauge, которые не удалось записать.
+// Они старше значений, пришедших после начала сброса: новое заданное значение остается как есть,
+// а новые приращения прибавляются к старому значению и старым приращениям.
+func (l *Listener) requeueGauges(gauges map[string]*gauge) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for key, old := range gauges {
+		g, ok := l.gauges[key]
+		if !ok {
+			l.gauges[key] = old
+			continue
+		}
+		if g.set {
+			continue
+		}
+		g.value, g.set = old.value, old.set
+		g.delta += old.delta
+	}
+}
+
+// ServeUDP читает датаграммы из conn, в одной датаграмме может быть несколько строк.
+// Завершается при закрытии conn.
+func (l *Listener) ServeUDP(conn net.PacketConn) error {
+	buf := make([]byte, maxPacketSize)
+	for {
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		for _, line := range bytes.Split(buf[:n], []byte("\n")) {
+			l.handleLogged(string(bytes.TrimSpace(line)))
+		}
+	}
+}
+
+// ServeTCP принимает соединения и читает из них строки протокола.
+// Завершается при закрытии ln.
+func (l *Listener) ServeTCP(ln net.Listener) error {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		go func() {
+			defer conn.Close()
+			scanner := bufio.NewScanner(conn)
+			scanner.Buffer(make([]byte, 4096), maxPacketSize)
+			for scanner.Scan() {
+				l.handleLogged(string(bytes.TrimSpace(scanner.Bytes())))
+			}
+		}()
+	}
+}
+
+func (l *Listener) handleLogged(line string) {
+	if err := l.Handle(line); err != nil {
+		l.log.Debug("StatsD: строка пропущена", zap.Error(err))
+	}
+}
@@ -0,0 +1,173 @@
+package statsd
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/Arcadian-Sky/musthave-metrics/internal/server/models"
+	"github.com/Arcadian-Sky/musthave-metrics/internal/server/storage"
+	"github.com/Arcadian-Sky/musthave-metrics/internal/server/storage/inmemory"
+)
+
+// countingStorage считает пачки и может отказывать в записи
+type countingStorage struct {
+	storage.MetricsStorage
+	batches int
+	fail    bool
+}
+
+func (s *countingStorage) UpdateJSONMetrics(ctx context.Context, metrics *[]models.Metrics) error {
+	if s.fail {
+		return errors.New("database is down")
+	}
+	s.batches++
+	return s.MetricsStorage.UpdateJSONMetrics(ctx, metrics)
+}
+
+func TestListenerFlush(t *testing.T) {
+	ctx := context.Background()
+	mem := inmemory.NewMemStorage()
+	mem.UpdateMetric(ctx, "gauge", "queue", "10")
+	mem.UpdateMetric(ctx, "counter", "requests", "5")
+	s := &countingStorage{MetricsStorage: mem}
+	l := New(s, time.Minute, 0)
+
+	for _, line := range []string{
+		"requests:1|c",
+		"requests:1|c|@0.5",
+		"queue:+3|g",
+		"queue:-1|g",
+		"temperature:21|g",
+		"temperature:+1|g",
+		"",
+	} {
+		assert.NoError(t, l.Handle(line))
+	}
+	assert.Error(t, l.Handle("latency:320|ms"))
+
+	assert.NoError(t, l.Flush(ctx))
+	assert.Equal(t, 1, s.batches, "все значения записаны одной пачкой")
+	assert.Equal(t, int64(8), mem.GetMetric(ctx, storage.Counter)["requests"])
+	assert.Equal(t, 12.0, mem.GetMetric(ctx, storage.Gauge)["queue"])
+	assert.Equal(t, 22.0, mem.GetMetric(ctx, storage.Gauge)["temperature"])
+
+	// Пустой сброс не обращается к хранилищу
+	assert.NoError(t, l.Flush(ctx))
+	assert.Equal(t, 1, s.batches)
+}
+
+func TestListenerFlushCarriesFractions(t *testing.T) {
+	ctx := context.Background()
+	mem := inmemory.NewMemStorage()
+	l := New(mem, time.Minute, 0)
+
+	assert.NoError(t, l.Handle("hits:1|c|@0.4"))
+	assert.NoError(t, l.Flush(ctx))
+	assert.Equal(t, int64(3), mem.GetMetric(ctx, storage.Counter)["hits"])
+
+	assert.NoError(t, l.Handle("hits:1|c|@0.4"))
+	assert.NoError(t, l.Flush(ctx))
+	assert.Equal(t, int64(5), mem.GetMetric(ctx, storage.Counter)["hits"], "округление 2.5 до 3 учтено в следующем сбросе")
+}
+
+func TestListenerFlushFailureKeepsCounters(t *testing.T) {
+	ctx := context.Background()
+	mem := inmemory.NewMemStorage()
+	s := &countingStorage{MetricsStorage: mem, fail: true}
+	l := New(s, time.Minute, 0)
+
+	assert.NoError(t, l.Handle("requests:2|c"))
+	assert.Error(t, l.Flush(ctx))
+
+	s.fail = false
+	assert.NoError(t, l.Handle("requests:1|c"))
+	assert.NoError(t, l.Flush(ctx))
+	assert.Equal(t, int64(3), mem.GetMetric(ctx, storage.Counter)["requests"])
+}
+
+func TestListenerFlushFailureKeepsGauges(t *testing.T) {
+	ctx := context.Background()
+	mem := inmemory.NewMemStorage()
+	mem.UpdateMetric(ctx, "gauge", "queue", "10")
+	s := &countingStorage{MetricsStorage: mem, fail: true}
+	l := New(s, time.Minute, 0)
+
+	for _, line := range []string{
+		"queue:+3|g",
+		"temperature:21|g",
+		"temperature:+1|g",
+		"pressure:700|g",
+	} {
+		assert.NoError(t, l.Handle(line))
+	}
+	assert.Error(t, l.Flush(ctx))
+
+	s.fail = false
+	for _, line := range []string{
+		"queue:+2|g",
+		"temperature:+1|g",
+		"pressure:750|g",
+	} {
+		assert.NoError(t, l.Handle(line))
+	}
+	assert.NoError(t, l.Flush(ctx))
+	assert.Equal(t, 15.0, mem.GetMetric(ctx, storage.Gauge)["queue"], "приращения сложены")
+	assert.Equal(t, 23.0, mem.GetMetric(ctx, storage.Gauge)["temperature"], "новое приращение прибавлено к старому значению")
+	assert.Equal(t, 750.0, mem.GetMetric(ctx, storage.Gauge)["pressure"], "новое значение важнее старого")
+}
+
+func TestListenerServe(t *testing.T) {
+	mem := inmemory.NewMemStorage()
+	l := New(mem, time.Hour, 2)
+
+	udp, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	tcp, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	go l.ServeUDP(udp)
+	go l.ServeTCP(tcp)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		l.Run(ctx)
+	}()
+
+	client, err := net.Dial("udp", udp.LocalAddr().String())
+	assert.NoError(t, err)
+	_, err = client.Write([]byte("udp_hits:2|c\nudp_gauge:7|g"))
+	assert.NoError(t, err)
+	client.Close()
+
+	conn, err := net.Dial("tcp", tcp.Addr().String())
+	assert.NoError(t, err)
+	_, err = conn.Write([]byte("tcp_hits:3|c\n"))
+	assert.NoError(t, err)
+	conn.Close()
+
+	// Две строки UDP заполняют пачку и вызывают внеочередной сброс
+	assert.Eventually(t, func() bool {
+		return mem.GetMetric(context.Background(), storage.Gauge)["udp_gauge"] == 7.0
+	}, time.Second, 10*time.Millisecond)
+
+	assert.Eventually(t, func() bool {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		return l.counters["tcp_hits"] != nil || mem.GetMetric(context.Background(), storage.Counter)["tcp_hits"] != nil
+	}, time.Second, 10*time.Millisecond)
+
+	// При остановке накопленные значения сбрасываются
+	cancel()
+	<-done
+	assert.Equal(t, int64(3), mem.GetMetric(context.Background(), storage.Counter)["tcp_hits"])
+	assert.Equal(t, int64(2), mem.GetMetric(context.Background(), storage.Counter)["udp_hits"])
+
+	udp.Close()
+	tcp.Close()
+}
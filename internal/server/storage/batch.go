@@ -0,0 +1,54 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/Arcadian-Sky/musthave-metrics/internal/labels"
+	"github.com/Arcadian-Sky/musthave-metrics/internal/server/models"
+)
+
+// BatchError ошибка в элементе пачки метрик. Пачка с такой ошибкой не применяется целиком.
+type BatchError struct {
+	Index int    // номер элемента в пачке, начиная с нуля
+	ID    string // имя метрики
+	Err   error
+}
+
+func (e *BatchError) Error() string {
+	return fmt.Sprintf("metric #%d (%q): %v", e.Index, e.ID, e.Err)
+}
+
+func (e *BatchError) Unwrap() error {
+	return e.Err
+}
+
+// ValidateMetrics проверяет все элементы пачки до ее применения.
+// Возвращает *BatchError для первого некорректного элемента.
+func ValidateMetrics(metrics []models.Metrics) error {
+	for i, metric := range metrics {
+		if err := validateMetric(metric); err != nil {
+			return &BatchError{Index: i, ID: metric.ID, Err: err}
+		}
+	}
+	return nil
+}
+
+func validateMetric(metric models.Metrics) error {
+	if metric.ID == "" {
+		return errors.New("metric name not provided")
+	}
+	switch MetricType(metric.MType) {
+	case Gauge:
+		if metric.Value == nil {
+			return errors.New("gauge value not provided")
+		}
+	case Counter:
+		if metric.Delta == nil {
+			return errors.New("counter delta not provided")
+		}
+	default:
+		return fmt.Errorf("invalid metric type %q", metric.MType)
+	}
+	return labels.Validate(metric.Labels)
+}
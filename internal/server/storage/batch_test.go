@@ -0,0 +1,50 @@
+package storage
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/Arcadian-Sky/musthave-metrics/internal/server/models"
+)
+
+func TestValidateMetrics(t *testing.T) {
+	value := 1.5
+	delta := int64(2)
+
+	tests := []struct {
+		name    string
+		metrics []models.Metrics
+		index   int
+	}{
+		{name: "Valid", metrics: []models.Metrics{
+			{ID: "Alloc", MType: "gauge", Value: &value},
+			{ID: "PollCount", MType: "counter", Delta: &delta},
+		}, index: -1},
+		{name: "NoName", metrics: []models.Metrics{{MType: "gauge", Value: &value}}, index: 0},
+		{name: "NoValue", metrics: []models.Metrics{
+			{ID: "Alloc", MType: "gauge", Value: &value},
+			{ID: "Free", MType: "gauge"},
+		}, index: 1},
+		{name: "NoDelta", metrics: []models.Metrics{{ID: "PollCount", MType: "counter", Value: &value}}, index: 0},
+		{name: "InvalidType", metrics: []models.Metrics{{ID: "Alloc", MType: "histogram", Value: &value}}, index: 0},
+		{name: "InvalidLabel", metrics: []models.Metrics{
+			{ID: "Alloc", MType: "gauge", Value: &value, Labels: map[string]string{"host-name": "a"}},
+		}, index: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateMetrics(tt.metrics)
+			if tt.index < 0 {
+				assert.NoError(t, err)
+				return
+			}
+			var batchErr *BatchError
+			if assert.True(t, errors.As(err, &batchErr)) {
+				assert.Equal(t, tt.index, batchErr.Index)
+				assert.Equal(t, tt.metrics[tt.index].ID, batchErr.ID)
+			}
+		})
+	}
+}
@@ -2,11 +2,13 @@ package config
 
 import (
 	"fmt"
+	"os"
 	"time"
 
 	"github.com/Arcadian-Sky/musthave-metrics/internal/server/caretaker"
 	"github.com/Arcadian-Sky/musthave-metrics/internal/server/flags"
 	"github.com/Arcadian-Sky/musthave-metrics/internal/server/storage"
+	"go.uber.org/zap"
 )
 
 //	Интервал с которым производим бэкапирование: parsed.StoreInterval,
@@ -20,6 +22,18 @@ type MementoStorage interface {
 	RestoreFromMemento(s *storage.Memento)
 }
 
+// MementoObserver получает длительность и размер сохраненного снимка
+type MementoObserver interface {
+	ObserveMemento(d time.Duration, size int64, err error)
+}
+
+var mementoObservers []MementoObserver
+
+// SetMementoObserver задает получателей статистики сохранения снимков. Вызывается до InitConfig.
+func SetMementoObserver(observers ...MementoObserver) {
+	mementoObservers = observers
+}
+
 // InitConfig инициализирует конфигурацию сервера на основе переданных параметров
 func InitConfig(storeMetrics MementoStorage, config *flags.InitedFlags) error {
 	// Создаем экземпляр Caretaker для работы с мементо
@@ -60,11 +74,22 @@ func InitConfig(storeMetrics MementoStorage, config *flags.InitedFlags) error {
 
 // saveMetricsToFile сохраняет текущие значения метрик на диск
 func SaveMetricsToFile(storeMetrics MementoStorage, fileStoragePath string) {
+	start := time.Now()
 	memento := storeMetrics.CreateMemento()
 	caretaker := &caretaker.Caretaker{}
 	err := caretaker.SaveToFile(memento, fileStoragePath)
 	if err != nil {
-		fmt.Println("Error saving memento:", err)
+		zap.L().Error("Error saving memento", zap.String("file", fileStoragePath), zap.Error(err))
 		// Обработка ошибок сохранения
 	}
+	if len(mementoObservers) > 0 {
+		d := time.Since(start)
+		var size int64
+		if info, statErr := os.Stat(fileStoragePath); err == nil && statErr == nil {
+			size = info.Size()
+		}
+		for _, o := range mementoObservers {
+			o.ObserveMemento(d, size, err)
+		}
+	}
 }
@@ -0,0 +1,107 @@
+// Пакет history содержит общие для хранилищ правила ведения истории метрик:
+// прореживание при записи, ограничение срока хранения и агрегацию при чтении.
+package history
+
+import (
+	"time"
+
+	"github.com/Arcadian-Sky/musthave-metrics/internal/server/models"
+	"github.com/Arcadian-Sky/musthave-metrics/internal/server/storage"
+)
+
+// Config параметры хранения истории
+type Config struct {
+	// Retention срок хранения точек, 0 отключает ведение истории
+	Retention time.Duration
+	// Resolution минимальный интервал между точками, значения внутри интервала перезаписываются
+	Resolution time.Duration
+}
+
+// Enabled сообщает, ведется ли история
+func (c Config) Enabled() bool {
+	return c.Retention > 0
+}
+
+// Bucket возвращает начало интервала прореживания, в который попадает момент t
+func (c Config) Bucket(t time.Time) time.Time {
+	if c.Resolution <= 0 {
+		return t
+	}
+	return t.Truncate(c.Resolution)
+}
+
+// Add добавляет значение в ряд с учетом прореживания и срока хранения.
+// Ряд должен быть упорядочен по времени.
+func (c Config) Add(points []models.HistoryPoint, now time.Time, value float64) []models.HistoryPoint {
+	ts := c.Bucket(now)
+	if n := len(points); n > 0 && !points[n-1].Time.Before(ts) {
+		points[n-1].Value = value
+	} else {
+		points = append(points, models.HistoryPoint{Time: ts, Value: value})
+	}
+	return c.Trim(points, now)
+}
+
+// Trim удаляет из ряда точки старше срока хранения
+func (c Config) Trim(points []models.HistoryPoint, now time.Time) []models.HistoryPoint {
+	border := now.Add(-c.Retention)
+	i := 0
+	for i < len(points) && points[i].Time.Before(border) {
+		i++
+	}
+	if i == 0 {
+		return points
+	}
+	return append(points[:0], points[i:]...)
+}
+
+// Range возвращает копию точек ряда из интервала [from, to]
+func Range(points []models.HistoryPoint, from, to time.Time) []models.HistoryPoint {
+	result := make([]models.HistoryPoint, 0)
+	for _, p := range points {
+		if p.Time.Before(from) || p.Time.After(to) {
+			continue
+		}
+		result = append(result, p)
+	}
+	return result
+}
+
+// Downsample агрегирует упорядоченные точки по интервалам step, отсчитываемым от from.
+// Для gauge берется среднее значение интервала, для counter — последнее накопленное значение.
+func Downsample(points []models.HistoryPoint, mtype storage.MetricType, from time.Time, step time.Duration) []models.HistoryPoint {
+	if step <= 0 || len(points) == 0 {
+		return points
+	}
+	result := make([]models.HistoryPoint, 0)
+	var (
+		bucket time.Time
+		sum    float64
+		count  int
+	)
+	flush := func() {
+		if count == 0 {
+			return
+		}
+		value := sum / float64(count)
+		if mtype == storage.Counter {
+			value = sum
+		}
+		result = append(result, models.HistoryPoint{Time: bucket, Value: value})
+	}
+	for _, p := range points {
+		b := from.Add(p.Time.Sub(from) / step * step)
+		if count == 0 || !b.Equal(bucket) {
+			flush()
+			bucket, sum, count = b, 0, 0
+		}
+		if mtype == storage.Counter {
+			sum = p.Value
+		} else {
+			sum += p.Value
+		}
+		count++
+	}
+	flush()
+	return result
+}
@@ -0,0 +1,98 @@
+package history
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/Arcadian-Sky/musthave-metrics/internal/server/models"
+	"github.com/Arcadian-Sky/musthave-metrics/internal/server/storage"
+)
+
+func TestConfig_Add(t *testing.T) {
+	cfg := Config{Retention: time.Minute, Resolution: 10 * time.Second}
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	var points []models.HistoryPoint
+	points = cfg.Add(points, start, 1)
+	points = cfg.Add(points, start.Add(5*time.Second), 2)
+	points = cfg.Add(points, start.Add(15*time.Second), 3)
+
+	assert.Equal(t, []models.HistoryPoint{
+		{Time: start, Value: 2},
+		{Time: start.Add(10 * time.Second), Value: 3},
+	}, points)
+
+	// Через две минуты старые точки вытесняются сроком хранения
+	points = cfg.Add(points, start.Add(2*time.Minute), 4)
+	assert.Equal(t, []models.HistoryPoint{
+		{Time: start.Add(2 * time.Minute), Value: 4},
+	}, points)
+}
+
+func TestConfig_Enabled(t *testing.T) {
+	assert.False(t, Config{}.Enabled())
+	assert.True(t, Config{Retention: time.Hour}.Enabled())
+}
+
+func TestRange(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	points := []models.HistoryPoint{
+		{Time: start, Value: 1},
+		{Time: start.Add(time.Minute), Value: 2},
+		{Time: start.Add(2 * time.Minute), Value: 3},
+	}
+
+	got := Range(points, start.Add(30*time.Second), start.Add(2*time.Minute))
+	assert.Equal(t, points[1:], got)
+
+	got = Range(points, start.Add(time.Hour), start.Add(2*time.Hour))
+	assert.Empty(t, got)
+}
+
+func TestDownsample(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	points := []models.HistoryPoint{
+		{Time: start, Value: 1},
+		{Time: start.Add(20 * time.Second), Value: 3},
+		{Time: start.Add(70 * time.Second), Value: 10},
+	}
+
+	tests := []struct {
+		name  string
+		mtype storage.MetricType
+		step  time.Duration
+		want  []models.HistoryPoint
+	}{
+		{
+			name:  "GaugeAverage",
+			mtype: storage.Gauge,
+			step:  time.Minute,
+			want: []models.HistoryPoint{
+				{Time: start, Value: 2},
+				{Time: start.Add(time.Minute), Value: 10},
+			},
+		},
+		{
+			name:  "CounterLast",
+			mtype: storage.Counter,
+			step:  time.Minute,
+			want: []models.HistoryPoint{
+				{Time: start, Value: 3},
+				{Time: start.Add(time.Minute), Value: 10},
+			},
+		},
+		{
+			name:  "NoStep",
+			mtype: storage.Gauge,
+			step:  0,
+			want:  points,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, Downsample(points, tt.mtype, start, tt.step))
+		})
+	}
+}
@@ -5,26 +5,50 @@ import (
 	"fmt"
 	"strconv"
 	"sync"
+	"time"
 
+	"go.uber.org/zap"
+
+	"github.com/Arcadian-Sky/musthave-metrics/internal/labels"
+	"github.com/Arcadian-Sky/musthave-metrics/internal/logging"
 	"github.com/Arcadian-Sky/musthave-metrics/internal/server/models"
 	"github.com/Arcadian-Sky/musthave-metrics/internal/server/storage"
+	"github.com/Arcadian-Sky/musthave-metrics/internal/server/storage/history"
 	"github.com/Arcadian-Sky/musthave-metrics/internal/server/storage/utils"
 )
 
 // MemStorage представляет хранилище метрик
 type MemStorage struct {
-	metrics map[storage.MetricType]map[string]interface{}
-	mu      sync.RWMutex
+	metrics    map[storage.MetricType]map[string]interface{}
+	history    map[storage.MetricType]map[string][]models.HistoryPoint
+	historyCfg history.Config
+	now        func() time.Time
+	log        *zap.Logger
+	obs        storage.Observer
+	mu         sync.RWMutex
 }
 
 // NewMemStorage создает новый экземпляр MemStorage
 func NewMemStorage() *MemStorage {
 	return &MemStorage{
 		metrics: make(map[storage.MetricType]map[string]interface{}),
+		log:     zap.NewNop(),
 	}
 }
 
-func (m *MemStorage) GetJSONMetric(ctx context.Context, metric *models.Metrics) error {
+// SetLogger задает логгер хранилища. В запросах используется логгер из контекста с идентификатором запроса.
+func (m *MemStorage) SetLogger(logger *zap.Logger) {
+	m.log = logger
+}
+
+// SetObserver задает получателя длительности операций и количества принятых метрик
+func (m *MemStorage) SetObserver(o storage.Observer) {
+	m.obs = o
+}
+
+func (m *MemStorage) GetJSONMetric(ctx context.Context, metric *models.Metrics) (err error) {
+	done := storage.StartOperation(m.obs, storage.OpGetJSONMetric)
+	defer func() { done(err) }()
 	m.mu.RLock()
 	defer m.mu.RUnlock()
 	metricType, err := utils.GetMetricTypeByCode(metric.MType)
@@ -36,7 +60,7 @@ func (m *MemStorage) GetJSONMetric(ctx context.Context, metric *models.Metrics)
 	if _, ok := m.metrics[metricType]; !ok {
 		m.metrics[metricType] = make(map[string]interface{})
 	}
-	realVal := m.metrics[metricType][metric.ID]
+	realVal := m.metrics[metricType][labels.Key(metric.ID, metric.Labels)]
 	switch metricType {
 	case storage.Gauge:
 		if f, ok := realVal.(float64); ok {
@@ -52,8 +76,8 @@ func (m *MemStorage) GetJSONMetric(ctx context.Context, metric *models.Metrics)
 			zeroValue := int64(0)
 			metric.Delta = &zeroValue
 		}
-		fmt.Printf("Get saved metric.Delta: %v\n", realVal)
-		fmt.Printf("Get returned metric.Delta: %v\n", *metric.Delta)
+		logging.FromContext(ctx, m.log).Debug("Get counter",
+			zap.String("id", metric.ID), zap.Any("saved", realVal), zap.Int64("returned", *metric.Delta))
 	default:
 		return fmt.Errorf("invalid metric type")
 	}
@@ -96,7 +120,9 @@ func (m *MemStorage) GetJSONMetrics(ctx context.Context, metric *[]models.Metric
 	return nil
 }
 
-func (m *MemStorage) UpdateJSONMetric(ctx context.Context, metric *models.Metrics) error {
+func (m *MemStorage) UpdateJSONMetric(ctx context.Context, metric *models.Metrics) (err error) {
+	done := storage.StartOperation(m.obs, storage.OpUpdateJSONMetric)
+	defer func() { done(err) }()
 	m.mu.Lock()
 	defer m.mu.Unlock()
 	metricType, err := utils.GetMetricTypeByCode(metric.MType)
@@ -104,6 +130,10 @@ func (m *MemStorage) UpdateJSONMetric(ctx context.Context, metric *models.Metric
 	if err != nil {
 		return err
 	}
+	if err := labels.Validate(metric.Labels); err != nil {
+		return err
+	}
+	key := labels.Key(metric.ID, metric.Labels)
 	if m.metrics == nil {
 		m.metrics = make(map[storage.MetricType]map[string]interface{})
 	}
@@ -116,27 +146,32 @@ func (m *MemStorage) UpdateJSONMetric(ctx context.Context, metric *models.Metric
 			zeroValue := float64(0)
 			metric.Value = &zeroValue
 		}
-		m.metrics[metricType][metric.ID] = *metric.Value
+		m.metrics[metricType][key] = *metric.Value
+		m.recordHistory(metricType, key, *metric.Value)
 	case storage.Counter:
 		if metric.Delta == nil {
 			zeroValue := int64(0)
 			metric.Delta = &zeroValue
 		}
-		currentCounter, ok := m.metrics[metricType][metric.ID].(int64)
+		currentCounter, ok := m.metrics[metricType][key].(int64)
 		if ok {
-			m.metrics[metricType][metric.ID] = currentCounter + *metric.Delta
+			m.metrics[metricType][key] = currentCounter + *metric.Delta
 		} else {
-			m.metrics[metricType][metric.ID] = *metric.Delta
+			m.metrics[metricType][key] = *metric.Delta
 		}
+		m.recordHistory(metricType, key, float64(m.metrics[metricType][key].(int64)))
 	default:
 		return fmt.Errorf("invalid metric type")
 	}
 
+	storage.ObserveIngested(m.obs, metricType, 1)
 	return nil
 }
 
 // UpdateMetric обновляет значение метрики в хранилище
-func (m *MemStorage) UpdateMetric(ctx context.Context, mtype string, name string, value string) error {
+func (m *MemStorage) UpdateMetric(ctx context.Context, mtype string, name string, value string) (err error) {
+	done := storage.StartOperation(m.obs, storage.OpUpdateMetric)
+	defer func() { done(err) }()
 	m.mu.Lock()
 	defer m.mu.Unlock()
 	// var metricType MetricType
@@ -153,6 +188,7 @@ func (m *MemStorage) UpdateMetric(ctx context.Context, mtype string, name string
 	case storage.Gauge:
 		if floatValue, err := strconv.ParseFloat(value, 64); err == nil {
 			m.metrics[metricType][name] = floatValue
+			m.recordHistory(metricType, name, floatValue)
 		} else {
 			return fmt.Errorf("invalid metric value: %v", err)
 		}
@@ -164,6 +200,7 @@ func (m *MemStorage) UpdateMetric(ctx context.Context, mtype string, name string
 			} else {
 				m.metrics[metricType][name] = intValue
 			}
+			m.recordHistory(metricType, name, float64(m.metrics[metricType][name].(int64)))
 		} else {
 			return fmt.Errorf("invalid metric value: %v", err)
 		}
@@ -171,36 +208,149 @@ func (m *MemStorage) UpdateMetric(ctx context.Context, mtype string, name string
 		return fmt.Errorf("invalid metric type")
 	}
 
+	storage.ObserveIngested(m.obs, metricType, 1)
 	return nil
 }
 
-func (m *MemStorage) UpdateJSONMetrics(ctx context.Context, metrics *[]models.Metrics) error {
-	// var metricType MetricType
+// UpdateJSONMetrics атомарно применяет пачку метрик: вся пачка проверяется и применяется
+// под одной блокировкой, при ошибке в любом элементе хранилище не меняется.
+// Дельты счетчиков с одним ключом суммируются, для gauge остается последнее значение.
+func (m *MemStorage) UpdateJSONMetrics(ctx context.Context, metrics *[]models.Metrics) (err error) {
+	done := storage.StartOperation(m.obs, storage.OpUpdateJSONMetrics)
+	defer func() { done(err) }()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := storage.ValidateMetrics(*metrics); err != nil {
+		return err
+	}
 
+	if m.metrics == nil {
+		m.metrics = make(map[storage.MetricType]map[string]interface{})
+	}
+	for _, metric := range *metrics {
+		metricType := storage.MetricType(metric.MType)
+		if _, ok := m.metrics[metricType]; !ok {
+			m.metrics[metricType] = make(map[string]interface{})
+		}
+		key := labels.Key(metric.ID, metric.Labels)
+		switch metricType {
+		case storage.Gauge:
+			m.metrics[metricType][key] = *metric.Value
+			m.recordHistory(metricType, key, *metric.Value)
+		case storage.Counter:
+			current, _ := m.metrics[metricType][key].(int64)
+			m.metrics[metricType][key] = current + *metric.Delta
+			m.recordHistory(metricType, key, float64(current+*metric.Delta))
+		}
+	}
+
+	storage.ObserveBatch(m.obs, *metrics)
 	return nil
 }
 
 // GetMetric возвращает текущие метрики из хранилища для типа
 func (m *MemStorage) GetMetric(ctx context.Context, mtype storage.MetricType) map[string]interface{} {
+	defer storage.StartOperation(m.obs, storage.OpGetMetric)(nil)
 	m.mu.RLock()
 	defer m.mu.RUnlock()
-	return m.metrics[mtype]
+	return copyMetrics(m.metrics[mtype])
 }
 
-// GetMetrics возвращает текущие метрики из хранилища == getState
+// GetMetrics возвращает копию текущих метрик хранилища == getState.
+// Копия снимается под блокировкой, поэтому ее можно читать параллельно с записью метрик.
 func (m *MemStorage) GetMetrics(ctx context.Context) map[storage.MetricType]map[string]interface{} {
+	defer storage.StartOperation(m.obs, storage.OpGetMetrics)(nil)
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	result := make(map[storage.MetricType]map[string]interface{}, len(m.metrics))
+	for mtype, metrics := range m.metrics {
+		result[mtype] = copyMetrics(metrics)
+	}
+	return result
+}
+
+// copyMetrics копирует метрики одного типа, вызывается под блокировкой
+func copyMetrics(metrics map[string]interface{}) map[string]interface{} {
+	if metrics == nil {
+		return nil
+	}
+	result := make(map[string]interface{}, len(metrics))
+	for key, value := range metrics {
+		result[key] = value
+	}
+	return result
+}
+
+// GetMetricsByLabels возвращает метрики, метки которых соответствуют селектору
+func (m *MemStorage) GetMetricsByLabels(ctx context.Context, selector labels.Selector) map[storage.MetricType]map[string]interface{} {
+	defer storage.StartOperation(m.obs, storage.OpGetMetricsLabels)(nil)
 	m.mu.RLock()
 	defer m.mu.RUnlock()
-	return m.metrics
+	result := make(map[storage.MetricType]map[string]interface{})
+	for mtype, metrics := range m.metrics {
+		for key, value := range metrics {
+			if !selector.MatchesKey(key) {
+				continue
+			}
+			if _, ok := result[mtype]; !ok {
+				result[mtype] = make(map[string]interface{})
+			}
+			result[mtype][key] = value
+		}
+	}
+	return result
 }
 
 // SetMetrics метод вызывается при инициализации для перезаписи всего хранилища == setState
 func (m *MemStorage) SetMetrics(ctx context.Context, metrics map[storage.MetricType]map[string]interface{}) {
+	defer storage.StartOperation(m.obs, storage.OpSetMetrics)(nil)
 	m.mu.Lock()
 	defer m.mu.Unlock()
 	m.metrics = metrics
 }
 
+// SetHistoryConfig включает ведение истории значений с заданными сроком хранения и прореживанием
+func (m *MemStorage) SetHistoryConfig(cfg history.Config) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.historyCfg = cfg
+}
+
+// recordHistory добавляет значение в историю метрики, вызывается под блокировкой на запись
+func (m *MemStorage) recordHistory(mtype storage.MetricType, name string, value float64) {
+	if !m.historyCfg.Enabled() {
+		return
+	}
+	if m.history == nil {
+		m.history = make(map[storage.MetricType]map[string][]models.HistoryPoint)
+	}
+	if _, ok := m.history[mtype]; !ok {
+		m.history[mtype] = make(map[string][]models.HistoryPoint)
+	}
+	m.history[mtype][name] = m.historyCfg.Add(m.history[mtype][name], m.timeNow(), value)
+}
+
+// GetHistory возвращает историю метрики за интервал [from, to], прореженную с шагом step
+func (m *MemStorage) GetHistory(ctx context.Context, mtype storage.MetricType, name string, from, to time.Time, step time.Duration) (_ []models.HistoryPoint, err error) {
+	done := storage.StartOperation(m.obs, storage.OpGetHistory)
+	defer func() { done(err) }()
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if !m.historyCfg.Enabled() {
+		return nil, fmt.Errorf("история метрик отключена")
+	}
+	points := history.Range(m.history[mtype][name], from, to)
+	return history.Downsample(points, mtype, from, step), nil
+}
+
+func (m *MemStorage) timeNow() time.Time {
+	if m.now != nil {
+		return m.now()
+	}
+	return time.Now()
+}
+
 func (m *MemStorage) Ping() error {
 	return fmt.Errorf("формат хранения не поддерживает бд")
 }
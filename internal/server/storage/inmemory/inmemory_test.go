@@ -6,11 +6,14 @@ import (
 	"fmt"
 	"reflect"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 
+	"github.com/Arcadian-Sky/musthave-metrics/internal/labels"
 	"github.com/Arcadian-Sky/musthave-metrics/internal/server/models"
 	"github.com/Arcadian-Sky/musthave-metrics/internal/server/storage"
+	"github.com/Arcadian-Sky/musthave-metrics/internal/server/storage/history"
 	"github.com/Arcadian-Sky/musthave-metrics/internal/server/storage/utils"
 )
 
@@ -658,6 +661,42 @@ func TestMemStorage_UpdateJSONMetrics(t *testing.T) {
 	}
 }
 
+func TestMemStorage_UpdateJSONMetricsAtomic(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemStorage()
+
+	value := 1.5
+	delta := int64(2)
+	batch := []models.Metrics{
+		{ID: "Alloc", MType: "gauge", Value: &value},
+		{ID: "PollCount", MType: "counter", Delta: &delta},
+		{ID: "PollCount", MType: "counter", Delta: &delta},
+	}
+	assert.NoError(t, m.UpdateJSONMetrics(ctx, &batch))
+	assert.Equal(t, map[storage.MetricType]map[string]interface{}{
+		storage.Gauge:   {"Alloc": 1.5},
+		storage.Counter: {"PollCount": int64(4)},
+	}, m.GetMetrics(ctx))
+
+	// Ошибка в последнем элементе не дает применить всю пачку
+	newValue := 3.0
+	batch = []models.Metrics{
+		{ID: "Alloc", MType: "gauge", Value: &newValue},
+		{ID: "PollCount", MType: "counter", Delta: &delta},
+		{ID: "Broken", MType: "counter"},
+	}
+	err := m.UpdateJSONMetrics(ctx, &batch)
+	var batchErr *storage.BatchError
+	if assert.ErrorAs(t, err, &batchErr) {
+		assert.Equal(t, 2, batchErr.Index)
+		assert.Equal(t, "Broken", batchErr.ID)
+	}
+	assert.Equal(t, map[storage.MetricType]map[string]interface{}{
+		storage.Gauge:   {"Alloc": 1.5},
+		storage.Counter: {"PollCount": int64(4)},
+	}, m.GetMetrics(ctx))
+}
+
 func TestMemStorage_CreateMemento(t *testing.T) {
 	var expected = `{"metrics":{"counter":{"metric1":100,"metric2":200},"gauge":{"metric3":300.5}}}`
 	var metrics = make(map[storage.MetricType]map[string]interface{})
@@ -718,3 +757,126 @@ func TestMemStorage_RestoreFromMemento(t *testing.T) {
 		t.Errorf("Expected result %v, got %v", string(respJSONData), expected)
 	}
 }
+
+func TestMemStorage_GetHistory(t *testing.T) {
+	ctx := context.Background()
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	now := start
+
+	m := NewMemStorage()
+	m.now = func() time.Time { return now }
+
+	_, err := m.GetHistory(ctx, storage.Gauge, "Alloc", start, start.Add(time.Hour), 0)
+	assert.Error(t, err, "история выключена по умолчанию")
+
+	m.SetHistoryConfig(history.Config{Retention: time.Hour, Resolution: 10 * time.Second})
+	for i, value := range []string{"1", "3", "5"} {
+		now = start.Add(time.Duration(i) * 30 * time.Second)
+		assert.NoError(t, m.UpdateMetric(ctx, "gauge", "Alloc", value))
+		assert.NoError(t, m.UpdateMetric(ctx, "counter", "PollCount", "1"))
+	}
+
+	points, err := m.GetHistory(ctx, storage.Gauge, "Alloc", start, now, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, []models.HistoryPoint{
+		{Time: start, Value: 1},
+		{Time: start.Add(30 * time.Second), Value: 3},
+		{Time: start.Add(time.Minute), Value: 5},
+	}, points)
+
+	points, err = m.GetHistory(ctx, storage.Counter, "PollCount", start, now, time.Minute)
+	assert.NoError(t, err)
+	assert.Equal(t, []models.HistoryPoint{
+		{Time: start, Value: 2},
+		{Time: start.Add(time.Minute), Value: 3},
+	}, points)
+}
+
+func TestMemStorage_Labels(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemStorage()
+
+	delta := int64(2)
+	for _, host := range []string{"a", "b", "a"} {
+		d := delta
+		assert.NoError(t, m.UpdateJSONMetric(ctx, &models.Metrics{
+			ID:     "PollCount",
+			MType:  "counter",
+			Delta:  &d,
+			Labels: map[string]string{"host": host},
+		}))
+	}
+	assert.NoError(t, m.UpdateMetric(ctx, "counter", "PollCount", "1"))
+
+	assert.Equal(t, map[string]interface{}{
+		"PollCount":           int64(1),
+		`PollCount{host="a"}`: int64(4),
+		`PollCount{host="b"}`: int64(2),
+	}, m.GetMetric(ctx, storage.Counter))
+
+	metric := &models.Metrics{ID: "PollCount", MType: "counter", Labels: map[string]string{"host": "a"}}
+	assert.NoError(t, m.GetJSONMetric(ctx, metric))
+	assert.Equal(t, int64(4), *metric.Delta)
+
+	selector, err := labels.ParseSelector("host=b")
+	assert.NoError(t, err)
+	assert.Equal(t, map[storage.MetricType]map[string]interface{}{
+		storage.Counter: {`PollCount{host="b"}`: int64(2)},
+	}, m.GetMetricsByLabels(ctx, selector))
+
+	err = m.UpdateJSONMetric(ctx, &models.Metrics{
+		ID:     "PollCount",
+		MType:  "counter",
+		Delta:  &delta,
+		Labels: map[string]string{"host-name": "a"},
+	})
+	assert.Error(t, err)
+}
+
+// fakeObserver запоминает операции и принятые метрики
+type fakeObserver struct {
+	ops      map[string]int
+	failed   map[string]int
+	ingested map[storage.MetricType]int
+}
+
+func newFakeObserver() *fakeObserver {
+	return &fakeObserver{ops: map[string]int{}, failed: map[string]int{}, ingested: map[storage.MetricType]int{}}
+}
+
+func (o *fakeObserver) ObserveOperation(op string, d time.Duration, err error) {
+	o.ops[op]++
+	if err != nil {
+		o.failed[op]++
+	}
+}
+
+func (o *fakeObserver) ObserveIngested(mtype storage.MetricType, n int) {
+	o.ingested[mtype] += n
+}
+
+func TestMemStorage_Observer(t *testing.T) {
+	m := NewMemStorage()
+	obs := newFakeObserver()
+	m.SetObserver(obs)
+	ctx := context.Background()
+
+	assert.NoError(t, m.UpdateMetric(ctx, "gauge", "Alloc", "1.5"))
+	assert.Error(t, m.UpdateMetric(ctx, "gauge", "Alloc", "abc"))
+	delta := int64(2)
+	value := 3.5
+	assert.NoError(t, m.UpdateJSONMetric(ctx, &models.Metrics{ID: "PollCount", MType: "counter", Delta: &delta}))
+	assert.NoError(t, m.UpdateJSONMetrics(ctx, &[]models.Metrics{
+		{ID: "PollCount", MType: "counter", Delta: &delta},
+		{ID: "Free", MType: "gauge", Value: &value},
+		{ID: "Total", MType: "gauge", Value: &value},
+	}))
+	m.GetMetrics(ctx)
+
+	assert.Equal(t, 2, obs.ops[storage.OpUpdateMetric])
+	assert.Equal(t, 1, obs.failed[storage.OpUpdateMetric])
+	assert.Equal(t, 1, obs.ops[storage.OpUpdateJSONMetric])
+	assert.Equal(t, 1, obs.ops[storage.OpUpdateJSONMetrics])
+	assert.Equal(t, 1, obs.ops[storage.OpGetMetrics])
+	assert.Equal(t, map[storage.MetricType]int{storage.Gauge: 3, storage.Counter: 2}, obs.ingested)
+}
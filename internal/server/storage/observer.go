@@ -0,0 +1,65 @@
+package storage
+
+import (
+	"time"
+
+	"github.com/Arcadian-Sky/musthave-metrics/internal/server/models"
+)
+
+// Имена операций хранилища для Observer
+const (
+	OpGetMetric         = "get_metric"
+	OpGetJSONMetric     = "get_json_metric"
+	OpGetMetrics        = "get_metrics"
+	OpGetMetricsLabels  = "get_metrics_by_labels"
+	OpGetHistory        = "get_history"
+	OpUpdateMetric      = "update_metric"
+	OpUpdateJSONMetric  = "update_json_metric"
+	OpUpdateJSONMetrics = "update_json_metrics"
+	OpSetMetrics        = "set_metrics"
+	OpPing              = "ping"
+)
+
+// Observer получает длительность операций хранилища и количество принятых метрик.
+// Задается хранилищу через SetObserver, вызывается из горутин запросов одновременно.
+type Observer interface {
+	ObserveOperation(op string, d time.Duration, err error)
+	ObserveIngested(mtype MetricType, n int)
+}
+
+// StartOperation засекает время операции op. Возвращенная функция передает в o длительность и ошибку операции.
+// Без Observer ничего не делает.
+func StartOperation(o Observer, op string) func(err error) {
+	if o == nil {
+		return func(error) {}
+	}
+	start := time.Now()
+	return func(err error) {
+		o.ObserveOperation(op, time.Since(start), err)
+	}
+}
+
+// ObserveIngested передает в o количество принятых метрик типа mtype
+func ObserveIngested(o Observer, mtype MetricType, n int) {
+	if o != nil && n > 0 {
+		o.ObserveIngested(mtype, n)
+	}
+}
+
+// ObserveBatch передает в o количество принятых метрик каждого типа из пачки
+func ObserveBatch(o Observer, metrics []models.Metrics) {
+	if o == nil {
+		return
+	}
+	var gauges, counters int
+	for _, m := range metrics {
+		switch MetricType(m.MType) {
+		case Gauge:
+			gauges++
+		case Counter:
+			counters++
+		}
+	}
+	ObserveIngested(o, Gauge, gauges)
+	ObserveIngested(o, Counter, counters)
+}
@@ -3,19 +3,25 @@ package postgres
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"log"
 	"strconv"
+	"sync"
 	"time"
 
 	"github.com/cenkalti/backoff/v4"
 	"github.com/jackc/pgerrcode"
 	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/pressly/goose/v3"
+	"go.uber.org/zap"
 
+	"github.com/Arcadian-Sky/musthave-metrics/internal/labels"
+	"github.com/Arcadian-Sky/musthave-metrics/internal/logging"
 	"github.com/Arcadian-Sky/musthave-metrics/internal/server/models"
 	"github.com/Arcadian-Sky/musthave-metrics/internal/server/storage"
+	"github.com/Arcadian-Sky/musthave-metrics/internal/server/storage/history"
 	"github.com/Arcadian-Sky/musthave-metrics/internal/server/storage/utils"
 	"github.com/Arcadian-Sky/musthave-metrics/migrations"
 )
@@ -26,6 +32,16 @@ type PostgresStorage struct {
 	retriableErrorMap map[string]bool
 	maxRetries        int
 	initialDelay      time.Duration
+	historyCfg        history.Config
+	lastPrune         time.Time
+	pruneMu           sync.Mutex
+	log               *zap.Logger
+	obs               storage.Observer
+}
+
+// execer общий интерфейс *sql.DB и *sql.Tx для выполнения запросов
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
 }
 
 // NewPostgresStorage создает новый экземпляр PostgresStorage
@@ -37,6 +53,7 @@ func NewPostgresStorage(db *sql.DB) *PostgresStorage {
 		},
 		maxRetries:   3,
 		initialDelay: time.Second,
+		log:          zap.NewNop(),
 	}
 	err := p.migrateDB()
 	if err != nil {
@@ -45,7 +62,22 @@ func NewPostgresStorage(db *sql.DB) *PostgresStorage {
 	return p
 }
 
-func (p *PostgresStorage) executeWithRetry(_ context.Context, operation func() error) error {
+// SetLogger задает логгер хранилища. В запросах используется логгер из контекста с идентификатором запроса.
+func (p *PostgresStorage) SetLogger(logger *zap.Logger) {
+	p.log = logger
+}
+
+// SetObserver задает получателя длительности операций и количества принятых метрик
+func (p *PostgresStorage) SetObserver(o storage.Observer) {
+	p.obs = o
+}
+
+// logger возвращает логгер запроса или логгер хранилища
+func (p *PostgresStorage) logger(ctx context.Context) *zap.Logger {
+	return logging.FromContext(ctx, p.log)
+}
+
+func (p *PostgresStorage) executeWithRetry(ctx context.Context, operation func() error) error {
 	// Создаем экземпляр стратегии повторных попыток
 	backoffStrategy := backoff.NewExponentialBackOff()
 	backoffStrategy.MaxElapsedTime = time.Duration(p.maxRetries) * p.initialDelay
@@ -54,7 +86,7 @@ func (p *PostgresStorage) executeWithRetry(_ context.Context, operation func() e
 	retryOperation := func() error {
 		err := operation()
 		if err != nil && p.isRetriableError(err) {
-			fmt.Printf("Retriable error occurred: %v\n", err)
+			p.logger(ctx).Warn("Retriable error occurred", zap.Error(err))
 			return err
 		}
 		return nil
@@ -76,14 +108,124 @@ func (p *PostgresStorage) getTableName() string {
 	return "metrics"
 }
 
-func (p *PostgresStorage) Ping() error {
-	err := p.db.Ping()
+func (p *PostgresStorage) getHistoryTableName() string {
+	return "metric_history"
+}
+
+// labelsJSON возвращает метки в виде JSON для столбца labels
+func labelsJSON(l map[string]string) (string, error) {
+	if len(l) == 0 {
+		return "{}", nil
+	}
+	b, err := json.Marshal(l)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// SetHistoryConfig включает ведение истории значений с заданными сроком хранения и прореживанием
+func (p *PostgresStorage) SetHistoryConfig(cfg history.Config) {
+	p.historyCfg = cfg
+}
+
+// recordHistory сохраняет значение в историю метрики.
+// Значения, попавшие в один интервал прореживания, перезаписываются.
+func (p *PostgresStorage) recordHistory(ctx context.Context, ex execer, mtype storage.MetricType, name string, value float64) error {
+	if !p.historyCfg.Enabled() {
+		return nil
+	}
+	now := time.Now()
+	query := "INSERT INTO " + p.getHistoryTableName() + " (name, type, ts, value)" +
+		" VALUES ($1, $2, $3, $4)" +
+		" ON CONFLICT (name, type, ts) DO UPDATE" +
+		" SET value = EXCLUDED.value"
+	_, err := ex.ExecContext(ctx, query, name, string(mtype), p.historyCfg.Bucket(now), value)
+	if err != nil {
+		return fmt.Errorf("ошибка при записи истории метрики: %v", err)
+	}
+	return nil
+}
+
+// pruneHistory удаляет точки старше срока хранения, не чаще одного раза в минуту.
+// Вызывается после записи метрик вне их транзакции, поэтому время очистки запоминается,
+// только если удаление выполнено. Пока идет одна очистка, другие запросы ее пропускают.
+// Ошибка очистки пишется в лог: метрики к этому моменту уже записаны.
+func (p *PostgresStorage) pruneHistory(ctx context.Context) {
+	if !p.historyCfg.Enabled() || !p.pruneMu.TryLock() {
+		return
+	}
+	defer p.pruneMu.Unlock()
+	now := time.Now()
+	if now.Sub(p.lastPrune) < time.Minute {
+		return
+	}
+	_, err := p.db.ExecContext(ctx, "DELETE FROM "+p.getHistoryTableName()+" WHERE ts < $1", now.Add(-p.historyCfg.Retention))
+	if err != nil {
+		p.logger(ctx).Error("Ошибка при очистке истории метрик", zap.Error(err))
+		return
+	}
+	p.lastPrune = now
+}
+
+// GetHistory возвращает историю метрики за интервал [from, to], прореженную с шагом step
+func (p *PostgresStorage) GetHistory(ctx context.Context, mtype storage.MetricType, name string, from, to time.Time, step time.Duration) (_ []models.HistoryPoint, err error) {
+	done := storage.StartOperation(p.obs, storage.OpGetHistory)
+	defer func() { done(err) }()
+	if !p.historyCfg.Enabled() {
+		return nil, fmt.Errorf("история метрик отключена")
+	}
+	query := "SELECT ts, value FROM " + p.getHistoryTableName() +
+		" WHERE name = $1 AND type = $2 AND ts BETWEEN $3 AND $4 ORDER BY ts"
+	rows, err := p.db.QueryContext(ctx, query, name, string(mtype), from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	points := make([]models.HistoryPoint, 0)
+	for rows.Next() {
+		var point models.HistoryPoint
+		if err := rows.Scan(&point.Time, &point.Value); err != nil {
+			return nil, err
+		}
+		points = append(points, point)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return history.Downsample(points, mtype, from, step), nil
+}
+
+func (p *PostgresStorage) Ping() (err error) {
+	done := storage.StartOperation(p.obs, storage.OpPing)
+	defer func() { done(err) }()
+	err = p.db.Ping()
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
+// MigrationStatus возвращает версию схемы базы и последнюю версию среди встроенных миграций
+func (p *PostgresStorage) MigrationStatus(ctx context.Context) (current, latest int64, err error) {
+	current, err = goose.GetDBVersionContext(ctx, p.db)
+	if err != nil {
+		return 0, 0, err
+	}
+	goose.SetBaseFS(migrations.Migrations)
+	all, err := goose.CollectMigrations(".", 0, goose.MaxVersion)
+	if err != nil {
+		return current, 0, err
+	}
+	last, err := all.Last()
+	if err != nil {
+		return current, 0, err
+	}
+	return current, last.Version, nil
+}
+
 func (p *PostgresStorage) migrateDB() error {
 	goose.SetBaseFS(migrations.Migrations)
 
@@ -99,6 +241,7 @@ func (p *PostgresStorage) migrateDB() error {
 }
 
 func (p *PostgresStorage) GetMetric(ctx context.Context, mtype storage.MetricType) map[string]interface{} {
+	defer storage.StartOperation(p.obs, storage.OpGetMetric)(nil)
 	var query string
 	var metrics map[string]interface{}
 	// fmt.Printf("mtype: %v\n", mtype)
@@ -151,13 +294,16 @@ func (p *PostgresStorage) GetMetric(ctx context.Context, mtype storage.MetricTyp
 	return metrics //, nil
 }
 
-func (p *PostgresStorage) GetJSONMetric(ctx context.Context, metric *models.Metrics) error {
+func (p *PostgresStorage) GetJSONMetric(ctx context.Context, metric *models.Metrics) (err error) {
+	done := storage.StartOperation(p.obs, storage.OpGetJSONMetric)
+	defer func() { done(err) }()
 	query := fmt.Sprintf("SELECT name, type, counter, gauge FROM %s WHERE name = $1 AND type = $2", p.getTableName())
-	row := p.db.QueryRowContext(ctx, query, metric.ID, metric.MType)
+	row := p.db.QueryRowContext(ctx, query, labels.Key(metric.ID, metric.Labels), metric.MType)
 
+	var name string
 	var counter sql.NullInt64
 	var gauge sql.NullFloat64
-	err := row.Scan(&metric.ID, &metric.MType, &counter, &gauge)
+	err = row.Scan(&name, &metric.MType, &counter, &gauge)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			// return nil
@@ -191,20 +337,46 @@ func (p *PostgresStorage) GetJSONMetric(ctx context.Context, metric *models.Metr
 
 // TODO:Add support error handling
 func (p *PostgresStorage) GetMetrics(ctx context.Context) map[storage.MetricType]map[string]interface{} {
-	metrics := make(map[storage.MetricType]map[string]interface{})
+	defer storage.StartOperation(p.obs, storage.OpGetMetrics)(nil)
 	query := fmt.Sprintf("SELECT name, type, counter, gauge FROM %s", p.getTableName())
+	return p.queryMetrics(ctx, query)
+}
+
+// GetMetricsByLabels возвращает метрики, метки которых соответствуют селектору.
+// Условия на равенство проверяются в базе по индексу на столбце labels, остальные - после выборки.
+func (p *PostgresStorage) GetMetricsByLabels(ctx context.Context, selector labels.Selector) map[storage.MetricType]map[string]interface{} {
+	defer storage.StartOperation(p.obs, storage.OpGetMetricsLabels)(nil)
+	eq, err := labelsJSON(selector.Equalities())
+	if err != nil {
+		return nil
+	}
+	query := fmt.Sprintf("SELECT name, type, counter, gauge FROM %s WHERE labels @> $1::jsonb", p.getTableName())
+	metrics := p.queryMetrics(ctx, query, eq)
+	for _, values := range metrics {
+		for key := range values {
+			if !selector.MatchesKey(key) {
+				delete(values, key)
+			}
+		}
+	}
+	return metrics
+}
+
+// queryMetrics выполняет запрос, возвращающий name, type, counter, gauge, и собирает метрики по типам
+func (p *PostgresStorage) queryMetrics(ctx context.Context, query string, args ...any) map[storage.MetricType]map[string]interface{} {
+	metrics := make(map[storage.MetricType]map[string]interface{})
 
 	// Выполнение запроса SQL
-	rows, err := p.db.Query(query)
+	rows, err := p.db.QueryContext(ctx, query, args...)
 	if err != nil {
-		fmt.Printf("1err: %v\n", err)
+		p.logger(ctx).Error("Ошибка при выполнении запроса", zap.Error(err))
 		return nil
 		//, fmt.Errorf("ошибка при выполнении запроса: %v", err)
 	}
 	defer rows.Close()
 	// Проверка наличия ошибок при чтении строк результата
 	if rows.Err() != nil {
-		fmt.Printf("2err: %v\n", err)
+		p.logger(ctx).Error("Ошибка при чтении строк результата", zap.Error(rows.Err()))
 		return nil
 		//, fmt.Errorf("ошибка при чтении строк результата: %v", rows.Err())
 	}
@@ -219,14 +391,14 @@ func (p *PostgresStorage) GetMetrics(ctx context.Context) map[storage.MetricType
 
 		// Сканирование значений строки результата
 		if err := rows.Scan(&row.name, &row.mType, &row.counter, &row.gauge); err != nil {
-			fmt.Printf("3err: %v\n", err)
+			p.logger(ctx).Error("Ошибка при сканировании строки", zap.Error(err))
 			return nil
 			//, fmt.Errorf("ошибка при сканировании строки: %v", err)
 		}
 		// Преобразование строкового представления типа метрики в тип MetricType
 		metricType, err := utils.GetMetricTypeByCode(row.mType)
 		if err != nil {
-			fmt.Printf("3err: %v\n", err)
+			p.logger(ctx).Error("Ошибка при преобразовании типа метрики", zap.Error(err))
 			return nil
 			//, fmt.Errorf("ошибка при преобразовании типа метрики: %v", err)
 		}
@@ -251,7 +423,9 @@ func (p *PostgresStorage) GetJSONMetrics(ctx context.Context, metric *[]models.M
 	return nil
 }
 
-func (p *PostgresStorage) UpdateMetric(ctx context.Context, mtype string, name string, value string) error {
+func (p *PostgresStorage) UpdateMetric(ctx context.Context, mtype string, name string, value string) (err error) {
+	done := storage.StartOperation(p.obs, storage.OpUpdateMetric)
+	defer func() { done(err) }()
 	// Получаем тип метрики
 	metricType, err := utils.GetMetricTypeByCode(mtype)
 	if err != nil {
@@ -301,57 +475,87 @@ func (p *PostgresStorage) UpdateMetric(ctx context.Context, mtype string, name s
 	if err != nil {
 		return fmt.Errorf("ошибка при обновлении метрики в базе данных: %v", err)
 	}
+	storage.ObserveIngested(p.obs, metricType, 1)
+	switch v := reValue.(type) {
+	case float64:
+		err = p.recordHistory(ctx, p.db, metricType, name, v)
+	case int64:
+		err = p.recordHistory(ctx, p.db, metricType, name, float64(v))
+	}
+	if err != nil {
+		return err
+	}
+	p.pruneHistory(ctx)
 	return nil
 }
 
-func (p *PostgresStorage) UpdateJSONMetric(ctx context.Context, metric *models.Metrics) error {
+func (p *PostgresStorage) UpdateJSONMetric(ctx context.Context, metric *models.Metrics) (err error) {
+	done := storage.StartOperation(p.obs, storage.OpUpdateJSONMetric)
+	defer func() { done(err) }()
 	mType, err := utils.GetMetricTypeByCode(metric.MType)
 	if err != nil {
 		return err
 	}
+	if err := labels.Validate(metric.Labels); err != nil {
+		return err
+	}
+	key := labels.Key(metric.ID, metric.Labels)
+	metricLabels, err := labelsJSON(metric.Labels)
+	if err != nil {
+		return err
+	}
 	var query string
 	var value any
+	var historyValue float64
 	switch mType {
 	case storage.Gauge:
 		if metric.Value == nil {
 			return nil
 		}
 		query = fmt.Sprintf(`
-				INSERT INTO %s (name, type, gauge)
-				VALUES ($1, 'gauge', $2)
+				INSERT INTO %s (name, type, gauge, labels)
+				VALUES ($1, 'gauge', $2, $3)
 				ON CONFLICT (name, type) DO UPDATE
 				SET gauge = EXCLUDED.gauge
 			`, p.getTableName())
 		value = metric.Value
+		historyValue = *metric.Value
 	case storage.Counter:
 		if metric.Delta == nil {
 			return nil
 		}
 		var currentCounter sql.NullInt64
-		_ = p.db.QueryRowContext(ctx, "SELECT counter FROM "+p.getTableName()+" WHERE name = $1 AND type = 'counter'", metric.ID).Scan(&currentCounter)
+		_ = p.db.QueryRowContext(ctx, "SELECT counter FROM "+p.getTableName()+" WHERE name = $1 AND type = 'counter'", key).Scan(&currentCounter)
 
 		*metric.Delta += currentCounter.Int64
 		// fmt.Printf("metric.Delta: %v\n", metric.Delta)
-		query = "INSERT INTO " + p.getTableName() + " (name, type, counter)" +
-			" VALUES ($1, 'counter', $2)" +
+		query = "INSERT INTO " + p.getTableName() + " (name, type, counter, labels)" +
+			" VALUES ($1, 'counter', $2, $3)" +
 			" ON CONFLICT (name, type) DO UPDATE" +
 			" SET counter = EXCLUDED.counter"
 		value = metric.Delta
+		historyValue = float64(*metric.Delta)
 	default:
 		return fmt.Errorf("неподдерживаемый тип метрики: %s", mType)
 	}
 
-	_, err = p.db.Exec(query, metric.ID, value)
+	_, err = p.db.Exec(query, key, value, metricLabels)
 
 	if err != nil {
 		return fmt.Errorf("ошибка при обновлении метрики в базе данных: %v", err)
 	}
+	storage.ObserveIngested(p.obs, mType, 1)
 
+	if err = p.recordHistory(ctx, p.db, mType, key, historyValue); err != nil {
+		return err
+	}
+	p.pruneHistory(ctx)
 	return nil
 }
 
 // TODO:Add support error handling
 func (p *PostgresStorage) SetMetrics(ctx context.Context, metrics map[storage.MetricType]map[string]interface{}) {
+	defer storage.StartOperation(p.obs, storage.OpSetMetrics)(nil)
 	// Начало транзакции
 	tx, err := p.db.Begin()
 	if err != nil {
@@ -360,7 +564,7 @@ func (p *PostgresStorage) SetMetrics(ctx context.Context, metrics map[storage.Me
 	defer func() {
 		if err != nil {
 			if rollbackErr := tx.Rollback(); rollbackErr != nil {
-				log.Println("Ошибка отката транзакции:", rollbackErr)
+				p.logger(ctx).Error("Ошибка отката транзакции", zap.Error(rollbackErr))
 			}
 		}
 	}()
@@ -383,14 +587,21 @@ func (p *PostgresStorage) SetMetrics(ctx context.Context, metrics map[storage.Me
 		for name, value := range metricValues {
 			// Формирование запроса SQL для обновления или вставки метрики
 			query := fmt.Sprintf(`
-                INSERT INTO %s (name, type, %s)
-                VALUES ($1, $2, $3)
+                INSERT INTO %s (name, type, %s, labels)
+                VALUES ($1, $2, $3, $4)
                 ON CONFLICT (name, type) DO UPDATE
                 SET %s = EXCLUDED.%s
             `, p.getTableName(), columnName, columnName, columnName)
 
+			// Метки восстанавливаются из ключа ряда
+			_, l := labels.ParseKey(name)
+			metricLabels, err := labelsJSON(l)
+			if err != nil {
+				return
+			}
+
 			// Выполнение запроса SQL внутри транзакции
-			_, err := tx.Exec(query, name, string(metricType), value)
+			_, err = tx.Exec(query, name, string(metricType), value, metricLabels)
 			if err != nil {
 				return
 				// fmt.Errorf("ошибка при выполнении запроса: %v", err)
@@ -405,9 +616,16 @@ func (p *PostgresStorage) SetMetrics(ctx context.Context, metrics map[storage.Me
 	}
 }
 
-func (p *PostgresStorage) UpdateJSONMetrics(ctx context.Context, metrics *[]models.Metrics) error {
+func (p *PostgresStorage) UpdateJSONMetrics(ctx context.Context, metrics *[]models.Metrics) (err error) {
+	done := storage.StartOperation(p.obs, storage.OpUpdateJSONMetrics)
+	defer func() { done(err) }()
 	// ctxB := context.Background()
 
+	// Проверяем всю пачку до начала транзакции
+	if err := storage.ValidateMetrics(*metrics); err != nil {
+		return err
+	}
+
 	// Начинаем транзакцию
 	tx, err := p.db.Begin()
 	if err != nil {
@@ -416,56 +634,75 @@ func (p *PostgresStorage) UpdateJSONMetrics(ctx context.Context, metrics *[]mode
 	defer func() {
 		if err != nil {
 			if rollbackErr := tx.Rollback(); rollbackErr != nil {
-				log.Println("Ошибка отката транзакции:", rollbackErr)
+				p.logger(ctx).Error("Ошибка отката транзакции", zap.Error(rollbackErr))
 			}
 		}
 	}()
 
 	// Создаем карты для хранения сумм дельт метрик типа "counter" и значений метрик типа "gauge"
+	// Ключи карт - ключи рядов с учетом меток
 	counterDeltas := make(map[string]int64)
 	gaugeValues := make(map[string]float64)
+	seriesLabels := make(map[string]string)
 	// Обновляем значения в карты в соответствии с типом метрики
 	for _, metric := range *metrics {
+		key := labels.Key(metric.ID, metric.Labels)
+		if seriesLabels[key], err = labelsJSON(metric.Labels); err != nil {
+			return err
+		}
 		switch metric.MType {
 		case "counter":
-			counterDeltas[metric.ID] += *metric.Delta
+			counterDeltas[key] += *metric.Delta
 		case "gauge":
-			gaugeValues[metric.ID] = *metric.Value
+			gaugeValues[key] = *metric.Value
 		}
 	}
 
-	var queryString = "INSERT INTO %s (name, type, %[2]s)" +
-		" VALUES ($1, '%[2]s', $2)" +
+	// Counter прибавляет дельту к сохраненному значению, в историю пишется итоговое значение
+	counterQuery := "INSERT INTO " + p.getTableName() + " AS m (name, type, counter, labels)" +
+		" VALUES ($1, 'counter', $2, $3)" +
+		" ON CONFLICT (name, type) DO UPDATE" +
+		" SET counter = COALESCE(m.counter, 0) + EXCLUDED.counter" +
+		" RETURNING counter"
+	gaugeQuery := "INSERT INTO " + p.getTableName() + " (name, type, gauge, labels)" +
+		" VALUES ($1, 'gauge', $2, $3)" +
 		" ON CONFLICT (name, type) DO UPDATE" +
-		" SET %[2]s = EXCLUDED.%[2]s;"
+		" SET gauge = EXCLUDED.gauge"
 
 	// Вставляем значения метрик типа "counter" из карты в базу данных
 	for id, delta := range counterDeltas {
-		fmt.Printf("id, value: %v, %v\n", id, delta)
-		query := fmt.Sprintf(queryString, p.getTableName(), "counter")
-		_, err := tx.ExecContext(ctx, query, id, delta)
+		p.logger(ctx).Debug("Обновляем counter", zap.String("id", id), zap.Int64("delta", delta))
+		var total int64
+		err = tx.QueryRowContext(ctx, counterQuery, id, delta, seriesLabels[id]).Scan(&total)
 		if err != nil {
-			fmt.Printf("2 err.Error(): %v\n", err.Error())
+			p.logger(ctx).Error("Ошибка при обновлении counter", zap.String("id", id), zap.Error(err))
+			return err
+		}
+		if err = p.recordHistory(ctx, tx, storage.Counter, id, float64(total)); err != nil {
 			return err
 		}
 	}
 
 	// Вставляем значения метрик типа "gauge" из карты в базу данных
 	for id, value := range gaugeValues {
-		fmt.Printf("id, value: %v, %v\n", id, value)
-		query := fmt.Sprintf(queryString, p.getTableName(), "gauge")
-		_, err := tx.ExecContext(ctx, query, id, value)
+		p.logger(ctx).Debug("Обновляем gauge", zap.String("id", id), zap.Float64("value", value))
+		_, err = tx.ExecContext(ctx, gaugeQuery, id, value, seriesLabels[id])
 		if err != nil {
-			fmt.Printf("1 err.Error(): %v\n", err.Error())
+			p.logger(ctx).Error("Ошибка при обновлении gauge", zap.String("id", id), zap.Error(err))
+			return err
+		}
+		if err = p.recordHistory(ctx, tx, storage.Gauge, id, value); err != nil {
 			return err
 		}
 	}
 
 	// Коммитим транзакцию
-	if err := tx.Commit(); err != nil {
-		fmt.Printf("3 err.Error(): %v\n", err.Error())
+	if err = tx.Commit(); err != nil {
+		p.logger(ctx).Error("Ошибка при фиксации транзакции", zap.Error(err))
 		return err
 	}
 
+	storage.ObserveBatch(p.obs, *metrics)
+	p.pruneHistory(ctx)
 	return nil
 }
@@ -3,6 +3,7 @@ package postgres
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"testing"
 
@@ -12,8 +13,10 @@ import (
 	"github.com/jackc/pgerrcode"
 	"github.com/stretchr/testify/assert"
 
+	"github.com/Arcadian-Sky/musthave-metrics/internal/labels"
 	"github.com/Arcadian-Sky/musthave-metrics/internal/server/models"
 	"github.com/Arcadian-Sky/musthave-metrics/internal/server/storage"
+	"github.com/Arcadian-Sky/musthave-metrics/internal/server/storage/history"
 )
 
 func NewTestPostgresStorage(db *sql.DB) *PostgresStorage {
@@ -58,6 +61,64 @@ func TestPostgreStorage_GetMetrics(t *testing.T) {
 	assert.Equal(t, expectedMetrics, metrics)
 }
 
+func TestPostgreStorage_GetMetricsByLabels(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("ошибка при создании mock базы данных: %v", err)
+	}
+	defer db.Close()
+
+	p := NewTestPostgresStorage(db)
+
+	rows := sqlmock.NewRows([]string{"name", "type", "counter", "gauge"}).
+		AddRow(`Alloc{env="prod",host="a"}`, "gauge", nil, 1.0).
+		AddRow(`Alloc{env="prod",host="b"}`, "gauge", nil, 2.0)
+	mock.ExpectQuery(`SELECT name, type, counter, gauge FROM metrics WHERE labels @> \$1::jsonb`).
+		WithArgs(`{"env":"prod"}`).
+		WillReturnRows(rows)
+
+	selector, err := labels.ParseSelector("env=prod,host!=b")
+	assert.NoError(t, err)
+
+	metrics := p.GetMetricsByLabels(context.Background(), selector)
+	assert.Equal(t, map[storage.MetricType]map[string]interface{}{
+		storage.Gauge: {`Alloc{env="prod",host="a"}`: 1.0},
+	}, metrics)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPostgreStorage_UpdateJSONMetricWithLabels(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("ошибка при создании mock базы данных: %v", err)
+	}
+	defer db.Close()
+
+	p := NewTestPostgresStorage(db)
+
+	value := 1.5
+	mock.ExpectExec("INSERT INTO metrics .*").
+		WithArgs(`Alloc{host="a"}`, &value, `{"host":"a"}`).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
+	err = p.UpdateJSONMetric(context.Background(), &models.Metrics{
+		ID:     "Alloc",
+		MType:  "gauge",
+		Value:  &value,
+		Labels: map[string]string{"host": "a"},
+	})
+	assert.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	err = p.UpdateJSONMetric(context.Background(), &models.Metrics{
+		ID:     "Alloc",
+		MType:  "gauge",
+		Value:  &value,
+		Labels: map[string]string{"host-name": "a"},
+	})
+	assert.Error(t, err)
+}
+
 func TestPostgreStorage_GetJSONMetrics(t *testing.T) {
 	// Создание mock базы данных и отложенное закрытие соединения
 	db, _, err := sqlmock.New()
@@ -243,7 +304,7 @@ func TestPostgreStorage_UpdateJSONMetricGauge(t *testing.T) {
 
 	// Ожидаемый результат выполнения mock запроса
 	mock.ExpectExec("INSERT INTO .*").
-		WithArgs("metric1", metric.Value).
+		WithArgs("metric1", metric.Value, "{}").
 		WillReturnResult(sqlmock.NewResult(1, 1))
 
 	// Выполнение тестируемого метода
@@ -274,7 +335,7 @@ func TestPostgreStorage_UpdateJSONMetricCounter(t *testing.T) {
 
 	// Ожидаемый результат выполнения mock запроса
 	mock.ExpectExec("INSERT INTO .*").
-		WithArgs("metric1", metric.Value).
+		WithArgs("metric1", metric.Value, "{}").
 		WillReturnResult(sqlmock.NewResult(1, 1))
 
 	// Выполнение тестируемого метода
@@ -303,7 +364,8 @@ func TestPostgreStorage_UpdateJSONMetrics(t *testing.T) {
 
 	// Ожидаемые SQL запросы
 	mock.ExpectBegin()
-	mock.ExpectExec("INSERT INTO .*").WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectQuery("INSERT INTO .* SET counter = COALESCE\\(m.counter, 0\\) \\+ EXCLUDED.counter RETURNING counter").
+		WillReturnRows(sqlmock.NewRows([]string{"counter"}).AddRow(5))
 	mock.ExpectExec("INSERT INTO .*").WillReturnResult(sqlmock.NewResult(1, 1))
 	mock.ExpectCommit()
 
@@ -319,6 +381,28 @@ func TestPostgreStorage_UpdateJSONMetrics(t *testing.T) {
 	}
 }
 
+func TestPostgreStorage_UpdateJSONMetricsInvalid(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("ошибка при создании mock базы данных: %v", err)
+	}
+	defer db.Close()
+
+	p := NewTestPostgresStorage(db)
+
+	// Некорректная пачка отклоняется до начала транзакции
+	metrics := []models.Metrics{
+		{ID: "metric1", MType: "counter", Delta: new(int64)},
+		{ID: "metric2", MType: "gauge"},
+	}
+	err = p.UpdateJSONMetrics(context.Background(), &metrics)
+	var batchErr *storage.BatchError
+	if assert.ErrorAs(t, err, &batchErr) {
+		assert.Equal(t, 1, batchErr.Index)
+	}
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
 func TestPostgreStorage_SetMetrics(t *testing.T) {
 	// Создание mock базы данных и отложенное закрытие соединения
 	db, mock, err := sqlmock.New()
@@ -415,3 +499,122 @@ func BenchmarkPostgresStorage_SetMetrics(b *testing.B) {
 		p.SetMetrics(ctx, metrics)
 	}
 }
+
+func TestPostgreStorage_UpdateJSONMetricWithHistory(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("ошибка при создании mock базы данных: %v", err)
+	}
+	defer db.Close()
+
+	p := NewTestPostgresStorage(db)
+	p.SetHistoryConfig(history.Config{Retention: time.Hour, Resolution: 10 * time.Second})
+
+	value := 42.5
+	mock.ExpectExec("INSERT INTO metrics .*").WithArgs("test", &value, "{}").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("INSERT INTO metric_history .*").WithArgs("test", "gauge", sqlmock.AnyArg(), 42.5).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("DELETE FROM metric_history .*").WithArgs(sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	err = p.UpdateJSONMetric(context.Background(), &models.Metrics{ID: "test", MType: "gauge", Value: &value})
+	assert.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+// TestPostgreStorage_PruneHistoryFailure проверяет, что ошибка очистки истории не отменяет записанную метрику,
+// а очистка повторяется при следующей записи
+func TestPostgreStorage_PruneHistoryFailure(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("ошибка при создании mock базы данных: %v", err)
+	}
+	defer db.Close()
+
+	p := NewTestPostgresStorage(db)
+	p.SetHistoryConfig(history.Config{Retention: time.Hour, Resolution: 10 * time.Second})
+
+	value := 42.5
+	for _, pruneErr := range []error{errors.New("lock timeout"), nil} {
+		mock.ExpectExec("INSERT INTO metrics .*").WithArgs("test", &value, "{}").
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec("INSERT INTO metric_history .*").WithArgs("test", "gauge", sqlmock.AnyArg(), 42.5).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		prune := mock.ExpectExec("DELETE FROM metric_history .*").WithArgs(sqlmock.AnyArg())
+		if pruneErr != nil {
+			prune.WillReturnError(pruneErr)
+		} else {
+			prune.WillReturnResult(sqlmock.NewResult(0, 0))
+		}
+
+		err = p.UpdateJSONMetric(context.Background(), &models.Metrics{ID: "test", MType: "gauge", Value: &value})
+		assert.NoError(t, err)
+	}
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+// TestPostgreStorage_UpdateJSONMetricsCounterHistory проверяет, что в историю пачки пишется итоговое значение counter
+func TestPostgreStorage_UpdateJSONMetricsCounterHistory(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("ошибка при создании mock базы данных: %v", err)
+	}
+	defer db.Close()
+
+	p := NewTestPostgresStorage(db)
+	p.SetHistoryConfig(history.Config{Retention: time.Hour, Resolution: 10 * time.Second})
+
+	first, second := int64(2), int64(3)
+	metrics := []models.Metrics{
+		{ID: "PollCount", MType: "counter", Delta: &first},
+		{ID: "PollCount", MType: "counter", Delta: &second},
+	}
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("INSERT INTO metrics .* RETURNING counter").
+		WithArgs("PollCount", int64(5), "{}").
+		WillReturnRows(sqlmock.NewRows([]string{"counter"}).AddRow(105))
+	mock.ExpectExec("INSERT INTO metric_history .*").WithArgs("PollCount", "counter", sqlmock.AnyArg(), 105.0).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+	// История очищается после фиксации, вне транзакции пачки
+	mock.ExpectExec("DELETE FROM metric_history .*").WithArgs(sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	assert.NoError(t, p.UpdateJSONMetrics(context.Background(), &metrics))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPostgreStorage_GetHistory(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("ошибка при создании mock базы данных: %v", err)
+	}
+	defer db.Close()
+
+	p := NewTestPostgresStorage(db)
+	ctx := context.Background()
+	from := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	to := from.Add(time.Hour)
+
+	_, err = p.GetHistory(ctx, storage.Gauge, "test", from, to, 0)
+	assert.Error(t, err, "история выключена по умолчанию")
+
+	p.SetHistoryConfig(history.Config{Retention: time.Hour})
+	rows := sqlmock.NewRows([]string{"ts", "value"}).
+		AddRow(from, 1.0).
+		AddRow(from.Add(10*time.Second), 3.0).
+		AddRow(from.Add(time.Minute), 5.0)
+	mock.ExpectQuery("SELECT ts, value FROM metric_history .*").
+		WithArgs("test", "gauge", from, to).
+		WillReturnRows(rows)
+
+	points, err := p.GetHistory(ctx, storage.Gauge, "test", from, to, time.Minute)
+	assert.NoError(t, err)
+	assert.Equal(t, []models.HistoryPoint{
+		{Time: from, Value: 2},
+		{Time: from.Add(time.Minute), Value: 5},
+	}, points)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -2,7 +2,9 @@ package storage
 
 import (
 	"context"
+	"time"
 
+	"github.com/Arcadian-Sky/musthave-metrics/internal/labels"
 	"github.com/Arcadian-Sky/musthave-metrics/internal/server/models"
 )
 
@@ -30,3 +32,15 @@ type MetricsStorage interface {
 
 	Ping() error
 }
+
+// HistoryStorage определяет интерфейс хранилища, которое ведет историю значений метрик.
+// Реализуется опционально, наличие проверяется приведением типа.
+type HistoryStorage interface {
+	GetHistory(ctx context.Context, mtype MetricType, name string, from, to time.Time, step time.Duration) ([]models.HistoryPoint, error)
+}
+
+// LabelStorage определяет интерфейс хранилища, которое умеет отбирать метрики по селектору меток.
+// Ключи возвращаемых метрик имеют вид name{label="value"}, см. labels.Key.
+type LabelStorage interface {
+	GetMetricsByLabels(ctx context.Context, selector labels.Selector) map[MetricType]map[string]interface{}
+}
@@ -0,0 +1,137 @@
+package telemetry
+
+import (
+	"database/sql"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/Arcadian-Sky/musthave-metrics/internal/server/exposition"
+	"github.com/Arcadian-Sky/musthave-metrics/internal/server/storage"
+)
+
+// Namespace префикс имен собственных метрик сервера
+const Namespace = "metrics_server_"
+
+// Metrics собственные метрики сервера. Методы безопасны для nil, тогда ничего не записывается.
+type Metrics struct {
+	reg *Registry
+
+	requests        *Counter
+	requestDuration *Histogram
+	ingested        *Counter
+	storageDuration *Histogram
+	mementoDuration *Gauge
+	mementoSize     *Gauge
+	mementoSaves    *Counter
+}
+
+// New создает собственные метрики сервера
+func New() *Metrics {
+	reg := NewRegistry()
+	return &Metrics{
+		reg: reg,
+		requests: reg.Counter(Namespace+"http_requests",
+			"HTTP requests by route, method and status code.", "route", "method", "code"),
+		requestDuration: reg.Histogram(Namespace+"http_request_duration_seconds",
+			"HTTP request latency by route and method.", DefaultBuckets, "route", "method"),
+		ingested: reg.Counter(Namespace+"ingested_metrics",
+			"Metrics written to storage by type.", "type"),
+		storageDuration: reg.Histogram(Namespace+"storage_operation_duration_seconds",
+			"Storage operation latency by operation and result.", DefaultBuckets, "operation", "result"),
+		mementoDuration: reg.Gauge(Namespace+"memento_save_duration_seconds",
+			"Duration of the last memento snapshot save."),
+		mementoSize: reg.Gauge(Namespace+"memento_size_bytes",
+			"Size of the last saved memento snapshot."),
+		mementoSaves: reg.Counter(Namespace+"memento_saves",
+			"Memento snapshot saves by result.", "result"),
+	}
+}
+
+// Registry возвращает набор метрик, в котором можно зарегистрировать дополнительные метрики
+func (m *Metrics) Registry() *Registry {
+	if m == nil {
+		return nil
+	}
+	return m.reg
+}
+
+// ObserveRequest учитывает обработанный HTTP запрос. route - шаблон маршрута, например /update/{type}/{name}/{value}.
+func (m *Metrics) ObserveRequest(route, method string, code int, d time.Duration) {
+	if m == nil {
+		return
+	}
+	m.requests.Inc(route, method, strconv.Itoa(code))
+	m.requestDuration.Observe(d.Seconds(), route, method)
+}
+
+// ObserveOperation учитывает операцию хранилища, см. storage.Observer
+func (m *Metrics) ObserveOperation(op string, d time.Duration, err error) {
+	if m == nil {
+		return
+	}
+	m.storageDuration.Observe(d.Seconds(), op, result(err))
+}
+
+// ObserveIngested учитывает принятые хранилищем метрики, см. storage.Observer
+func (m *Metrics) ObserveIngested(mtype storage.MetricType, n int) {
+	if m == nil {
+		return
+	}
+	m.ingested.Add(float64(n), string(mtype))
+}
+
+// ObserveMemento учитывает сохранение снимка хранилища в файл.
+// Длительность и размер обновляются только при успешном сохранении.
+func (m *Metrics) ObserveMemento(d time.Duration, size int64, err error) {
+	if m == nil {
+		return
+	}
+	m.mementoSaves.Inc(result(err))
+	if err != nil {
+		return
+	}
+	m.mementoDuration.Set(d.Seconds())
+	m.mementoSize.Set(float64(size))
+}
+
+// WatchDB выводит статистику пула соединений stats, например sql.DB.Stats, при каждом выводе метрик
+func (m *Metrics) WatchDB(stats func() sql.DBStats) {
+	if m == nil {
+		return
+	}
+	open := m.reg.Gauge(Namespace+"db_open_connections", "Established database connections, in use and idle.")
+	inUse := m.reg.Gauge(Namespace+"db_in_use_connections", "Database connections currently in use.")
+	idle := m.reg.Gauge(Namespace+"db_idle_connections", "Idle database connections.")
+	maxOpen := m.reg.Gauge(Namespace+"db_max_open_connections", "Maximum number of open database connections, 0 is unlimited.")
+	waitCount := m.reg.Gauge(Namespace+"db_wait_count", "Total number of connections waited for.")
+	waitDuration := m.reg.Gauge(Namespace+"db_wait_duration_seconds", "Total time blocked waiting for a new connection.")
+	closed := m.reg.Gauge(Namespace+"db_closed_connections", "Connections closed due to SetMaxIdleConns, SetConnMaxIdleTime or SetConnMaxLifetime.", "reason")
+	m.reg.OnCollect(func() {
+		s := stats()
+		open.Set(float64(s.OpenConnections))
+		inUse.Set(float64(s.InUse))
+		idle.Set(float64(s.Idle))
+		maxOpen.Set(float64(s.MaxOpenConnections))
+		waitCount.Set(float64(s.WaitCount))
+		waitDuration.Set(s.WaitDuration.Seconds())
+		closed.Set(float64(s.MaxIdleClosed), "max_idle")
+		closed.Set(float64(s.MaxIdleTimeClosed), "max_idle_time")
+		closed.Set(float64(s.MaxLifetimeClosed), "max_lifetime")
+	})
+}
+
+// Write выводит метрики в выбранном формате, см. exposition.Source
+func (m *Metrics) Write(w io.Writer, format exposition.Format) error {
+	if m == nil {
+		return nil
+	}
+	return m.reg.Write(w, format)
+}
+
+func result(err error) string {
+	if err != nil {
+		return "error"
+	}
+	return "ok"
+}
@@ -0,0 +1,262 @@
+// Пакет telemetry собирает собственные метрики сервера: запросы, прием метрик,
+// операции хранилища, пул соединений с базой и сохранение снимков.
+//
+// Метрики выводятся в /metrics после метрик хранилища в формате Prometheus или OpenMetrics.
+// Счетчики, gauge и гистограммы хранятся в памяти процесса и обнуляются при перезапуске.
+package telemetry
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/Arcadian-Sky/musthave-metrics/internal/labels"
+	"github.com/Arcadian-Sky/musthave-metrics/internal/server/exposition"
+)
+
+// DefaultBuckets границы гистограмм длительности в секундах
+var DefaultBuckets = []float64{0.0005, 0.001, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+type kind string
+
+const (
+	kindCounter   kind = "counter"
+	kindGauge     kind = "gauge"
+	kindHistogram kind = "histogram"
+)
+
+// series значения одного ряда семейства
+type series struct {
+	labels  map[string]string
+	value   float64
+	buckets []uint64 // для гистограммы: число наблюдений не больше соответствующей границы
+	sum     float64
+	count   uint64
+}
+
+// family семейство метрик с одним именем и набором меток
+type family struct {
+	name       string
+	help       string
+	kind       kind
+	labelNames []string
+	buckets    []float64
+
+	mu     sync.Mutex
+	series map[string]*series
+}
+
+// get возвращает ряд с заданными значениями меток, вызывается под блокировкой
+func (f *family) get(values []string) *series {
+	if len(values) != len(f.labelNames) {
+		panic(fmt.Sprintf("telemetry: %s ожидает %d меток, передано %d", f.name, len(f.labelNames), len(values)))
+	}
+	key := strings.Join(values, "\xff")
+	s, ok := f.series[key]
+	if !ok {
+		l := make(map[string]string, len(values))
+		for i, name := range f.labelNames {
+			l[name] = values[i]
+		}
+		s = &series{labels: l}
+		if f.kind == kindHistogram {
+			s.buckets = make([]uint64, len(f.buckets))
+		}
+		f.series[key] = s
+	}
+	return s
+}
+
+// Counter монотонно растущий счетчик
+type Counter struct{ f *family }
+
+// Add увеличивает счетчик ряда с заданными значениями меток
+func (c *Counter) Add(delta float64, labelValues ...string) {
+	c.f.mu.Lock()
+	defer c.f.mu.Unlock()
+	c.f.get(labelValues).value += delta
+}
+
+// Inc увеличивает счетчик на единицу
+func (c *Counter) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Gauge значение, которое может как расти, так и убывать
+type Gauge struct{ f *family }
+
+// Set задает значение ряда с заданными значениями меток
+func (g *Gauge) Set(value float64, labelValues ...string) {
+	g.f.mu.Lock()
+	defer g.f.mu.Unlock()
+	g.f.get(labelValues).value = value
+}
+
+// Histogram распределение наблюдаемых значений по корзинам
+type Histogram struct{ f *family }
+
+// Observe добавляет наблюдение в ряд с заданными значениями меток
+func (h *Histogram) Observe(value float64, labelValues ...string) {
+	h.f.mu.Lock()
+	defer h.f.mu.Unlock()
+	s := h.f.get(labelValues)
+	for i, bound := range h.f.buckets {
+		if value <= bound {
+			s.buckets[i]++
+		}
+	}
+	s.sum += value
+	s.count++
+}
+
+// Registry набор собственных метрик сервера
+type Registry struct {
+	mu         sync.Mutex
+	families   map[string]*family
+	collectors []func()
+}
+
+// NewRegistry создает пустой набор метрик
+func NewRegistry() *Registry {
+	return &Registry{families: make(map[string]*family)}
+}
+
+// Counter регистрирует счетчик. В выводе к имени добавляется суффикс _total.
+func (r *Registry) Counter(name, help string, labelNames ...string) *Counter {
+	return &Counter{f: r.register(name, help, kindCounter, nil, labelNames)}
+}
+
+// Gauge регистрирует gauge
+func (r *Registry) Gauge(name, help string, labelNames ...string) *Gauge {
+	return &Gauge{f: r.register(name, help, kindGauge, nil, labelNames)}
+}
+
+// Histogram регистрирует гистограмму с возрастающими границами корзин buckets
+func (r *Registry) Histogram(name, help string, buckets []float64, labelNames ...string) *Histogram {
+	if !sort.Float64sAreSorted(buckets) {
+		panic(fmt.Sprintf("telemetry: границы корзин %s не упорядочены", name))
+	}
+	return &Histogram{f: r.register(name, help, kindHistogram, buckets, labelNames)}
+}
+
+// OnCollect добавляет функцию, которая вызывается перед каждым выводом метрик.
+// Так обновляются gauge, значения которых берутся из внешних источников, например sql.DB.Stats.
+func (r *Registry) OnCollect(f func()) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.collectors = append(r.collectors, f)
+}
+
+func (r *Registry) register(name, help string, k kind, buckets []float64, labelNames []string) *family {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.families[name]; ok {
+		panic(fmt.Sprintf("telemetry: метрика %s уже зарегистрирована", name))
+	}
+	f := &family{
+		name:       name,
+		help:       help,
+		kind:       k,
+		labelNames: labelNames,
+		buckets:    buckets,
+		series:     make(map[string]*series),
+	}
+	r.families[name] = f
+	return f
+}
+
+// Write выводит метрики, отсортированные по имени, в выбранном формате.
+// Семейства без рядов пропускаются.
+func (r *Registry) Write(w io.Writer, format exposition.Format) error {
+	r.mu.Lock()
+	collectors := append([]func(){}, r.collectors...)
+	families := make([]*family, 0, len(r.families))
+	for _, f := range r.families {
+		families = append(families, f)
+	}
+	r.mu.Unlock()
+
+	for _, collect := range collectors {
+		collect()
+	}
+	sort.Slice(families, func(i, j int) bool {
+		return families[i].name < families[j].name
+	})
+	for _, f := range families {
+		if err := f.write(w, format); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *family) write(w io.Writer, format exposition.Format) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if len(f.series) == 0 {
+		return nil
+	}
+	keys := make([]string, 0, len(f.series))
+	for key := range f.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	name := f.name
+	if f.kind == kindCounter && format == exposition.FormatText {
+		// В текстовом формате Prometheus суффикс _total входит в имя семейства
+		name += "_total"
+	}
+	if _, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, f.help, name, f.kind); err != nil {
+		return err
+	}
+	for _, key := range keys {
+		s := f.series[key]
+		var err error
+		switch f.kind {
+		case kindCounter:
+			err = writeSample(w, f.name+"_total", s.labels, s.value)
+		case kindGauge:
+			err = writeSample(w, f.name, s.labels, s.value)
+		case kindHistogram:
+			err = f.writeHistogram(w, s)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *family) writeHistogram(w io.Writer, s *series) error {
+	for i, bound := range f.buckets {
+		if err := writeSample(w, f.name+"_bucket", withLabel(s.labels, "le", exposition.FormatValue(bound)), float64(s.buckets[i])); err != nil {
+			return err
+		}
+	}
+	if err := writeSample(w, f.name+"_bucket", withLabel(s.labels, "le", exposition.FormatValue(math.Inf(1))), float64(s.count)); err != nil {
+		return err
+	}
+	if err := writeSample(w, f.name+"_sum", s.labels, s.sum); err != nil {
+		return err
+	}
+	return writeSample(w, f.name+"_count", s.labels, float64(s.count))
+}
+
+func writeSample(w io.Writer, name string, l map[string]string, value float64) error {
+	_, err := fmt.Fprintf(w, "%s %s\n", labels.Key(name, l), exposition.FormatValue(value))
+	return err
+}
+
+// withLabel возвращает копию меток с добавленной меткой
+func withLabel(l map[string]string, name, value string) map[string]string {
+	result := make(map[string]string, len(l)+1)
+	for k, v := range l {
+		result[k] = v
+	}
+	result[name] = value
+	return result
+}
@@ -0,0 +1,119 @@
+package telemetry
+
+import (
+	"bytes"
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Arcadian-Sky/musthave-metrics/internal/server/exposition"
+	"github.com/Arcadian-Sky/musthave-metrics/internal/server/storage"
+)
+
+func TestRegistryWrite(t *testing.T) {
+	reg := NewRegistry()
+	requests := reg.Counter("requests", "Requests.", "code")
+	temp := reg.Gauge("temperature", "Temperature.")
+	latency := reg.Histogram("latency_seconds", "Latency.", []float64{0.1, 1})
+	reg.Gauge("unused", "Never set.")
+
+	requests.Inc("200")
+	requests.Add(2, "500")
+	temp.Set(36.6)
+	latency.Observe(0.05)
+	latency.Observe(0.5)
+	latency.Observe(3)
+
+	histogram := "latency_seconds_bucket{le=\"0.1\"} 1\n" +
+		"latency_seconds_bucket{le=\"1\"} 2\n" +
+		"latency_seconds_bucket{le=\"+Inf\"} 3\n" +
+		"latency_seconds_sum 3.55\n" +
+		"latency_seconds_count 3\n"
+	tests := []struct {
+		name   string
+		format exposition.Format
+		want   string
+	}{
+		{
+			name:   "Text",
+			format: exposition.FormatText,
+			want: "# HELP latency_seconds Latency.\n# TYPE latency_seconds histogram\n" + histogram +
+				"# HELP requests_total Requests.\n# TYPE requests_total counter\n" +
+				"requests_total{code=\"200\"} 1\nrequests_total{code=\"500\"} 2\n" +
+				"# HELP temperature Temperature.\n# TYPE temperature gauge\ntemperature 36.6\n",
+		},
+		{
+			name:   "OpenMetrics",
+			format: exposition.FormatOpenMetrics,
+			want: "# HELP latency_seconds Latency.\n# TYPE latency_seconds histogram\n" + histogram +
+				"# HELP requests Requests.\n# TYPE requests counter\n" +
+				"requests_total{code=\"200\"} 1\nrequests_total{code=\"500\"} 2\n" +
+				"# HELP temperature Temperature.\n# TYPE temperature gauge\ntemperature 36.6\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			require.NoError(t, reg.Write(&buf, tt.format))
+			assert.Equal(t, tt.want, buf.String())
+		})
+	}
+}
+
+func TestRegistryPanics(t *testing.T) {
+	reg := NewRegistry()
+	c := reg.Counter("c", "C.", "a", "b")
+	assert.Panics(t, func() { c.Inc("only-one") })
+	assert.Panics(t, func() { reg.Gauge("c", "Duplicate.") })
+	assert.Panics(t, func() { reg.Histogram("h", "H.", []float64{1, 0.5}) })
+}
+
+func TestMetrics(t *testing.T) {
+	m := New()
+	m.ObserveRequest("/update/{type}/{name}/{value}", "POST", 200, 20*time.Millisecond)
+	m.ObserveOperation(storage.OpUpdateMetric, time.Millisecond, nil)
+	m.ObserveOperation(storage.OpUpdateMetric, time.Millisecond, errors.New("boom"))
+	m.ObserveIngested(storage.Counter, 3)
+	m.ObserveMemento(10*time.Millisecond, 512, nil)
+	m.ObserveMemento(time.Second, 0, errors.New("disk full"))
+	m.WatchDB(func() sql.DBStats {
+		return sql.DBStats{OpenConnections: 4, InUse: 1, Idle: 3, WaitCount: 7, WaitDuration: 2 * time.Second}
+	})
+
+	var buf bytes.Buffer
+	require.NoError(t, m.Write(&buf, exposition.FormatText))
+	out := buf.String()
+	for _, want := range []string{
+		`metrics_server_http_requests_total{code="200",method="POST",route="/update/{type}/{name}/{value}"} 1`,
+		`metrics_server_http_request_duration_seconds_count{method="POST",route="/update/{type}/{name}/{value}"} 1`,
+		`metrics_server_storage_operation_duration_seconds_count{operation="update_metric",result="ok"} 1`,
+		`metrics_server_storage_operation_duration_seconds_count{operation="update_metric",result="error"} 1`,
+		`metrics_server_ingested_metrics_total{type="counter"} 3`,
+		`metrics_server_memento_size_bytes 512`,
+		`metrics_server_memento_save_duration_seconds 0.01`,
+		`metrics_server_memento_saves_total{result="error"} 1`,
+		`metrics_server_db_open_connections 4`,
+		`metrics_server_db_wait_duration_seconds 2`,
+	} {
+		assert.Contains(t, out, want+"\n")
+	}
+}
+
+func TestMetricsNil(t *testing.T) {
+	var m *Metrics
+	assert.NotPanics(t, func() {
+		m.ObserveRequest("/", "GET", 200, time.Millisecond)
+		m.ObserveOperation(storage.OpPing, time.Millisecond, nil)
+		m.ObserveIngested(storage.Gauge, 1)
+		m.ObserveMemento(time.Millisecond, 1, nil)
+		m.WatchDB(nil)
+	})
+	var buf bytes.Buffer
+	assert.NoError(t, m.Write(&buf, exposition.FormatText))
+	assert.Empty(t, buf.String())
+	assert.Nil(t, m.Registry())
+}
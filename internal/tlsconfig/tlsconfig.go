@@ -0,0 +1,68 @@
+// Пакет tlsconfig собирает настройки TLS для взаимной аутентификации агента и сервера.
+// Сертификаты, ключи и корневые сертификаты читаются из PEM файлов.
+package tlsconfig
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+)
+
+// Server возвращает настройки TLS сервера.
+// Если задан clientCAFile, сервер требует от агентов сертификат, подписанный этим центром.
+func Server(certFile, keyFile, clientCAFile string) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка при загрузке сертификата сервера: %w", err)
+	}
+	config := &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{cert},
+	}
+	if clientCAFile != "" {
+		pool, err := loadPool(clientCAFile)
+		if err != nil {
+			return nil, err
+		}
+		config.ClientCAs = pool
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return config, nil
+}
+
+// Client возвращает настройки TLS агента.
+// Сертификат клиента подключается, если заданы certFile и keyFile,
+// сертификат сервера проверяется по serverCAFile или по системным корневым сертификатам.
+func Client(certFile, keyFile, serverCAFile string) (*tls.Config, error) {
+	config := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+	}
+	if certFile != "" || keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("ошибка при загрузке сертификата агента: %w", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	if serverCAFile != "" {
+		pool, err := loadPool(serverCAFile)
+		if err != nil {
+			return nil, err
+		}
+		config.RootCAs = pool
+	}
+	return config, nil
+}
+
+func loadPool(path string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка при чтении сертификата центра: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("в файле %s нет сертификатов", path)
+	}
+	return pool, nil
+}
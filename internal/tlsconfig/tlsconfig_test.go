@@ -0,0 +1,111 @@
+package tlsconfig
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// issue выпускает сертификат, подписанный parent, и сохраняет его с ключом в dir
+func issue(t *testing.T, dir, name string, tmpl *x509.Certificate, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	if parent == nil {
+		parent, parentKey = tmpl, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, &key.PublicKey, parentKey)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, name+".crt"), pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, name+".key"), pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600))
+	return cert, key
+}
+
+func template(serial int64, cn string) *x509.Certificate {
+	return &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+}
+
+func TestMutualTLS(t *testing.T) {
+	dir := t.TempDir()
+
+	caTmpl := template(1, "test ca")
+	caTmpl.IsCA = true
+	caTmpl.BasicConstraintsValid = true
+	caTmpl.KeyUsage = x509.KeyUsageCertSign
+	ca, caKey := issue(t, dir, "ca", caTmpl, nil, nil)
+
+	serverTmpl := template(2, "server")
+	serverTmpl.IPAddresses = []net.IP{net.ParseIP("127.0.0.1")}
+	serverTmpl.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+	issue(t, dir, "server", serverTmpl, ca, caKey)
+
+	agentTmpl := template(3, "agent-1")
+	agentTmpl.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}
+	issue(t, dir, "agent", agentTmpl, ca, caKey)
+
+	serverConfig, err := Server(filepath.Join(dir, "server.crt"), filepath.Join(dir, "server.key"), filepath.Join(dir, "ca.crt"))
+	require.NoError(t, err)
+	assert.Equal(t, tls.RequireAndVerifyClientCert, serverConfig.ClientAuth)
+
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(r.TLS.VerifiedChains[0][0].Subject.CommonName))
+	}))
+	srv.TLS = serverConfig
+	srv.StartTLS()
+	defer srv.Close()
+
+	clientConfig, err := Client(filepath.Join(dir, "agent.crt"), filepath.Join(dir, "agent.key"), filepath.Join(dir, "ca.crt"))
+	require.NoError(t, err)
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: clientConfig}}
+	resp, err := client.Get(srv.URL)
+	require.NoError(t, err)
+	body := make([]byte, 64)
+	n, _ := resp.Body.Read(body)
+	resp.Body.Close()
+	assert.Equal(t, "agent-1", string(body[:n]))
+
+	// Без клиентского сертификата соединение отклоняется
+	clientConfig, err = Client("", "", filepath.Join(dir, "ca.crt"))
+	require.NoError(t, err)
+	client = &http.Client{Transport: &http.Transport{TLSClientConfig: clientConfig}}
+	_, err = client.Get(srv.URL)
+	assert.Error(t, err)
+}
+
+func TestLoadErrors(t *testing.T) {
+	_, err := Server("missing.crt", "missing.key", "")
+	assert.Error(t, err)
+
+	_, err = Client("missing.crt", "", "")
+	assert.Error(t, err)
+
+	empty := filepath.Join(t.TempDir(), "empty.pem")
+	require.NoError(t, os.WriteFile(empty, nil, 0600))
+	_, err = Client("", "", empty)
+	assert.Error(t, err)
+}
@@ -0,0 +1,15 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS metric_history (
+			name varchar NOT NULL,
+			type metric_type NOT NULL,
+			ts timestamptz NOT NULL,
+			value double precision NOT NULL,
+			CONSTRAINT constraint_history_name_type_ts UNIQUE (name, type, ts)
+		);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE metric_history;
+-- +goose StatementEnd
@@ -0,0 +1,11 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE metrics ADD COLUMN IF NOT EXISTS labels jsonb NOT NULL DEFAULT '{}'::jsonb;
+CREATE INDEX IF NOT EXISTS metrics_labels_idx ON metrics USING GIN (labels);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS metrics_labels_idx;
+ALTER TABLE metrics DROP COLUMN IF EXISTS labels;
+-- +goose StatementEnd